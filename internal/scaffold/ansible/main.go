@@ -41,20 +41,33 @@ import (
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
-	aoflags "github.com/operator-framework/operator-sdk/pkg/ansible/flags"
 	"github.com/operator-framework/operator-sdk/pkg/ansible"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/controller"
+	aoflags "github.com/operator-framework/operator-sdk/pkg/ansible/flags"
 	"github.com/operator-framework/operator-sdk/pkg/log/zap"
 
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// hooks are called around the runs of the CRs of the watches with their
+// GVKs. Register a hook with e.g.:
+//
+//	schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "AppService"}: controller.HookFuncs{
+//		PreRunFunc: func(u *unstructured.Unstructured) (bool, error) {
+//			// change the parameters of the run, or return true to skip it
+//			return false, nil
+//		},
+//	},
+var hooks = map[schema.GroupVersionKind]controller.Hooks{}
+
 func main() {
 	aflags := aoflags.AddTo(pflag.CommandLine)
 	pflag.Parse()
 	logf.SetLogger(zap.Logger())
 
-	if err := ansible.Run(aflags); err != nil {
+	if err := ansible.RunWithHooks(aflags, hooks); err != nil {
 		logf.Log.WithName("cmd").Error(err, "")
 		os.Exit(1)
 	}
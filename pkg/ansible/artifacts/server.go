@@ -0,0 +1,229 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Server - serves the artifacts of a Store over HTTP, and periodically
+// exports and prunes them. It implements manager.Runnable.
+//
+// "/artifacts" lists the CRs with artifacts, and
+// "/artifacts/<group>/<version>/<kind>/<namespace>/<name>" lists the runs of
+// a CR. The "stdout", "events" and "facts" of a run are served below
+// "/artifacts/<group>/<version>/<kind>/<namespace>/<name>/<ident>/".
+//
+// The artifacts may contain sensitive output. When Address is not a loopback
+// address, requests must carry the bearer token of a user that is allowed to
+// get the path of the request as a non-resource URL, which is checked with
+// TokenReviews and SubjectAccessReviews like the metrics of Kubernetes
+// components.
+type Server struct {
+	Store *Store
+	// Address is the address the artifacts are served on. They are not
+	// served if it is empty.
+	Address string
+	// Client reviews the tokens and access of the requests. It is required
+	// if Address is not a loopback address.
+	Client kubernetes.Interface
+	// SyncPeriod is the period in which runs are exported and pruned.
+	SyncPeriod time.Duration
+
+	// authorize is true if the requests are authorized.
+	authorize bool
+}
+
+// Start - serves the artifacts and syncs the store until stop is closed.
+func (s *Server) Start(stop <-chan struct{}) error {
+	errChan := make(chan error, 1)
+	var srv *http.Server
+	if s.Address != "" {
+		l, err := net.Listen("tcp", s.Address)
+		if err != nil {
+			return err
+		}
+		if addr, ok := l.Addr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+			if s.Client == nil {
+				l.Close()
+				return errors.New("artifacts served on an address other than a loopback address require a client to authorize requests")
+			}
+			s.authorize = true
+		}
+		srv = &http.Server{Handler: s}
+		go func() {
+			log.Info("Serving artifacts", "address", l.Addr().String(), "authorized", s.authorize)
+			if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(s.SyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sync()
+		case err := <-errChan:
+			return err
+		case <-stop:
+			if srv != nil {
+				return srv.Shutdown(context.Background())
+			}
+			return nil
+		}
+	}
+}
+
+func (s *Server) sync() {
+	if err := s.Store.Export(); err != nil {
+		log.Error(err, "Failed to export artifacts")
+	}
+	if err := s.Store.Prune(); err != nil {
+		log.Error(err, "Failed to prune artifacts")
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.authorize {
+		if code, err := s.authorized(req); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if parts[0] != "artifacts" {
+		http.NotFound(w, req)
+		return
+	}
+	parts = parts[1:]
+
+	switch len(parts) {
+	case 0:
+		resources, err := s.Store.Resources()
+		writeJSON(w, resources, err)
+		return
+	case 5, 7:
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	r := Resource{Group: parts[0], Version: parts[1], Kind: parts[2], Namespace: parts[3], Name: parts[4]}
+	if len(parts) == 5 {
+		runs, err := s.Store.Runs(r)
+		writeJSON(w, runs, err)
+		return
+	}
+
+	ident := parts[5]
+	switch parts[6] {
+	case "stdout":
+		stdout, err := s.Store.Stdout(r, ident)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err := w.Write(stdout); err != nil {
+			log.Error(err, "Failed to write stdout")
+		}
+	case "events":
+		events, err := s.Store.Events(r, ident)
+		writeJSON(w, events, err)
+	case "facts":
+		facts, err := s.Store.Facts(r, ident)
+		writeJSON(w, facts, err)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// authorized - returns an error and its status code unless the bearer token
+// of the request belongs to a user that may get the request's path.
+func (s *Server) authorized(req *http.Request) (int, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+	review, err := s.Client.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		log.Error(err, "Failed to review token")
+		return http.StatusInternalServerError, errors.New("failed to review token")
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access, err := s.Client.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:                  user.Username,
+			UID:                   user.UID,
+			Groups:                user.Groups,
+			Extra:                 extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: req.URL.Path, Verb: "get"},
+		},
+	})
+	if err != nil {
+		log.Error(err, "Failed to review access")
+		return http.StatusInternalServerError, errors.New("failed to review access")
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("user %q may not get %s", user.Username, req.URL.Path)
+	}
+	return http.StatusOK, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err, "Failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Error(err, "Failed to read artifacts")
+	http.Error(w, "failed to read artifacts", http.StatusInternalServerError)
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestServer(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	writeRun(t, s.runnerArtifactsDir(testResource), "100", "failed", time.Hour)
+
+	srv := httptest.NewServer(&Server{Store: s})
+	defer srv.Close()
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "list resources",
+			path:         "/artifacts",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"group":"app.example.com","version":"v1alpha1","kind":"Memcached","namespace":"default","name":"example"}]`,
+		},
+		{
+			name:         "list runs",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/example",
+			expectedCode: http.StatusOK,
+			expectedBody: `"ident":"100","status":"failed","rc":0`,
+		},
+		{
+			name:         "stdout",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/example/100/stdout",
+			expectedCode: http.StatusOK,
+			expectedBody: "PLAY RECAP",
+		},
+		{
+			name:         "events",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/example/100/events",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"counter":1,"event":"playbook_on_start"},{"counter":2,"event":"playbook_on_stats"}]`,
+		},
+		{
+			name:         "facts",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/example/100/facts",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"localhost":{"ansible_hostname":"operator"}}`,
+		},
+		{
+			name:         "unknown run",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/example/200/stdout",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown resource",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/other",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown artifact",
+			path:         "/artifacts/app.example.com/v1alpha1/Memcached/default/example/100/env",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown path",
+			path:         "/metrics",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "method not allowed",
+			method:       http.MethodDelete,
+			path:         "/artifacts",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, srv.URL+tc.path, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if resp.StatusCode != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d: %s", resp.StatusCode, tc.expectedCode, body)
+			}
+			if !strings.Contains(string(body), tc.expectedBody) {
+				t.Fatalf("Unexpected body:\n%s\nexpected to contain:\n%s", body, tc.expectedBody)
+			}
+		})
+	}
+}
+
+func TestServerAuthorization(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	writeRun(t, s.runnerArtifactsDir(testResource), "100", "failed", time.Hour)
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "admin-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "admin"}}
+		case "viewer-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "viewer"}}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "admin" && attrs != nil && attrs.Verb == "get" && strings.HasPrefix(attrs.Path, "/artifacts")
+		return true, review, nil
+	})
+	srv := httptest.NewServer(&Server{Store: s, Client: client, authorize: true})
+	defer srv.Close()
+
+	testCases := []struct {
+		name         string
+		token        string
+		expectedCode int
+	}{
+		{
+			name:         "no token",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "invalid token",
+			token:        "invalid",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "user without access",
+			token:        "viewer-token",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "user with access",
+			token:        "admin-token",
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/artifacts/app.example.com/v1alpha1/Memcached/default/example/100/stdout", nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d", resp.StatusCode, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestServerRequiresClient(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	srv := &Server{Store: s, Address: "0.0.0.0:0", SyncPeriod: time.Minute}
+	if err := srv.Start(make(chan struct{})); err == nil {
+		t.Fatalf("Expected an error serving artifacts on all interfaces without a client")
+	}
+}
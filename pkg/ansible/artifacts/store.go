@@ -0,0 +1,490 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package artifacts provides access to the ansible-runner artifacts of the
+// runs of an ansible operator, and keeps them according to a retention
+// policy.
+package artifacts
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("artifacts")
+
+const (
+	// latest is the symlink to the artifacts of the latest run of a CR.
+	latest = "latest"
+	// statusFile is written by ansible-runner once a run has finished.
+	statusFile = "status"
+	// ScrubbedFile - written by the operator once the sensitive values of a
+	// run were scrubbed from its artifacts. Only the artifacts of runs with it
+	// are served and exported.
+	ScrubbedFile = "scrubbed"
+	rcFile       = "rc"
+	stdoutFile   = "stdout"
+	eventsDir    = "job_events"
+	factsDir     = "fact_cache"
+)
+
+// ErrNotFound is returned if a CR or run has no artifacts.
+var ErrNotFound = errors.New("artifacts not found")
+
+// Resource - a CR with artifacts.
+type Resource struct {
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// GroupVersionKind - returns the GVK of the CR.
+func (r Resource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// Run - the artifacts of a run of ansible-runner for a CR.
+type Run struct {
+	Ident string `json:"ident"`
+	// Status is the status of the run reported by ansible-runner, e.g.
+	// "successful" or "failed". It is empty while the run is in progress.
+	Status     string    `json:"status,omitempty"`
+	ReturnCode *int      `json:"rc,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+// Store - reads the artifacts that ansible-runner writes to RunnerDir and,
+// optionally, exports them to ExportDir, e.g. a PersistentVolume, so that
+// they outlive the operator's pod.
+type Store struct {
+	// RunnerDir is the directory that contains the ansible-runner input
+	// directory of each CR.
+	RunnerDir string
+	// ExportDir is the directory finished runs are exported to. Runs are not
+	// exported if it is empty.
+	ExportDir string
+	// MaxAge is the age after which the artifacts of a run are removed. Runs
+	// are kept regardless of their age if it is zero.
+	MaxAge time.Duration
+	// MaxCount is the number of runs kept in ExportDir for each CR. All runs
+	// are kept if it is zero. The number of runs in RunnerDir is limited by
+	// the maxRunnerArtifacts option of the watch instead.
+	MaxCount int
+	// GVKs are the GVKs watched by the operator. Only their artifacts are
+	// accessible.
+	GVKs []schema.GroupVersionKind
+}
+
+// accessible returns true if the artifacts of the CR may be accessed.
+func (s *Store) accessible(r Resource) bool {
+	if !validName(r.Namespace) || !validName(r.Name) {
+		return false
+	}
+	for _, gvk := range s.GVKs {
+		if gvk == r.GroupVersionKind() {
+			return true
+		}
+	}
+	return false
+}
+
+// runnerArtifactsDir returns the directory that ansible-runner writes the
+// artifacts of the CR's runs to.
+func (s *Store) runnerArtifactsDir(r Resource) string {
+	return filepath.Join(s.RunnerDir, r.Group, r.Version, r.Kind, r.Namespace, r.Name, "artifacts")
+}
+
+func (s *Store) exportArtifactsDir(r Resource) string {
+	return filepath.Join(s.ExportDir, r.Group, r.Version, r.Kind, r.Namespace, r.Name)
+}
+
+// artifactsDirs returns the directories that contain the artifacts of the
+// CR's runs.
+func (s *Store) artifactsDirs(r Resource) []string {
+	dirs := []string{s.runnerArtifactsDir(r)}
+	if s.ExportDir != "" {
+		dirs = append(dirs, s.exportArtifactsDir(r))
+	}
+	return dirs
+}
+
+// Resources - lists the CRs with artifacts.
+func (s *Store) Resources() ([]Resource, error) {
+	seen := map[Resource]bool{}
+	resources := []Resource{}
+	for _, gvk := range s.GVKs {
+		roots := []string{s.RunnerDir}
+		if s.ExportDir != "" {
+			roots = append(roots, s.ExportDir)
+		}
+		for _, root := range roots {
+			gvkDir := filepath.Join(root, gvk.Group, gvk.Version, gvk.Kind)
+			namespaces, err := subdirs(gvkDir)
+			if err != nil {
+				return nil, err
+			}
+			for _, ns := range namespaces {
+				names, err := subdirs(filepath.Join(gvkDir, ns))
+				if err != nil {
+					return nil, err
+				}
+				for _, name := range names {
+					r := Resource{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Namespace: ns, Name: name}
+					if !seen[r] {
+						seen[r] = true
+						resources = append(resources, r)
+					}
+				}
+			}
+		}
+	}
+	return resources, nil
+}
+
+// Runs - lists the runs of the CR with artifacts, newest first.
+func (s *Store) Runs(r Resource) ([]Run, error) {
+	if !s.accessible(r) {
+		return nil, ErrNotFound
+	}
+	seen := map[string]bool{}
+	runs := []Run{}
+	for _, dir := range s.artifactsDirs(r) {
+		dirRuns, err := readRuns(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range dirRuns {
+			if !seen[run.Ident] {
+				seen[run.Ident] = true
+				runs = append(runs, run)
+			}
+		}
+	}
+	if len(runs) == 0 {
+		return nil, ErrNotFound
+	}
+	sortRuns(runs)
+	return runs, nil
+}
+
+// runDir returns the directory of the artifacts of a run of the CR,
+// preferring the runner's directory over the exported one.
+func (s *Store) runDir(r Resource, ident string) (string, error) {
+	if !s.accessible(r) || !validName(ident) || ident == latest {
+		return "", ErrNotFound
+	}
+	for _, dir := range s.artifactsDirs(r) {
+		runDir := filepath.Join(dir, ident)
+		if fi, err := os.Stat(runDir); err == nil && fi.IsDir() && scrubbed(runDir) {
+			return runDir, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Stdout - returns the stdout of a run of the CR.
+func (s *Store) Stdout(r Resource, ident string) ([]byte, error) {
+	runDir, err := s.runDir(r, ident)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(runDir, stdoutFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Events - returns the job events of a run of the CR in the order they were
+// emitted.
+func (s *Store) Events(r Resource, ident string) ([]json.RawMessage, error) {
+	runDir, err := s.runDir(r, ident)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := readDir(filepath.Join(runDir, eventsDir))
+	if err != nil {
+		return nil, err
+	}
+	// Job event files are named <counter>-<uuid>.json.
+	counter := func(name string) int {
+		i, _ := strconv.Atoi(strings.SplitN(name, "-", 2)[0])
+		return i
+	}
+	sort.Slice(infos, func(i, j int) bool { return counter(infos[i].Name()) < counter(infos[j].Name()) })
+
+	events := []json.RawMessage{}
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(runDir, eventsDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(b) {
+			// The event may still be written.
+			continue
+		}
+		events = append(events, json.RawMessage(b))
+	}
+	return events, nil
+}
+
+// Facts - returns the facts gathered for each host during a run of the CR.
+func (s *Store) Facts(r Resource, ident string) (map[string]json.RawMessage, error) {
+	runDir, err := s.runDir(r, ident)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := readDir(filepath.Join(runDir, factsDir))
+	if err != nil {
+		return nil, err
+	}
+	facts := map[string]json.RawMessage{}
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(runDir, factsDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if json.Valid(b) {
+			facts[fi.Name()] = json.RawMessage(b)
+		}
+	}
+	return facts, nil
+}
+
+// Export - copies the finished runs that have not been exported yet from
+// RunnerDir to ExportDir.
+func (s *Store) Export() error {
+	if s.ExportDir == "" {
+		return nil
+	}
+	resources, err := s.Resources()
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		runs, err := readRuns(s.runnerArtifactsDir(r))
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			if run.Status == "" {
+				continue
+			}
+			dst := filepath.Join(s.exportArtifactsDir(r), run.Ident)
+			if _, err := os.Stat(dst); err == nil {
+				continue
+			}
+			if err := exportRun(filepath.Join(s.runnerArtifactsDir(r), run.Ident), dst); err != nil {
+				return err
+			}
+			log.V(1).Info("Exported artifacts", "kind", r.Kind, "namespace", r.Namespace, "name", r.Name, "ident", run.Ident)
+		}
+	}
+	return nil
+}
+
+// exportRun copies the run's artifacts to a temporary directory first, so
+// that an interrupted export is retried. The exported run keeps the
+// modification time of the run, which its age is based on.
+func exportRun(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := copyDir(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Prune - removes the finished runs that are older than MaxAge, and the
+// exported runs of each CR beyond the MaxCount newest ones.
+func (s *Store) Prune() error {
+	resources, err := s.Resources()
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		if err := s.prune(s.runnerArtifactsDir(r), 0); err != nil {
+			return err
+		}
+		if s.ExportDir != "" {
+			if err := s.prune(s.exportArtifactsDir(r), s.MaxCount); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) prune(dir string, maxCount int) error {
+	runs, err := readRuns(dir)
+	if err != nil {
+		return err
+	}
+	sortRuns(runs)
+	kept := 0
+	for _, run := range runs {
+		if run.Status == "" {
+			continue
+		}
+		expired := s.MaxAge > 0 && time.Since(run.Created) > s.MaxAge
+		if !expired && (maxCount <= 0 || kept < maxCount) {
+			kept++
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, run.Ident)); err != nil {
+			return err
+		}
+		log.V(1).Info("Removed artifacts", "dir", dir, "ident", run.Ident)
+	}
+	return nil
+}
+
+// scrubbed returns true if the artifacts of the run in runDir were scrubbed.
+func scrubbed(runDir string) bool {
+	_, err := os.Stat(filepath.Join(runDir, ScrubbedFile))
+	return err == nil
+}
+
+// readRuns reads the runs in an artifacts directory. The "latest" symlink,
+// and runs that are being exported, are skipped.
+func readRuns(dir string) ([]Run, error) {
+	infos, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	runs := []Run{}
+	for _, fi := range infos {
+		if !fi.IsDir() || fi.Name() == latest || strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		run := Run{Ident: fi.Name(), Created: fi.ModTime()}
+		// A run is in progress until its artifacts were scrubbed.
+		if !scrubbed(filepath.Join(dir, fi.Name())) {
+			runs = append(runs, run)
+			continue
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name(), statusFile)); err == nil {
+			run.Status = strings.TrimSpace(string(b))
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name(), rcFile)); err == nil {
+			if rc, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+				run.ReturnCode = &rc
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func sortRuns(runs []Run) {
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Created.After(runs[j].Created) })
+}
+
+// readDir reads a directory, which may not exist.
+func readDir(dir string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return infos, err
+}
+
+func subdirs(dir string) ([]string, error) {
+	infos, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// copyDir copies the regular files and directories in src to dst. Symlinks
+// are skipped.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// validName returns true if name can be used as a single path element.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
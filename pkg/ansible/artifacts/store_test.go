@@ -0,0 +1,221 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	testGVK      = schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	testResource = Resource{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached", Namespace: "default", Name: "example"}
+)
+
+// writeRun writes the artifacts of a run to dir, and sets their age.
+func writeRun(t *testing.T, dir, ident, status string, age time.Duration) {
+	runDir := filepath.Join(dir, ident)
+	files := map[string]string{
+		"stdout":                   "PLAY RECAP\n",
+		"job_events/2-bbb.json":    `{"counter": 2, "event": "playbook_on_stats"}`,
+		"job_events/1-aaa.json":    `{"counter": 1, "event": "playbook_on_start"}`,
+		"job_events/3-ccc.json":    `{"counter": 3, `,
+		"fact_cache/localhost":     `{"ansible_hostname": "operator"}`,
+		"fact_cache/invalid-facts": `{`,
+	}
+	if status != "" {
+		files["status"] = status + "\n"
+		files["rc"] = "0\n"
+		files[ScrubbedFile] = ""
+	}
+	for name, content := range files {
+		path := filepath.Join(runDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(runDir, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+}
+
+func newTestStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	s := &Store{
+		RunnerDir: filepath.Join(dir, "runner"),
+		ExportDir: filepath.Join(dir, "export"),
+		GVKs:      []schema.GroupVersionKind{testGVK},
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func runIdents(runs []Run) []string {
+	idents := []string{}
+	for _, run := range runs {
+		idents = append(idents, run.Ident)
+	}
+	return idents
+}
+
+func TestStoreRead(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	dir := s.runnerArtifactsDir(testResource)
+	writeRun(t, dir, "100", "successful", 2*time.Hour)
+	writeRun(t, dir, "200", "failed", time.Hour)
+	writeRun(t, dir, "300", "", 0)
+	if err := os.Symlink(filepath.Join(dir, "300"), filepath.Join(dir, latest)); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	writeRun(t, s.exportArtifactsDir(testResource), "50", "successful", 3*time.Hour)
+
+	resources, err := s.Resources()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(resources, []Resource{testResource}) {
+		t.Fatalf("Unexpected resources: %#v", resources)
+	}
+
+	runs, err := s.Runs(testResource)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if idents := runIdents(runs); !reflect.DeepEqual(idents, []string{"300", "200", "100", "50"}) {
+		t.Fatalf("Unexpected runs: %v", idents)
+	}
+	if runs[1].Status != "failed" || runs[1].ReturnCode == nil || *runs[1].ReturnCode != 0 {
+		t.Fatalf("Unexpected run: %#v", runs[1])
+	}
+	if runs[0].Status != "" || runs[0].ReturnCode != nil {
+		t.Fatalf("Unexpected run in progress: %#v", runs[0])
+	}
+	// The artifacts of runs are only served once they were scrubbed.
+	if _, err := s.Stdout(testResource, "300"); err != ErrNotFound {
+		t.Fatalf("Expected the stdout of a run in progress not to be found; got %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "200", ScrubbedFile)); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if _, err := s.Events(testResource, "200"); err != ErrNotFound {
+		t.Fatalf("Expected the events of a run that was not scrubbed not to be found; got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "200", ScrubbedFile), nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	stdout, err := s.Stdout(testResource, "50")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(stdout) != "PLAY RECAP\n" {
+		t.Fatalf("Unexpected stdout: %q", stdout)
+	}
+
+	events, err := s.Events(testResource, "200")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 2 || string(events[0]) != `{"counter": 1, "event": "playbook_on_start"}` {
+		t.Fatalf("Unexpected events: %s", events)
+	}
+
+	facts, err := s.Facts(testResource, "200")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(facts) != 1 || string(facts["localhost"]) != `{"ansible_hostname": "operator"}` {
+		t.Fatalf("Unexpected facts: %s", facts)
+	}
+
+	notFound := []struct {
+		resource Resource
+		ident    string
+	}{
+		{resource: testResource, ident: "400"},
+		{resource: testResource, ident: latest},
+		{resource: testResource, ident: ".."},
+		{resource: Resource{Group: "other.example.com", Version: "v1alpha1", Kind: "Memcached", Namespace: "default", Name: "example"}, ident: "100"},
+		{resource: Resource{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached", Namespace: "..", Name: "example"}, ident: "100"},
+	}
+	for _, tc := range notFound {
+		if _, err := s.Stdout(tc.resource, tc.ident); err != ErrNotFound {
+			t.Fatalf("Expected ErrNotFound for %#v run %q; got %v", tc.resource, tc.ident, err)
+		}
+	}
+}
+
+func TestStoreExportAndPrune(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	s.MaxAge = 24 * time.Hour
+	s.MaxCount = 2
+
+	dir := s.runnerArtifactsDir(testResource)
+	writeRun(t, dir, "100", "successful", 48*time.Hour)
+	writeRun(t, dir, "200", "failed", 3*time.Hour)
+	writeRun(t, dir, "300", "successful", 2*time.Hour)
+	writeRun(t, dir, "400", "successful", time.Hour)
+	writeRun(t, dir, "500", "", 0)
+
+	if err := s.Export(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exported, err := readRuns(s.exportArtifactsDir(testResource))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sortRuns(exported)
+	if idents := runIdents(exported); len(idents) != 4 {
+		t.Fatalf("Expected the 4 finished runs to be exported; got %v", idents)
+	}
+	if _, err := os.Stat(filepath.Join(s.exportArtifactsDir(testResource), "200", "job_events", "1-aaa.json")); err != nil {
+		t.Fatalf("Expected job events to be exported: %v", err)
+	}
+
+	if err := s.Prune(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runs, err := readRuns(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sortRuns(runs)
+	if idents := runIdents(runs); !reflect.DeepEqual(idents, []string{"500", "400", "300", "200"}) {
+		t.Fatalf("Unexpected runner runs after pruning: %v", idents)
+	}
+	exported, err = readRuns(s.exportArtifactsDir(testResource))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sortRuns(exported)
+	if idents := runIdents(exported); !reflect.DeepEqual(idents, []string{"400", "300"}) {
+		t.Fatalf("Unexpected exported runs after pruning: %v", idents)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/operator-framework/operator-sdk/pkg/ansible/events"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
 	"github.com/operator-framework/operator-sdk/pkg/predicate"
 
@@ -28,10 +29,12 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crtpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -45,9 +48,30 @@ type Options struct {
 	GVK                         schema.GroupVersionKind
 	ReconcilePeriod             time.Duration
 	ManageStatus                bool
+	MaxTaskResults              int
+	SkipUnchanged               bool
+	MaxStaleness                time.Duration
 	WatchDependentResources     bool
 	WatchClusterScopedResources bool
 	MaxWorkers                  int
+	// RateLimiter, if set, delays the retries of failed reconciliations
+	// instead of the default rate limiter of the controller's workqueue.
+	RateLimiter workqueue.RateLimiter
+	// RunLimiter, if set, limits the number of concurrent runs of all
+	// controllers it is shared by.
+	RunLimiter *RunLimiter
+	// Selector, if set, selects the CRs that are reconciled. The requests of
+	// the watches added to the returned controller are filtered by it too.
+	// The CRs are listed and watched with its label selector.
+	Selector *Selector
+	// Namespace is the namespace whose CRs are watched, or all namespaces if
+	// empty.
+	Namespace string
+	// Hooks, if set, are called around the runs of the CRs of the controller.
+	Hooks Hooks
+	// Credentials, if set, issue the tokens that the runs authenticate to
+	// the proxy with.
+	Credentials *kubeconfig.Credentials
 }
 
 // Add - Creates a new ansible operator controller and adds it to the manager
@@ -58,16 +82,35 @@ func Add(mgr manager.Manager, options Options) *controller.Controller {
 	}
 	eventHandlers := append(options.EventHandlers, events.NewLoggingEventHandler(options.LoggingLevel))
 
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(options.GVK)
+	crClient := mgr.GetClient()
+	var src source.Source = &source.Kind{Type: u}
+	if options.Selector != nil && !options.Selector.Labels.Empty() {
+		selected, err := addSelectedInformer(mgr, options.GVK, options.Namespace, options.Selector.Labels)
+		if err != nil {
+			log.Error(err, "Failed to add informer of selected resources", "GVK", options.GVK.String())
+			os.Exit(1)
+		}
+		crClient, src = selected, &source.Informer{Informer: selected.informer}
+	}
+
 	aor := &AnsibleOperatorReconciler{
-		Client:          mgr.GetClient(),
+		Client:          crClient,
 		GVK:             options.GVK,
 		Runner:          options.Runner,
 		EventHandlers:   eventHandlers,
 		ReconcilePeriod: options.ReconcilePeriod,
 		ManageStatus:    options.ManageStatus,
+		MaxTaskResults:  options.MaxTaskResults,
+		SkipUnchanged:   options.SkipUnchanged,
+		MaxStaleness:    options.MaxStaleness,
+		RunLimiter:      options.RunLimiter,
+		Selector:        options.Selector,
+		Hooks:           options.Hooks,
+		Credentials:     options.Credentials,
 		APIReader:       mgr.GetAPIReader(),
 	}
-
 	scheme := mgr.GetScheme()
 	_, err := scheme.New(options.GVK)
 	if runtime.IsNotRegisteredError(err) {
@@ -83,19 +126,34 @@ func Add(mgr manager.Manager, options Options) *controller.Controller {
 	}
 
 	//Create new controller runtime controller and set the controller to watch GVK.
-	c, err := controller.New(fmt.Sprintf("%v-controller", strings.ToLower(options.GVK.Kind)), mgr, controller.Options{
-		Reconciler:              aor,
-		MaxConcurrentReconciles: options.MaxWorkers,
-	})
+	name := fmt.Sprintf("%v-controller", strings.ToLower(options.GVK.Kind))
+	pc, err := newPriorityController(name, mgr, aor, options.MaxWorkers, options.RateLimiter, requestPriority(crClient, options.GVK))
 	if err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
-	u := &unstructured.Unstructured{}
-	u.SetGroupVersionKind(options.GVK)
-	if err := c.Watch(&source.Kind{Type: u}, &crthandler.EnqueueRequestForObject{}, predicate.GenerationChangedPredicate{}); err != nil {
+	var c controller.Controller = pc
+	var prct crtpredicate.Predicate = predicate.GenerationChangedPredicate{}
+	if options.Selector != nil {
+		log.Info("Selecting resources", "GVK", options.GVK.String(), "selector", options.Selector.String())
+		prct = selectorPredicate(options.Selector)
+	}
+	if err := c.Watch(src, &crthandler.EnqueueRequestForObject{}, prct); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
+	if options.SkipUnchanged {
+		if _, ok := options.Runner.(runner.InputHasher); !ok {
+			log.Info("Runner does not hash its inputs, runs will not be skipped", "GVK", options.GVK.String())
+		}
+		// Watches of dependent resources are added to the returned
+		// controller, so that their changes are not skipped.
+		c = &trackingController{Controller: c, tracker: aor.changeTracker()}
+	}
+	if options.Selector != nil {
+		// Watches of dependent resources are added to the returned
+		// controller, so that they only enqueue selected resources.
+		c = &selectingController{Controller: c, selector: options.Selector, reader: crClient, gvk: options.GVK}
+	}
 	return &c
 }
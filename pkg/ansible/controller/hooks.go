@@ -0,0 +1,80 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	ansiblestatus "github.com/operator-framework/operator-sdk/pkg/ansible/controller/status"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Hooks - Go logic that is run around the runs of the CRs of a controller,
+// e.g. by hybrid operators. Hooks are called by the workers of the
+// controller, and must be safe for concurrent use if MaxWorkers is greater
+// than 1.
+type Hooks interface {
+	// PreRun is called before a CR is run, with the copy of the CR that the
+	// parameters of the run are built from. Changes to its spec and metadata
+	// change the parameters of the run, but are not saved. If PreRun
+	// returns true the run is skipped, and the CR is reconciled again after
+	// its reconcile period. If PreRun returns an error the run is skipped
+	// and the error is recorded in the CR's status.
+	PreRun(u *unstructured.Unstructured) (skip bool, err error)
+	// PostRun is called after a run, with the CR after its status was
+	// updated, the result of the run, and the status of the run. The events
+	// of the result were read by the controller already. An error is
+	// returned by the reconciliation, so that it is retried.
+	PostRun(u *unstructured.Unstructured, result runner.RunResult, status ansiblestatus.Status) error
+	// OnDelete is called when a CR that is marked for deletion is
+	// reconciled, before the finalizer of the CR is run. The finalizer is
+	// not run and not removed if OnDelete returns an error. OnDelete may be
+	// called more than once for a CR and must be idempotent.
+	OnDelete(u *unstructured.Unstructured) error
+}
+
+// HookFuncs - implements Hooks with functions. Hooks whose function is nil
+// do nothing.
+type HookFuncs struct {
+	PreRunFunc   func(u *unstructured.Unstructured) (bool, error)
+	PostRunFunc  func(u *unstructured.Unstructured, result runner.RunResult, status ansiblestatus.Status) error
+	OnDeleteFunc func(u *unstructured.Unstructured) error
+}
+
+var _ Hooks = HookFuncs{}
+
+// PreRun - implements Hooks.
+func (h HookFuncs) PreRun(u *unstructured.Unstructured) (bool, error) {
+	if h.PreRunFunc == nil {
+		return false, nil
+	}
+	return h.PreRunFunc(u)
+}
+
+// PostRun - implements Hooks.
+func (h HookFuncs) PostRun(u *unstructured.Unstructured, result runner.RunResult, status ansiblestatus.Status) error {
+	if h.PostRunFunc == nil {
+		return nil
+	}
+	return h.PostRunFunc(u, result, status)
+}
+
+// OnDelete - implements Hooks.
+func (h HookFuncs) OnDelete(u *unstructured.Unstructured) error {
+	if h.OnDeleteFunc == nil {
+		return nil
+	}
+	return h.OnDeleteFunc(u)
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/controller"
+	ansiblestatus "github.com/operator-framework/operator-sdk/pkg/ansible/controller/status"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestHooks(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "operator-sdk", Version: "v1beta1", Kind: "Testing"}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "reconcile"}}
+	newCR := func(deleted bool) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"size": int64(1)},
+		}}
+		u.SetGroupVersionKind(gvk)
+		u.SetNamespace(request.Namespace)
+		u.SetName(request.Name)
+		u.SetFinalizers([]string{"finalizer.operator-sdk"})
+		if deleted {
+			now := metav1.Now()
+			u.SetDeletionTimestamp(&now)
+		}
+		return u
+	}
+
+	testCases := []struct {
+		name            string
+		deleted         bool
+		hooks           controller.HookFuncs
+		shouldError     bool
+		expectedRuns    int
+		expectedSize    int64
+		expectedPostRun bool
+		expectedReason  string
+	}{
+		{
+			name: "PreRun changes the parameters",
+			hooks: controller.HookFuncs{
+				PreRunFunc: func(u *unstructured.Unstructured) (bool, error) {
+					return false, unstructured.SetNestedField(u.Object, int64(3), "spec", "size")
+				},
+			},
+			expectedRuns:    1,
+			expectedSize:    3,
+			expectedPostRun: true,
+			expectedReason:  ansiblestatus.SuccessfulReason,
+		},
+		{
+			name: "PreRun skips the run",
+			hooks: controller.HookFuncs{
+				PreRunFunc: func(u *unstructured.Unstructured) (bool, error) {
+					return true, nil
+				},
+			},
+			expectedRuns: 0,
+		},
+		{
+			name: "PreRun fails",
+			hooks: controller.HookFuncs{
+				PreRunFunc: func(u *unstructured.Unstructured) (bool, error) {
+					return false, errors.New("not ready")
+				},
+			},
+			shouldError:    true,
+			expectedRuns:   0,
+			expectedReason: ansiblestatus.FailedReason,
+		},
+		{
+			name:    "OnDelete fails",
+			deleted: true,
+			hooks: controller.HookFuncs{
+				OnDeleteFunc: func(u *unstructured.Unstructured) error {
+					return errors.New("backup failed")
+				},
+			},
+			shouldError:  true,
+			expectedRuns: 0,
+		},
+		{
+			name:    "OnDelete succeeds",
+			deleted: true,
+			hooks: controller.HookFuncs{
+				OnDeleteFunc: func(u *unstructured.Unstructured) error {
+					return nil
+				},
+			},
+			expectedRuns:    1,
+			expectedSize:    1,
+			expectedPostRun: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeRunner := &fake.Runner{
+				Finalizer: "finalizer.operator-sdk",
+				JobEvents: []eventapi.JobEvent{
+					eventapi.JobEvent{Event: eventapi.EventPlaybookOnStats, Created: eventapi.EventTime{Time: time.Now()}},
+				},
+			}
+			c := fakeclient.NewFakeClient(newCR(tc.deleted))
+			postRun := false
+			hooks := tc.hooks
+			hooks.PostRunFunc = func(u *unstructured.Unstructured, result runner.RunResult, status ansiblestatus.Status) error {
+				postRun = true
+				if c := ansiblestatus.GetCondition(status, ansiblestatus.RunningConditionType); tc.expectedReason != "" && (c == nil || c.Reason != tc.expectedReason) {
+					t.Fatalf("Unexpected status %#v, expected reason %v", status, tc.expectedReason)
+				}
+				return nil
+			}
+			aor := &controller.AnsibleOperatorReconciler{
+				GVK:          gvk,
+				Runner:       fakeRunner,
+				Client:       c,
+				APIReader:    c,
+				ManageStatus: true,
+				Hooks:        hooks,
+			}
+
+			_, err := aor.Reconcile(request)
+			if err != nil && !tc.shouldError {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err == nil && tc.shouldError {
+				t.Fatalf("Expected an error")
+			}
+			if len(fakeRunner.Runs) != tc.expectedRuns {
+				t.Fatalf("Unexpected number of runs %d, expected %d", len(fakeRunner.Runs), tc.expectedRuns)
+			}
+			if tc.expectedRuns > 0 {
+				size, _, _ := unstructured.NestedInt64(fakeRunner.Runs[0].Object, "spec", "size")
+				if size != tc.expectedSize {
+					t.Fatalf("Unexpected size %d in the parameters of the run, expected %d", size, tc.expectedSize)
+				}
+			}
+			if postRun != tc.expectedPostRun {
+				t.Fatalf("Unexpected call of PostRun %v, expected %v", postRun, tc.expectedPostRun)
+			}
+
+			if tc.deleted {
+				return
+			}
+			actual := &unstructured.Unstructured{}
+			actual.SetGroupVersionKind(gvk)
+			if err := c.Get(context.TODO(), request.NamespacedName, actual); err != nil {
+				t.Fatalf("Failed to get object: %v", err)
+			}
+			if size, _, _ := unstructured.NestedInt64(actual.Object, "spec", "size"); size != 1 {
+				t.Fatalf("Unexpected size %d of the CR, expected it to be unchanged", size)
+			}
+			if tc.shouldError {
+				sMap, _ := actual.Object["status"].(map[string]interface{})
+				if c := ansiblestatus.GetCondition(ansiblestatus.CreateFromMap(sMap), ansiblestatus.FailureConditionType); c == nil {
+					t.Fatalf("Expected a failure condition")
+				}
+			}
+		})
+	}
+}
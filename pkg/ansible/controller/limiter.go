@@ -0,0 +1,125 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"container/heap"
+	"strconv"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// PriorityAnnotation - annotation used by a user to set the priority of
+	// the runs of a CR. To use create a CR with an annotation
+	// "ansible.operator-sdk/priority: 10" or some other integer. The workers
+	// of a controller take the queued requests of CRs with a higher priority
+	// first, and runs of CRs with a higher priority take a runner slot before
+	// the waiting runs of CRs with a lower priority. The default priority is
+	// 0.
+	PriorityAnnotation = "ansible.operator-sdk/priority"
+)
+
+// Priority - returns the priority of the runs of a CR.
+func Priority(u *unstructured.Unstructured) int {
+	v, ok := u.GetAnnotations()[PriorityAnnotation]
+	if !ok {
+		return 0
+	}
+	priority, err := strconv.Atoi(v)
+	if err != nil {
+		log.Info("Invalid priority annotation", "err", err, "value", v)
+		return 0
+	}
+	return priority
+}
+
+// RunLimiter - limits the number of concurrent runs of all controllers it is
+// shared by. Runs that wait for a slot get it in the order of their
+// priority, and in the order they started waiting for the same priority.
+type RunLimiter struct {
+	mutex   sync.Mutex
+	max     int
+	running int
+	waiting runWaiters
+	seq     uint64
+}
+
+// NewRunLimiter - returns a RunLimiter that allows max concurrent runs.
+func NewRunLimiter(max int) *RunLimiter {
+	return &RunLimiter{max: max}
+}
+
+// Acquire - blocks until a run with the given priority may start. The
+// returned function must be called once the run finished.
+func (l *RunLimiter) Acquire(priority int) func() {
+	l.mutex.Lock()
+	if l.running < l.max && len(l.waiting) == 0 {
+		l.running++
+		l.mutex.Unlock()
+		return l.release
+	}
+	w := &runWaiter{priority: priority, seq: l.seq, ready: make(chan struct{})}
+	l.seq++
+	heap.Push(&l.waiting, w)
+	l.mutex.Unlock()
+	<-w.ready
+	return l.release
+}
+
+// release - hands the slot of a finished run to the waiting run with the
+// highest priority.
+func (l *RunLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.waiting) > 0 {
+		w := heap.Pop(&l.waiting).(*runWaiter)
+		close(w.ready)
+		return
+	}
+	l.running--
+}
+
+type runWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+}
+
+// runWaiters - a heap of waiting runs, ordered by priority and then by the
+// order they started waiting.
+type runWaiters []*runWaiter
+
+func (w runWaiters) Len() int { return len(w) }
+
+func (w runWaiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w runWaiters) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+
+func (w *runWaiters) Push(x interface{}) { *w = append(*w, x.(*runWaiter)) }
+
+func (w *runWaiters) Pop() interface{} {
+	old := *w
+	n := len(old)
+	x := old[n-1]
+	*w = old[:n-1]
+	return x
+}
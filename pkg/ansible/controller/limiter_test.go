@@ -0,0 +1,102 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestPriority(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    int
+	}{
+		{
+			name:     "no annotation",
+			expected: 0,
+		},
+		{
+			name:        "priority",
+			annotations: map[string]string{PriorityAnnotation: "10"},
+			expected:    10,
+		},
+		{
+			name:        "negative priority",
+			annotations: map[string]string{PriorityAnnotation: "-1"},
+			expected:    -1,
+		},
+		{
+			name:        "invalid priority",
+			annotations: map[string]string{PriorityAnnotation: "high"},
+			expected:    0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{}
+			u.SetAnnotations(tc.annotations)
+			if priority := Priority(u); priority != tc.expected {
+				t.Fatalf("Unexpected priority %d, expected %d", priority, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRunLimiter(t *testing.T) {
+	l := NewRunLimiter(1)
+	release := l.Acquire(0)
+
+	started := make(chan int)
+	for i, priority := range []int{0, 5, 1, 5} {
+		go func(priority int) {
+			release := l.Acquire(priority)
+			started <- priority
+			release()
+		}(priority)
+		// wait for the run to wait, so that the order of runs with the same
+		// priority is known
+		err := wait.PollImmediate(time.Millisecond, time.Second, func() (bool, error) {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			return len(l.waiting) == i+1, nil
+		})
+		if err != nil {
+			t.Fatalf("Run did not wait for a slot: %v", err)
+		}
+	}
+
+	release()
+	order := []int{}
+	for range []int{0, 5, 1, 5} {
+		order = append(order, <-started)
+	}
+	if expected := []int{5, 5, 1, 0}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Unexpected order of runs %v, expected %v", order, expected)
+	}
+	err := wait.PollImmediate(time.Millisecond, time.Second, func() (bool, error) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		return l.running == 0 && len(l.waiting) == 0, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected runs after all runs finished: %v", err)
+	}
+}
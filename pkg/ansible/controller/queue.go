@@ -0,0 +1,395 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// priorityQueue - a rate limited workqueue whose workers get the queued item
+// with the highest priority first, and the items with the same priority in
+// the order they were queued. Like the workqueues of client-go, an item is
+// queued at most once, and is not handed to a worker while another worker
+// processes it.
+type priorityQueue struct {
+	cond        *sync.Cond
+	queue       queuedItems
+	dirty       map[interface{}]int
+	processing  map[interface{}]struct{}
+	seq         uint64
+	stopping    bool
+	priority    func(interface{}) int
+	rateLimiter workqueue.RateLimiter
+	depth       prometheus.Gauge
+	adds        prometheus.Counter
+}
+
+var _ workqueue.RateLimitingInterface = &priorityQueue{}
+
+// newPriorityQueue - returns a workqueue that orders its items by priority,
+// and delays the retries of items with rateLimiter.
+func newPriorityQueue(name string, rateLimiter workqueue.RateLimiter, priority func(interface{}) int) *priorityQueue {
+	return &priorityQueue{
+		cond:        sync.NewCond(&sync.Mutex{}),
+		dirty:       map[interface{}]int{},
+		processing:  map[interface{}]struct{}{},
+		priority:    priority,
+		rateLimiter: rateLimiter,
+		depth:       metrics.QueueDepth(name),
+		adds:        metrics.QueueAdds(name),
+	}
+}
+
+// Add - queues an item with its current priority, unless it is queued
+// already.
+func (q *priorityQueue) Add(item interface{}) {
+	priority := q.priority(item)
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.stopping {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.adds.Inc()
+	q.dirty[item] = priority
+	if _, ok := q.processing[item]; ok {
+		// queued again once the worker processing it is done
+		return
+	}
+	q.push(item, priority)
+}
+
+func (q *priorityQueue) push(item interface{}, priority int) {
+	heap.Push(&q.queue, &queuedItem{item: item, priority: priority, seq: q.seq})
+	q.seq++
+	q.depth.Set(float64(len(q.queue)))
+	q.cond.Signal()
+}
+
+// AddAfter - queues an item once duration passed.
+func (q *priorityQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	time.AfterFunc(duration, func() { q.Add(item) })
+}
+
+// AddRateLimited - queues an item once the rate limiter allows it.
+func (q *priorityQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+// Forget - stops the rate limiter from tracking the retries of an item.
+func (q *priorityQueue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues - returns the number of retries of an item.
+func (q *priorityQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+// Len - returns the number of queued items.
+func (q *priorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.queue)
+}
+
+// Get - blocks until an item is queued, and returns the queued item with the
+// highest priority. shutdown is true once the queue was shut down.
+func (q *priorityQueue) Get() (item interface{}, shutdown bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.stopping {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return nil, true
+	}
+	item = heap.Pop(&q.queue).(*queuedItem).item
+	q.depth.Set(float64(len(q.queue)))
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+	return item, false
+}
+
+// Done - marks an item as processed, and queues it again if it was added
+// while it was processed.
+func (q *priorityQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if priority, ok := q.dirty[item]; ok {
+		q.push(item, priority)
+	}
+}
+
+// ShutDown - makes the queue ignore new items, and its workers stop once
+// the queued items were processed.
+func (q *priorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.stopping = true
+	q.cond.Broadcast()
+}
+
+// ShuttingDown - returns true once the queue was shut down.
+func (q *priorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.stopping
+}
+
+type queuedItem struct {
+	item     interface{}
+	priority int
+	seq      uint64
+}
+
+// queuedItems - a heap of queued items, ordered by priority and then by the
+// order they were queued.
+type queuedItems []*queuedItem
+
+func (q queuedItems) Len() int { return len(q) }
+
+func (q queuedItems) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queuedItems) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *queuedItems) Push(x interface{}) { *q = append(*q, x.(*queuedItem)) }
+
+func (q *queuedItems) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// requestPriority - returns the priority of the CR of a reconcile request,
+// read from reader, or 0 if the CR can't be read.
+func requestPriority(reader client.Reader, gvk schema.GroupVersionKind) func(interface{}) int {
+	return func(item interface{}) int {
+		req, ok := item.(reconcile.Request)
+		if !ok {
+			return 0
+		}
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		if err := reader.Get(context.TODO(), req.NamespacedName, u); err != nil {
+			return 0
+		}
+		return Priority(u)
+	}
+}
+
+// priorityController - a controller like the ones created by controller.New,
+// whose workers take the reconcile requests from a priorityQueue.
+type priorityController struct {
+	name        string
+	workers     int
+	reconciler  reconcile.Reconciler
+	cache       cache.Cache
+	setFields   func(interface{}) error
+	rateLimiter workqueue.RateLimiter
+	priority    func(interface{}) int
+
+	mutex   sync.Mutex
+	queue   *priorityQueue
+	watches []watchDescription
+	started bool
+}
+
+type watchDescription struct {
+	src        source.Source
+	handler    handler.EventHandler
+	predicates []predicate.Predicate
+}
+
+var _ controller.Controller = &priorityController{}
+
+// newPriorityController - creates a controller that reconciles requests with
+// reconciler, in the order of priority, and adds it to the manager. If
+// rateLimiter is nil, the default rate limiter of controller-runtime's
+// controllers delays the retries of failed reconciliations.
+func newPriorityController(name string, mgr manager.Manager, reconciler reconcile.Reconciler, workers int,
+	rateLimiter workqueue.RateLimiter, priority func(interface{}) int) (*priorityController, error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if rateLimiter == nil {
+		rateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
+	if err := mgr.SetFields(reconciler); err != nil {
+		return nil, err
+	}
+	c := &priorityController{
+		name:        name,
+		workers:     workers,
+		reconciler:  reconciler,
+		cache:       mgr.GetCache(),
+		setFields:   mgr.SetFields,
+		rateLimiter: rateLimiter,
+		priority:    priority,
+	}
+	return c, mgr.Add(c)
+}
+
+// Reconcile - reconciles a request with the reconciler of the controller.
+func (c *priorityController) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	return c.reconciler.Reconcile(req)
+}
+
+// Watch - adds a source whose events enqueue reconcile requests. The source
+// is started with the controller, or right away if it was started already.
+func (c *priorityController) Watch(src source.Source, evthdler handler.EventHandler, prct ...predicate.Predicate) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.setFields(src); err != nil {
+		return err
+	}
+	if err := c.setFields(evthdler); err != nil {
+		return err
+	}
+	for _, pr := range prct {
+		if err := c.setFields(pr); err != nil {
+			return err
+		}
+	}
+	c.watches = append(c.watches, watchDescription{src: src, handler: evthdler, predicates: prct})
+	if c.started {
+		log.Info("Starting EventSource", "controller", c.name, "source", src)
+		return src.Start(evthdler, c.queue, prct...)
+	}
+	return nil
+}
+
+// Start - starts the sources of the controller and, once the caches synced,
+// its workers. It blocks until stop is closed.
+func (c *priorityController) Start(stop <-chan struct{}) error {
+	c.mutex.Lock()
+	c.queue = newPriorityQueue(c.name, c.rateLimiter, c.priority)
+	defer c.queue.ShutDown()
+
+	err := func() error {
+		defer c.mutex.Unlock()
+		defer utilruntime.HandleCrash()
+
+		for _, watch := range c.watches {
+			log.Info("Starting EventSource", "controller", c.name, "source", watch.src)
+			if err := watch.src.Start(watch.handler, c.queue, watch.predicates...); err != nil {
+				return err
+			}
+		}
+
+		log.Info("Starting Controller", "controller", c.name)
+		if ok := c.cache.WaitForCacheSync(stop); !ok {
+			return fmt.Errorf("failed to wait for %s caches to sync", c.name)
+		}
+
+		log.Info("Starting workers", "controller", c.name, "worker count", c.workers)
+		for i := 0; i < c.workers; i++ {
+			go wait.Until(c.worker, time.Second, stop)
+		}
+		c.started = true
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	<-stop
+	log.Info("Stopping workers", "controller", c.name)
+	return nil
+}
+
+func (c *priorityController) worker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem - reconciles the next request of the queue, and
+// requeues it as the result of the reconciliation requires.
+func (c *priorityController) processNextWorkItem() bool {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(obj)
+
+	req, ok := obj.(reconcile.Request)
+	if !ok {
+		c.queue.Forget(obj)
+		log.Error(nil, "Queue item was not a Request", "controller", c.name, "type", fmt.Sprintf("%T", obj), "value", obj)
+		return true
+	}
+
+	timer := metrics.ControllerReconcileTimer(c.name)
+	result, err := c.reconciler.Reconcile(req)
+	timer.ObserveDuration()
+	switch {
+	case err != nil:
+		c.queue.AddRateLimited(req)
+		log.Error(err, "Reconciler error", "controller", c.name, "request", req)
+		metrics.ControllerReconcileError(c.name)
+		metrics.ControllerReconciled(c.name, "error")
+		return false
+	case result.RequeueAfter > 0:
+		c.queue.Forget(obj)
+		c.queue.AddAfter(req, result.RequeueAfter)
+		metrics.ControllerReconciled(c.name, "requeue_after")
+	case result.Requeue:
+		c.queue.AddRateLimited(req)
+		metrics.ControllerReconciled(c.name, "requeue")
+	default:
+		c.queue.Forget(obj)
+		log.V(1).Info("Successfully Reconciled", "controller", c.name, "request", req)
+		metrics.ControllerReconciled(c.name, "success")
+	}
+	return true
+}
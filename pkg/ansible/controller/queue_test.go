@@ -0,0 +1,160 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func request(name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	priorities := map[interface{}]int{request("low"): 0, request("high"): 10, request("medium"): 5, request("medium-2"): 5}
+	q := newPriorityQueue("testing-queue", workqueue.DefaultControllerRateLimiter(), func(item interface{}) int {
+		return priorities[item]
+	})
+	defer q.ShutDown()
+
+	for _, name := range []string{"low", "medium", "high", "medium-2", "low"} {
+		q.Add(request(name))
+	}
+	if q.Len() != 4 {
+		t.Fatalf("Unexpected queue length %d, expected 4", q.Len())
+	}
+	order := []string{}
+	for q.Len() > 0 {
+		item, _ := q.Get()
+		order = append(order, item.(reconcile.Request).Name)
+		q.Done(item)
+	}
+	if expected := []string{"high", "medium", "medium-2", "low"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Unexpected order of requests %v, expected %v", order, expected)
+	}
+
+	// an item added while it is processed is queued again once it is done
+	q.Add(request("low"))
+	item, _ := q.Get()
+	q.Add(request("low"))
+	if q.Len() != 0 {
+		t.Fatalf("Unexpected queue length %d while the item is processed, expected 0", q.Len())
+	}
+	q.Done(item)
+	if q.Len() != 1 {
+		t.Fatalf("Unexpected queue length %d after the item was processed, expected 1", q.Len())
+	}
+	item, _ = q.Get()
+	q.Done(item)
+
+	q.ShutDown()
+	q.Add(request("low"))
+	if _, shutdown := q.Get(); !shutdown {
+		t.Fatalf("Expected queue to be shut down; got an item")
+	}
+}
+
+// recordingRateLimiter - a rate limiter that records the items it delayed.
+type recordingRateLimiter struct {
+	workqueue.RateLimiter
+	delayed []interface{}
+}
+
+func (l *recordingRateLimiter) When(item interface{}) time.Duration {
+	l.delayed = append(l.delayed, item)
+	return l.RateLimiter.When(item)
+}
+
+// controllerManager - a manager with just enough methods for
+// newPriorityController.
+type controllerManager struct {
+	manager.Manager
+}
+
+func (controllerManager) SetFields(interface{}) error { return nil }
+func (controllerManager) GetCache() cache.Cache       { return nil }
+func (controllerManager) Add(manager.Runnable) error  { return nil }
+
+type fakeReconciler struct {
+	err error
+}
+
+func (r fakeReconciler) Reconcile(reconcile.Request) (reconcile.Result, error) {
+	return reconcile.Result{}, r.err
+}
+
+func TestPriorityControllerRateLimiter(t *testing.T) {
+	limiter := &recordingRateLimiter{RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Second)}
+	reconciler := fakeReconciler{err: errors.New("failed run")}
+	c, err := newPriorityController("testing-controller", controllerManager{}, reconciler, 1, limiter, func(interface{}) int { return 0 })
+	if err != nil {
+		t.Fatalf("Failed to create controller: %v", err)
+	}
+	c.queue = newPriorityQueue(c.name, c.rateLimiter, c.priority)
+	defer c.queue.ShutDown()
+
+	c.queue.Add(request("example"))
+	if c.processNextWorkItem() {
+		t.Fatalf("Expected failed reconciliation to back off the worker")
+	}
+	if expected := []interface{}{request("example")}; !reflect.DeepEqual(limiter.delayed, expected) {
+		t.Fatalf("Unexpected delayed items %v, expected %v", limiter.delayed, expected)
+	}
+	if c.queue.NumRequeues(request("example")) != 1 {
+		t.Fatalf("Unexpected requeues %d, expected 1", c.queue.NumRequeues(request("example")))
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Example"}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	u.SetNamespace("default")
+	u.SetName("example")
+	u.SetAnnotations(map[string]string{PriorityAnnotation: "10"})
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	priority := requestPriority(fakeclient.NewFakeClientWithScheme(scheme, u), gvk)
+
+	testCases := []struct {
+		name     string
+		item     interface{}
+		expected int
+	}{
+		{name: "annotated CR", item: request("example"), expected: 10},
+		{name: "missing CR", item: request("missing"), expected: 0},
+		{name: "not a request", item: "example", expected: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if p := priority(tc.item); p != tc.expected {
+				t.Fatalf("Unexpected priority %d, expected %d", p, tc.expected)
+			}
+		})
+	}
+}
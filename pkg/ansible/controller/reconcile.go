@@ -23,6 +23,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	ansiblestatus "github.com/operator-framework/operator-sdk/pkg/ansible/controller/status"
@@ -59,6 +60,39 @@ type AnsibleOperatorReconciler struct {
 	EventHandlers   []events.EventHandler
 	ReconcilePeriod time.Duration
 	ManageStatus    bool
+	// MaxTaskResults is the number of most recent task results recorded in
+	// the CR's status. Task results are not recorded if it is zero.
+	MaxTaskResults int
+	// SkipUnchanged skips runs for a CR whose inputs, as hashed by the
+	// Runner, and dependent resources did not change since its last
+	// successful run. The Runner must implement runner.InputHasher.
+	SkipUnchanged bool
+	// MaxStaleness is the age of the last successful run for a CR after
+	// which a run is no longer skipped. Runs are skipped regardless of the
+	// age of the last run if it is zero.
+	MaxStaleness time.Duration
+	// RunLimiter, if set, limits the number of concurrent runs of the
+	// reconcilers it is shared by.
+	RunLimiter *RunLimiter
+	// Selector, if set, selects the CRs that are reconciled. Requests for
+	// other CRs are ignored.
+	Selector *Selector
+	// Hooks, if set, are called around the runs of the CRs.
+	Hooks Hooks
+	// Credentials, if set, issue the token that a run authenticates to the
+	// proxy with. The token is revoked once the run ended.
+	Credentials *kubeconfig.Credentials
+
+	trackerOnce sync.Once
+	tracker     *changeTracker
+}
+
+// changeTracker - returns the tracker of the inputs of runs for CRs.
+func (r *AnsibleOperatorReconciler) changeTracker() *changeTracker {
+	r.trackerOnce.Do(func() {
+		r.tracker = newChangeTracker()
+	})
+	return r.tracker
 }
 
 // Reconcile - handle the event.
@@ -67,11 +101,19 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 	u.SetGroupVersionKind(r.GVK)
 	err := r.Client.Get(context.TODO(), request.NamespacedName, u)
 	if apierrors.IsNotFound(err) {
+		r.changeTracker().forget(request.NamespacedName)
 		return reconcile.Result{}, nil
 	}
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	if r.Selector != nil && !r.Selector.Matches(u) {
+		// The resource is reconciled by another operator, e.g. a shard
+		// whose selector it matches.
+		log.V(1).Info("Resource is not selected, ignoring request", "name", u.GetName(), "namespace", u.GetNamespace())
+		r.changeTracker().forget(request.NamespacedName)
+		return reconcile.Result{}, nil
+	}
 
 	ident := strconv.Itoa(rand.Int())
 	logger := logf.Log.WithName("reconciler").WithValues(
@@ -113,6 +155,36 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		logger.Info("Resource is terminated, skipping reconciliation")
 		return reconcile.Result{}, nil
 	}
+	if deleted && r.Hooks != nil {
+		if err := r.Hooks.OnDelete(u); err != nil {
+			logger.Error(err, "OnDelete hook failed")
+			return reconcileResult, err
+		}
+	}
+
+	checkMode := runner.CheckMode(u)
+	inputHash := ""
+	if r.SkipUnchanged && !deleted && !checkMode {
+		if hasher, ok := r.Runner.(runner.InputHasher); ok {
+			inputHash, err = hasher.InputHash(u)
+			if err != nil {
+				logger.Error(err, "Unable to hash the inputs of the run")
+			} else if r.changeTracker().unchanged(request.NamespacedName, inputHash, r.MaxStaleness, time.Now()) {
+				logger.V(1).Info("Inputs and dependent resources did not change, skipping reconciliation")
+				metrics.ReconcileSkipped(r.GVK.String())
+				return reconcileResult, nil
+			}
+		}
+	}
+
+	if r.RunLimiter != nil {
+		priority := Priority(u)
+		logger.V(1).Info("Waiting for a runner slot", "priority", priority)
+		metrics.RunWaiting(r.GVK.String())
+		release := r.RunLimiter.Acquire(priority)
+		metrics.RunDoneWaiting(r.GVK.String())
+		defer release()
+	}
 
 	spec := u.Object["spec"]
 	_, ok := spec.(map[string]interface{})
@@ -125,7 +197,29 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		u.Object["spec"] = map[string]interface{}{}
 	}
 
-	if r.ManageStatus {
+	// The parameters of the run are built from a copy of the CR, which the
+	// PreRun hook may change without the changes being saved.
+	params := u
+	if r.Hooks != nil {
+		params = u.DeepCopy()
+		skip, err := r.Hooks.PreRun(params)
+		if err != nil {
+			errmark := r.markError(u, request.NamespacedName, fmt.Sprintf("PreRun hook failed: %v", err))
+			if errmark != nil {
+				logger.Error(errmark, "Unable to mark error to run reconciliation")
+			}
+			logger.Error(err, "PreRun hook failed")
+			return reconcileResult, err
+		}
+		if skip {
+			logger.V(1).Info("PreRun hook skipped the run")
+			return reconcileResult, nil
+		}
+	}
+
+	// The conditions of check mode runs are only set once they finished, so
+	// that the status keeps reporting the last run that changed the CR.
+	if r.ManageStatus && !checkMode {
 		errmark := r.markRunning(u, request.NamespacedName)
 		if errmark != nil {
 			logger.Error(errmark, "Unable to update the status to mark cr as running")
@@ -140,7 +234,17 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		UID:        u.GetUID(),
 	}
 
-	kc, err := kubeconfig.Create(ownerRef, "http://localhost:8888", u.GetNamespace())
+	owner := kubeconfig.NamespacedOwnerReference{OwnerReference: ownerRef, Namespace: u.GetNamespace(), CheckMode: checkMode}
+	password := "unused"
+	if r.Credentials != nil {
+		password, err = r.Credentials.Issue(owner)
+		if err != nil {
+			logger.Error(err, "Unable to issue proxy token")
+			return reconcileResult, err
+		}
+		defer r.Credentials.Revoke(password)
+	}
+	kc, err := kubeconfig.CreateForOwner(owner, "http://localhost:8888", password)
 	if err != nil {
 		errmark := r.markError(u, request.NamespacedName, "Unable to run reconciliation")
 		if errmark != nil {
@@ -154,7 +258,7 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 			logger.Error(err, "Failed to remove generated kubeconfig file")
 		}
 	}()
-	result, err := r.Runner.Run(ident, u, kc.Name())
+	result, err := r.Runner.Run(ident, params, kc.Name())
 	if err != nil {
 		errmark := r.markError(u, request.NamespacedName, "Unable to run reconciliation")
 		if errmark != nil {
@@ -167,6 +271,8 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 	// iterate events from ansible, looking for the final one
 	statusEvent := eventapi.StatusJobEvent{}
 	failureMessages := eventapi.FailureMessages{}
+	taskResults := []ansiblestatus.TaskResult{}
+	checkModeChanges := []string{}
 	for event := range result.Events() {
 		for _, eHandler := range r.EventHandlers {
 			go eHandler.Handle(ident, u, event)
@@ -185,6 +291,19 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		if event.Event == eventapi.EventRunnerOnFailed && !event.IgnoreError() {
 			failureMessages = append(failureMessages, event.GetFailedPlaybookMessage())
 		}
+		if checkMode {
+			if change, ok := ansiblestatus.NewCheckModeChangeFromJobEvent(event); ok {
+				checkModeChanges = append(checkModeChanges, change)
+			}
+		}
+		if r.MaxTaskResults > 0 {
+			if tr, ok := ansiblestatus.NewTaskResultFromJobEvent(event); ok {
+				taskResults = append(taskResults, tr)
+				if len(taskResults) > r.MaxTaskResults {
+					taskResults = taskResults[1:]
+				}
+			}
+		}
 	}
 	if statusEvent.Event == "" {
 		eventErr := errors.New("did not receive playbook_on_stats event")
@@ -213,6 +332,11 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 	// We only want to update the CustomResource once, so we'll track changes
 	// and do it at the end
 	runSuccessful := len(failureMessages) == 0
+	if runSuccessful && inputHash != "" {
+		r.changeTracker().record(request.NamespacedName, inputHash, time.Now())
+	} else {
+		r.changeTracker().forget(request.NamespacedName)
+	}
 
 	// The finalizer has run successfully, time to remove it
 	if deleted && finalizerExists && runSuccessful {
@@ -229,23 +353,42 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 			return reconcileResult, err
 		}
 	}
-	if r.ManageStatus {
-		errmark := r.markDone(u, request.NamespacedName, statusEvent, failureMessages)
-		if errmark != nil {
-			logger.Error(errmark, "Failed to mark status done")
+	var runErr error
+	switch {
+	case checkMode:
+		logger.Info("Finished check mode run", "changes", len(checkModeChanges))
+		if r.ManageStatus {
+			runErr = r.markCheckMode(u, request.NamespacedName, statusEvent, failureMessages, checkModeChanges)
+			if runErr != nil {
+				logger.Error(runErr, "Failed to mark status of check mode run")
+			}
+		}
+		if !runSuccessful {
+			runErr = errors.New("check mode run failed")
+		}
+	case r.ManageStatus:
+		runErr = r.markDone(u, request.NamespacedName, statusEvent, failureMessages, taskResults)
+		if runErr != nil {
+			logger.Error(runErr, "Failed to mark status done")
 		}
 		// re-trigger reconcile because of failures
 		if !runSuccessful {
-			return reconcileResult, errors.New("event runner on failed")
+			runErr = errors.New("event runner on failed")
 		}
-		return reconcileResult, errmark
+	case !runSuccessful:
+		// re-trigger reconcile because of failures
+		runErr = errors.New("received failed task event")
 	}
 
-	// re-trigger reconcile because of failures
-	if !runSuccessful {
-		return reconcileResult, errors.New("received failed task event")
+	if r.Hooks != nil {
+		if err := r.Hooks.PostRun(u, result, getStatus(u)); err != nil {
+			logger.Error(err, "PostRun hook failed")
+			if runErr == nil {
+				runErr = err
+			}
+		}
 	}
-	return reconcileResult, nil
+	return reconcileResult, runErr
 }
 
 func (r *AnsibleOperatorReconciler) markRunning(u *unstructured.Unstructured, namespacedName types.NamespacedName) error {
@@ -314,7 +457,7 @@ func (r *AnsibleOperatorReconciler) markError(u *unstructured.Unstructured, name
 	return r.Client.Status().Update(context.TODO(), u)
 }
 
-func (r *AnsibleOperatorReconciler) markDone(u *unstructured.Unstructured, namespacedName types.NamespacedName, statusEvent eventapi.StatusJobEvent, failureMessages eventapi.FailureMessages) error {
+func (r *AnsibleOperatorReconciler) markDone(u *unstructured.Unstructured, namespacedName types.NamespacedName, statusEvent eventapi.StatusJobEvent, failureMessages eventapi.FailureMessages, taskResults []ansiblestatus.TaskResult) error {
 	logger := logf.Log.WithName("markDone")
 	// Get the latest resource to prevent updating a stale status.
 	if err := r.APIReader.Get(context.TODO(), namespacedName, u); err != nil {
@@ -357,12 +500,55 @@ func (r *AnsibleOperatorReconciler) markDone(u *unstructured.Unstructured, names
 		ansiblestatus.RemoveCondition(&crStatus, ansiblestatus.FailureConditionType)
 		ansiblestatus.SetCondition(&crStatus, *c)
 	}
+	if r.MaxTaskResults > 0 {
+		crStatus.TaskResults = taskResults
+	}
+	// The result of a previous check mode run is outdated once the CR was
+	// reconciled.
+	ansiblestatus.RemoveCondition(&crStatus, ansiblestatus.CheckModeConditionType)
 	// This needs the status subresource to be enabled by default.
 	u.Object["status"] = crStatus.GetJSONMap()
 
 	return r.Client.Status().Update(context.TODO(), u)
 }
 
+// markCheckMode - records the result of a check mode run in the check mode
+// condition. It does not touch the other conditions, which report the last
+// run that reconciled the CR.
+func (r *AnsibleOperatorReconciler) markCheckMode(u *unstructured.Unstructured, namespacedName types.NamespacedName, statusEvent eventapi.StatusJobEvent, failureMessages eventapi.FailureMessages, changes []string) error {
+	logger := logf.Log.WithName("markCheckMode")
+	// Get the latest resource to prevent updating a stale status.
+	if err := r.APIReader.Get(context.TODO(), namespacedName, u); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Resource not found, assuming it was deleted")
+			return nil
+		}
+		return err
+	}
+	crStatus := getStatus(u)
+	ansibleStatus := ansiblestatus.NewAnsibleResultFromStatusJobEvent(statusEvent)
+
+	status, reason, message := v1.ConditionTrue, ansiblestatus.NoChangesReason, ansiblestatus.NoChangesMessage
+	switch {
+	case len(failureMessages) > 0:
+		status, reason, message = v1.ConditionFalse, ansiblestatus.FailedReason, strings.Join(failureMessages, "\n")
+	case len(changes) > 0:
+		reason, message = ansiblestatus.ChangesPendingReason, strings.Join(changes, "\n")
+	}
+	message = ansiblestatus.TruncateMessage(message, ansiblestatus.MaxCheckModeMessageLength)
+	c := ansiblestatus.NewCondition(
+		ansiblestatus.CheckModeConditionType,
+		status,
+		ansibleStatus,
+		reason,
+		message,
+	)
+	ansiblestatus.SetCondition(&crStatus, *c)
+	u.Object["status"] = crStatus.GetJSONMap()
+
+	return r.Client.Status().Update(context.TODO(), u)
+}
+
 func contains(l []string, s string) bool {
 	for _, elem := range l {
 		if elem == s {
@@ -16,6 +16,7 @@ package controller_test
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 	"time"
@@ -54,6 +55,7 @@ func TestReconcile(t *testing.T) {
 		Request         reconcile.Request
 		ShouldError     bool
 		ManageStatus    bool
+		MaxTaskResults  int
 	}{
 		{
 			Name:            "cr not found",
@@ -132,6 +134,165 @@ func TestReconcile(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:            "completed reconcile with task results",
+			GVK:             gvk,
+			ReconcilePeriod: 5 * time.Second,
+			ManageStatus:    true,
+			MaxTaskResults:  1,
+			Runner: &fake.Runner{
+				JobEvents: []eventapi.JobEvent{
+					eventapi.JobEvent{
+						Event:     eventapi.EventRunnerOnOk,
+						EventData: map[string]interface{}{"task": "Gather facts", "host": "localhost"},
+					},
+					eventapi.JobEvent{
+						Event: eventapi.EventRunnerOnOk,
+						EventData: map[string]interface{}{
+							"task": "Create deployment",
+							"host": "localhost",
+							"res":  map[string]interface{}{"changed": true},
+						},
+					},
+					eventapi.JobEvent{
+						Event:   eventapi.EventPlaybookOnStats,
+						Created: eventapi.EventTime{Time: eventTime},
+					},
+				},
+			},
+			Client: fakeclient.NewFakeClient(&unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"name":      "reconcile",
+						"namespace": "default",
+					},
+					"apiVersion": "operator-sdk/v1beta1",
+					"kind":       "Testing",
+				},
+			}),
+			Result: reconcile.Result{
+				RequeueAfter: 5 * time.Second,
+			},
+			Request: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      "reconcile",
+					Namespace: "default",
+				},
+			},
+			ExpectedObject: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"name":      "reconcile",
+						"namespace": "default",
+					},
+					"apiVersion": "operator-sdk/v1beta1",
+					"kind":       "Testing",
+					"spec":       map[string]interface{}{},
+					"status": map[string]interface{}{
+						"conditions": []interface{}{
+							map[string]interface{}{
+								"status": "True",
+								"type":   "Running",
+								"ansibleResult": map[string]interface{}{
+									"changed":    int64(0),
+									"failures":   int64(0),
+									"ok":         int64(0),
+									"skipped":    int64(0),
+									"completion": eventTime.Format("2006-01-02T15:04:05.99999999"),
+								},
+								"message": "Awaiting next reconciliation",
+								"reason":  "Successful",
+							},
+						},
+						"taskResults": []interface{}{
+							map[string]interface{}{
+								"task":     "Create deployment",
+								"host":     "localhost",
+								"duration": "0s",
+								"changed":  true,
+								"failed":   false,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:            "check mode reconcile",
+			GVK:             gvk,
+			ReconcilePeriod: 5 * time.Second,
+			ManageStatus:    true,
+			Runner: &fake.Runner{
+				JobEvents: []eventapi.JobEvent{
+					eventapi.JobEvent{
+						Event:     eventapi.EventRunnerOnOk,
+						EventData: map[string]interface{}{"task": "Gather facts", "host": "localhost"},
+					},
+					eventapi.JobEvent{
+						Event: eventapi.EventRunnerOnOk,
+						EventData: map[string]interface{}{
+							"task": "Create deployment",
+							"host": "localhost",
+							"res":  map[string]interface{}{"changed": true},
+						},
+						StdOut: "+  replicas: 3",
+					},
+					eventapi.JobEvent{
+						Event:   eventapi.EventPlaybookOnStats,
+						Created: eventapi.EventTime{Time: eventTime},
+					},
+				},
+			},
+			Client: fakeclient.NewFakeClient(&unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"name":        "reconcile",
+						"namespace":   "default",
+						"annotations": map[string]interface{}{runner.CheckModeAnnotation: "true"},
+					},
+					"apiVersion": "operator-sdk/v1beta1",
+					"kind":       "Testing",
+				},
+			}),
+			Result: reconcile.Result{
+				RequeueAfter: 5 * time.Second,
+			},
+			Request: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      "reconcile",
+					Namespace: "default",
+				},
+			},
+			ExpectedObject: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"name":        "reconcile",
+						"namespace":   "default",
+						"annotations": map[string]interface{}{runner.CheckModeAnnotation: "true"},
+					},
+					"apiVersion": "operator-sdk/v1beta1",
+					"kind":       "Testing",
+					"spec":       map[string]interface{}{},
+					"status": map[string]interface{}{
+						"conditions": []interface{}{
+							map[string]interface{}{
+								"status": "True",
+								"type":   "CheckMode",
+								"ansibleResult": map[string]interface{}{
+									"changed":    int64(0),
+									"failures":   int64(0),
+									"ok":         int64(0),
+									"skipped":    int64(0),
+									"completion": eventTime.Format("2006-01-02T15:04:05.99999999"),
+								},
+								"message": "TASK [Create deployment] would change localhost",
+								"reason":  "ChangesPending",
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			Name:         "Failure event runner on failed with manageStatus == true",
 			GVK:          gvk,
@@ -528,6 +689,7 @@ func TestReconcile(t *testing.T) {
 				EventHandlers:   tc.EventHandlers,
 				ReconcilePeriod: tc.ReconcilePeriod,
 				ManageStatus:    tc.ManageStatus,
+				MaxTaskResults:  tc.MaxTaskResults,
 			}
 			result, err := aor.Reconcile(tc.Request)
 			if err != nil && !tc.ShouldError {
@@ -557,6 +719,9 @@ func TestReconcile(t *testing.T) {
 				expectedStatus := ansiblestatus.CreateFromMap(sMap)
 				sMap, _ = actualObject.Object["status"].(map[string]interface{})
 				actualStatus := ansiblestatus.CreateFromMap(sMap)
+				if !reflect.DeepEqual(expectedStatus.TaskResults, actualStatus.TaskResults) {
+					t.Fatalf("Task results not the same\nexpected: %#v\nactual: %#v", expectedStatus.TaskResults, actualStatus.TaskResults)
+				}
 				if len(expectedStatus.Conditions) != len(actualStatus.Conditions) {
 					t.Fatalf("Status conditions not the same\nexpected: %v\nactual: %v", expectedStatus, actualStatus)
 				}
@@ -578,3 +743,65 @@ func TestReconcile(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileSkipUnchanged(t *testing.T) {
+	gvk := schema.GroupVersionKind{
+		Kind:    "Testing",
+		Group:   "operator-sdk",
+		Version: "v1beta1",
+	}
+	cr := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":      "reconcile",
+				"namespace": "default",
+			},
+			"apiVersion": "operator-sdk/v1beta1",
+			"kind":       "Testing",
+			"spec":       map[string]interface{}{"size": int64(1)},
+		},
+	}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "reconcile", Namespace: "default"}}
+	fakeRunner := &fake.Runner{
+		JobEvents: []eventapi.JobEvent{
+			eventapi.JobEvent{Event: eventapi.EventPlaybookOnStats},
+		},
+	}
+	c := fakeclient.NewFakeClient(cr)
+	aor := &controller.AnsibleOperatorReconciler{
+		GVK:             gvk,
+		Runner:          fakeRunner,
+		Client:          c,
+		APIReader:       c,
+		ReconcilePeriod: 5 * time.Second,
+		ManageStatus:    true,
+		SkipUnchanged:   true,
+	}
+
+	if _, err := aor.Reconcile(request); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// runs fail from now on, so that only skipped runs succeed
+	fakeRunner.Error = errors.New("run was not skipped")
+	result, err := aor.Reconcile(request)
+	if err != nil {
+		t.Fatalf("Expected the unchanged run to be skipped; got error: %v", err)
+	}
+	if result.RequeueAfter != 5*time.Second {
+		t.Fatalf("Unexpected result of the skipped run: %#v", result)
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	if err := c.Get(context.TODO(), request.NamespacedName, u); err != nil {
+		t.Fatalf("Failed to get object: %v", err)
+	}
+	u.Object["spec"] = map[string]interface{}{"size": int64(2)}
+	if err := c.Update(context.TODO(), u); err != nil {
+		t.Fatalf("Failed to update object: %v", err)
+	}
+	if _, err := aor.Reconcile(request); err == nil {
+		t.Fatalf("Expected the run with a changed spec not to be skipped")
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/predicate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crtpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Selector - selects the CRs that a controller reconciles by their labels,
+// annotations and fields.
+type Selector struct {
+	Labels      labels.Selector
+	Annotations labels.Selector
+	Fields      fields.Selector
+}
+
+// NewSelector - parses a label selector, an annotation selector in the
+// syntax of label selectors, and a field selector. It returns nil if all of
+// them are empty.
+func NewSelector(labelSelector, annotationSelector, fieldSelector string) (*Selector, error) {
+	if labelSelector == "" && annotationSelector == "" && fieldSelector == "" {
+		return nil, nil
+	}
+	s := &Selector{}
+	var err error
+	if s.Labels, err = labels.Parse(labelSelector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", labelSelector, err)
+	}
+	if s.Annotations, err = labels.Parse(annotationSelector); err != nil {
+		return nil, fmt.Errorf("invalid annotation selector %q: %w", annotationSelector, err)
+	}
+	if s.Fields, err = fields.ParseSelector(fieldSelector); err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %w", fieldSelector, err)
+	}
+	return s, nil
+}
+
+// Matches - returns true if a CR is selected. The fields of the field
+// selector are the paths of the CR's fields separated by ".", e.g.
+// "metadata.name" or "spec.tier".
+func (s *Selector) Matches(u *unstructured.Unstructured) bool {
+	if !s.Labels.Matches(labels.Set(u.GetLabels())) {
+		return false
+	}
+	if !s.Annotations.Matches(labels.Set(u.GetAnnotations())) {
+		return false
+	}
+	fieldSet := fields.Set{}
+	for _, r := range s.Fields.Requirements() {
+		value, found, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(r.Field, ".")...)
+		if err == nil && found && value != nil {
+			fieldSet[r.Field] = fmt.Sprint(value)
+		}
+	}
+	return s.Fields.Matches(fieldSet)
+}
+
+func (s *Selector) String() string {
+	return fmt.Sprintf("labels: %q, annotations: %q, fields: %q", s.Labels, s.Annotations, s.Fields)
+}
+
+// addSelectedInformer - adds an informer of the CRs of a GVK to the manager
+// that only lists and watches the CRs matching the label selector, so that
+// the CRs that are not selected are not cached. The shared cache of the
+// manager can't apply a label selector to the informer of a single GVK.
+func addSelectedInformer(mgr manager.Manager, gvk schema.GroupVersionKind, namespace string, selector labels.Selector) (*selectedClient, error) {
+	mapping, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = metav1.NamespaceAll
+	}
+	dc, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	informer := newSelectedInformer(dc, mapping.Resource, namespace, selector)
+	if err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		informer.Run(stop)
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+	return &selectedClient{Client: mgr.GetClient(), gvk: gvk, resource: mapping.Resource.GroupResource(), informer: informer}, nil
+}
+
+// newSelectedInformer - returns an informer of a resource that lists and
+// watches the objects matching the label selector.
+func newSelectedInformer(dc dynamic.Interface, resource schema.GroupVersionResource, namespace string, selector labels.Selector) toolscache.SharedIndexInformer {
+	return dynamicinformer.NewFilteredDynamicInformer(dc, resource, namespace, 0,
+		toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = selector.String()
+		},
+	).Informer()
+}
+
+// selectedClient - a client.Client that gets the CRs of a GVK from the
+// informer of the selected CRs, and everything else with the wrapped client.
+// CRs that are not selected are not found.
+type selectedClient struct {
+	client.Client
+	gvk      schema.GroupVersionKind
+	resource schema.GroupResource
+	informer toolscache.SharedIndexInformer
+}
+
+func (c *selectedClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || u.GroupVersionKind() != c.gvk {
+		return c.Client.Get(ctx, key, obj)
+	}
+	if !toolscache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		return fmt.Errorf("failed to wait for the cache of selected %s to sync", c.gvk)
+	}
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = key.Namespace + "/" + key.Name
+	}
+	item, exists, err := c.informer.GetIndexer().GetByKey(storeKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(c.resource, key.Name)
+	}
+	item.(*unstructured.Unstructured).DeepCopyInto(u)
+	return nil
+}
+
+// selectorPredicate - filters the events of the CRs of a controller. Updates
+// are passed on if the generation of a selected CR changed, as with
+// predicate.GenerationChangedPredicate, or if a CR became selected.
+func selectorPredicate(s *Selector) crtpredicate.Funcs {
+	matches := func(obj interface{}) bool {
+		u, ok := obj.(*unstructured.Unstructured)
+		return ok && s.Matches(u)
+	}
+	return crtpredicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return matches(e.Object)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return matches(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !matches(e.ObjectNew) {
+				return false
+			}
+			return !matches(e.ObjectOld) || predicate.GenerationChangedPredicate{}.Update(e)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return matches(e.Object)
+		},
+	}
+}
+
+// selectingController - a controller.Controller that drops the requests that
+// the watches added to it enqueue for CRs that are not selected. This
+// applies the selector to the watches of dependent resources, which enqueue
+// the CRs that own them. The watch of the CRs themselves must be added to the
+// wrapped controller.
+type selectingController struct {
+	controller.Controller
+	selector *Selector
+	reader   client.Reader
+	gvk      schema.GroupVersionKind
+}
+
+func (c *selectingController) Watch(src source.Source, h handler.EventHandler, prct ...crtpredicate.Predicate) error {
+	return c.Controller.Watch(src, &selectingHandler{EventHandler: h, controller: c}, prct...)
+}
+
+// selected - returns false if the request is for a CR that is not selected,
+// or that does not exist.
+func (c *selectingController) selected(req reconcile.Request) bool {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(c.gvk)
+	err := c.reader.Get(context.TODO(), req.NamespacedName, u)
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		// let the reconciler decide
+		return true
+	}
+	return c.selector.Matches(u)
+}
+
+// selectingHandler - drops the requests that an EventHandler enqueues for CRs
+// that are not selected.
+type selectingHandler struct {
+	handler.EventHandler
+	controller *selectingController
+}
+
+func (h *selectingHandler) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Create(evt, &selectingQueue{RateLimitingInterface: q, controller: h.controller})
+}
+
+func (h *selectingHandler) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Update(evt, &selectingQueue{RateLimitingInterface: q, controller: h.controller})
+}
+
+func (h *selectingHandler) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Delete(evt, &selectingQueue{RateLimitingInterface: q, controller: h.controller})
+}
+
+func (h *selectingHandler) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Generic(evt, &selectingQueue{RateLimitingInterface: q, controller: h.controller})
+}
+
+type selectingQueue struct {
+	workqueue.RateLimitingInterface
+	controller *selectingController
+}
+
+func (q *selectingQueue) selected(item interface{}) bool {
+	req, ok := item.(reconcile.Request)
+	return !ok || q.controller.selected(req)
+}
+
+func (q *selectingQueue) Add(item interface{}) {
+	if q.selected(item) {
+		q.RateLimitingInterface.Add(item)
+	}
+}
+
+func (q *selectingQueue) AddRateLimited(item interface{}) {
+	if q.selected(item) {
+		q.RateLimitingInterface.AddRateLimited(item)
+	}
+}
+
+func (q *selectingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.selected(item) {
+		q.RateLimitingInterface.AddAfter(item, duration)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/util/workqueue"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newSelectorTestCR(name string, labels, annotations map[string]string, spec map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	u.SetGroupVersionKind(schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"})
+	u.SetNamespace("default")
+	u.SetName(name)
+	u.SetLabels(labels)
+	u.SetAnnotations(annotations)
+	return u
+}
+
+func TestNewSelector(t *testing.T) {
+	s, err := NewSelector("", "", "")
+	if err != nil || s != nil {
+		t.Fatalf("Expected no selector without selectors; got %v, %v", s, err)
+	}
+	if _, err := NewSelector("tenant in (a", "", ""); err == nil {
+		t.Fatalf("Expected an error for an invalid label selector")
+	}
+	if _, err := NewSelector("", "", "spec.tier"); err == nil {
+		t.Fatalf("Expected an error for an invalid field selector")
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	testCases := []struct {
+		name               string
+		labelSelector      string
+		annotationSelector string
+		fieldSelector      string
+		cr                 *unstructured.Unstructured
+		expected           bool
+	}{
+		{
+			name:          "matching labels",
+			labelSelector: "tenant=a",
+			cr:            newSelectorTestCR("db", map[string]string{"tenant": "a"}, nil, nil),
+			expected:      true,
+		},
+		{
+			name:          "other labels",
+			labelSelector: "tenant=a",
+			cr:            newSelectorTestCR("db", map[string]string{"tenant": "b"}, nil, nil),
+			expected:      false,
+		},
+		{
+			name:               "matching annotations",
+			annotationSelector: "canary",
+			cr:                 newSelectorTestCR("db", nil, map[string]string{"canary": "true"}, nil),
+			expected:           true,
+		},
+		{
+			name:               "missing annotation",
+			annotationSelector: "canary",
+			cr:                 newSelectorTestCR("db", nil, nil, nil),
+			expected:           false,
+		},
+		{
+			name:          "matching fields",
+			fieldSelector: "metadata.name=db,spec.tier!=gold",
+			cr:            newSelectorTestCR("db", nil, nil, map[string]interface{}{"tier": "silver"}),
+			expected:      true,
+		},
+		{
+			name:          "other fields",
+			fieldSelector: "spec.tier!=gold",
+			cr:            newSelectorTestCR("db", nil, nil, map[string]interface{}{"tier": "gold"}),
+			expected:      false,
+		},
+		{
+			name:          "all selectors must match",
+			labelSelector: "tenant=a",
+			fieldSelector: "metadata.name=other",
+			cr:            newSelectorTestCR("db", map[string]string{"tenant": "a"}, nil, nil),
+			expected:      false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSelector(tc.labelSelector, tc.annotationSelector, tc.fieldSelector)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if matches := s.Matches(tc.cr); matches != tc.expected {
+				t.Fatalf("Unexpected match %v, expected %v", matches, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSelectorPredicate(t *testing.T) {
+	s, err := NewSelector("tenant=a", "", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p := selectorPredicate(s)
+	selected := newSelectorTestCR("db", map[string]string{"tenant": "a"}, nil, nil)
+	selected.SetGeneration(1)
+	changed := selected.DeepCopy()
+	changed.SetGeneration(2)
+	other := newSelectorTestCR("db", map[string]string{"tenant": "b"}, nil, nil)
+	other.SetGeneration(1)
+
+	testCases := []struct {
+		name     string
+		old, new *unstructured.Unstructured
+		expected bool
+	}{
+		{
+			name:     "generation of a selected resource changed",
+			old:      selected,
+			new:      changed,
+			expected: true,
+		},
+		{
+			name:     "metadata of a selected resource changed",
+			old:      selected,
+			new:      selected.DeepCopy(),
+			expected: false,
+		},
+		{
+			name:     "resource became selected",
+			old:      other,
+			new:      selected,
+			expected: true,
+		},
+		{
+			name:     "resource is no longer selected",
+			old:      selected,
+			new:      other,
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := event.UpdateEvent{MetaOld: tc.old, ObjectOld: tc.old, MetaNew: tc.new, ObjectNew: tc.new}
+			if result := p.Update(e); result != tc.expected {
+				t.Fatalf("Unexpected result %v, expected %v", result, tc.expected)
+			}
+		})
+	}
+	if !p.Create(event.CreateEvent{Meta: selected, Object: selected}) || p.Create(event.CreateEvent{Meta: other, Object: other}) {
+		t.Fatalf("Unexpected create events")
+	}
+}
+
+func TestSelectingQueue(t *testing.T) {
+	s, err := NewSelector("tenant=a", "", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c := &selectingController{
+		selector: s,
+		reader: fakeclient.NewFakeClient(
+			newSelectorTestCR("selected", map[string]string{"tenant": "a"}, nil, nil),
+			newSelectorTestCR("other", map[string]string{"tenant": "b"}, nil, nil),
+		),
+		gvk: schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"},
+	}
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	q := &selectingQueue{RateLimitingInterface: queue, controller: c}
+	for _, name := range []string{"selected", "other", "missing"} {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}})
+	}
+
+	if queue.Len() != 1 {
+		t.Fatalf("Unexpected queue length %d, expected 1", queue.Len())
+	}
+	item, _ := queue.Get()
+	if req := item.(reconcile.Request); req.Name != "selected" {
+		t.Fatalf("Unexpected request %v", req)
+	}
+}
+
+func TestSelectedClient(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"}
+	resource := schema.GroupVersionResource{Group: "app.example.com", Version: "v1alpha1", Resource: "databases"}
+	s, err := NewSelector("tenant=a", "", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newSelectorTestCR("selected", map[string]string{"tenant": "a"}, nil, nil),
+		newSelectorTestCR("other", map[string]string{"tenant": "b"}, nil, nil),
+	)
+	informer := newSelectedInformer(dc, resource, "default", s.Labels)
+	stop := make(chan struct{})
+	defer close(stop)
+	go informer.Run(stop)
+
+	c := &selectedClient{
+		Client:   fakeclient.NewFakeClient(),
+		gvk:      gvk,
+		resource: resource.GroupResource(),
+		informer: informer,
+	}
+	testCases := []struct {
+		name           string
+		expectNotFound bool
+	}{
+		{name: "selected"},
+		{name: "other", expectNotFound: true},
+		{name: "missing", expectNotFound: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(gvk)
+			err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: tc.name}, u)
+			if tc.expectNotFound {
+				if !apierrors.IsNotFound(err) {
+					t.Fatalf("Expected not found error; got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if u.GetName() != tc.name || u.GetLabels()["tenant"] != "a" {
+				t.Fatalf("Unexpected resource %s with labels %v", u.GetName(), u.GetLabels())
+			}
+		})
+	}
+	if keys := informer.GetStore().ListKeys(); len(keys) != 1 || keys[0] != "default/selected" {
+		t.Fatalf("Unexpected cached resources %v, expected only the selected resource", keys)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// inputRecord - the input hash of the last successful run for a CR.
+type inputRecord struct {
+	hash string
+	time time.Time
+}
+
+// changeTracker - tracks the input hashes of the last successful runs for
+// CRs, and the CRs whose dependent resources changed since, to decide if a
+// run can be skipped.
+type changeTracker struct {
+	mutex            sync.Mutex
+	records          map[types.NamespacedName]inputRecord
+	dependentChanged map[types.NamespacedName]bool
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{
+		records:          map[types.NamespacedName]inputRecord{},
+		dependentChanged: map[types.NamespacedName]bool{},
+	}
+}
+
+// unchanged - returns true if the last run for a CR was successful and had
+// the given input hash, no dependent resource of the CR changed since, and
+// the run is not older than maxStaleness. maxStaleness is ignored if it is
+// zero. The dependent resource changes of the CR are reset.
+func (t *changeTracker) unchanged(name types.NamespacedName, hash string, maxStaleness time.Duration, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	dependentChanged := t.dependentChanged[name]
+	delete(t.dependentChanged, name)
+	record, ok := t.records[name]
+	if !ok || dependentChanged || record.hash != hash {
+		return false
+	}
+	return maxStaleness == 0 || now.Sub(record.time) < maxStaleness
+}
+
+// record - records a successful run for a CR.
+func (t *changeTracker) record(name types.NamespacedName, hash string, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.records[name] = inputRecord{hash: hash, time: now}
+}
+
+// forget - forgets the last run for a CR, so that the next run is not
+// skipped.
+func (t *changeTracker) forget(name types.NamespacedName) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.records, name)
+	delete(t.dependentChanged, name)
+}
+
+func (t *changeTracker) markDependentChanged(name types.NamespacedName) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.dependentChanged[name] = true
+}
+
+// trackingController - a controller.Controller that marks the CRs that
+// requests are enqueued for by the watches added to it as having changed
+// dependent resources. The watch of the CRs themselves must be added to the
+// wrapped controller.
+type trackingController struct {
+	controller.Controller
+	tracker *changeTracker
+}
+
+func (c *trackingController) Watch(src source.Source, h handler.EventHandler, prct ...predicate.Predicate) error {
+	return c.Controller.Watch(src, &trackingHandler{EventHandler: h, tracker: c.tracker}, prct...)
+}
+
+// trackingHandler - marks the CRs that an EventHandler enqueues requests for.
+type trackingHandler struct {
+	handler.EventHandler
+	tracker *changeTracker
+}
+
+func (h *trackingHandler) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Create(evt, &trackingQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+func (h *trackingHandler) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Update(evt, &trackingQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+func (h *trackingHandler) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Delete(evt, &trackingQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+func (h *trackingHandler) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Generic(evt, &trackingQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+type trackingQueue struct {
+	workqueue.RateLimitingInterface
+	tracker *changeTracker
+}
+
+func (q *trackingQueue) Add(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok {
+		q.tracker.markDependentChanged(req.NamespacedName)
+	}
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *trackingQueue) AddRateLimited(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok {
+		q.tracker.markDependentChanged(req.NamespacedName)
+	}
+	q.RateLimitingInterface.AddRateLimited(item)
+}
+
+func (q *trackingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if req, ok := item.(reconcile.Request); ok {
+		q.tracker.markDependentChanged(req.NamespacedName)
+	}
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestChangeTracker(t *testing.T) {
+	name := types.NamespacedName{Namespace: "default", Name: "example"}
+	now := time.Now()
+	testCases := []struct {
+		name             string
+		recordHash       string
+		recordAge        time.Duration
+		dependentChanged bool
+		hash             string
+		maxStaleness     time.Duration
+		expected         bool
+	}{
+		{
+			name:     "no previous run",
+			hash:     "a",
+			expected: false,
+		},
+		{
+			name:       "unchanged",
+			recordHash: "a",
+			recordAge:  48 * time.Hour,
+			hash:       "a",
+			expected:   true,
+		},
+		{
+			name:       "changed inputs",
+			recordHash: "a",
+			hash:       "b",
+			expected:   false,
+		},
+		{
+			name:             "changed dependent resources",
+			recordHash:       "a",
+			dependentChanged: true,
+			hash:             "a",
+			expected:         false,
+		},
+		{
+			name:         "within max staleness",
+			recordHash:   "a",
+			recordAge:    time.Minute,
+			hash:         "a",
+			maxStaleness: time.Hour,
+			expected:     true,
+		},
+		{
+			name:         "stale",
+			recordHash:   "a",
+			recordAge:    2 * time.Hour,
+			hash:         "a",
+			maxStaleness: time.Hour,
+			expected:     false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newChangeTracker()
+			if tc.recordHash != "" {
+				tracker.record(name, tc.recordHash, now.Add(-tc.recordAge))
+			}
+			if tc.dependentChanged {
+				tracker.markDependentChanged(name)
+			}
+			if got := tracker.unchanged(name, tc.hash, tc.maxStaleness, now); got != tc.expected {
+				t.Fatalf("Unexpected result %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTrackingHandler(t *testing.T) {
+	tracker := newChangeTracker()
+	name := types.NamespacedName{Namespace: "default", Name: "example"}
+	tracker.record(name, "a", time.Now())
+
+	h := &trackingHandler{
+		EventHandler: &handler.Funcs{
+			UpdateFunc: func(_ event.UpdateEvent, q workqueue.RateLimitingInterface) {
+				q.Add(reconcile.Request{NamespacedName: name})
+			},
+		},
+		tracker: tracker,
+	}
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	if !tracker.unchanged(name, "a", 0, time.Now()) {
+		t.Fatalf("Expected no changes before the dependent resource event")
+	}
+	h.Update(event.UpdateEvent{}, q)
+	if q.Len() != 1 {
+		t.Fatalf("Expected the request to be enqueued; got %d requests", q.Len())
+	}
+	if tracker.unchanged(name, "a", 0, time.Now()) {
+		t.Fatalf("Expected the dependent resource event to be tracked")
+	}
+	if !tracker.unchanged(name, "a", 0, time.Now()) {
+		t.Fatalf("Expected the dependent resource change to be reset")
+	}
+}
@@ -16,10 +16,15 @@ package status
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
 
+	"github.com/ghodss/yaml"
+	"github.com/sergi/go-diff/diffmatchpatch"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -29,6 +34,13 @@ var log = logf.Log.WithName("controller.status")
 
 const (
 	host = "localhost"
+
+	// maxTaskMessageLength is the maximum length of a task result message.
+	maxTaskMessageLength = 256
+
+	// MaxCheckModeMessageLength is the maximum length of the message of the
+	// check mode condition.
+	MaxCheckModeMessageLength = 32768
 )
 
 // AnsibleResult - encapsulation of the ansible result.
@@ -64,18 +76,10 @@ func NewAnsibleResultFromMap(sm map[string]interface{}) *AnsibleResult {
 	//Create Old top level status
 	// ok events.
 	a := &AnsibleResult{}
-	if v, ok := sm["changed"]; ok {
-		a.Changed = int(v.(int64))
-	}
-	if v, ok := sm["ok"]; ok {
-		a.Ok = int(v.(int64))
-	}
-	if v, ok := sm["skipped"]; ok {
-		a.Skipped = int(v.(int64))
-	}
-	if v, ok := sm["failures"]; ok {
-		a.Failures = int(v.(int64))
-	}
+	a.Changed = resultCount(sm["changed"])
+	a.Ok = resultCount(sm["ok"])
+	a.Skipped = resultCount(sm["skipped"])
+	a.Failures = resultCount(sm["failures"])
 	if v, ok := sm["completion"]; ok {
 		s := v.(string)
 		if err := a.TimeOfCompletion.UnmarshalJSON([]byte(s)); err != nil {
@@ -85,6 +89,169 @@ func NewAnsibleResultFromMap(sm map[string]interface{}) *AnsibleResult {
 	return a
 }
 
+// resultCount - returns a count of an ansible result map. Counts are int64
+// in maps decoded by the API machinery, and float64 in maps decoded from
+// JSON, e.g. by Status.GetJSONMap.
+func resultCount(v interface{}) int {
+	switch c := v.(type) {
+	case int64:
+		return int(c)
+	case float64:
+		return int(c)
+	case int:
+		return c
+	}
+	return 0
+}
+
+// TaskResult - outcome of a single task of an ansible run on a host.
+type TaskResult struct {
+	Task     string          `json:"task"`
+	Host     string          `json:"host"`
+	Duration metav1.Duration `json:"duration"`
+	Changed  bool            `json:"changed"`
+	Failed   bool            `json:"failed"`
+	Message  string          `json:"message,omitempty"`
+}
+
+// NewTaskResultFromJobEvent - creates a task result from a job event. It
+// returns false if the job event does not report the outcome of a task.
+func NewTaskResultFromJobEvent(je eventapi.JobEvent) (TaskResult, bool) {
+	tr := TaskResult{}
+	switch je.Event {
+	case eventapi.EventRunnerOnOk:
+	case eventapi.EventRunnerOnFailed, eventapi.EventRunnerOnUnreachable:
+		tr.Failed = true
+	default:
+		return tr, false
+	}
+	tr.Task, _ = je.EventData["task"].(string)
+	tr.Host, _ = je.EventData["host"].(string)
+	if d, ok := je.EventData["duration"].(float64); ok {
+		tr.Duration.Duration = time.Duration(d * float64(time.Second))
+	}
+	res, _ := je.EventData["res"].(map[string]interface{})
+	tr.Changed, _ = res["changed"].(bool)
+	if tr.Failed {
+		tr.Message = je.GetFailedPlaybookMessage()
+	} else {
+		tr.Message, _ = res["msg"].(string)
+	}
+	tr.Message = TruncateMessage(tr.Message, maxTaskMessageLength)
+	return tr, true
+}
+
+// TruncateMessage - truncates message to at most max bytes, ending it with
+// "..." if it was truncated. It is only cut at a rune boundary.
+func TruncateMessage(message string, max int) string {
+	if len(message) <= max {
+		return message
+	}
+	end := max - len("...")
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+	return message[:end] + "..."
+}
+
+// NewCheckModeChangeFromJobEvent - describes the change a task of a check
+// mode run would make, followed by the changed lines of the diff reported by
+// ansible. The job events of a run are scrubbed of its sensitive values, and
+// ansible reports no diff for tasks with no_log. It returns false if the job
+// event does not report a task that would change anything.
+func NewCheckModeChangeFromJobEvent(je eventapi.JobEvent) (string, bool) {
+	if je.Event != eventapi.EventRunnerOnOk {
+		return "", false
+	}
+	res, _ := je.EventData["res"].(map[string]interface{})
+	if changed, _ := res["changed"].(bool); !changed {
+		return "", false
+	}
+	task, _ := je.EventData["task"].(string)
+	host, _ := je.EventData["host"].(string)
+	change := fmt.Sprintf("TASK [%s] would change %s", task, host)
+	if diff := checkModeDiff(res["diff"]); diff != "" {
+		change += "\n" + diff
+	}
+	return change, true
+}
+
+// checkModeDiff - returns the changed lines of the diff of a task result,
+// which ansible reports as one or a list of maps with either a prepared diff
+// or the states before and after the change.
+func checkModeDiff(v interface{}) string {
+	var diffs []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		diffs = v
+	case map[string]interface{}:
+		diffs = []interface{}{v}
+	}
+	var b strings.Builder
+	for _, d := range diffs {
+		m, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if prepared, ok := m["prepared"].(string); ok && prepared != "" {
+			b.WriteString(strings.TrimRight(prepared, "\n") + "\n")
+			continue
+		}
+		before, after := diffText(m["before"]), diffText(m["after"])
+		if before == after {
+			continue
+		}
+		if header, ok := m["before_header"].(string); ok {
+			b.WriteString("--- " + header + "\n")
+		}
+		if header, ok := m["after_header"].(string); ok {
+			b.WriteString("+++ " + header + "\n")
+		}
+		dmp := diffmatchpatch.New()
+		a, c, lines := dmp.DiffLinesToRunes(before, after)
+		for _, diff := range dmp.DiffCharsToLines(dmp.DiffMainRunes(a, c, false), lines) {
+			prefix := ""
+			switch diff.Type {
+			case diffmatchpatch.DiffDelete:
+				prefix = "-"
+			case diffmatchpatch.DiffInsert:
+				prefix = "+"
+			default:
+				continue
+			}
+			for _, line := range strings.SplitAfter(diff.Text, "\n") {
+				if line != "" {
+					b.WriteString(prefix + line)
+				}
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// diffText - returns a state of a diff as text, with a trailing newline. The
+// states of modules like k8s are objects, which are marshalled to YAML.
+func diffText(v interface{}) string {
+	var text string
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		text = v
+	default:
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			text = fmt.Sprint(v)
+		} else {
+			text = string(b)
+		}
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
 // ConditionType - type of condition
 type ConditionType string
 
@@ -93,6 +260,8 @@ const (
 	RunningConditionType ConditionType = "Running"
 	// FailureConditionType - condition type of failure.
 	FailureConditionType ConditionType = "Failure"
+	// CheckModeConditionType - condition type of the result of a check mode run.
+	CheckModeConditionType ConditionType = "CheckMode"
 )
 
 // Condition - the condition for the ansible operator.
@@ -152,6 +321,7 @@ func createConditionFromMap(cm map[string]interface{}) Condition {
 // Status - The status for custom resources managed by the operator-sdk.
 type Status struct {
 	Conditions   []Condition            `json:"conditions"`
+	TaskResults  []TaskResult           `json:"taskResults,omitempty"`
 	CustomStatus map[string]interface{} `json:"-"`
 }
 
@@ -159,13 +329,14 @@ type Status struct {
 func CreateFromMap(statusMap map[string]interface{}) Status {
 	customStatus := make(map[string]interface{})
 	for key, value := range statusMap {
-		if key != "conditions" {
+		if key != "conditions" && key != "taskResults" {
 			customStatus[key] = value
 		}
 	}
+	taskResults := createTaskResultsFromMap(statusMap)
 	conditionsInterface, ok := statusMap["conditions"].([]interface{})
 	if !ok {
-		return Status{Conditions: []Condition{}, CustomStatus: customStatus}
+		return Status{Conditions: []Condition{}, TaskResults: taskResults, CustomStatus: customStatus}
 	}
 	conditions := []Condition{}
 	for _, ci := range conditionsInterface {
@@ -176,7 +347,25 @@ func CreateFromMap(statusMap map[string]interface{}) Status {
 		}
 		conditions = append(conditions, createConditionFromMap(cm))
 	}
-	return Status{Conditions: conditions, CustomStatus: customStatus}
+	return Status{Conditions: conditions, TaskResults: taskResults, CustomStatus: customStatus}
+}
+
+func createTaskResultsFromMap(statusMap map[string]interface{}) []TaskResult {
+	ti, ok := statusMap["taskResults"]
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(ti)
+	if err != nil {
+		log.Error(err, "Unable to marshal task results")
+		return nil
+	}
+	var taskResults []TaskResult
+	if err := json.Unmarshal(b, &taskResults); err != nil {
+		log.Info("Unknown task results, removing task results", "TaskResults", ti)
+		return nil
+	}
+	return taskResults
 }
 
 // GetJSONMap - gets the map value for the status object.
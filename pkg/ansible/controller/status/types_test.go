@@ -0,0 +1,306 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewTaskResultFromJobEvent(t *testing.T) {
+	testCases := []struct {
+		name           string
+		jobEvent       eventapi.JobEvent
+		expectedResult TaskResult
+		expectedOk     bool
+	}{
+		{
+			name: "changed task",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task":     "Create deployment",
+					"host":     "localhost",
+					"duration": 1.5,
+					"res":      map[string]interface{}{"changed": true},
+				},
+			},
+			expectedResult: TaskResult{
+				Task:     "Create deployment",
+				Host:     "localhost",
+				Duration: metav1.Duration{Duration: 1500 * time.Millisecond},
+				Changed:  true,
+			},
+			expectedOk: true,
+		},
+		{
+			name: "failed task",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnFailed,
+				EventData: map[string]interface{}{
+					"task": "Create service",
+					"host": "localhost",
+					"res":  map[string]interface{}{"msg": "invalid port"},
+				},
+			},
+			expectedResult: TaskResult{
+				Task:    "Create service",
+				Host:    "localhost",
+				Failed:  true,
+				Message: "invalid port",
+			},
+			expectedOk: true,
+		},
+		{
+			name: "unreachable host",
+			jobEvent: eventapi.JobEvent{
+				Event:     eventapi.EventRunnerOnUnreachable,
+				EventData: map[string]interface{}{"task": "Gather facts", "host": "remote"},
+			},
+			expectedResult: TaskResult{
+				Task:    "Gather facts",
+				Host:    "remote",
+				Failed:  true,
+				Message: "unknown playbook failure",
+			},
+			expectedOk: true,
+		},
+		{
+			name: "long message",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task": "Print",
+					"host": "localhost",
+					"res":  map[string]interface{}{"msg": strings.Repeat("a", 300)},
+				},
+			},
+			expectedResult: TaskResult{
+				Task:    "Print",
+				Host:    "localhost",
+				Message: strings.Repeat("a", maxTaskMessageLength-3) + "...",
+			},
+			expectedOk: true,
+		},
+		{
+			name:       "not a task result",
+			jobEvent:   eventapi.JobEvent{Event: eventapi.EventPlaybookOnTaskStart},
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, ok := NewTaskResultFromJobEvent(tc.jobEvent)
+			if ok != tc.expectedOk {
+				t.Fatalf("Unexpected ok: %v expected: %v", ok, tc.expectedOk)
+			}
+			if ok && !reflect.DeepEqual(tr, tc.expectedResult) {
+				t.Fatalf("Task result did not match expected:\nActual: %#v\nExpected: %#v", tr, tc.expectedResult)
+			}
+		})
+	}
+}
+
+func TestNewCheckModeChangeFromJobEvent(t *testing.T) {
+	testCases := []struct {
+		name           string
+		jobEvent       eventapi.JobEvent
+		expectedChange string
+		expectedOk     bool
+	}{
+		{
+			name: "changed task with object diff",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task": "Create deployment",
+					"host": "localhost",
+					"res": map[string]interface{}{
+						"changed": true,
+						"diff": map[string]interface{}{
+							"before": map[string]interface{}{"spec": map[string]interface{}{"replicas": 1, "paused": false}},
+							"after":  map[string]interface{}{"spec": map[string]interface{}{"replicas": 3, "paused": false}},
+						},
+					},
+				},
+				StdOut: "\x1b[0;33m-    replicas: 1\x1b[0m\n",
+			},
+			expectedChange: "TASK [Create deployment] would change localhost\n-  replicas: 1\n+  replicas: 3",
+			expectedOk:     true,
+		},
+		{
+			name: "changed task with text and prepared diffs",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task": "Template config",
+					"host": "localhost",
+					"res": map[string]interface{}{
+						"changed": true,
+						"diff": []interface{}{
+							map[string]interface{}{
+								"before_header": "config.yml",
+								"after_header":  "config.yml",
+								"before":        "size: 1\nname: example\n",
+								"after":         "size: 3\nname: example\n",
+							},
+							map[string]interface{}{"prepared": "--- before\n+++ after\n@@ -1 +1 @@\n-a\n+b\n"},
+						},
+					},
+				},
+			},
+			expectedChange: "TASK [Template config] would change localhost\n--- config.yml\n+++ config.yml\n-size: 1\n+size: 3\n--- before\n+++ after\n@@ -1 +1 @@\n-a\n+b",
+			expectedOk:     true,
+		},
+		{
+			name: "changed task with scrubbed diff",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task": "Create secret",
+					"host": "localhost",
+					"res": map[string]interface{}{
+						"changed": true,
+						"diff":    map[string]interface{}{"before": "", "after": "password: ********\n"},
+					},
+				},
+			},
+			expectedChange: "TASK [Create secret] would change localhost\n+password: ********",
+			expectedOk:     true,
+		},
+		{
+			name: "changed task without diff",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task": "Restart pods",
+					"host": "localhost",
+					"res":  map[string]interface{}{"changed": true},
+				},
+			},
+			expectedChange: "TASK [Restart pods] would change localhost",
+			expectedOk:     true,
+		},
+		{
+			name: "unchanged task",
+			jobEvent: eventapi.JobEvent{
+				Event: eventapi.EventRunnerOnOk,
+				EventData: map[string]interface{}{
+					"task": "Gather facts",
+					"host": "localhost",
+				},
+			},
+			expectedOk: false,
+		},
+		{
+			name:       "failed task",
+			jobEvent:   eventapi.JobEvent{Event: eventapi.EventRunnerOnFailed},
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			change, ok := NewCheckModeChangeFromJobEvent(tc.jobEvent)
+			if ok != tc.expectedOk {
+				t.Fatalf("Unexpected ok: %v expected: %v", ok, tc.expectedOk)
+			}
+			if change != tc.expectedChange {
+				t.Fatalf("Unexpected change:\nActual: %q\nExpected: %q", change, tc.expectedChange)
+			}
+		})
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		max      int
+		expected string
+	}{
+		{
+			name:     "short message",
+			message:  "no changes",
+			max:      16,
+			expected: "no changes",
+		},
+		{
+			name:     "long message",
+			message:  "TASK [Create deployment] would change localhost",
+			max:      16,
+			expected: "TASK [Create ...",
+		},
+		{
+			name:     "multi-byte rune at the cut",
+			message:  "TASK [Größe ändern]",
+			max:      12,
+			expected: "TASK [Gr...",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := TruncateMessage(tc.message, tc.max)
+			if message != tc.expected {
+				t.Fatalf("Unexpected message:\nActual: %q\nExpected: %q", message, tc.expected)
+			}
+			if !utf8.ValidString(message) {
+				t.Fatalf("Truncated message is not valid UTF-8: %q", message)
+			}
+		})
+	}
+}
+
+func TestCreateFromMapTaskResults(t *testing.T) {
+	status := Status{
+		Conditions:   []Condition{},
+		TaskResults:  []TaskResult{{Task: "Create deployment", Host: "localhost", Duration: metav1.Duration{Duration: time.Second}, Changed: true}},
+		CustomStatus: map[string]interface{}{"custom": "value"},
+	}
+	actual := CreateFromMap(status.GetJSONMap())
+	if !reflect.DeepEqual(actual.TaskResults, status.TaskResults) {
+		t.Fatalf("Task results did not match expected:\nActual: %#v\nExpected: %#v", actual.TaskResults, status.TaskResults)
+	}
+	if _, ok := actual.CustomStatus["taskResults"]; ok {
+		t.Fatalf("Task results should not be part of the custom status")
+	}
+	if actual.CustomStatus["custom"] != "value" {
+		t.Fatalf("Custom status was not kept: %#v", actual.CustomStatus)
+	}
+}
+
+func TestCreateFromMapAnsibleResult(t *testing.T) {
+	result := &AnsibleResult{Ok: 3, Changed: 1}
+	status := Status{
+		Conditions: []Condition{*NewCondition(RunningConditionType, "True", result, SuccessfulReason, SuccessfulMessage)},
+	}
+	actual := CreateFromMap(status.GetJSONMap())
+	c := GetCondition(actual, RunningConditionType)
+	if c == nil || c.AnsibleResult == nil {
+		t.Fatalf("Expected the running condition with an ansible result: %#v", actual)
+	}
+	if c.AnsibleResult.Ok != 3 || c.AnsibleResult.Changed != 1 {
+		t.Fatalf("Unexpected ansible result %#v", c.AnsibleResult)
+	}
+}
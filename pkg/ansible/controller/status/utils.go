@@ -28,6 +28,10 @@ const (
 	FailedReason = "Failed"
 	// UnknownFailedReason - Condition is unknown
 	UnknownFailedReason = "Unknown"
+	// ChangesPendingReason - Condition is check mode due to a check mode run that would change resources
+	ChangesPendingReason = "ChangesPending"
+	// NoChangesReason - Condition is check mode due to a check mode run that would not change anything
+	NoChangesReason = "NoChanges"
 )
 
 const (
@@ -35,6 +39,8 @@ const (
 	RunningMessage = "Running reconciliation"
 	// SuccessfulMessage - message for successful reason.
 	SuccessfulMessage = "Awaiting next reconciliation"
+	// NoChangesMessage - message for no changes reason.
+	NoChangesMessage = "Check mode run found no changes"
 )
 
 // NewCondition -  condition
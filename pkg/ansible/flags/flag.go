@@ -16,6 +16,7 @@ package flags
 
 import (
 	"strings"
+	"time"
 
 	"github.com/operator-framework/operator-sdk/internal/flags/watch"
 	"github.com/operator-framework/operator-sdk/pkg/log/zap"
@@ -25,9 +26,21 @@ import (
 // AnsibleOperatorFlags - Options to be used by an ansible operator
 type AnsibleOperatorFlags struct {
 	watch.WatchFlags
-	InjectOwnerRef   bool
-	MaxWorkers       int
-	AnsibleVerbosity int
+	InjectOwnerRef       bool
+	MaxWorkers           int
+	MaxConcurrentRuns    int
+	AnsibleVerbosity     int
+	ArtifactsBindAddress string
+	ArtifactsExportPath  string
+	ArtifactsMaxAge      time.Duration
+	ArtifactsMaxCount    int
+	RunnerBackend        string
+	RunnerJobTemplate    string
+	RunnerCallbackHost   string
+	RunnerJobTimeout     time.Duration
+	AuditLogPath         string
+	AuditLogMaxSize      int
+	AuditLogMaxBackups   int
 }
 
 // AddTo - Add the ansible operator flags to the the flagset
@@ -48,6 +61,13 @@ func AddTo(flagSet *pflag.FlagSet, helpTextPrefix ...string) *AnsibleOperatorFla
 			"Maximum number of workers to use. Overridden by environment variable."),
 			" "),
 	)
+	flagSet.IntVar(&aof.MaxConcurrentRuns,
+		"max-concurrent-runs",
+		0,
+		strings.Join(append(helpTextPrefix,
+			"Maximum number of concurrent ansible-runner runs of all watches. Runs waiting for a slot start in the order of the priority annotation of their resource. Not limited if 0."),
+			" "),
+	)
 	flagSet.IntVar(&aof.AnsibleVerbosity,
 		"ansible-verbosity",
 		2,
@@ -55,5 +75,82 @@ func AddTo(flagSet *pflag.FlagSet, helpTextPrefix ...string) *AnsibleOperatorFla
 			"Ansible verbosity. Overridden by environment variable."),
 			" "),
 	)
+	flagSet.StringVar(&aof.ArtifactsBindAddress,
+		"artifacts-bind-address",
+		"",
+		strings.Join(append(helpTextPrefix,
+			"Address to serve the ansible-runner artifacts of each resource on, e.g. \"127.0.0.1:8080\". Requests to other than loopback addresses must be authorized with a bearer token. Artifacts are not served if empty."),
+			" "),
+	)
+	flagSet.StringVar(&aof.ArtifactsExportPath,
+		"artifacts-export-path",
+		"",
+		strings.Join(append(helpTextPrefix,
+			"Path, e.g. of a mounted PersistentVolumeClaim, to export the ansible-runner artifacts of finished runs to. Artifacts are not exported if empty."),
+			" "),
+	)
+	flagSet.DurationVar(&aof.ArtifactsMaxAge,
+		"artifacts-max-age",
+		0,
+		strings.Join(append(helpTextPrefix,
+			"Age after which the ansible-runner artifacts of a run are removed. Artifacts are kept regardless of their age if 0."),
+			" "),
+	)
+	flagSet.IntVar(&aof.ArtifactsMaxCount,
+		"artifacts-max-count",
+		20,
+		strings.Join(append(helpTextPrefix,
+			"Maximum number of exported ansible-runner artifacts to keep for each resource. All are kept if 0."),
+			" "),
+	)
+	flagSet.StringVar(&aof.RunnerBackend,
+		"runner-backend",
+		"local",
+		strings.Join(append(helpTextPrefix,
+			"Where ansible-runner is run: \"local\" runs it in the operator's container, \"job\" runs it in a Kubernetes Job for each reconcile."),
+			" "),
+	)
+	flagSet.StringVar(&aof.RunnerJobTemplate,
+		"runner-job-template",
+		"",
+		strings.Join(append(helpTextPrefix,
+			"Path to the YAML file of the Job template used by the \"job\" runner backend."),
+			" "),
+	)
+	flagSet.StringVar(&aof.RunnerCallbackHost,
+		"runner-callback-host",
+		"",
+		strings.Join(append(helpTextPrefix,
+			"Host, e.g. the pod IP, the Jobs of the \"job\" runner backend reach the operator's proxy and event API on over plain HTTP, so the pod network must be trusted or encrypted."),
+			" "),
+	)
+	flagSet.DurationVar(&aof.RunnerJobTimeout,
+		"runner-job-timeout",
+		time.Hour,
+		strings.Join(append(helpTextPrefix,
+			"Maximum duration of a run of the \"job\" runner backend. Set as the activeDeadlineSeconds of Jobs whose template has no shorter deadline."),
+			" "),
+	)
+	flagSet.StringVar(&aof.AuditLogPath,
+		"audit-log-path",
+		"",
+		strings.Join(append(helpTextPrefix,
+			"Path of the file to write an audit record of every create, update, patch and delete request of the ansible runs to. Requests are not audited if empty."),
+			" "),
+	)
+	flagSet.IntVar(&aof.AuditLogMaxSize,
+		"audit-log-max-size",
+		100,
+		strings.Join(append(helpTextPrefix,
+			"Maximum size in megabytes of the audit log before it is rotated. Not rotated if 0."),
+			" "),
+	)
+	flagSet.IntVar(&aof.AuditLogMaxBackups,
+		"audit-log-max-backups",
+		5,
+		strings.Join(append(helpTextPrefix,
+			"Maximum number of rotated audit logs to keep."),
+			" "),
+	)
 	return aof
 }
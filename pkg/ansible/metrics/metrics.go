@@ -16,6 +16,7 @@ package metrics
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -38,6 +39,27 @@ var (
 			"result",
 		})
 
+	runsWaiting = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "runs_waiting",
+			Help:      "Gauge of runs waiting for a runner slot.",
+		},
+		[]string{
+			"GVK",
+		})
+
+	proxyCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "proxy_cache_requests_total",
+			Help:      "Counter of the get, list and watch requests to the proxy, by whether they were served from the cache.",
+		},
+		[]string{
+			"verb",
+			"result",
+		})
+
 	reconciles = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Subsystem: subsystem,
@@ -49,9 +71,61 @@ var (
 		})
 )
 
+// controllerMetrics - the reconcile metrics of controller-runtime's
+// controllers, which the controllers of the Ansible operator report too. They
+// are looked up once all packages were initialized, since controller-runtime
+// registers them when its controller package is initialized.
+var controllerMetrics struct {
+	once   sync.Once
+	total  *prometheus.CounterVec
+	errors *prometheus.CounterVec
+	time   *prometheus.HistogramVec
+}
+
+func initControllerMetrics() {
+	controllerMetrics.once.Do(func() {
+		total := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "controller_runtime_reconcile_total",
+			Help: "Total number of reconciliations per controller",
+		}, []string{"controller", "result"})
+		if c, ok := registered(total).(*prometheus.CounterVec); ok {
+			total = c
+		}
+		errors := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "controller_runtime_reconcile_errors_total",
+			Help: "Total number of reconciliation errors per controller",
+		}, []string{"controller"})
+		if c, ok := registered(errors).(*prometheus.CounterVec); ok {
+			errors = c
+		}
+		time := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "controller_runtime_reconcile_time_seconds",
+			Help: "Length of time per reconciliation per controller",
+		}, []string{"controller"})
+		if c, ok := registered(time).(*prometheus.HistogramVec); ok {
+			time = c
+		}
+		controllerMetrics.total, controllerMetrics.errors, controllerMetrics.time = total, errors, time
+	})
+}
+
+// registered - registers a collector, or returns the collector that is
+// already registered with the same metrics.
+func registered(c prometheus.Collector) prometheus.Collector {
+	if err := metrics.Registry.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		logf.Log.WithName("metrics").Error(err, "Failed to register metric")
+	}
+	return c
+}
+
 func init() {
 	metrics.Registry.MustRegister(reconcileResults)
 	metrics.Registry.MustRegister(reconciles)
+	metrics.Registry.MustRegister(runsWaiting)
+	metrics.Registry.MustRegister(proxyCacheRequests)
 }
 
 // We will never want to panic our app because of metric saving.
@@ -75,9 +149,84 @@ func ReconcileFailed(gvk string) {
 	reconcileResults.WithLabelValues(gvk, "failed").Inc()
 }
 
+func ReconcileSkipped(gvk string) {
+	defer recoverMetricPanic()
+	reconcileResults.WithLabelValues(gvk, "skipped").Inc()
+}
+
+func RunWaiting(gvk string) {
+	defer recoverMetricPanic()
+	runsWaiting.WithLabelValues(gvk).Inc()
+}
+
+func RunDoneWaiting(gvk string) {
+	defer recoverMetricPanic()
+	runsWaiting.WithLabelValues(gvk).Dec()
+}
+
 func ReconcileTimer(gvk string) *prometheus.Timer {
 	defer recoverMetricPanic()
 	return prometheus.NewTimer(prometheus.ObserverFunc(func(duration float64) {
 		reconciles.WithLabelValues(gvk).Observe(duration)
 	}))
 }
+
+func ProxyCacheHit(verb string) {
+	defer recoverMetricPanic()
+	proxyCacheRequests.WithLabelValues(verb, "hit").Inc()
+}
+
+func ProxyCacheMiss(verb string) {
+	defer recoverMetricPanic()
+	proxyCacheRequests.WithLabelValues(verb, "miss").Inc()
+}
+
+func ControllerReconciled(controller, result string) {
+	defer recoverMetricPanic()
+	initControllerMetrics()
+	controllerMetrics.total.WithLabelValues(controller, result).Inc()
+}
+
+func ControllerReconcileError(controller string) {
+	defer recoverMetricPanic()
+	initControllerMetrics()
+	controllerMetrics.errors.WithLabelValues(controller).Inc()
+}
+
+func ControllerReconcileTimer(controller string) *prometheus.Timer {
+	defer recoverMetricPanic()
+	initControllerMetrics()
+	return prometheus.NewTimer(prometheus.ObserverFunc(func(duration float64) {
+		controllerMetrics.time.WithLabelValues(controller).Observe(duration)
+	}))
+}
+
+// QueueDepth - returns the workqueue_depth gauge of a workqueue, which
+// controller-runtime reports for the workqueues of its controllers.
+func QueueDepth(queue string) prometheus.Gauge {
+	defer recoverMetricPanic()
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "workqueue_depth",
+		Help:        "Current depth of workqueue",
+		ConstLabels: prometheus.Labels{"name": queue},
+	})
+	if g, ok := registered(depth).(prometheus.Gauge); ok {
+		return g
+	}
+	return depth
+}
+
+// QueueAdds - returns the workqueue_adds_total counter of a workqueue, which
+// controller-runtime reports for the workqueues of its controllers.
+func QueueAdds(queue string) prometheus.Counter {
+	defer recoverMetricPanic()
+	adds := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "workqueue_adds_total",
+		Help:        "Total number of adds handled by workqueue",
+		ConstLabels: prometheus.Labels{"name": queue},
+	})
+	if c, ok := registered(adds).(prometheus.Counter); ok {
+		return c
+	}
+	return adds
+}
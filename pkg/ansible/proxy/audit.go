@@ -0,0 +1,420 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	k8sRequest "github.com/operator-framework/operator-sdk/pkg/ansible/proxy/requestfactory"
+
+	"github.com/mattbaird/jsonpatch"
+	kcorev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/transport"
+)
+
+// auditRedacted - replaces the data of Secrets in the patches of audit
+// records.
+const auditRedacted = "<redacted>"
+
+// AuditOwner - the CR whose run made an audited request.
+type AuditOwner struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// AuditRecord - the record of a create, update, patch or delete request that
+// a run sent through the proxy.
+type AuditRecord struct {
+	Time  time.Time  `json:"time"`
+	Owner AuditOwner `json:"owner"`
+	// CheckMode is set for the requests of check mode runs, which are
+	// refused by the proxy.
+	CheckMode bool `json:"checkMode,omitempty"`
+	// User is the service account the request was made as, if the watch of
+	// the owner impersonates one.
+	User        string `json:"user,omitempty"`
+	Verb        string `json:"verb"`
+	Group       string `json:"group"`
+	Version     string `json:"version"`
+	Kind        string `json:"kind,omitempty"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Code        int    `json:"code"`
+	// Patch is the JSON patch from the object before the request to the
+	// object in the response. It is empty if the request failed.
+	Patch []jsonpatch.JsonPatchOperation `json:"patch,omitempty"`
+}
+
+// AuditSink - receives the audit records of the proxy.
+type AuditSink interface {
+	Record(AuditRecord) error
+}
+
+// auditHandler - records the mutating requests that come through the proxy
+// in an AuditSink.
+type auditHandler struct {
+	next       http.Handler
+	sink       AuditSink
+	restMapper meta.RESTMapper
+}
+
+func (a *auditHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		a.next.ServeHTTP(w, req)
+		return
+	}
+	rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+	r, err := rf.NewRequestInfo(req)
+	if err != nil || !r.IsResourceRequest {
+		a.next.ServeHTTP(w, req)
+		return
+	}
+
+	record := AuditRecord{
+		Verb:        r.Verb,
+		Group:       r.APIGroup,
+		Version:     r.APIVersion,
+		Resource:    r.Resource,
+		Subresource: r.Subresource,
+		Namespace:   r.Namespace,
+		Name:        r.Name,
+	}
+	// The owner must be read before the request is served, since the
+	// authorization header is removed by the handlers of the proxy.
+	if owner, err := getRequestOwnerRef(req); err == nil {
+		record.Owner = AuditOwner{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Namespace:  owner.Namespace,
+			Name:       owner.Name,
+			UID:        string(owner.UID),
+		}
+		record.CheckMode = owner.CheckMode
+	}
+	if a.restMapper != nil {
+		if gvk, err := getGVKFromRequestInfo(r, a.restMapper); err == nil {
+			record.Kind = gvk.Kind
+		}
+	}
+
+	before := []byte("{}")
+	if r.Verb != "create" && r.Name != "" {
+		before = a.get(req)
+	}
+
+	rw := &auditResponseWriter{ResponseWriter: w, code: http.StatusOK}
+	a.next.ServeHTTP(rw, req)
+	record.Code = rw.code
+	record.User = req.Header.Get(transport.ImpersonateUserHeader)
+	record.Time = time.Now().UTC()
+
+	if record.Code < http.StatusBadRequest && before != nil {
+		after := []byte("{}")
+		if r.Verb != "delete" {
+			after = rw.json()
+		}
+		if after != nil {
+			record.Patch, err = auditPatch(before, after, record.Group == "" && record.Resource == "secrets")
+			if err != nil {
+				log.Error(err, "Failed to create the patch of an audit record", "path", req.URL.Path)
+			}
+		}
+		if record.Name == "" && after != nil {
+			record.Name = objectName(after)
+		}
+	}
+	if err := a.sink.Record(record); err != nil {
+		log.Error(err, "Failed to write audit record", "verb", record.Verb, "path", req.URL.Path)
+	}
+}
+
+// get - returns the JSON of the object a request is about to change, or nil
+// if it can not be read.
+func (a *auditHandler) get(req *http.Request) []byte {
+	get, err := http.NewRequestWithContext(req.Context(), http.MethodGet, req.URL.Path, nil)
+	if err != nil {
+		return nil
+	}
+	get.Host = req.Host
+	get.Header = req.Header.Clone()
+	get.Header.Set("Accept", "application/json")
+	get.Header.Del("Accept-Encoding")
+	get.Header.Del("Content-Type")
+	rec := httptest.NewRecorder()
+	a.next.ServeHTTP(rec, get)
+	if rec.Code != http.StatusOK {
+		log.V(1).Info("Failed to get the object of an audited request", "path", req.URL.Path, "code", rec.Code)
+		return nil
+	}
+	return rec.Body.Bytes()
+}
+
+// auditResponseWriter - keeps the status code and body of a response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// json - returns the JSON body of the response, or nil if it is not JSON.
+func (w *auditResponseWriter) json() []byte {
+	return responseJSON(w.Header(), w.body.Bytes())
+}
+
+// responseJSON - returns the decompressed body of a response, or nil if it is
+// not JSON.
+func responseJSON(h http.Header, body []byte) []byte {
+	if !strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	if h.Get("Content-Encoding") != "gzip" {
+		return body
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	b, err := ioutil.ReadAll(gr)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// auditPatch - returns the JSON patch from before to after, without the
+// changes of the fields the API server maintains. The values of the data of
+// Secrets are redacted.
+func auditPatch(before, after []byte, secret bool) ([]jsonpatch.JsonPatchOperation, error) {
+	before, err := auditObject(before, secret)
+	if err != nil {
+		return nil, err
+	}
+	after, err = auditObject(after, secret)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := jsonpatch.CreatePatch(before, after)
+	if err != nil {
+		return nil, err
+	}
+	patch := make([]jsonpatch.JsonPatchOperation, 0, len(ops))
+	for _, op := range ops {
+		if op.Path == "/metadata/resourceVersion" {
+			continue
+		}
+		if secret && op.Value != nil {
+			op.Value = redactSecretData(op.Path, op.Value)
+		}
+		patch = append(patch, op)
+	}
+	// The order of the operations created for maps is random, while the
+	// operations on the elements of an array must keep their order.
+	sort.SliceStable(patch, func(i, j int) bool { return patchSortKey(patch[i].Path) < patchSortKey(patch[j].Path) })
+	return patch, nil
+}
+
+// auditObject - returns the object without its managedFields, which the API
+// server maintains, and, for Secrets, with the last applied configuration
+// annotation redacted, since it contains the data of the Secret.
+func auditObject(b []byte, secret bool) ([]byte, error) {
+	o := map[string]interface{}{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	metadata, ok := o["metadata"].(map[string]interface{})
+	if !ok {
+		return b, nil
+	}
+	delete(metadata, "managedFields")
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok && secret {
+		if _, ok := annotations[kcorev1.LastAppliedConfigAnnotation]; ok {
+			annotations[kcorev1.LastAppliedConfigAnnotation] = auditRedacted
+		}
+	}
+	return json.Marshal(o)
+}
+
+// patchSortKey - returns the path up to its first array index.
+func patchSortKey(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if _, err := strconv.Atoi(s); err == nil {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return path
+}
+
+func redactSecretData(path string, v interface{}) interface{} {
+	for _, field := range []string{"/data", "/stringData"} {
+		if strings.HasPrefix(path, field+"/") {
+			return auditRedacted
+		}
+		if path == field {
+			return redactValues(v)
+		}
+	}
+	return v
+}
+
+func redactValues(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return auditRedacted
+	}
+	redacted := make(map[string]interface{}, len(m))
+	for k := range m {
+		redacted[k] = auditRedacted
+	}
+	return redacted
+}
+
+func objectName(b []byte) string {
+	o := struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return ""
+	}
+	return o.Metadata.Name
+}
+
+// FileAuditSink - writes audit records as lines of JSON to a file, which is
+// rotated when it reaches its maximum size.
+type FileAuditSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileAuditSink - returns a FileAuditSink writing to path. When a record
+// would make the file larger than maxSize bytes, the file is renamed to
+// path.1, path.1 to path.2 and so on, keeping maxBackups rotated files. The
+// file is not rotated if maxSize is 0.
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	s := &FileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileAuditSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log %s: %w", s.path, err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	for i := s.maxBackups; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i-1), fmt.Sprintf("%s.%d", s.path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	if s.maxBackups > 0 {
+		err = os.Rename(s.path, s.path+".1")
+	} else {
+		err = os.Remove(s.path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.open()
+}
+
+// Record - writes a record to the file.
+func (s *FileAuditSink) Record(r AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("audit log %s is closed", s.path)
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log %s: %w", s.path, err)
+		}
+	}
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// Close - closes the file.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
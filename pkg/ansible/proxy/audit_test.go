@@ -0,0 +1,302 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+
+	"github.com/mattbaird/jsonpatch"
+	kmetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *fakeAuditSink) Record(r AuditRecord) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func TestAuditHandler(t *testing.T) {
+	objects := map[string]string{
+		"/api/v1/namespaces/default/configmaps/example": `{"kind":"ConfigMap","metadata":{"name":"example","resourceVersion":"1"},"data":{"size":"1"}}`,
+		"/api/v1/namespaces/default/secrets/example":    `{"kind":"Secret","metadata":{"name":"example"},"data":{"password":"aHVudGVyMg=="}}`,
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			t.Fatalf("Missing authorization of the %s request", req.Method)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Method {
+		case http.MethodGet:
+			o, ok := objects[req.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(o))
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"kind":"ConfigMap","metadata":{"name":"created"},"data":{"size":"1"}}`))
+		case http.MethodPatch:
+			if strings.Contains(req.URL.Path, "/secrets/") {
+				_, _ = w.Write([]byte(`{"kind":"Secret","metadata":{"name":"example"},"data":{"password":"czNjcjN0"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"kind":"ConfigMap","metadata":{"name":"example","resourceVersion":"2"},"data":{"size":"3"}}`))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"kind":"Status","code":403}`))
+		}
+	})
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedRecord bool
+		expectedVerb   string
+		expectedName   string
+		expectedCode   int
+		expectedPatch  []jsonpatch.JsonPatchOperation
+	}{
+		{
+			name:   "get",
+			method: http.MethodGet,
+			path:   "/api/v1/namespaces/default/configmaps/example",
+		},
+		{
+			name:           "patch",
+			method:         http.MethodPatch,
+			path:           "/api/v1/namespaces/default/configmaps/example",
+			expectedRecord: true,
+			expectedVerb:   "patch",
+			expectedName:   "example",
+			expectedCode:   http.StatusOK,
+			expectedPatch: []jsonpatch.JsonPatchOperation{
+				{Operation: "replace", Path: "/data/size", Value: "3"},
+			},
+		},
+		{
+			name:           "create",
+			method:         http.MethodPost,
+			path:           "/api/v1/namespaces/default/configmaps",
+			expectedRecord: true,
+			expectedVerb:   "create",
+			expectedName:   "created",
+			expectedCode:   http.StatusCreated,
+			expectedPatch: []jsonpatch.JsonPatchOperation{
+				{Operation: "add", Path: "/data", Value: map[string]interface{}{"size": "1"}},
+				{Operation: "add", Path: "/kind", Value: "ConfigMap"},
+				{Operation: "add", Path: "/metadata", Value: map[string]interface{}{"name": "created"}},
+			},
+		},
+		{
+			name:           "failed delete",
+			method:         http.MethodDelete,
+			path:           "/api/v1/namespaces/default/configmaps/example",
+			expectedRecord: true,
+			expectedVerb:   "delete",
+			expectedName:   "example",
+			expectedCode:   http.StatusForbidden,
+		},
+		{
+			name:           "patch of a secret",
+			method:         http.MethodPatch,
+			path:           "/api/v1/namespaces/default/secrets/example",
+			expectedRecord: true,
+			expectedVerb:   "patch",
+			expectedName:   "example",
+			expectedCode:   http.StatusOK,
+			expectedPatch: []jsonpatch.JsonPatchOperation{
+				{Operation: "replace", Path: "/data/password", Value: auditRedacted},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &fakeAuditSink{}
+			h := &auditHandler{next: next, sink: sink}
+			owner := kubeconfig.NamespacedOwnerReference{
+				OwnerReference: kmetav1.OwnerReference{APIVersion: "app.example.com/v1alpha1", Kind: "Memcached", Name: "example", UID: "1234"},
+				Namespace:      "default",
+			}
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+			req.SetBasicAuth("", "token")
+			req = withRequestOwner(req, owner)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if !tc.expectedRecord {
+				if len(sink.records) != 0 {
+					t.Fatalf("Unexpected audit records %#v", sink.records)
+				}
+				return
+			}
+			if len(sink.records) != 1 {
+				t.Fatalf("Unexpected number of audit records %d, expected 1", len(sink.records))
+			}
+			r := sink.records[0]
+			expectedOwner := AuditOwner{APIVersion: "app.example.com/v1alpha1", Kind: "Memcached", Namespace: "default", Name: "example", UID: "1234"}
+			if r.Owner != expectedOwner {
+				t.Fatalf("Unexpected owner %#v, expected %#v", r.Owner, expectedOwner)
+			}
+			if r.Verb != tc.expectedVerb || r.Name != tc.expectedName || r.Code != tc.expectedCode || r.Namespace != "default" || r.Version != "v1" {
+				t.Fatalf("Unexpected audit record %#v", r)
+			}
+			if r.Code != w.Code {
+				t.Fatalf("Unexpected code %d of the audit record, the response code is %d", r.Code, w.Code)
+			}
+			if !reflect.DeepEqual(r.Patch, tc.expectedPatch) {
+				t.Fatalf("Unexpected patch %#v, expected %#v", r.Patch, tc.expectedPatch)
+			}
+		})
+	}
+}
+
+func TestAuditPatch(t *testing.T) {
+	lastApplied := `{"apiVersion":"v1","kind":"Secret","data":{"password":"aHVudGVyMg=="}}`
+	testCases := []struct {
+		name          string
+		before        string
+		after         string
+		secret        bool
+		expectedPatch []jsonpatch.JsonPatchOperation
+	}{
+		{
+			name:   "created secret",
+			before: `{}`,
+			after: `{"kind":"Secret","metadata":{"name":"example","annotations":{"kubectl.kubernetes.io/last-applied-configuration":` +
+				strconv.Quote(lastApplied) + `},"managedFields":[{"manager":"kubectl","fieldsV1":{"f:data":{"f:password":{}}}}]},"data":{"password":"aHVudGVyMg=="}}`,
+			secret: true,
+			expectedPatch: []jsonpatch.JsonPatchOperation{
+				{Operation: "add", Path: "/data", Value: map[string]interface{}{"password": auditRedacted}},
+				{Operation: "add", Path: "/kind", Value: "Secret"},
+				{Operation: "add", Path: "/metadata", Value: map[string]interface{}{
+					"name":        "example",
+					"annotations": map[string]interface{}{"kubectl.kubernetes.io/last-applied-configuration": auditRedacted},
+				}},
+			},
+		},
+		{
+			name: "updated secret",
+			before: `{"kind":"Secret","metadata":{"name":"example","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"},` +
+				`"managedFields":[{"manager":"kubectl"}]},"data":{"password":"czNjcjN0"}}`,
+			after: `{"kind":"Secret","metadata":{"name":"example","annotations":{"kubectl.kubernetes.io/last-applied-configuration":` +
+				strconv.Quote(lastApplied) + `},"managedFields":[{"manager":"ansible"}]},"data":{"password":"aHVudGVyMg=="}}`,
+			secret: true,
+			expectedPatch: []jsonpatch.JsonPatchOperation{
+				{Operation: "replace", Path: "/data/password", Value: auditRedacted},
+			},
+		},
+		{
+			name:   "updated config map",
+			before: `{"kind":"ConfigMap","metadata":{"name":"example","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}}}`,
+			after:  `{"kind":"ConfigMap","metadata":{"name":"example","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"data\":{}}"},"managedFields":[{"manager":"ansible"}]}}`,
+			expectedPatch: []jsonpatch.JsonPatchOperation{
+				{Operation: "replace", Path: "/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration", Value: `{"data":{}}`},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, err := auditPatch([]byte(tc.before), []byte(tc.after), tc.secret)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(patch, tc.expectedPatch) {
+				t.Fatalf("Unexpected patch %#v, expected %#v", patch, tc.expectedPatch)
+			}
+			if b, _ := json.Marshal(patch); strings.Contains(string(b), "aHVudGVyMg==") || strings.Contains(string(b), "managedFields") {
+				t.Fatalf("Unexpected secret data or managed fields in the patch %s", b)
+			}
+		})
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	record := AuditRecord{Verb: "patch", Version: "v1", Resource: "configmaps", Name: "example", Code: http.StatusOK}
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("Failed to marshal record: %v", err)
+	}
+	// Two records fit in a file.
+	s, err := NewFileAuditSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := s.Record(record); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Record(record); err == nil {
+		t.Fatalf("Expected an error writing to a closed audit log")
+	}
+
+	expected := map[string]int{"audit.log": 1, "audit.log.1": 2, "audit.log.2": 2}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("Unexpected number of audit logs %d, expected %d", len(files), len(expected))
+	}
+	for name, lines := range expected {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to open audit log: %v", err)
+		}
+		scanner := bufio.NewScanner(f)
+		count := 0
+		for scanner.Scan() {
+			actual := AuditRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), &actual); err != nil {
+				t.Fatalf("Failed to parse audit record: %v", err)
+			}
+			if !reflect.DeepEqual(actual, record) {
+				t.Fatalf("Unexpected audit record %#v, expected %#v", actual, record)
+			}
+			count++
+		}
+		f.Close()
+		if count != lines {
+			t.Fatalf("Unexpected number of records %d in %s, expected %d", count, name, lines)
+		}
+	}
+}
@@ -17,12 +17,16 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/operator-framework/operator-sdk/internal/util/k8sutil"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/requestfactory"
 	k8sRequest "github.com/operator-framework/operator-sdk/pkg/ansible/proxy/requestfactory"
@@ -52,6 +56,15 @@ type cacheResponseHandler struct {
 	cMap              *controllermap.ControllerMap
 	injectOwnerRef    bool
 	apiResources      *apiResources
+	// bookmarkInterval is the interval of the bookmark events of the
+	// watches served from the cache, defaultBookmarkInterval if not set.
+	bookmarkInterval time.Duration
+	// minRequestTimeout is the minimum timeout of the watches served from
+	// the cache without timeoutSeconds, defaultMinRequestTimeout if not set.
+	minRequestTimeout time.Duration
+
+	watchMu      sync.Mutex
+	broadcasters map[schema.GroupVersionKind]*cacheWatchBroadcaster
 }
 
 func (c *cacheResponseHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -64,80 +77,111 @@ func (c *cacheResponseHandler) ServeHTTP(w http.ResponseWriter, req *http.Reques
 			log.Error(err, "Failed to convert request")
 			break
 		}
-
-		if c.skipCacheLookup(r) {
-			log.V(2).Info("Skipping cache lookup", "resource", r)
-			break
-		}
-
-		if c.restMapper == nil {
-			c.restMapper = meta.NewDefaultRESTMapper([]schema.GroupVersion{schema.GroupVersion{
-				Group:   r.APIGroup,
-				Version: r.APIVersion,
-			}})
+		served := c.serveFromCache(w, req, r)
+		if r.IsResourceRequest && (r.Verb == "get" || r.Verb == "list" || r.Verb == "watch") {
+			if served {
+				metrics.ProxyCacheHit(r.Verb)
+			} else {
+				metrics.ProxyCacheMiss(r.Verb)
+			}
 		}
-		k, err := getGVKFromRequestInfo(r, c.restMapper)
-		if err != nil {
-			// break here in case resource doesn't exist in cache
-			log.Info("Cache miss, can not find in rest mapper")
-			break
+		if served {
+			// Return so that request isn't passed along to APIserver
+			return
 		}
+	}
+	c.next.ServeHTTP(w, req)
+}
 
-		// Determine if the resource is virtual. If it is then we should not attempt to use cache
-		isVR, err := c.apiResources.IsVirtualResource(k)
-		if err != nil {
-			// break here in case we can not understand if virtual resource or not
-			log.Info("Unable to determine if virtual resource", "gvk", k)
-			break
-		}
+// serveFromCache - writes the response to a get, list or watch request from
+// the cache, or returns false if the request must be sent to the cluster API.
+func (c *cacheResponseHandler) serveFromCache(w http.ResponseWriter, req *http.Request, r *requestfactory.RequestInfo) bool {
+	if c.skipCacheLookup(r) {
+		log.V(2).Info("Skipping cache lookup", "resource", r)
+		return false
+	}
 
-		if isVR {
-			log.V(2).Info("Virtual resource, must ask the cluster API", "gvk", k)
-			break
-		}
+	// The cache is filled with the operator's identity, which must not
+	// be used to read resources for runs that impersonate a service
+	// account, or for requests that are refused because they may have to.
+	if _, ok, err := getRequestServiceAccount(req, c.cMap); ok || err != nil {
+		log.V(2).Info("Skipping cache lookup for an impersonated request", "resource", r)
+		return false
+	}
 
-		var m marshaler
+	if c.restMapper == nil {
+		c.restMapper = meta.NewDefaultRESTMapper([]schema.GroupVersion{schema.GroupVersion{
+			Group:   r.APIGroup,
+			Version: r.APIVersion,
+		}})
+	}
+	k, err := getGVKFromRequestInfo(r, c.restMapper)
+	if err != nil {
+		// return here in case resource doesn't exist in cache
+		log.Info("Cache miss, can not find in rest mapper")
+		return false
+	}
 
-		log.V(2).Info("Get resource in our cache", "r", r)
-		if r.Verb == "list" {
-			m, err = c.getListFromCache(r, req, k)
-			if err != nil {
-				break
-			}
-		} else {
-			m, err = c.getObjectFromCache(r, req, k)
-			if err != nil {
-				break
-			}
-		}
+	// Determine if the resource is virtual. If it is then we should not attempt to use cache
+	isVR, err := c.apiResources.IsVirtualResource(k)
+	if err != nil {
+		// return here in case we can not understand if virtual resource or not
+		log.Info("Unable to determine if virtual resource", "gvk", k)
+		return false
+	}
 
-		i := bytes.Buffer{}
-		resp, err := m.MarshalJSON()
-		if err != nil {
-			// return will give a 500
-			log.Error(err, "Failed to marshal data")
-			http.Error(w, "", http.StatusInternalServerError)
-			return
-		}
+	if isVR {
+		log.V(2).Info("Virtual resource, must ask the cluster API", "gvk", k)
+		return false
+	}
 
-		// Set Content-Type header
-		w.Header().Set("Content-Type", "application/json")
-		// Set X-Cache header to signal that response is served from Cache
-		w.Header().Set("X-Cache", "HIT")
-		if err := json.Indent(&i, resp, "", "  "); err != nil {
-			log.Error(err, "Failed to indent json")
-		}
-		_, err = w.Write(i.Bytes())
-		if err != nil {
-			log.Error(err, "Failed to write response")
-			http.Error(w, "", http.StatusInternalServerError)
-			return
-		}
+	// Reading from the cache starts an informer for the GVK, so
+	// resources that are not watched for the request's owner are read
+	// from the cluster API.
+	if !c.allowsCacheLookup(req, k) {
+		log.V(2).Info("Dependent resource is not watched, must ask the cluster API", "gvk", k)
+		return false
+	}
 
-		// Return so that request isn't passed along to APIserver
-		return
+	var m marshaler
+
+	log.V(2).Info("Get resource in our cache", "r", r)
+	switch r.Verb {
+	case "list":
+		m, err = c.getListFromCache(r, req, k)
+	case "get":
+		m, err = c.getObjectFromCache(r, req, k)
+	case "watch":
+		return c.serveWatchFromCache(w, req, r, k)
+	default:
+		return false
 	}
-	c.next.ServeHTTP(w, req)
+	if err != nil {
+		return false
+	}
+
+	i := bytes.Buffer{}
+	resp, err := m.MarshalJSON()
+	if err != nil {
+		// return will give a 500
+		log.Error(err, "Failed to marshal data")
+		http.Error(w, "", http.StatusInternalServerError)
+		return true
+	}
+
+	// Set Content-Type header
+	w.Header().Set("Content-Type", "application/json")
+	// Set X-Cache header to signal that response is served from Cache
+	w.Header().Set("X-Cache", "HIT")
+	if err := json.Indent(&i, resp, "", "  "); err != nil {
+		log.Error(err, "Failed to indent json")
+	}
+	_, err = w.Write(i.Bytes())
+	if err != nil {
+		log.Error(err, "Failed to write response")
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+	return true
 }
 
 // skipCacheLookup - determine if we should skip the cache lookup
@@ -165,6 +209,20 @@ func (c *cacheResponseHandler) skipCacheLookup(r *requestfactory.RequestInfo) bo
 	return false
 }
 
+// allowsCacheLookup - returns false if resources of the GVK may not be watched
+// for the owner of the request.
+func (c *cacheResponseHandler) allowsCacheLookup(req *http.Request, gvk schema.GroupVersionKind) bool {
+	owner, err := getRequestOwnerRef(req)
+	if err != nil {
+		return true
+	}
+	ownerGVK := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+	if _, ok := c.cMap.Get(ownerGVK); !ok || gvk == ownerGVK {
+		return true
+	}
+	return c.cMap.AllowsDependentWatch(ownerGVK, gvk)
+}
+
 func (c *cacheResponseHandler) recoverDependentWatches(req *http.Request, un *unstructured.Unstructured) {
 	ownerRef, err := getRequestOwnerRef(req)
 	if err != nil {
@@ -203,39 +261,84 @@ func (c *cacheResponseHandler) getListFromCache(r *requestfactory.RequestInfo, r
 		log.Error(err, "Unable to decode list options from request")
 		return nil, err
 	}
+	resourceVersion, err := c.cacheResourceVersion(k, k8sListOpts.ResourceVersion)
+	if err != nil {
+		log.V(2).Info("Cache miss, a resource version was requested", "gvk", k,
+			"resourceVersion", k8sListOpts.ResourceVersion)
+		return nil, err
+	}
+	start := ""
+	if k8sListOpts.Continue != "" {
+		// Continue tokens of the cluster API can not be served from the cache.
+		if start, err = decodeCacheContinue(k8sListOpts.Continue); err != nil {
+			log.V(2).Info("Cache miss, continue token was not issued by the cache", "gvk", k)
+			return nil, err
+		}
+	}
 	clientListOpts := []client.ListOption{
 		client.InNamespace(r.Namespace),
 	}
 	if k8sListOpts.LabelSelector != "" {
-		sel, err := labels.ConvertSelectorToLabelsMap(k8sListOpts.LabelSelector)
+		sel, err := labels.Parse(k8sListOpts.LabelSelector)
 		if err != nil {
-			log.Error(err, "Unable to convert label selectors for the client")
+			log.Error(err, "Unable to parse label selectors for the client")
 			return nil, err
 		}
-		clientListOpts = append(clientListOpts, client.MatchingLabels(sel))
+		clientListOpts = append(clientListOpts, client.MatchingLabelsSelector{Selector: sel})
 	}
-	if k8sListOpts.FieldSelector != "" {
-		sel, err := fields.ParseSelector(k8sListOpts.FieldSelector)
-		if err != nil {
-			log.Error(err, "Unable to parse field selectors for the client")
-			return nil, err
-		}
-		clientListOpts = append(clientListOpts, k8sutil.MatchingFields{Sel: sel})
+	// The cache only supports field selectors of indexed fields, so fields
+	// are matched against the listed objects.
+	fieldSel, err := parseCacheFieldSelector(k8sListOpts.FieldSelector)
+	if err != nil {
+		log.V(2).Info("Cache miss, field selector is not supported by the cache", "gvk", k,
+			"fieldSelector", k8sListOpts.FieldSelector, "error", err.Error())
+		return nil, err
 	}
 	k.Kind = k.Kind + "List"
 	un := unstructured.UnstructuredList{}
 	un.SetGroupVersionKind(k)
-	err := c.informerCache.List(context.Background(), &un, clientListOpts...)
+	err = c.informerCache.List(context.Background(), &un, clientListOpts...)
 	if err != nil {
-		// break here in case resource doesn't exist in cache but exists on APIserver
+		// return here in case resource doesn't exist in cache but exists on APIserver
 		// This is very unlikely but provides user with expected 404
 		log.Info(fmt.Sprintf("cache miss: %v err-%v", k, err))
 		return nil, err
 	}
+
+	// Lists are sorted by key like the lists of the cluster API, so that
+	// pages continue after the key of the last item of the previous page.
+	items := make([]unstructured.Unstructured, 0, len(un.Items))
+	for _, item := range un.Items {
+		if cacheKey(&item) > start && fieldSel.Matches(objectFields(&item)) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return cacheKey(&items[i]) < cacheKey(&items[j])
+	})
+	if k8sListOpts.Limit > 0 && int64(len(items)) > k8sListOpts.Limit {
+		remaining := int64(len(items)) - k8sListOpts.Limit
+		items = items[:k8sListOpts.Limit]
+		token, err := encodeCacheContinue(cacheKey(&items[len(items)-1]))
+		if err != nil {
+			log.Error(err, "Unable to encode continue token")
+			return nil, err
+		}
+		un.SetContinue(token)
+		un.SetRemainingItemCount(&remaining)
+	}
+	un.Items = items
+	un.SetResourceVersion(resourceVersion)
 	return &un, nil
 }
 
 func (c *cacheResponseHandler) getObjectFromCache(r *requestfactory.RequestInfo, req *http.Request, k schema.GroupVersionKind) (marshaler, error) {
+	resourceVersion := req.URL.Query().Get("resourceVersion")
+	if _, err := c.cacheResourceVersion(k, resourceVersion); err != nil {
+		log.V(2).Info("Cache miss, a resource version was requested", "gvk", k,
+			"resourceVersion", resourceVersion)
+		return nil, err
+	}
 	un := &unstructured.Unstructured{}
 	un.SetGroupVersionKind(k)
 	obj := client.ObjectKey{Namespace: r.Namespace, Name: r.Name}
@@ -253,3 +356,98 @@ func (c *cacheResponseHandler) getObjectFromCache(r *requestfactory.RequestInfo,
 	}
 	return un, nil
 }
+
+// lastSyncResourceVersioner - the informers of the cache that report the
+// resource version they are synced to.
+type lastSyncResourceVersioner interface {
+	LastSyncResourceVersion() string
+}
+
+// cacheResourceVersion - returns the resource version that the cache of the
+// GVK is synced to. Only requests without a resource version or with "0",
+// which accept any state of the cache, can be served from the cache; resource
+// versions are opaque, so other requested versions can't be compared with
+// the cache's and return an error.
+func (c *cacheResponseHandler) cacheResourceVersion(k schema.GroupVersionKind, requested string) (string, error) {
+	if requested != "" && requested != "0" {
+		return "", fmt.Errorf("resource version %q of %v can not be served from the cache", requested, k)
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(k)
+	if i, err := c.informerCache.GetInformer(obj); err == nil {
+		if v, ok := i.(lastSyncResourceVersioner); ok {
+			return v.LastSyncResourceVersion(), nil
+		}
+	}
+	return "", nil
+}
+
+// cacheContinueVersion - the version of the continue tokens of the lists
+// served from the cache, which tells them apart from the tokens of the
+// cluster API.
+const cacheContinueVersion = "ansible-operator.proxy/v1"
+
+type cacheContinueToken struct {
+	Version string `json:"v"`
+	Start   string `json:"start"`
+}
+
+func encodeCacheContinue(start string) (string, error) {
+	b, err := json.Marshal(cacheContinueToken{Version: cacheContinueVersion, Start: start})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCacheContinue - returns the key that the page of a continue token
+// issued by the cache starts after.
+func decodeCacheContinue(token string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid continue token: %w", err)
+	}
+	c := cacheContinueToken{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return "", fmt.Errorf("invalid continue token: %w", err)
+	}
+	if c.Version != cacheContinueVersion || c.Start == "" {
+		return "", fmt.Errorf("continue token was not issued by the cache")
+	}
+	return c.Start, nil
+}
+
+// cacheKey - returns the key that lists are sorted by, which is the
+// namespace and name of the object.
+func cacheKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// parseCacheFieldSelector - parses a field selector of a request that is
+// served from the cache. Only the metadata.name and metadata.namespace fields,
+// which all resources support, are matched by the cache; selectors of other
+// fields return an error, so that the request is sent to the cluster API.
+func parseCacheFieldSelector(selector string) (fields.Selector, error) {
+	sel, err := fields.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range sel.Requirements() {
+		if r.Field != "metadata.name" && r.Field != "metadata.namespace" {
+			return nil, fmt.Errorf("field %q is not supported", r.Field)
+		}
+	}
+	return sel, nil
+}
+
+// objectFields - returns the fields of an object that the field selectors of
+// the cache can match.
+func objectFields(obj *unstructured.Unstructured) fields.Set {
+	return fields.Set{
+		"metadata.name":      obj.GetName(),
+		"metadata.namespace": obj.GetNamespace(),
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	kmetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type fakeInformer struct {
+	cache.Informer
+	cache *fakeCache
+}
+
+func (i *fakeInformer) LastSyncResourceVersion() string {
+	return i.cache.resourceVersion
+}
+
+func (i *fakeInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	i.cache.handlers = append(i.cache.handlers, handler)
+}
+
+// fakeCache - a cache that supports the same list options as the informer
+// cache without indexes, i.e. no field selectors.
+type fakeCache struct {
+	cache.Cache
+	objects         []*unstructured.Unstructured
+	resourceVersion string
+	handlers        []toolscache.ResourceEventHandler
+}
+
+func (c *fakeCache) Get(_ context.Context, key client.ObjectKey, obj runtime.Object) error {
+	for _, o := range c.objects {
+		if o.GetNamespace() == key.Namespace && o.GetName() == key.Name {
+			o.DeepCopyInto(obj.(*unstructured.Unstructured))
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, key.Name)
+}
+
+func (c *fakeCache) List(_ context.Context, list runtime.Object, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector != nil {
+		return fmt.Errorf("field selectors are not supported")
+	}
+	ul := list.(*unstructured.UnstructuredList)
+	for _, o := range c.objects {
+		if listOpts.Namespace != "" && o.GetNamespace() != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(o.GetLabels())) {
+			continue
+		}
+		ul.Items = append(ul.Items, *o.DeepCopy())
+	}
+	return nil
+}
+
+func (c *fakeCache) GetInformer(runtime.Object) (cache.Informer, error) {
+	return &fakeInformer{cache: c}, nil
+}
+
+func newPod(namespace, name, app, phase, resourceVersion string) *unstructured.Unstructured {
+	pod := &unstructured.Unstructured{}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetNamespace(namespace)
+	pod.SetName(name)
+	pod.SetLabels(map[string]string{"app": app})
+	pod.SetResourceVersion(resourceVersion)
+	_ = unstructured.SetNestedField(pod.Object, phase, "status", "phase")
+	return pod
+}
+
+// newTestCacheResponseHandler - returns a handler that serves pods from the
+// cache, and responds with 204 No Content to the requests that it passes on.
+func newTestCacheResponseHandler(informerCache *fakeCache) *cacheResponseHandler {
+	podGVK := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(podGVK, meta.RESTScopeNamespace)
+	return &cacheResponseHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		informerCache:     informerCache,
+		restMapper:        restMapper,
+		watchedNamespaces: map[string]interface{}{"": nil},
+		cMap:              controllermap.NewControllerMap(),
+		apiResources: &apiResources{
+			mu: &sync.RWMutex{},
+			gvkToAPIResource: map[string]kmetav1.APIResource{
+				podGVK.String(): {Name: "pods", Namespaced: true, Kind: "Pod", Verbs: []string{"get", "list", "watch"}},
+			},
+		},
+	}
+}
+
+func TestCacheResponseHandler(t *testing.T) {
+	informerCache := &fakeCache{
+		objects: []*unstructured.Unstructured{
+			newPod("default", "d", "web", "Running", "8"),
+			newPod("other", "e", "web", "Running", "9"),
+			newPod("default", "b", "web", "Pending", "10"),
+			newPod("default", "a", "web", "Running", "11"),
+			newPod("default", "c", "db", "Running", "12"),
+		},
+		resourceVersion: "12",
+	}
+	h := newTestCacheResponseHandler(informerCache)
+
+	testCases := []struct {
+		name  string
+		path  string
+		query url.Values
+		// expectedPages are the names of the items of each page, or nil if
+		// the request is not served from the cache.
+		expectedPages [][]string
+	}{
+		{
+			name:          "list",
+			path:          "/api/v1/namespaces/default/pods",
+			expectedPages: [][]string{{"a", "b", "c", "d"}},
+		},
+		{
+			name:          "list across all namespaces",
+			path:          "/api/v1/pods",
+			expectedPages: [][]string{{"a", "b", "c", "d", "e"}},
+		},
+		{
+			name:          "equality label selector",
+			path:          "/api/v1/namespaces/default/pods",
+			query:         url.Values{"labelSelector": {"app=web"}},
+			expectedPages: [][]string{{"a", "b", "d"}},
+		},
+		{
+			name:          "set-based label selector",
+			path:          "/api/v1/namespaces/default/pods",
+			query:         url.Values{"labelSelector": {"app notin (web)"}},
+			expectedPages: [][]string{{"c"}},
+		},
+		{
+			name:          "field selector",
+			path:          "/api/v1/pods",
+			query:         url.Values{"fieldSelector": {"metadata.namespace=default,metadata.name!=a"}},
+			expectedPages: [][]string{{"b", "c", "d"}},
+		},
+		{
+			name:  "field selector of an unsupported field",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"fieldSelector": {"status.phase=Running"}},
+		},
+		{
+			name:  "invalid label selector",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"labelSelector": {"app in web"}},
+		},
+		{
+			name:          "pagination",
+			path:          "/api/v1/pods",
+			query:         url.Values{"limit": {"2"}},
+			expectedPages: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name:          "pagination with selectors",
+			path:          "/api/v1/pods",
+			query:         url.Values{"limit": {"2"}, "labelSelector": {"app=web"}, "fieldSelector": {"metadata.name!=b"}},
+			expectedPages: [][]string{{"a", "d"}, {"e"}},
+		},
+		{
+			name:  "continue token of the cluster API",
+			path:  "/api/v1/pods",
+			query: url.Values{"limit": {"2"}, "continue": {"eyJ2IjoibWV0YS5rOHMuaW8vdjEiLCJydiI6MTIsInN0YXJ0IjoiYSJ9"}},
+		},
+		{
+			name:          "any resource version",
+			path:          "/api/v1/namespaces/default/pods",
+			query:         url.Values{"resourceVersion": {"0"}},
+			expectedPages: [][]string{{"a", "b", "c", "d"}},
+		},
+		{
+			name:  "resource version of the cache",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"resourceVersion": {"12"}},
+		},
+		{
+			name:  "other resource version",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"resourceVersion": {"13"}},
+		},
+		{
+			name:          "get",
+			path:          "/api/v1/namespaces/default/pods/a",
+			expectedPages: [][]string{{"a"}},
+		},
+		{
+			name:          "get of any resource version",
+			path:          "/api/v1/namespaces/default/pods/a",
+			query:         url.Values{"resourceVersion": {"0"}},
+			expectedPages: [][]string{{"a"}},
+		},
+		{
+			name:  "get of a resource version",
+			path:  "/api/v1/namespaces/default/pods/a",
+			query: url.Values{"resourceVersion": {"10"}},
+		},
+		{
+			name: "get of a missing object",
+			path: "/api/v1/namespaces/default/pods/missing",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query := url.Values{}
+			for k, v := range tc.query {
+				query[k] = v
+			}
+			pages := [][]string{}
+			for {
+				req := httptest.NewRequest(http.MethodGet, tc.path+"?"+query.Encode(), nil)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				if w.Code == http.StatusNoContent {
+					if tc.expectedPages != nil {
+						t.Fatalf("Unexpected cache miss after %d pages", len(pages))
+					}
+					return
+				}
+				if tc.expectedPages == nil {
+					t.Fatalf("Unexpected cache hit: %s", w.Body.String())
+				}
+				obj := &unstructured.Unstructured{}
+				if err := json.Unmarshal(w.Body.Bytes(), obj); err != nil {
+					t.Fatalf("Failed to parse response: %v", err)
+				}
+				if !obj.IsList() {
+					pages = append(pages, []string{obj.GetName()})
+					break
+				}
+				list, err := obj.ToList()
+				if err != nil {
+					t.Fatalf("Failed to parse list: %v", err)
+				}
+				if list.GetResourceVersion() != informerCache.resourceVersion {
+					t.Fatalf("Unexpected resource version %q, expected %q", list.GetResourceVersion(), informerCache.resourceVersion)
+				}
+				page := []string{}
+				for _, item := range list.Items {
+					page = append(page, item.GetName())
+				}
+				pages = append(pages, page)
+				if list.GetContinue() == "" {
+					if list.GetRemainingItemCount() != nil {
+						t.Fatalf("Unexpected remaining item count %d of the last page", *list.GetRemainingItemCount())
+					}
+					break
+				}
+				if list.GetRemainingItemCount() == nil {
+					t.Fatalf("Missing remaining item count")
+				}
+				query.Set("continue", list.GetContinue())
+			}
+			if !reflect.DeepEqual(pages, tc.expectedPages) {
+				t.Fatalf("Unexpected pages %v, expected %v", pages, tc.expectedPages)
+			}
+		})
+	}
+}
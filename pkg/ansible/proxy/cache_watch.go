@@ -0,0 +1,354 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/requestfactory"
+
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// cacheWatchBufferSize - the number of events buffered for each watch
+	// served from the cache. Watches that fall further behind are ended, so
+	// that their clients watch again.
+	cacheWatchBufferSize = 100
+	// defaultBookmarkInterval - the interval of the bookmark events of the
+	// watches served from the cache that allow them.
+	defaultBookmarkInterval = time.Minute
+	// defaultMinRequestTimeout - the minimum timeout of the watches served
+	// from the cache without timeoutSeconds, like the default
+	// --min-request-timeout of the API server.
+	defaultMinRequestTimeout = 1800 * time.Second
+)
+
+// sentObject - the version of an object that a watch sent to its client.
+type sentObject struct {
+	uid             types.UID
+	resourceVersion string
+}
+
+type cacheWatchEvent struct {
+	obj     *unstructured.Unstructured
+	deleted bool
+}
+
+// cacheWatchBroadcaster - sends the events of the informer of a GVK to the
+// watches served from the cache. Event handlers can not be removed from
+// informers, so each informer has a single handler for all of its watches.
+type cacheWatchBroadcaster struct {
+	mu      sync.Mutex
+	watches map[chan cacheWatchEvent]struct{}
+}
+
+func (b *cacheWatchBroadcaster) OnAdd(obj interface{}) {
+	b.broadcast(obj, false)
+}
+
+func (b *cacheWatchBroadcaster) OnUpdate(_, newObj interface{}) {
+	b.broadcast(newObj, false)
+}
+
+func (b *cacheWatchBroadcaster) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	b.broadcast(obj, true)
+}
+
+func (b *cacheWatchBroadcaster) broadcast(obj interface{}, deleted bool) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.watches {
+		select {
+		case events <- cacheWatchEvent{obj: u, deleted: deleted}:
+		default:
+			// The informer must not wait for a slow watch.
+			delete(b.watches, events)
+			close(events)
+		}
+	}
+}
+
+func (b *cacheWatchBroadcaster) subscribe() chan cacheWatchEvent {
+	events := make(chan cacheWatchEvent, cacheWatchBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.watches[events] = struct{}{}
+	return events
+}
+
+func (b *cacheWatchBroadcaster) unsubscribe(events chan cacheWatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.watches[events]; ok {
+		delete(b.watches, events)
+		close(events)
+	}
+}
+
+// watchBroadcaster - returns the broadcaster of the events of the informer
+// of a GVK, which starts the informer if it is not running yet.
+func (c *cacheResponseHandler) watchBroadcaster(k schema.GroupVersionKind) (*cacheWatchBroadcaster, error) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if b, ok := c.broadcasters[k]; ok {
+		return b, nil
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(k)
+	i, err := c.informerCache.GetInformer(obj)
+	if err != nil {
+		return nil, err
+	}
+	b := &cacheWatchBroadcaster{watches: map[chan cacheWatchEvent]struct{}{}}
+	i.AddEventHandler(b)
+	if c.broadcasters == nil {
+		c.broadcasters = map[schema.GroupVersionKind]*cacheWatchBroadcaster{}
+	}
+	c.broadcasters[k] = b
+	return b, nil
+}
+
+// serveWatchFromCache - streams the events of a watch request from the
+// informer of the cache, or returns false if the request must be sent to
+// the cluster API.
+func (c *cacheResponseHandler) serveWatchFromCache(w http.ResponseWriter, req *http.Request, r *requestfactory.RequestInfo, k schema.GroupVersionKind) bool {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return false
+	}
+	k8sListOpts := &metav1.ListOptions{}
+	if err := metainternalversion.ParameterCodec.DecodeParameters(req.URL.Query(), metav1.SchemeGroupVersion, k8sListOpts); err != nil {
+		log.Error(err, "Unable to decode list options from request")
+		return false
+	}
+	labelSel, err := labels.Parse(k8sListOpts.LabelSelector)
+	if err != nil {
+		log.Error(err, "Unable to parse label selectors for the client")
+		return false
+	}
+	// A watch from a resource version is served from the cache only if the
+	// cache is synced to that version, e.g. when the client listed from the
+	// cache and nothing changed since. Other resource versions are opaque and
+	// can't be compared with the cache's, so their watches are sent to the
+	// cluster API.
+	fromVersion := k8sListOpts.ResourceVersion != "" && k8sListOpts.ResourceVersion != "0"
+	fieldSel, err := parseCacheFieldSelector(k8sListOpts.FieldSelector)
+	if err != nil {
+		log.V(2).Info("Cache miss, field selector is not supported by the cache", "gvk", k,
+			"fieldSelector", k8sListOpts.FieldSelector, "error", err.Error())
+		return false
+	}
+	if r.Name != "" {
+		fieldSel = fields.AndSelectors(fieldSel, fields.OneTermEqualSelector("metadata.name", r.Name))
+	}
+	matches := func(obj *unstructured.Unstructured) bool {
+		return (r.Namespace == metav1.NamespaceAll || obj.GetNamespace() == r.Namespace) &&
+			labelSel.Matches(labels.Set(obj.GetLabels())) && fieldSel.Matches(objectFields(obj))
+	}
+
+	b, err := c.watchBroadcaster(k)
+	if err != nil {
+		log.Info("Cache miss, can not watch the informer", "gvk", k, "error", err.Error())
+		return false
+	}
+	// The watch receives the events of the changes after it subscribes,
+	// which may already be in the cache when it is listed. The cache
+	// contains the changes up to the resource version read before it is
+	// listed.
+	events := b.subscribe()
+	defer b.unsubscribe(events)
+	progress, err := c.cacheResourceVersion(k, "")
+	if err != nil {
+		return false
+	}
+	if fromVersion && progress != k8sListOpts.ResourceVersion {
+		log.V(2).Info("Cache miss, the cache is not synced to the requested resource version", "gvk", k,
+			"resourceVersion", k8sListOpts.ResourceVersion, "cacheResourceVersion", progress)
+		return false
+	}
+	listGVK := k
+	listGVK.Kind = listGVK.Kind + "List"
+	un := unstructured.UnstructuredList{}
+	un.SetGroupVersionKind(listGVK)
+	if err := c.informerCache.List(context.Background(), &un, client.InNamespace(r.Namespace)); err != nil {
+		log.Info("Cache miss, can not list the informer", "gvk", k, "error", err.Error())
+		return false
+	}
+	// A watch from the resource version of the cache must not miss the
+	// changes that reach the cache while it is listed, which the client
+	// doesn't know yet.
+	if fromVersion {
+		if v, err := c.cacheResourceVersion(k, ""); err != nil || v != progress {
+			log.V(2).Info("Cache miss, the cache changed while it was listed", "gvk", k,
+				"resourceVersion", k8sListOpts.ResourceVersion)
+			return false
+		}
+	}
+	// sent are the versions of the objects the client knows. The client of a
+	// watch from the resource version of the cache knows the listed objects
+	// already, so they are not sent to it.
+	sent := map[string]sentObject{}
+	initial := []unstructured.Unstructured{}
+	for _, item := range un.Items {
+		if matches(&item) {
+			sent[cacheKey(&item)] = sentObject{uid: item.GetUID(), resourceVersion: item.GetResourceVersion()}
+			if !fromVersion {
+				initial = append(initial, item)
+			}
+		}
+	}
+	sort.Slice(initial, func(i, j int) bool {
+		return cacheKey(&initial[i]) < cacheKey(&initial[j])
+	})
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.watchTimeout(k8sListOpts.TimeoutSeconds))
+	defer cancel()
+	interval := c.bookmarkInterval
+	if interval == 0 {
+		interval = defaultBookmarkInterval
+	}
+	bookmarks := time.NewTicker(interval)
+	defer bookmarks.Stop()
+
+	w.Header().Set("Content-Type", "application/json")
+	// Set X-Cache header to signal that response is served from Cache
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(http.StatusOK)
+	for i := range initial {
+		if err := writeWatchEvent(w, watch.Added, &initial[i]); err != nil {
+			return true
+		}
+	}
+	flusher.Flush()
+	log.V(2).Info("Serving watch from the cache", "gvk", k, "namespace", r.Namespace)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case e, ok := <-events:
+			if !ok {
+				log.V(1).Info("Ending watch that fell behind the cache", "gvk", k, "namespace", r.Namespace)
+				return true
+			}
+			key := cacheKey(e.obj)
+			resourceVersion := e.obj.GetResourceVersion()
+			// The informer sends its events in order, so the resource
+			// version of the last event is the newest one of the watch.
+			// Events that were already in the cache when it was listed may
+			// set an older one, which the client can still watch from.
+			progress = resourceVersion
+			sentObj, known := sent[key]
+			// Skip the events of changes that the client already knows, and
+			// the deletions of objects that were replaced by the ones the
+			// client knows.
+			if known && ((!e.deleted && resourceVersion == sentObj.resourceVersion) ||
+				(e.deleted && e.obj.GetUID() != sentObj.uid)) {
+				continue
+			}
+			// Objects are added to and deleted from the watch as they start
+			// and stop matching its selectors.
+			match := !e.deleted && matches(e.obj)
+			var t watch.EventType
+			switch {
+			case match && known:
+				t = watch.Modified
+			case match:
+				t = watch.Added
+			case known:
+				t = watch.Deleted
+			default:
+				continue
+			}
+			if match {
+				sent[key] = sentObject{uid: e.obj.GetUID(), resourceVersion: resourceVersion}
+			} else {
+				delete(sent, key)
+			}
+			if err := writeWatchEvent(w, t, e.obj); err != nil {
+				return true
+			}
+			flusher.Flush()
+		case <-bookmarks.C:
+			if !k8sListOpts.AllowWatchBookmarks || progress == "" {
+				continue
+			}
+			bookmark := &unstructured.Unstructured{}
+			bookmark.SetGroupVersionKind(k)
+			bookmark.SetResourceVersion(progress)
+			if err := writeWatchEvent(w, watch.Bookmark, bookmark); err != nil {
+				return true
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// watchTimeout - returns the timeout of a watch served from the cache. Like
+// the API server, watches without timeoutSeconds end after a random timeout
+// between the minimum request timeout and twice that, so that the watches of
+// clients that start together don't all end together.
+func (c *cacheResponseHandler) watchTimeout(timeoutSeconds *int64) time.Duration {
+	if timeoutSeconds != nil && *timeoutSeconds > 0 {
+		return time.Duration(*timeoutSeconds) * time.Second
+	}
+	min := c.minRequestTimeout
+	if min == 0 {
+		min = defaultMinRequestTimeout
+	}
+	return time.Duration(float64(min) * (1 + rand.Float64()))
+}
+
+func writeWatchEvent(w http.ResponseWriter, t watch.EventType, obj *unstructured.Unstructured) error {
+	raw, err := obj.MarshalJSON()
+	if err != nil {
+		log.Error(err, "Failed to marshal data")
+		return err
+	}
+	b, err := json.Marshal(metav1.WatchEvent{Type: string(t), Object: runtime.RawExtension{Raw: raw}})
+	if err != nil {
+		log.Error(err, "Failed to marshal watch event")
+		return err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		log.V(1).Info("Failed to write watch event", "error", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	kmetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// flushRecorder - a response recorder that signals each flush, after which
+// the handler of a watch waits for the next event.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan struct{}
+}
+
+func (r *flushRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	r.flushed <- struct{}{}
+}
+
+// serveWatch - serves a watch request, calls events once the initial events
+// are written and waits for the given number of further flushes. It returns
+// the events of the watch as "<type> <name>", with the resource version as
+// the name of bookmarks, or false if the request was passed on.
+func serveWatch(t *testing.T, h http.Handler, path string, query url.Values, events func(), flushes int) ([]string, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil).WithContext(ctx)
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Unexpected status code %d", rec.Code)
+		}
+		return nil, false
+	case <-rec.flushed:
+	}
+	if events != nil {
+		events()
+	}
+	for i := 0; i < flushes; i++ {
+		select {
+		case <-rec.flushed:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for %d events, got %d", flushes, i)
+		}
+	}
+	cancel()
+	for stopped := false; !stopped; {
+		select {
+		case <-rec.flushed:
+		case <-done:
+			stopped = true
+		}
+	}
+
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("Unexpected response %d %v", rec.Code, rec.Header())
+	}
+	result := []string{}
+	decoder := json.NewDecoder(rec.Body)
+	for {
+		e := kmetav1.WatchEvent{}
+		if err := decoder.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to parse watch event: %v", err)
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(e.Object.Raw); err != nil {
+			t.Fatalf("Failed to parse object: %v", err)
+		}
+		if e.Type == string(watch.Bookmark) {
+			result = append(result, fmt.Sprintf("%s %s", e.Type, obj.GetResourceVersion()))
+			continue
+		}
+		result = append(result, fmt.Sprintf("%s %s", e.Type, obj.GetName()))
+	}
+	return result, true
+}
+
+func TestCacheWatch(t *testing.T) {
+	newCache := func() *fakeCache {
+		return &fakeCache{
+			objects: []*unstructured.Unstructured{
+				newPod("default", "b", "web", "Pending", "10"),
+				newPod("default", "a", "web", "Running", "11"),
+				newPod("default", "c", "db", "Running", "12"),
+				newPod("other", "e", "web", "Running", "9"),
+			},
+			resourceVersion: "12",
+		}
+	}
+	testCases := []struct {
+		name  string
+		path  string
+		query url.Values
+		// events are sent to the informer's handlers once the watch started.
+		events           func(handler toolscache.ResourceEventHandler)
+		flushes          int
+		bookmarkInterval time.Duration
+		// expectedEvents are nil if the request is not served from the cache.
+		expectedEvents []string
+	}{
+		{
+			name:           "initial events",
+			path:           "/api/v1/namespaces/default/pods",
+			query:          url.Values{"watch": {"true"}},
+			expectedEvents: []string{"ADDED a", "ADDED b", "ADDED c"},
+		},
+		{
+			name:           "selectors",
+			path:           "/api/v1/namespaces/default/pods",
+			query:          url.Values{"watch": {"true"}, "labelSelector": {"app=web"}, "fieldSelector": {"metadata.name!=b"}},
+			expectedEvents: []string{"ADDED a"},
+		},
+		{
+			name:  "field selector of an unsupported field",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"watch": {"true"}, "fieldSelector": {"status.phase=Running"}},
+		},
+		{
+			name:           "single object",
+			path:           "/api/v1/watch/namespaces/default/pods/b",
+			expectedEvents: []string{"ADDED b"},
+		},
+		{
+			name:           "all namespaces",
+			path:           "/api/v1/pods",
+			query:          url.Values{"watch": {"true"}, "labelSelector": {"app=web"}},
+			expectedEvents: []string{"ADDED a", "ADDED b", "ADDED e"},
+		},
+		{
+			name:  "changes",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"watch": {"true"}, "labelSelector": {"app=web"}},
+			events: func(handler toolscache.ResourceEventHandler) {
+				// A change that is already in the cache.
+				handler.OnAdd(newPod("default", "a", "web", "Running", "11"))
+				handler.OnUpdate(nil, newPod("default", "b", "web", "Running", "13"))
+				// c starts and a stops matching the label selector.
+				handler.OnUpdate(nil, newPod("default", "c", "web", "Running", "14"))
+				handler.OnUpdate(nil, newPod("default", "a", "db", "Running", "15"))
+				handler.OnDelete(newPod("default", "b", "web", "Running", "16"))
+				handler.OnAdd(newPod("other", "f", "web", "Running", "17"))
+				handler.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "default/c", Obj: newPod("default", "c", "web", "Running", "14")})
+				handler.OnAdd(newPod("default", "g", "web", "Pending", "19"))
+			},
+			flushes:        6,
+			expectedEvents: []string{"ADDED a", "ADDED b", "MODIFIED b", "ADDED c", "DELETED a", "DELETED b", "DELETED c", "ADDED g"},
+		},
+		{
+			name:  "deletion of a replaced object",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"watch": {"true"}, "labelSelector": {"app=db"}},
+			events: func(handler toolscache.ResourceEventHandler) {
+				// c was deleted and created again before the cache was listed.
+				deleted := newPod("default", "c", "db", "Running", "7")
+				deleted.SetUID("old")
+				handler.OnDelete(deleted)
+				handler.OnUpdate(nil, newPod("default", "c", "db", "Pending", "13"))
+			},
+			flushes:        1,
+			expectedEvents: []string{"ADDED c", "MODIFIED c"},
+		},
+		{
+			name:           "any resource version",
+			path:           "/api/v1/namespaces/default/pods",
+			query:          url.Values{"watch": {"true"}, "resourceVersion": {"0"}, "labelSelector": {"app=db"}},
+			expectedEvents: []string{"ADDED c"},
+		},
+		{
+			name:           "resource version of the cache",
+			path:           "/api/v1/namespaces/default/pods",
+			query:          url.Values{"watch": {"true"}, "resourceVersion": {"12"}},
+			expectedEvents: []string{},
+		},
+		{
+			name:  "changes from the resource version of the cache",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"watch": {"true"}, "resourceVersion": {"12"}, "labelSelector": {"app=web"}},
+			events: func(handler toolscache.ResourceEventHandler) {
+				// A change that the client already knows.
+				handler.OnAdd(newPod("default", "a", "web", "Running", "11"))
+				handler.OnUpdate(nil, newPod("default", "b", "web", "Running", "13"))
+				handler.OnAdd(newPod("default", "g", "web", "Pending", "14"))
+			},
+			flushes:        2,
+			expectedEvents: []string{"MODIFIED b", "ADDED g"},
+		},
+		{
+			name:  "older resource version",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"watch": {"true"}, "resourceVersion": {"11"}},
+		},
+		{
+			name:             "bookmarks",
+			path:             "/api/v1/namespaces/default/pods",
+			query:            url.Values{"watch": {"true"}, "labelSelector": {"app=db"}, "allowWatchBookmarks": {"true"}},
+			bookmarkInterval: 50 * time.Millisecond,
+			flushes:          1,
+			expectedEvents:   []string{"ADDED c", "BOOKMARK 12"},
+		},
+		{
+			name:  "bookmarks after changes",
+			path:  "/api/v1/namespaces/default/pods",
+			query: url.Values{"watch": {"true"}, "labelSelector": {"app=db"}, "allowWatchBookmarks": {"true"}},
+			events: func(handler toolscache.ResourceEventHandler) {
+				// Changes of objects that are not watched advance the bookmarks.
+				handler.OnUpdate(nil, newPod("default", "a", "web", "Pending", "20"))
+			},
+			bookmarkInterval: 50 * time.Millisecond,
+			flushes:          1,
+			expectedEvents:   []string{"ADDED c", "BOOKMARK 20"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			informerCache := newCache()
+			h := newTestCacheResponseHandler(informerCache)
+			h.bookmarkInterval = tc.bookmarkInterval
+			if h.bookmarkInterval == 0 {
+				h.bookmarkInterval = time.Hour
+			}
+			var events func()
+			if tc.events != nil {
+				events = func() {
+					if len(informerCache.handlers) != 1 {
+						t.Fatalf("Unexpected number of event handlers %d", len(informerCache.handlers))
+					}
+					tc.events(informerCache.handlers[0])
+				}
+			}
+			actual, served := serveWatch(t, h, tc.path, tc.query, events, tc.flushes)
+			if !served {
+				if tc.expectedEvents != nil {
+					t.Fatalf("Unexpected cache miss")
+				}
+				return
+			}
+			if !reflect.DeepEqual(actual, tc.expectedEvents) {
+				t.Fatalf("Unexpected events %v, expected %v", actual, tc.expectedEvents)
+			}
+		})
+	}
+}
+
+func TestCacheWatchTimeout(t *testing.T) {
+	h := newTestCacheResponseHandler(&fakeCache{})
+	thirty := int64(30)
+	zero := int64(0)
+	for i := 0; i < 100; i++ {
+		for _, timeoutSeconds := range []*int64{nil, &zero} {
+			timeout := h.watchTimeout(timeoutSeconds)
+			if timeout < defaultMinRequestTimeout || timeout >= 2*defaultMinRequestTimeout {
+				t.Fatalf("Unexpected timeout %v, expected at least %v and less than %v", timeout,
+					defaultMinRequestTimeout, 2*defaultMinRequestTimeout)
+			}
+		}
+	}
+	if timeout := h.watchTimeout(&thirty); timeout != 30*time.Second {
+		t.Fatalf("Unexpected timeout %v, expected %v", timeout, 30*time.Second)
+	}
+
+	// A watch without timeoutSeconds ends by itself.
+	h.minRequestTimeout = 10 * time.Millisecond
+	h.bookmarkInterval = time.Hour
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/default/pods?watch=true", nil)
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{}, 1)}
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the watch to end")
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("Unexpected response %d %v", rec.Code, rec.Header())
+	}
+}
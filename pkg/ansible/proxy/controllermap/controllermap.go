@@ -18,6 +18,7 @@ import (
 	"sync"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 )
 
@@ -40,6 +41,133 @@ type Contents struct {
 	WatchClusterScopedResources bool
 	OwnerWatchMap               *WatchMap
 	AnnotationWatchMap          *WatchMap
+	// ServiceAccount is impersonated by the proxy for the requests of the
+	// runs of the controller's CRs, unless its name is empty.
+	ServiceAccount types.NamespacedName
+	// IncludeDependentGVKs, if not empty, are the only GVKs of dependent
+	// resources that are watched. A GVK without a version matches all
+	// versions of its group and kind.
+	IncludeDependentGVKs []schema.GroupVersionKind
+	// ExcludeDependentGVKs are GVKs of dependent resources that are not
+	// watched.
+	ExcludeDependentGVKs []schema.GroupVersionKind
+	// MaxDependentWatches, if not zero, is the maximum number of distinct
+	// GVKs of dependent resources that are watched by all controllers when
+	// the controller starts watching a GVK.
+	MaxDependentWatches int
+	// ApplyFieldManager, if not empty, makes the proxy send the writes of
+	// complete objects of the runs of the controller's CRs as server-side
+	// apply requests with this field manager.
+	ApplyFieldManager string
+	// ForceApply makes the apply requests take over the fields that conflict
+	// with other field managers.
+	ForceApply bool
+}
+
+// AllowsDependentWatch - returns true if the controller of ownerGVK watches
+// the dependent resources of gvk already, or may watch them according to its
+// include and exclude lists and its maximum number of dependent watches. It
+// returns false if there is no controller for ownerGVK.
+func (cm *ControllerMap) AllowsDependentWatch(ownerGVK, gvk schema.GroupVersionKind) bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	c, ok := cm.internal[ownerGVK]
+	return ok && cm.allowsDependentWatch(c, gvk)
+}
+
+// AddDependentWatch - stores gvk in watchMap, the owner or annotation watch
+// map of the controller of ownerGVK, if its dependent resources may be
+// watched. The check and the store are done under the lock of the map, so
+// that concurrent requests can't exceed the maximum number of dependent
+// watches. It returns whether gvk was stored, and whether it is allowed;
+// gvk is allowed but not stored if watchMap contains it already.
+func (cm *ControllerMap) AddDependentWatch(ownerGVK, gvk schema.GroupVersionKind, watchMap *WatchMap) (stored bool, allowed bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	c, ok := cm.internal[ownerGVK]
+	if !ok || !cm.allowsDependentWatch(c, gvk) {
+		return false, false
+	}
+	if _, ok := watchMap.Get(gvk); ok {
+		return false, true
+	}
+	watchMap.Store(gvk)
+	return true, true
+}
+
+// DependentWatchCount - returns the number of distinct GVKs of dependent
+// resources that are watched by all controllers.
+func (cm *ControllerMap) DependentWatchCount() int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.dependentWatchCount()
+}
+
+// allowsDependentWatch - the maximum number of dependent watches of a
+// controller limits the number of distinct GVKs of dependent resources that
+// are watched by all controllers, since their watches share the informers of
+// the cache. A GVK that another controller watches already does not need a
+// new informer, so it is always allowed by the maximum. cm.mutex must be held.
+func (cm *ControllerMap) allowsDependentWatch(c *Contents, gvk schema.GroupVersionKind) bool {
+	if c.watchesDependent(gvk) {
+		return true
+	}
+	if len(c.IncludeDependentGVKs) > 0 && !MatchesGVK(c.IncludeDependentGVKs, gvk) {
+		return false
+	}
+	if MatchesGVK(c.ExcludeDependentGVKs, gvk) {
+		return false
+	}
+	if c.MaxDependentWatches == 0 {
+		return true
+	}
+	for _, other := range cm.internal {
+		if other.watchesDependent(gvk) {
+			return true
+		}
+	}
+	return cm.dependentWatchCount() < c.MaxDependentWatches
+}
+
+// dependentWatchCount - cm.mutex must be held.
+func (cm *ControllerMap) dependentWatchCount() int {
+	gvks := map[schema.GroupVersionKind]bool{}
+	for _, c := range cm.internal {
+		for _, wm := range []*WatchMap{c.OwnerWatchMap, c.AnnotationWatchMap} {
+			if wm == nil {
+				continue
+			}
+			wm.mutex.RLock()
+			for gvk := range wm.internal {
+				gvks[gvk] = true
+			}
+			wm.mutex.RUnlock()
+		}
+	}
+	return len(gvks)
+}
+
+func (c *Contents) watchesDependent(gvk schema.GroupVersionKind) bool {
+	for _, wm := range []*WatchMap{c.OwnerWatchMap, c.AnnotationWatchMap} {
+		if wm == nil {
+			continue
+		}
+		if _, ok := wm.Get(gvk); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesGVK - returns true if gvk is one of gvks. A GVK of gvks without a
+// version matches all versions of its group and kind.
+func MatchesGVK(gvks []schema.GroupVersionKind, gvk schema.GroupVersionKind) bool {
+	for _, m := range gvks {
+		if m.Group == gvk.Group && m.Kind == gvk.Kind && (m.Version == "" || m.Version == gvk.Version) {
+			return true
+		}
+	}
+	return false
 }
 
 // NewControllerMap returns a new object that contains a mapping between GVK
@@ -67,6 +195,19 @@ func (cm *ControllerMap) Get(key schema.GroupVersionKind) (value *Contents, ok b
 	return value, ok
 }
 
+// UsesServiceAccounts - returns true if the proxy impersonates a service
+// account for the runs of any controller's CRs.
+func (cm *ControllerMap) UsesServiceAccounts() bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for _, c := range cm.internal {
+		if c.ServiceAccount.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
 // Delete - Deletes associated GVK to controller mapping from the ControllerMap
 func (cm *ControllerMap) Delete(key schema.GroupVersionKind) {
 	cm.mutex.Lock()
@@ -0,0 +1,181 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllermap
+
+import (
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	owner        = schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Foo"}
+	otherOwner   = schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Bar"}
+	unknownOwner = schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Baz"}
+)
+
+func TestAllowsDependentWatch(t *testing.T) {
+	deployment := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	service := schema.GroupVersionKind{Version: "v1", Kind: "Service"}
+	event := schema.GroupVersionKind{Version: "v1", Kind: "Event"}
+	testCases := []struct {
+		name     string
+		include  []schema.GroupVersionKind
+		exclude  []schema.GroupVersionKind
+		max      int
+		watched  []schema.GroupVersionKind
+		others   []schema.GroupVersionKind
+		gvk      schema.GroupVersionKind
+		expected bool
+	}{
+		{
+			name:     "no restrictions",
+			gvk:      event,
+			expected: true,
+		},
+		{
+			name:     "included without version",
+			include:  []schema.GroupVersionKind{{Group: "apps", Kind: "Deployment"}},
+			gvk:      deployment,
+			expected: true,
+		},
+		{
+			name:     "not included",
+			include:  []schema.GroupVersionKind{deployment},
+			gvk:      service,
+			expected: false,
+		},
+		{
+			name:     "excluded",
+			exclude:  []schema.GroupVersionKind{event},
+			gvk:      event,
+			expected: false,
+		},
+		{
+			name:     "other version excluded",
+			exclude:  []schema.GroupVersionKind{{Group: "apps", Version: "v1beta1", Kind: "Deployment"}},
+			gvk:      deployment,
+			expected: true,
+		},
+		{
+			name:     "below the maximum",
+			max:      2,
+			watched:  []schema.GroupVersionKind{deployment},
+			gvk:      service,
+			expected: true,
+		},
+		{
+			name:     "maximum reached",
+			max:      1,
+			watched:  []schema.GroupVersionKind{deployment},
+			gvk:      service,
+			expected: false,
+		},
+		{
+			name:     "maximum reached by other watches",
+			max:      2,
+			watched:  []schema.GroupVersionKind{deployment},
+			others:   []schema.GroupVersionKind{event},
+			gvk:      service,
+			expected: false,
+		},
+		{
+			name:     "watched by other watches",
+			max:      1,
+			others:   []schema.GroupVersionKind{deployment},
+			gvk:      deployment,
+			expected: true,
+		},
+		{
+			name:     "watched already",
+			max:      1,
+			exclude:  []schema.GroupVersionKind{deployment},
+			watched:  []schema.GroupVersionKind{deployment},
+			gvk:      deployment,
+			expected: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cm := NewControllerMap()
+			c := &Contents{
+				OwnerWatchMap:        NewWatchMap(),
+				AnnotationWatchMap:   NewWatchMap(),
+				IncludeDependentGVKs: tc.include,
+				ExcludeDependentGVKs: tc.exclude,
+				MaxDependentWatches:  tc.max,
+			}
+			for _, gvk := range tc.watched {
+				c.OwnerWatchMap.Store(gvk)
+				c.AnnotationWatchMap.Store(gvk)
+			}
+			other := &Contents{OwnerWatchMap: NewWatchMap(), AnnotationWatchMap: NewWatchMap()}
+			for _, gvk := range tc.others {
+				other.OwnerWatchMap.Store(gvk)
+			}
+			cm.Store(owner, c)
+			cm.Store(otherOwner, other)
+			if allowed := cm.AllowsDependentWatch(owner, tc.gvk); allowed != tc.expected {
+				t.Fatalf("Unexpected result %v, expected %v", allowed, tc.expected)
+			}
+			if allowed := cm.AllowsDependentWatch(unknownOwner, tc.gvk); allowed {
+				t.Fatalf("Unexpected result %v for an unknown owner, expected false", allowed)
+			}
+		})
+	}
+}
+
+func TestAddDependentWatch(t *testing.T) {
+	cm := NewControllerMap()
+	c := &Contents{OwnerWatchMap: NewWatchMap(), AnnotationWatchMap: NewWatchMap(), MaxDependentWatches: 3}
+	other := &Contents{OwnerWatchMap: NewWatchMap(), AnnotationWatchMap: NewWatchMap(), MaxDependentWatches: 3}
+	cm.Store(owner, c)
+	cm.Store(otherOwner, other)
+
+	// Add the same GVKs for both owners concurrently; only 3 distinct GVKs
+	// may be stored, and each owner stores each of them at most once.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, o := range []schema.GroupVersionKind{owner, otherOwner} {
+			wg.Add(1)
+			go func(o schema.GroupVersionKind, i int) {
+				defer wg.Done()
+				contents, _ := cm.Get(o)
+				gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: string(rune('A' + i))}
+				cm.AddDependentWatch(o, gvk, contents.OwnerWatchMap)
+			}(o, i)
+		}
+	}
+	wg.Wait()
+	if count := cm.DependentWatchCount(); count != 3 {
+		t.Fatalf("Unexpected dependent watch count %d, expected 3", count)
+	}
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Service"}
+	if stored, allowed := cm.AddDependentWatch(owner, gvk, c.OwnerWatchMap); stored || allowed {
+		t.Fatalf("Unexpected result stored %v allowed %v, expected the maximum to be reached", stored, allowed)
+	}
+	for gvk := range c.OwnerWatchMap.internal {
+		stored, allowed := cm.AddDependentWatch(owner, gvk, c.OwnerWatchMap)
+		if stored || !allowed {
+			t.Fatalf("Unexpected result stored %v allowed %v, expected %v to be watched already", stored, allowed, gvk)
+		}
+		stored, allowed = cm.AddDependentWatch(owner, gvk, c.AnnotationWatchMap)
+		if !stored || !allowed {
+			t.Fatalf("Unexpected result stored %v allowed %v, expected %v to be stored", stored, allowed, gvk)
+		}
+	}
+}
@@ -115,32 +115,13 @@ func (i *injectOwnerReferenceHandler) ServeHTTP(w http.ResponseWriter, req *http
 			return
 		}
 
-		addOwnerRef, err := shouldAddOwnerRef(data, owner, i.restMapper)
+		addOwnerRef, err := setOwner(data, owner, i.restMapper)
 		if err != nil {
-			m := "Could not determine if we should add owner ref"
+			m := "Could not set owner of the resource"
 			log.Error(err, m)
 			http.Error(w, m, http.StatusBadRequest)
 			return
 		}
-		if addOwnerRef {
-			data.SetOwnerReferences(append(data.GetOwnerReferences(), owner.OwnerReference))
-		} else {
-			ownerGV, err := schema.ParseGroupVersion(owner.APIVersion)
-			if err != nil {
-				m := fmt.Sprintf("could not get broup version for: %v", owner)
-				log.Error(err, m)
-				http.Error(w, m, http.StatusBadRequest)
-				return
-			}
-			a := data.GetAnnotations()
-			if a == nil {
-				a = map[string]string{}
-			}
-			a[osdkHandler.NamespacedNameAnnotation] = strings.Join([]string{owner.Namespace, owner.Name}, "/")
-			a[osdkHandler.TypeAnnotation] = fmt.Sprintf("%v.%v", owner.Kind, ownerGV.Group)
-
-			data.SetAnnotations(a)
-		}
 		newBody, err := json.Marshal(data.Object)
 		if err != nil {
 			m := "Could not serialize body"
@@ -173,6 +154,37 @@ func (i *injectOwnerReferenceHandler) ServeHTTP(w http.ResponseWriter, req *http
 	i.next.ServeHTTP(w, req)
 }
 
+// setOwner - sets an owner reference to owner on data if it can have one, and
+// the annotations of the owner otherwise. It returns whether an owner
+// reference was set.
+func setOwner(data *unstructured.Unstructured, owner kubeconfig.NamespacedOwnerReference, restMapper meta.RESTMapper) (bool, error) {
+	addOwnerRef, err := shouldAddOwnerRef(data, owner, restMapper)
+	if err != nil {
+		return false, err
+	}
+	if addOwnerRef {
+		for _, ref := range data.GetOwnerReferences() {
+			if ref.UID == owner.UID {
+				return true, nil
+			}
+		}
+		data.SetOwnerReferences(append(data.GetOwnerReferences(), owner.OwnerReference))
+		return true, nil
+	}
+	ownerGV, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil {
+		return false, fmt.Errorf("could not get group version for: %v: %w", owner, err)
+	}
+	a := data.GetAnnotations()
+	if a == nil {
+		a = map[string]string{}
+	}
+	a[osdkHandler.NamespacedNameAnnotation] = strings.Join([]string{owner.Namespace, owner.Name}, "/")
+	a[osdkHandler.TypeAnnotation] = fmt.Sprintf("%v.%v", owner.Kind, ownerGV.Group)
+	data.SetAnnotations(a)
+	return false, nil
+}
+
 func shouldAddOwnerRef(data *unstructured.Unstructured, owner kubeconfig.NamespacedOwnerReference, restMapper meta.RESTMapper) (bool, error) {
 	dataMapping, err := restMapper.RESTMapping(data.GroupVersionKind().GroupKind(), data.GroupVersionKind().Version)
 	if err != nil {
@@ -0,0 +1,67 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeconfig
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Credentials - the credentials of the runs that make requests through the
+// proxy. Each run gets a random token as the password of its kubeconfig,
+// which the proxy maps back to the owner the token was issued for. The owner
+// of a request is never taken from what the run sends, so a run can't act as
+// the owner of another run.
+type Credentials struct {
+	mutex  sync.RWMutex
+	owners map[string]NamespacedOwnerReference
+}
+
+// NewCredentials - returns an empty set of credentials.
+func NewCredentials() *Credentials {
+	return &Credentials{owners: map[string]NamespacedOwnerReference{}}
+}
+
+// Issue - returns a new token for the requests of a run of owner. The token
+// must be revoked once the run ended.
+func (c *Credentials) Issue(owner NamespacedOwnerReference) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate proxy token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.owners[token] = owner
+	return token, nil
+}
+
+// Revoke - revokes a token, after which requests with it are rejected.
+func (c *Credentials) Revoke(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.owners, token)
+}
+
+// Owner - returns the owner a token was issued for, or false if the token
+// was not issued or was revoked.
+func (c *Credentials) Owner(token string) (NamespacedOwnerReference, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	owner, ok := c.owners[token]
+	return owner, ok
+}
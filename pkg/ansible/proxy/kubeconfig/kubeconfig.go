@@ -54,7 +54,7 @@ users:
 - name: admin/proxy-server
   user:
     username: {{.Username}}
-    password: unused
+    password: {{.Password}}
 `
 
 // values holds the data used to render the template
@@ -62,36 +62,27 @@ type values struct {
 	Username  string
 	ProxyURL  string
 	Namespace string
+	Password  string
 }
 
 type NamespacedOwnerReference struct {
 	metav1.OwnerReference
 	Namespace string
+	// CheckMode is set for the requests of check mode runs, whose mutating
+	// requests are refused by the proxy.
+	CheckMode bool `json:"checkMode,omitempty"`
 }
 
 // Create renders a kubeconfig template and writes it to disk
 func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (*os.File, error) {
-	nsOwnerRef := NamespacedOwnerReference{OwnerReference: ownerRef, Namespace: namespace}
-	parsedURL, err := url.Parse(proxyURL)
-	if err != nil {
-		return nil, err
-	}
-	ownerRefJSON, err := json.Marshal(nsOwnerRef)
-	if err != nil {
-		return nil, err
-	}
-	username := base64.URLEncoding.EncodeToString([]byte(ownerRefJSON))
-	parsedURL.User = url.User(username)
-	v := values{
-		Username:  username,
-		ProxyURL:  parsedURL.String(),
-		Namespace: namespace,
-	}
-
-	var parsed bytes.Buffer
+	return CreateForOwner(NamespacedOwnerReference{OwnerReference: ownerRef, Namespace: namespace}, proxyURL, "unused")
+}
 
-	t := template.Must(template.New("kubeconfig").Parse(kubeConfigTemplate))
-	if err := t.Execute(&parsed, v); err != nil {
+// CreateForOwner renders a kubeconfig template for the given owner, which
+// authenticates with the given password, and writes it to disk
+func CreateForOwner(owner NamespacedOwnerReference, proxyURL, password string) (*os.File, error) {
+	parsed, err := Render(owner, proxyURL, password)
+	if err != nil {
 		return nil, err
 	}
 
@@ -108,7 +99,7 @@ func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (
 		}
 	}()
 
-	if _, err := file.WriteString(parsed.String()); err != nil {
+	if _, err := file.Write(parsed); err != nil {
 		return nil, err
 	}
 	if err := file.Close(); err != nil {
@@ -116,3 +107,34 @@ func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (
 	}
 	return file, nil
 }
+
+// Render renders a kubeconfig template for the proxy at proxyURL, which
+// authenticates with the given password. The owner is only the username of
+// the kubeconfig; a proxy with Credentials takes the owner of a request from
+// the token in its password.
+func Render(owner NamespacedOwnerReference, proxyURL, password string) ([]byte, error) {
+	parsedURL, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	ownerRefJSON, err := json.Marshal(owner)
+	if err != nil {
+		return nil, err
+	}
+	username := base64.URLEncoding.EncodeToString([]byte(ownerRefJSON))
+	parsedURL.User = url.User(username)
+	v := values{
+		Username:  username,
+		ProxyURL:  parsedURL.String(),
+		Namespace: owner.Namespace,
+		Password:  password,
+	}
+
+	var parsed bytes.Buffer
+
+	t := template.Must(template.New("kubeconfig").Parse(kubeConfigTemplate))
+	if err := t.Execute(&parsed, v); err != nil {
+		return nil, err
+	}
+	return parsed.Bytes(), nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	k8sRequest "github.com/operator-framework/operator-sdk/pkg/ansible/proxy/requestfactory"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Policy - limits the requests that the runs of the CRs of a GVK make through
+// the proxy. The GVK itself is always an allowed GVK.
+type Policy struct {
+	// OwnerNamespace allows the requests to namespaced resources in the
+	// namespace of the CR.
+	OwnerNamespace bool
+	// AllowedNamespaces are namespaces that the requests to namespaced
+	// resources are allowed in. Requests are allowed in all namespaces if
+	// neither OwnerNamespace nor AllowedNamespaces are set.
+	AllowedNamespaces []string
+	// AllowedGVKs, if not empty, are the only GVKs requests are allowed for.
+	// A GVK without a version matches all versions of its group and kind.
+	AllowedGVKs []schema.GroupVersionKind
+	// ReadOnlyGVKs are GVKs that only get, list and watch requests are
+	// allowed for.
+	ReadOnlyGVKs []schema.GroupVersionKind
+}
+
+// readVerbs - the verbs of the requests allowed for read-only GVKs.
+var readVerbs = sets.NewString("get", "list", "watch")
+
+// enforcePolicies - refuses the requests that violate the policy of the GVK
+// of the request's owner. The owner is the one the request's token was issued
+// for by credentials, never the one the run sends, so a run can't choose the
+// policy it is limited by. It is only used when a watch has a policy, so
+// requests without an issued token, which could otherwise bypass the
+// policies, are refused.
+type enforcePolicies struct {
+	next        http.Handler
+	credentials *kubeconfig.Credentials
+	policies    map[schema.GroupVersionKind]Policy
+	restMapper  meta.RESTMapper
+}
+
+func (e *enforcePolicies) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	owner, ok := runOwner(e.credentials, req)
+	if !ok {
+		log.Info("Refused request without the token of a run", "method", req.Method, "path", req.URL.Path)
+		writeForbidden(w, "requests without the token of a run are refused when watches have policies")
+		return
+	}
+	ownerGVK := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+	policy, ok := e.policies[ownerGVK]
+	if !ok {
+		e.next.ServeHTTP(w, req)
+		return
+	}
+	rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+	r, err := rf.NewRequestInfo(req)
+	if err != nil {
+		writeForbidden(w, "could not parse the request")
+		return
+	}
+	// Discovery and other non-resource requests don't touch any resources.
+	if !r.IsResourceRequest {
+		e.next.ServeHTTP(w, req)
+		return
+	}
+	if reason := policy.violation(r, owner.Namespace, ownerGVK, e.restMapper); reason != "" {
+		m := fmt.Sprintf("the policy of %s does not allow %s %s: %s", ownerGVK.Kind, r.Verb, req.URL.Path, reason)
+		log.Info("Refused request that violates a policy", "method", req.Method, "path", req.URL.Path,
+			"owner", owner.Name, "namespace", owner.Namespace, "reason", reason)
+		writeForbidden(w, m)
+		return
+	}
+	e.next.ServeHTTP(w, req)
+}
+
+// violation - returns why a request of a CR in ownerNamespace violates the
+// policy, or "" if it does not.
+func (p Policy) violation(r *k8sRequest.RequestInfo, ownerNamespace string, ownerGVK schema.GroupVersionKind, restMapper meta.RESTMapper) string {
+	var gvk schema.GroupVersionKind
+	var mapping *meta.RESTMapping
+	var err error
+	if restMapper == nil {
+		err = fmt.Errorf("no REST mapper")
+	} else if gvk, err = getGVKFromRequestInfo(r, restMapper); err == nil {
+		mapping, err = restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		log.V(1).Info("Unable to get the kind of a request", "resource", r.Resource, "error", err.Error())
+		if len(p.AllowedGVKs) > 0 || len(p.ReadOnlyGVKs) > 0 {
+			return "the kind of the resource is unknown"
+		}
+	}
+	if p.OwnerNamespace || len(p.AllowedNamespaces) > 0 {
+		namespaced := r.Namespace != ""
+		if mapping != nil {
+			namespaced = mapping.Scope.Name() == meta.RESTScopeNameNamespace
+		} else if r.Namespace == "" && err != nil {
+			return "the scope of the resource is unknown"
+		}
+		// Namespaces are limited like the resources in them.
+		if gvk.Group == "" && gvk.Kind == "Namespace" {
+			namespaced = true
+		}
+		if namespaced && !p.allowsNamespace(r.Namespace, ownerNamespace) {
+			if r.Namespace == metav1.NamespaceAll {
+				return "requests across all namespaces are not allowed"
+			}
+			return fmt.Sprintf("namespace %q is not allowed", r.Namespace)
+		}
+	}
+	ownKind := gvk.Group == ownerGVK.Group && gvk.Kind == ownerGVK.Kind
+	if len(p.AllowedGVKs) > 0 && !ownKind && !controllermap.MatchesGVK(p.AllowedGVKs, gvk) {
+		return fmt.Sprintf("kind %s is not allowed", gvk.GroupKind())
+	}
+	if controllermap.MatchesGVK(p.ReadOnlyGVKs, gvk) && !readVerbs.Has(r.Verb) {
+		return fmt.Sprintf("kind %s is read-only", gvk.GroupKind())
+	}
+	return ""
+}
+
+func (p Policy) allowsNamespace(namespace, ownerNamespace string) bool {
+	if namespace == metav1.NamespaceAll {
+		return false
+	}
+	if p.OwnerNamespace && namespace == ownerNamespace {
+		return true
+	}
+	for _, ns := range p.AllowedNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// writeForbidden - writes a Status with a 403 Forbidden code.
+func writeForbidden(w http.ResponseWriter, message string) {
+	status := metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   metav1.StatusReasonForbidden,
+		Code:     http.StatusForbidden,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Error(err, "Failed to write response")
+	}
+}
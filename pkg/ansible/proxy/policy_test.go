@@ -0,0 +1,243 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	kmetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestEnforcePolicies(t *testing.T) {
+	ownerGVK := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	otherGVK := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Other"}
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(ownerGVK, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	restMapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+
+	credentials := kubeconfig.NewCredentials()
+	h := &enforcePolicies{
+		next: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		credentials: credentials,
+		policies: map[schema.GroupVersionKind]Policy{
+			ownerGVK: {
+				OwnerNamespace:    true,
+				AllowedNamespaces: []string{"shared"},
+				AllowedGVKs: []schema.GroupVersionKind{
+					{Group: "apps", Kind: "Deployment"},
+					{Version: "v1", Kind: "Secret"},
+					{Version: "v1", Kind: "Namespace"},
+					{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"},
+				},
+				ReadOnlyGVKs: []schema.GroupVersionKind{
+					{Version: "v1", Kind: "Secret"},
+				},
+			},
+		},
+		restMapper: restMapper,
+	}
+
+	testCases := []struct {
+		name           string
+		ownerGVK       schema.GroupVersionKind
+		forgedGVK      schema.GroupVersionKind
+		method         string
+		path           string
+		expectedCode   int
+		expectedReason string
+	}{
+		{
+			name:         "discovery",
+			ownerGVK:     ownerGVK,
+			method:       http.MethodGet,
+			path:         "/apis/apps/v1",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "allowed kind in the owner's namespace",
+			ownerGVK:     ownerGVK,
+			method:       http.MethodPatch,
+			path:         "/apis/apps/v1/namespaces/tenant-a/deployments/example",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "allowed kind in an allowed namespace",
+			ownerGVK:     ownerGVK,
+			method:       http.MethodPost,
+			path:         "/apis/apps/v1/namespaces/shared/deployments",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:           "other namespace",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodDelete,
+			path:           "/apis/apps/v1/namespaces/tenant-b/deployments/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: `namespace "tenant-b" is not allowed`,
+		},
+		{
+			name:           "all namespaces",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodGet,
+			path:           "/apis/apps/v1/deployments",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "requests across all namespaces are not allowed",
+		},
+		{
+			name:           "namespace object",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodGet,
+			path:           "/api/v1/namespaces/tenant-b",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: `namespace "tenant-b" is not allowed`,
+		},
+		{
+			name:         "cluster-scoped kind",
+			ownerGVK:     ownerGVK,
+			method:       http.MethodGet,
+			path:         "/apis/rbac.authorization.k8s.io/v1/clusterroles/view",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:           "kind that is not allowed",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodGet,
+			path:           "/api/v1/namespaces/tenant-a/configmaps/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "kind ConfigMap is not allowed",
+		},
+		{
+			name:         "own kind",
+			ownerGVK:     ownerGVK,
+			method:       http.MethodPut,
+			path:         "/apis/app.example.com/v1alpha1/namespaces/tenant-a/memcacheds/example/status",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "read of a read-only kind",
+			ownerGVK:     ownerGVK,
+			method:       http.MethodGet,
+			path:         "/api/v1/namespaces/tenant-a/secrets/example",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:           "write of a read-only kind",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPut,
+			path:           "/api/v1/namespaces/tenant-a/secrets/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "kind Secret is read-only",
+		},
+		{
+			name:           "unknown kind",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodGet,
+			path:           "/apis/unknown.example.com/v1/namespaces/tenant-a/things/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "the kind of the resource is unknown",
+		},
+		{
+			name:         "owner without a policy",
+			ownerGVK:     otherGVK,
+			method:       http.MethodDelete,
+			path:         "/api/v1/namespaces/tenant-b/configmaps/example",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:           "owner without a policy in the username",
+			ownerGVK:       ownerGVK,
+			forgedGVK:      otherGVK,
+			method:         http.MethodDelete,
+			path:           "/api/v1/namespaces/tenant-b/configmaps/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: `namespace "tenant-b" is not allowed`,
+		},
+		{
+			name:           "owner in the username without a token",
+			forgedGVK:      otherGVK,
+			method:         http.MethodDelete,
+			path:           "/api/v1/namespaces/tenant-b/configmaps/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "requests without the token of a run are refused when watches have policies",
+		},
+		{
+			name:           "request without an owner",
+			method:         http.MethodGet,
+			path:           "/api/v1/namespaces/tenant-b/configmaps/example",
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "requests without the token of a run are refused when watches have policies",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			username, token := "", "unused"
+			if !tc.forgedGVK.Empty() {
+				forged, err := json.Marshal(kubeconfig.NamespacedOwnerReference{
+					OwnerReference: kmetav1.OwnerReference{APIVersion: tc.forgedGVK.GroupVersion().String(), Kind: tc.forgedGVK.Kind, Name: "example"},
+					Namespace:      "tenant-a",
+				})
+				if err != nil {
+					t.Fatalf("Failed to marshal owner: %v", err)
+				}
+				username = base64.StdEncoding.EncodeToString(forged)
+			}
+			if !tc.ownerGVK.Empty() {
+				owner := kubeconfig.NamespacedOwnerReference{
+					OwnerReference: kmetav1.OwnerReference{APIVersion: tc.ownerGVK.GroupVersion().String(), Kind: tc.ownerGVK.Kind, Name: "example"},
+					Namespace:      "tenant-a",
+				}
+				issued, err := credentials.Issue(owner)
+				if err != nil {
+					t.Fatalf("Failed to issue token: %v", err)
+				}
+				defer credentials.Revoke(issued)
+				token = issued
+			}
+			if username != "" || !tc.ownerGVK.Empty() {
+				req.SetBasicAuth(username, token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d: %s", w.Code, tc.expectedCode, w.Body.String())
+			}
+			if w.Code == http.StatusForbidden {
+				status := kmetav1.Status{}
+				if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+					t.Fatalf("Failed to parse status: %v", err)
+				}
+				if status.Reason != kmetav1.StatusReasonForbidden || !strings.HasSuffix(status.Message, tc.expectedReason) {
+					t.Fatalf("Unexpected status %#v, expected the reason %q", status, tc.expectedReason)
+				}
+			}
+		})
+	}
+}
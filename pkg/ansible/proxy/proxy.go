@@ -19,6 +19,7 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -37,8 +38,10 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -80,6 +83,20 @@ type Options struct {
 	DisableCache      bool
 	OwnerInjection    bool
 	LogRequests       bool
+	// Credentials, when set, are the tokens issued to runs. The basic auth
+	// password of every request to the proxy must be one of them, and the
+	// owner of a request is the owner its token was issued for. Without
+	// Credentials the owner is taken from the basic auth username, which any
+	// client can choose, so Credentials are required by Policies and by
+	// watches with a service account.
+	Credentials *kubeconfig.Credentials
+	// Policies, when set, limit the requests of the runs of the CRs of
+	// their GVKs. Requests that violate them are refused with a 403
+	// Forbidden error.
+	Policies map[schema.GroupVersionKind]Policy
+	// AuditSink, when set, receives a record of every create, update, patch
+	// and delete request that comes through the proxy.
+	AuditSink AuditSink
 }
 
 // Run will start a proxy server in a go routine that returns on the error
@@ -99,6 +116,9 @@ func Run(done chan error, o Options) error {
 	if o.WatchedNamespaces == nil {
 		return fmt.Errorf("failed to get list of watched namespaces from options")
 	}
+	if o.Credentials == nil && (len(o.Policies) > 0 || o.ControllerMap.UsesServiceAccounts()) {
+		return fmt.Errorf("credentials are required by policies and service accounts")
+	}
 
 	watchedNamespaceMap := make(map[string]interface{})
 	// Convert string list to map
@@ -142,6 +162,15 @@ func Run(done chan error, o Options) error {
 
 	// Remove the authorization header so the proxy can correctly inject the header.
 	server.Handler = removeAuthorizationHeader(server.Handler)
+	server.Handler = impersonateServiceAccount(o.ControllerMap, server.Handler)
+	// Server-side apply requests are created from the requests the owner
+	// reference was injected into.
+	server.Handler = &serverSideApplyHandler{
+		next:           server.Handler,
+		cMap:           o.ControllerMap,
+		restMapper:     o.RESTMapper,
+		injectOwnerRef: o.OwnerInjection,
+	}
 
 	if o.OwnerInjection {
 		server.Handler = &injectOwnerReferenceHandler{
@@ -168,6 +197,27 @@ func Run(done chan error, o Options) error {
 			apiResources:      resources,
 		}
 	}
+	server.Handler = refuseCheckModeMutations(server.Handler)
+	if len(o.Policies) > 0 {
+		server.Handler = &enforcePolicies{
+			next:        server.Handler,
+			credentials: o.Credentials,
+			policies:    o.Policies,
+			restMapper:  o.RESTMapper,
+		}
+	}
+	if o.AuditSink != nil {
+		server.Handler = &auditHandler{
+			next:       server.Handler,
+			sink:       o.AuditSink,
+			restMapper: o.RESTMapper,
+		}
+	}
+	if o.Credentials != nil {
+		server.Handler = authenticateRuns(o.Credentials, server.Handler)
+	} else {
+		server.Handler = ownerFromUsername(server.Handler)
+	}
 
 	l, err := server.Listen(o.Address, o.Port)
 	if err != nil {
@@ -217,41 +267,166 @@ func addWatchToController(owner kubeconfig.NamespacedOwnerReference, cMap *contr
 
 	// Add a watch to controller
 	if contents.WatchDependentResources {
-		// Store watch in map
 		// Use EnqueueRequestForOwner unless user has configured watching cluster scoped resources and we have to
+		var watchMap *controllermap.WatchMap
 		switch {
 		case useOwnerRef:
-			_, exists := owMap.Get(resource.GroupVersionKind())
-			// If already watching resource no need to add a new watch
-			if exists {
-				return nil
-			}
-
-			owMap.Store(resource.GroupVersionKind())
-			log.Info("Watching child resource", "kind", resource.GroupVersionKind(), "enqueue_kind", u.GroupVersionKind())
-			err := contents.Controller.Watch(&source.Kind{Type: resource}, &handler.EnqueueRequestForOwner{OwnerType: u}, dependentPredicate)
-			// Store watch in map
-			if err != nil {
-				return err
-			}
+			watchMap = owMap
 		case (!useOwnerRef && dataNamespaceScoped) || contents.WatchClusterScopedResources:
-			_, exists := awMap.Get(resource.GroupVersionKind())
-			// If already watching resource no need to add a new watch
-			if exists {
-				return nil
-			}
-			awMap.Store(resource.GroupVersionKind())
+			watchMap = awMap
+		default:
+			return nil
+		}
+		// Store watch in map. The map is checked and updated under one lock so
+		// that concurrent requests can't exceed the maximum number of watches.
+		stored, allowed := cMap.AddDependentWatch(ownerMapping.GroupVersionKind, resource.GroupVersionKind(), watchMap)
+		if !allowed {
+			log.V(1).Info("Not watching child resource", "kind", resource.GroupVersionKind(), "owner_kind", u.GroupVersionKind(),
+				"dependent_watches", cMap.DependentWatchCount())
+			return nil
+		}
+		// If already watching resource no need to add a new watch
+		if !stored {
+			return nil
+		}
+		if watchMap == owMap {
+			log.Info("Watching child resource", "kind", resource.GroupVersionKind(), "enqueue_kind", u.GroupVersionKind())
+			err = contents.Controller.Watch(&source.Kind{Type: resource}, &handler.EnqueueRequestForOwner{OwnerType: u}, dependentPredicate)
+		} else {
 			typeString := fmt.Sprintf("%v.%v", owner.Kind, ownerGV.Group)
 			log.Info("Watching child resource", "kind", resource.GroupVersionKind(), "enqueue_annotation_type", typeString)
 			err = contents.Controller.Watch(&source.Kind{Type: resource}, &osdkHandler.EnqueueRequestForAnnotation{Type: typeString}, dependentPredicate)
-			if err != nil {
-				return err
-			}
+		}
+		if err != nil {
+			watchMap.Delete(resource.GroupVersionKind())
+			return err
 		}
 	}
 	return nil
 }
 
+// requestOwnerKey - the context key of the owner of a request.
+type requestOwnerKey struct{}
+
+// withRequestOwner - returns the request with the owner of the run that
+// made it.
+func withRequestOwner(req *http.Request, owner kubeconfig.NamespacedOwnerReference) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestOwnerKey{}, owner))
+}
+
+// authenticateRuns - rejects requests whose basic auth password is not a
+// token issued by credentials, and sets the owner the token was issued for as
+// the owner of the request. The username, which the run chooses, is ignored.
+func authenticateRuns(credentials *kubeconfig.Credentials, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		owner, ok := runOwner(credentials, req)
+		if !ok {
+			log.Info("Rejected request with an invalid password", "method", req.Method, "path", req.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, withRequestOwner(req, owner))
+	})
+}
+
+// runOwner - returns the owner that the token in the basic auth password of a
+// request was issued for by credentials, or false if the request has no
+// issued token.
+func runOwner(credentials *kubeconfig.Credentials, req *http.Request) (kubeconfig.NamespacedOwnerReference, bool) {
+	_, token, ok := req.BasicAuth()
+	if !ok {
+		return kubeconfig.NamespacedOwnerReference{}, false
+	}
+	return credentials.Owner(token)
+}
+
+// ownerFromUsername - sets the owner of requests from their basic auth
+// username, for proxies without credentials. Any client can choose that
+// owner, so it must not limit what a request may do.
+func ownerFromUsername(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		owner, err := parseUsernameOwner(req)
+		if err != nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		h.ServeHTTP(w, withRequestOwner(req, owner))
+	})
+}
+
+// refuseCheckModeMutations - refuses the mutating requests of check mode
+// runs, so that a check mode run can't change anything even if a module does
+// not support check mode.
+func refuseCheckModeMutations(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			h.ServeHTTP(w, req)
+			return
+		}
+		owner, err := getRequestOwnerRef(req)
+		if err != nil || !owner.CheckMode {
+			h.ServeHTTP(w, req)
+			return
+		}
+		log.Info("Refused mutating request of a check mode run", "method", req.Method, "path", req.URL.Path,
+			"owner", owner.Name, "namespace", owner.Namespace)
+		writeForbidden(w, fmt.Sprintf("%s requests are refused in check mode", req.Method))
+	})
+}
+
+// impersonateServiceAccount - impersonates the service account of the watch
+// of a request's owner, if the watch has one, so that the runs of a watch are
+// limited to the RBAC of its service account. Requests whose owner is not the
+// CR of a watch are refused if any watch has a service account, since their
+// identity can't be determined.
+func impersonateServiceAccount(cMap *controllermap.ControllerMap, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Runs must not pick the identity their requests are made with.
+		for header := range req.Header {
+			if strings.HasPrefix(header, "Impersonate-") {
+				req.Header.Del(header)
+			}
+		}
+		sa, ok, err := getRequestServiceAccount(req, cMap)
+		if err != nil {
+			log.Info("Refused request without an owner", "method", req.Method, "path", req.URL.Path, "error", err.Error())
+			writeForbidden(w, "requests without the owner of a watch are refused when watches impersonate service accounts")
+			return
+		}
+		if ok {
+			// The API server adds the groups of the service account.
+			req.Header.Set(transport.ImpersonateUserHeader, fmt.Sprintf("system:serviceaccount:%s:%s", sa.Namespace, sa.Name))
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// getRequestServiceAccount - returns the service account that is
+// impersonated for a request, if the watch of the request's owner has one.
+// It returns an error if the request's owner is not the CR of a watch while
+// any watch has a service account.
+func getRequestServiceAccount(req *http.Request, cMap *controllermap.ControllerMap) (types.NamespacedName, bool, error) {
+	owner, err := getRequestOwnerRef(req)
+	if err != nil {
+		if cMap.UsesServiceAccounts() {
+			return types.NamespacedName{}, false, err
+		}
+		return types.NamespacedName{}, false, nil
+	}
+	contents, ok := cMap.Get(schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind))
+	if !ok {
+		if cMap.UsesServiceAccounts() {
+			return types.NamespacedName{}, false, fmt.Errorf("no watch of owner kind %s", owner.Kind)
+		}
+		return types.NamespacedName{}, false, nil
+	}
+	if contents.ServiceAccount.Name == "" {
+		return types.NamespacedName{}, false, nil
+	}
+	return contents.ServiceAccount, true, nil
+}
+
 func removeAuthorizationHeader(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		req.Header.Del("Authorization")
@@ -260,7 +435,18 @@ func removeAuthorizationHeader(h http.Handler) http.Handler {
 }
 
 // Helper function used by recovering dependent watches and owner ref injection.
+// The owner is set by authenticateRuns or ownerFromUsername.
 func getRequestOwnerRef(req *http.Request) (kubeconfig.NamespacedOwnerReference, error) {
+	owner, ok := req.Context().Value(requestOwnerKey{}).(kubeconfig.NamespacedOwnerReference)
+	if !ok {
+		return owner, errors.New("request has no owner")
+	}
+	return owner, nil
+}
+
+// parseUsernameOwner - returns the owner in the basic auth username of a
+// request.
+func parseUsernameOwner(req *http.Request) (kubeconfig.NamespacedOwnerReference, error) {
 	owner := kubeconfig.NamespacedOwnerReference{}
 	user, _, ok := req.BasicAuth()
 	if !ok {
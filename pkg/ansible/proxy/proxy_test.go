@@ -16,17 +16,23 @@ package proxy
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/operator-framework/operator-sdk/internal/util/fileutil"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 
 	kcorev1 "k8s.io/api/core/v1"
 	kmetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -119,3 +125,235 @@ func createPod(name, namespace string, cl client.Client) (runtime.Object, error)
 	}
 	return pod, nil
 }
+
+func TestAuthenticateRuns(t *testing.T) {
+	credentials := kubeconfig.NewCredentials()
+	owner := kubeconfig.NamespacedOwnerReference{
+		OwnerReference: kmetav1.OwnerReference{APIVersion: "app.example.com/v1alpha1", Kind: "Memcached", Name: "example"},
+		Namespace:      "default",
+	}
+	token, err := credentials.Issue(owner)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	revoked, err := credentials.Issue(owner)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	credentials.Revoke(revoked)
+	forged, err := json.Marshal(kubeconfig.NamespacedOwnerReference{
+		OwnerReference: kmetav1.OwnerReference{APIVersion: "app.example.com/v1alpha1", Kind: "Memcached", Name: "other"},
+		Namespace:      "tenant-b",
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal owner: %v", err)
+	}
+	var got *kubeconfig.NamespacedOwnerReference
+	h := authenticateRuns(credentials, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		o, err := getRequestOwnerRef(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got = &o
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	testCases := []struct {
+		name         string
+		username     string
+		password     string
+		basicAuth    bool
+		expectedCode int
+	}{
+		{
+			name:         "issued token",
+			password:     token,
+			basicAuth:    true,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "issued token with a forged owner",
+			username:     base64.StdEncoding.EncodeToString(forged),
+			password:     token,
+			basicAuth:    true,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "revoked token",
+			password:     revoked,
+			basicAuth:    true,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "unknown token",
+			password:     "unused",
+			basicAuth:    true,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "no basic auth",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/default/pods", nil)
+			if tc.basicAuth {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d", w.Code, tc.expectedCode)
+			}
+			if tc.expectedCode == http.StatusNoContent && (got == nil || *got != owner) {
+				t.Fatalf("Unexpected owner %v, expected %v", got, owner)
+			}
+		})
+	}
+}
+
+func TestRefuseCheckModeMutations(t *testing.T) {
+	h := refuseCheckModeMutations(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	testCases := []struct {
+		name         string
+		method       string
+		checkMode    bool
+		expectedCode int
+	}{
+		{
+			name:         "get in check mode",
+			method:       http.MethodGet,
+			checkMode:    true,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "patch in check mode",
+			method:       http.MethodPatch,
+			checkMode:    true,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "delete in check mode",
+			method:       http.MethodDelete,
+			checkMode:    true,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "patch of a regular run",
+			method:       http.MethodPatch,
+			expectedCode: http.StatusNoContent,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			owner := kubeconfig.NamespacedOwnerReference{
+				OwnerReference: kmetav1.OwnerReference{APIVersion: "app.example.com/v1alpha1", Kind: "Memcached", Name: "example"},
+				Namespace:      "default",
+				CheckMode:      tc.checkMode,
+			}
+			req := httptest.NewRequest(tc.method, "/apis/apps/v1/namespaces/default/deployments/example", nil)
+			req = withRequestOwner(req, owner)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d", w.Code, tc.expectedCode)
+			}
+			if w.Code == http.StatusForbidden {
+				status := kmetav1.Status{}
+				if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+					t.Fatalf("Failed to parse status: %v", err)
+				}
+				if status.Reason != kmetav1.StatusReasonForbidden {
+					t.Fatalf("Unexpected status reason %q", status.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestImpersonateServiceAccount(t *testing.T) {
+	withServiceAccount := controllermap.NewControllerMap()
+	withServiceAccount.Store(schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"}, &controllermap.Contents{
+		ServiceAccount: types.NamespacedName{Namespace: "operators", Name: "memcached-runner"},
+	})
+	withServiceAccount.Store(schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Redis"}, &controllermap.Contents{})
+	withoutServiceAccount := controllermap.NewControllerMap()
+	withoutServiceAccount.Store(schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Redis"}, &controllermap.Contents{})
+
+	testCases := []struct {
+		name         string
+		cMap         *controllermap.ControllerMap
+		kind         string
+		expectedCode int
+		expectedUser string
+	}{
+		{
+			name:         "watch with a service account",
+			cMap:         withServiceAccount,
+			kind:         "Memcached",
+			expectedCode: http.StatusNoContent,
+			expectedUser: "system:serviceaccount:operators:memcached-runner",
+		},
+		{
+			name:         "watch without a service account",
+			cMap:         withServiceAccount,
+			kind:         "Redis",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "owner of no watch",
+			cMap:         withServiceAccount,
+			kind:         "Other",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "no owner",
+			cMap:         withServiceAccount,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "no owner without service accounts",
+			cMap:         withoutServiceAccount,
+			expectedCode: http.StatusNoContent,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got http.Header
+			h := impersonateServiceAccount(tc.cMap, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				got = req.Header
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/default/pods", nil)
+			if tc.kind != "" {
+				owner := kubeconfig.NamespacedOwnerReference{
+					OwnerReference: kmetav1.OwnerReference{APIVersion: "app.example.com/v1alpha1", Kind: tc.kind, Name: "example"},
+					Namespace:      "default",
+				}
+				req = withRequestOwner(req, owner)
+			}
+			req.Header.Set("Impersonate-User", "someone")
+			req.Header.Set("Impersonate-Group", "system:masters")
+			req.Header.Set("Impersonate-Extra-Scopes", "all")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d", w.Code, tc.expectedCode)
+			}
+			if w.Code != http.StatusNoContent {
+				return
+			}
+			if user := got.Get("Impersonate-User"); user != tc.expectedUser {
+				t.Fatalf("Unexpected impersonated user %q, expected %q", user, tc.expectedUser)
+			}
+			for header := range got {
+				if header != "Impersonate-User" && strings.HasPrefix(header, "Impersonate-") {
+					t.Fatalf("Unexpected impersonation header %s", header)
+				}
+			}
+		})
+	}
+}
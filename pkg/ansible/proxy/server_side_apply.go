@@ -0,0 +1,218 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	k8sRequest "github.com/operator-framework/operator-sdk/pkg/ansible/proxy/requestfactory"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// serverSetFields - the fields of an object that are set by the API server,
+// which are removed from the objects that are applied.
+var serverSetFields = [][]string{
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "selfLink"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "deletionTimestamp"},
+	{"metadata", "deletionGracePeriodSeconds"},
+	{"metadata", "managedFields"},
+	{"status"},
+}
+
+// conflictManagerRegexp - matches the field manager in the message of the
+// causes of apply conflicts, e.g. `conflict with "kubectl" using apps/v1`.
+var conflictManagerRegexp = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// serverSideApplyHandler - sends the creates, updates and merge patches of
+// complete objects made by the runs of the watches with an apply field
+// manager as server-side apply requests, and reports the conflicts of the
+// apply requests in the details of the response's Status.
+type serverSideApplyHandler struct {
+	next           http.Handler
+	cMap           *controllermap.ControllerMap
+	restMapper     meta.RESTMapper
+	injectOwnerRef bool
+}
+
+func (s *serverSideApplyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut:
+	case http.MethodPatch:
+		switch types.PatchType(req.Header.Get("Content-Type")) {
+		case types.MergePatchType, types.StrategicMergePatchType:
+		default:
+			s.next.ServeHTTP(w, req)
+			return
+		}
+	default:
+		s.next.ServeHTTP(w, req)
+		return
+	}
+	owner, err := getRequestOwnerRef(req)
+	if err != nil {
+		s.next.ServeHTTP(w, req)
+		return
+	}
+	contents, ok := s.cMap.Get(schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind))
+	if !ok || contents.ApplyFieldManager == "" {
+		s.next.ServeHTTP(w, req)
+		return
+	}
+	rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+	r, err := rf.NewRequestInfo(req)
+	if err != nil || !r.IsResourceRequest || r.Subresource != "" {
+		s.next.ServeHTTP(w, req)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		m := "Could not read request body"
+		log.Error(err, m)
+		http.Error(w, m, http.StatusInternalServerError)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	obj, ok := applyConfiguration(body, r)
+	if !ok {
+		// not a complete object, e.g. a patch of some fields
+		s.next.ServeHTTP(w, req)
+		return
+	}
+	// The owner reference is only injected into created resources. It must
+	// be part of every apply request though, since applying an object
+	// without it would remove it.
+	if s.injectOwnerRef && s.restMapper != nil && req.Method != http.MethodPost {
+		if _, err := setOwner(obj, owner, s.restMapper); err != nil {
+			m := "Could not set owner of the resource"
+			log.Error(err, m)
+			http.Error(w, m, http.StatusBadRequest)
+			return
+		}
+	}
+	applyBody, err := json.Marshal(obj.Object)
+	if err != nil {
+		m := "Could not serialize body"
+		log.Error(err, m)
+		http.Error(w, m, http.StatusInternalServerError)
+		return
+	}
+
+	if req.Method == http.MethodPost {
+		req.URL.Path = strings.TrimSuffix(req.URL.Path, "/") + "/" + obj.GetName()
+		req.URL.RawPath = ""
+	}
+	query := req.URL.Query()
+	query.Set("fieldManager", contents.ApplyFieldManager)
+	query.Del("force")
+	if contents.ForceApply {
+		query.Set("force", "true")
+	}
+	req.URL.RawQuery = query.Encode()
+	req.RequestURI = req.URL.RequestURI()
+	req.Method = http.MethodPatch
+	req.Header.Set("Content-Type", string(types.ApplyPatchType))
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(applyBody))
+	req.ContentLength = int64(len(applyBody))
+	log.V(1).Info("Sending server-side apply request", "path", req.URL.Path, "fieldManager", contents.ApplyFieldManager)
+
+	rec := httptest.NewRecorder()
+	s.next.ServeHTTP(rec, req)
+	if rec.Code == http.StatusConflict {
+		if status, ok := applyConflictStatus(responseJSON(rec.Header(), rec.Body.Bytes()), contents.ApplyFieldManager); ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			if err := json.NewEncoder(w).Encode(status); err != nil {
+				log.Error(err, "Failed to write response")
+			}
+			return
+		}
+	}
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	if _, err := w.Write(rec.Body.Bytes()); err != nil {
+		log.Error(err, "Failed to write response")
+	}
+}
+
+// applyConfiguration - returns the object in the body of a request without
+// the fields set by the API server, if the body is a complete object with the
+// name of the request.
+func applyConfiguration(body []byte, r *k8sRequest.RequestInfo) (*unstructured.Unstructured, bool) {
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(body, obj); err != nil {
+		return nil, false
+	}
+	if obj.GetAPIVersion() == "" || obj.GetName() == "" {
+		return nil, false
+	}
+	if r.Name != "" && obj.GetName() != r.Name {
+		return nil, false
+	}
+	for _, field := range serverSetFields {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+	return obj, true
+}
+
+// applyConflictStatus - returns the Status of a failed apply request with a
+// cause for each conflicting field, whose message is the field manager of the
+// field, or false if the body is not the Status of apply conflicts.
+func applyConflictStatus(body []byte, fieldManager string) (*metav1.Status, bool) {
+	status := &metav1.Status{}
+	if body == nil || json.Unmarshal(body, status) != nil || status.Details == nil {
+		return nil, false
+	}
+	causes := []metav1.StatusCause{}
+	conflicts := []string{}
+	for _, c := range status.Details.Causes {
+		if c.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		manager := c.Message
+		if m := conflictManagerRegexp.FindStringSubmatch(c.Message); m != nil {
+			manager = m[1]
+		}
+		causes = append(causes, metav1.StatusCause{Type: c.Type, Field: c.Field, Message: manager})
+		conflicts = append(conflicts, fmt.Sprintf("%s is managed by %q", c.Field, manager))
+	}
+	if len(causes) == 0 {
+		return nil, false
+	}
+	status.Message = fmt.Sprintf("server-side apply as field manager %q conflicts: %s",
+		fieldManager, strings.Join(conflicts, ", "))
+	status.Details.Causes = causes
+	return status, true
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	kmetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestServerSideApplyHandler(t *testing.T) {
+	ownerGVK := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	otherGVK := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Other"}
+	cMap := controllermap.NewControllerMap()
+	cMap.Store(ownerGVK, &controllermap.Contents{ApplyFieldManager: "memcached-operator"})
+	cMap.Store(otherGVK, &controllermap.Contents{})
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(ownerGVK, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+
+	deployment := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"example","namespace":"default","resourceVersion":"5"},"spec":{"replicas":3},"status":{"replicas":1}}`
+	testCases := []struct {
+		name            string
+		ownerGVK        schema.GroupVersionKind
+		method          string
+		path            string
+		contentType     string
+		body            string
+		conflict        bool
+		expectedMethod  string
+		expectedPath    string
+		expectedQuery   string
+		expectedBody    map[string]interface{}
+		expectedCode    int
+		expectedMessage string
+	}{
+		{
+			name:           "create",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPost,
+			path:           "/apis/apps/v1/namespaces/default/deployments",
+			contentType:    "application/json",
+			body:           deployment,
+			expectedMethod: http.MethodPatch,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedQuery:  "fieldManager=memcached-operator",
+			expectedBody: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "example", "namespace": "default"},
+				"spec":       map[string]interface{}{"replicas": float64(3)},
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:           "update",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPut,
+			path:           "/apis/apps/v1/namespaces/default/deployments/example",
+			contentType:    "application/json",
+			body:           deployment,
+			expectedMethod: http.MethodPatch,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedQuery:  "fieldManager=memcached-operator",
+			expectedBody: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name":      "example",
+					"namespace": "default",
+					"ownerReferences": []interface{}{
+						map[string]interface{}{"apiVersion": "app.example.com/v1alpha1", "kind": "Memcached", "name": "example", "uid": "1234"},
+					},
+				},
+				"spec": map[string]interface{}{"replicas": float64(3)},
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:           "merge patch of a complete object",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPatch,
+			path:           "/apis/apps/v1/namespaces/default/deployments/example?dryRun=All",
+			contentType:    string(types.StrategicMergePatchType),
+			body:           deployment,
+			expectedMethod: http.MethodPatch,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedQuery:  "dryRun=All&fieldManager=memcached-operator",
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "merge patch of some fields",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPatch,
+			path:           "/apis/apps/v1/namespaces/default/deployments/example",
+			contentType:    string(types.MergePatchType),
+			body:           `{"spec":{"replicas":3}}`,
+			expectedMethod: http.MethodPatch,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "JSON patch",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPatch,
+			path:           "/apis/apps/v1/namespaces/default/deployments/example",
+			contentType:    string(types.JSONPatchType),
+			body:           `[{"op":"replace","path":"/spec/replicas","value":3}]`,
+			expectedMethod: http.MethodPatch,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "update of a subresource",
+			ownerGVK:       ownerGVK,
+			method:         http.MethodPut,
+			path:           "/apis/apps/v1/namespaces/default/deployments/example/status",
+			contentType:    "application/json",
+			body:           deployment,
+			expectedMethod: http.MethodPut,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example/status",
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "watch without server-side apply",
+			ownerGVK:       otherGVK,
+			method:         http.MethodPut,
+			path:           "/apis/apps/v1/namespaces/default/deployments/example",
+			contentType:    "application/json",
+			body:           deployment,
+			expectedMethod: http.MethodPut,
+			expectedPath:   "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:            "conflict",
+			ownerGVK:        ownerGVK,
+			method:          http.MethodPut,
+			path:            "/apis/apps/v1/namespaces/default/deployments/example",
+			contentType:     "application/json",
+			body:            deployment,
+			conflict:        true,
+			expectedMethod:  http.MethodPatch,
+			expectedPath:    "/apis/apps/v1/namespaces/default/deployments/example",
+			expectedQuery:   "fieldManager=memcached-operator",
+			expectedCode:    http.StatusConflict,
+			expectedMessage: `server-side apply as field manager "memcached-operator" conflicts: .spec.replicas is managed by "hpa"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received *http.Request
+			var receivedBody []byte
+			next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				received = req
+				receivedBody, _ = ioutil.ReadAll(req.Body)
+				w.Header().Set("Content-Type", "application/json")
+				if tc.conflict {
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Conflict","code":409,` +
+						`"message":"Apply failed with 1 conflict: conflict with \"hpa\" using autoscaling/v1: .spec.replicas",` +
+						`"details":{"causes":[{"reason":"FieldManagerConflict","message":"conflict with \"hpa\" using autoscaling/v1","field":".spec.replicas"}]}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{}`))
+			})
+			h := &serverSideApplyHandler{next: next, cMap: cMap, restMapper: restMapper, injectOwnerRef: true}
+			owner := kubeconfig.NamespacedOwnerReference{
+				OwnerReference: kmetav1.OwnerReference{APIVersion: tc.ownerGVK.GroupVersion().String(), Kind: tc.ownerGVK.Kind, Name: "example", UID: "1234"},
+				Namespace:      "default",
+			}
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req = withRequestOwner(req, owner)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if received.Method != tc.expectedMethod || received.URL.Path != tc.expectedPath {
+				t.Fatalf("Unexpected request %s %s, expected %s %s", received.Method, received.URL.Path, tc.expectedMethod, tc.expectedPath)
+			}
+			if w.Code != tc.expectedCode {
+				t.Fatalf("Unexpected status code %d, expected %d", w.Code, tc.expectedCode)
+			}
+			if tc.expectedQuery != "" {
+				if received.URL.RawQuery != tc.expectedQuery {
+					t.Fatalf("Unexpected query %q, expected %q", received.URL.RawQuery, tc.expectedQuery)
+				}
+				if ct := received.Header.Get("Content-Type"); ct != string(types.ApplyPatchType) {
+					t.Fatalf("Unexpected content type %q", ct)
+				}
+			} else if string(receivedBody) != tc.body {
+				t.Fatalf("Unexpected body %s, expected it to be unchanged", receivedBody)
+			}
+			if tc.expectedBody != nil {
+				body := map[string]interface{}{}
+				if err := json.Unmarshal(receivedBody, &body); err != nil {
+					t.Fatalf("Failed to parse body: %v", err)
+				}
+				if !reflect.DeepEqual(body, tc.expectedBody) {
+					t.Fatalf("Unexpected body %#v, expected %#v", body, tc.expectedBody)
+				}
+			}
+			if tc.expectedMessage != "" {
+				status := kmetav1.Status{}
+				if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+					t.Fatalf("Failed to parse status: %v", err)
+				}
+				if status.Message != tc.expectedMessage {
+					t.Fatalf("Unexpected message %q, expected %q", status.Message, tc.expectedMessage)
+				}
+				expectedCauses := []kmetav1.StatusCause{{Type: kmetav1.CauseTypeFieldManagerConflict, Field: ".spec.replicas", Message: "hpa"}}
+				if status.Reason != kmetav1.StatusReasonConflict || !reflect.DeepEqual(status.Details.Causes, expectedCauses) {
+					t.Fatalf("Unexpected status %#v", status)
+				}
+			}
+		})
+	}
+}
+
+func TestSetOwnerIsIdempotent(t *testing.T) {
+	ownerGVK := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(ownerGVK, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	owner := kubeconfig.NamespacedOwnerReference{
+		OwnerReference: kmetav1.OwnerReference{APIVersion: "app.example.com/v1alpha1", Kind: "Memcached", Name: "example", UID: "1234"},
+		Namespace:      "default",
+	}
+	data := &unstructured.Unstructured{}
+	data.SetAPIVersion("v1")
+	data.SetKind("ConfigMap")
+	data.SetNamespace("default")
+	for i := 0; i < 2; i++ {
+		added, err := setOwner(data, owner, restMapper)
+		if err != nil || !added {
+			t.Fatalf("Unexpected result %v, %v", added, err)
+		}
+	}
+	if refs := data.GetOwnerReferences(); len(refs) != 1 {
+		t.Fatalf("Unexpected owner references %#v", refs)
+	}
+}
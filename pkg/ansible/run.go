@@ -16,15 +16,23 @@ package ansible
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"runtime"
+	"strconv"
+	"time"
 
+	"github.com/operator-framework/operator-sdk/pkg/ansible/artifacts"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/controller"
 	aoflags "github.com/operator-framework/operator-sdk/pkg/ansible/flags"
 	proxy "github.com/operator-framework/operator-sdk/pkg/ansible/proxy"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
@@ -32,11 +40,18 @@ import (
 	"github.com/operator-framework/operator-sdk/pkg/metrics"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 
+	"github.com/ghodss/yaml"
+	"golang.org/x/time/rate"
+
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -50,8 +65,25 @@ var (
 	log                       = logf.Log.WithName("cmd")
 	metricsPort         int32 = 8383
 	operatorMetricsPort int32 = 8686
+	proxyPort                 = 8888
+	runnerEventsPort          = 8889
 )
 
+// verifyHooks - returns an error if there are hooks for a GVK without a
+// watch.
+func verifyHooks(hooks map[schema.GroupVersionKind]controller.Hooks, ws []watches.Watch) error {
+	watched := map[schema.GroupVersionKind]bool{}
+	for _, w := range ws {
+		watched[w.GroupVersionKind] = true
+	}
+	for gvk := range hooks {
+		if !watched[gvk] {
+			return fmt.Errorf("hooks for GVK %v that is not watched", gvk.String())
+		}
+	}
+	return nil
+}
+
 func printVersion() {
 	log.Info(fmt.Sprintf("Go Version: %s", runtime.Version()))
 	log.Info(fmt.Sprintf("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH))
@@ -61,6 +93,13 @@ func printVersion() {
 // Run will start the ansible operator and proxy, blocking until one of them
 // returns.
 func Run(flags *aoflags.AnsibleOperatorFlags) error {
+	return RunWithHooks(flags, nil)
+}
+
+// RunWithHooks will start the ansible operator and proxy like Run, with hooks
+// that are called around the runs of the CRs of the watches with their GVKs.
+// It returns an error if there are hooks for a GVK that is not watched.
+func RunWithHooks(flags *aoflags.AnsibleOperatorFlags, hooks map[schema.GroupVersionKind]controller.Hooks) error {
 	printVersion()
 
 	namespace, found := os.LookupEnv(k8sutil.WatchNamespaceEnvVar)
@@ -99,40 +138,142 @@ func Run(flags *aoflags.AnsibleOperatorFlags) error {
 		return err
 	}
 
+	done := make(chan error)
+
+	// Every run authenticates to the proxy with a token of its own, which
+	// the proxy maps back to the owner of the run.
+	credentials := kubeconfig.NewCredentials()
+	proxyAddress := "localhost"
+	var executor runner.Executor
+	switch flags.RunnerBackend {
+	case "local":
+	case "job":
+		// The proxy must be reachable from the Jobs' pods, so it listens on
+		// all interfaces.
+		proxyAddress = "0.0.0.0"
+		executor, err = newJobExecutor(flags, cfg, credentials, done)
+		if err != nil {
+			log.Error(err, "Failed to create the job runner backend.")
+			return err
+		}
+		log.Info("Warning: the proxy and the event API of the job runner backend serve plain HTTP, "+
+			"restrict their ingress to the runner Jobs and encrypt the pod network",
+			"callbackHost", flags.RunnerCallbackHost)
+	default:
+		return fmt.Errorf("invalid runner backend %q, must be \"local\" or \"job\"", flags.RunnerBackend)
+	}
+
 	var gvks []schema.GroupVersionKind
+	policies := map[schema.GroupVersionKind]proxy.Policy{}
 	cMap := controllermap.NewControllerMap()
 	watches, err := watches.Load(flags.WatchesFile, flags.MaxWorkers, flags.AnsibleVerbosity)
 	if err != nil {
 		log.Error(err, "Failed to load watches.")
 		return err
 	}
+	if err := verifyHooks(hooks, watches); err != nil {
+		log.Error(err, "Invalid hooks.")
+		return err
+	}
+	var runLimiter *controller.RunLimiter
+	if flags.MaxConcurrentRuns > 0 {
+		runLimiter = controller.NewRunLimiter(flags.MaxConcurrentRuns)
+	}
 	for _, w := range watches {
-		runner, err := runner.New(w)
+		var r runner.Runner
+		if executor != nil {
+			r, err = runner.NewWithExecutor(w, executor, runner.SecretReader(mgr.GetAPIReader()))
+		} else {
+			r, err = runner.New(w, runner.SecretReader(mgr.GetAPIReader()))
+		}
 		if err != nil {
 			log.Error(err, "Failed to create runner")
 			return err
 		}
 
+		selector, err := controller.NewSelector(w.LabelSelector, w.AnnotationSelector, w.FieldSelector)
+		if err != nil {
+			log.Error(err, "Failed to parse selectors", "GVK", w.GroupVersionKind.String())
+			return err
+		}
+
 		ctr := controller.Add(mgr, controller.Options{
 			GVK:             w.GroupVersionKind,
-			Runner:          runner,
+			Runner:          r,
 			ManageStatus:    w.ManageStatus,
+			MaxTaskResults:  w.MaxTaskResults,
+			SkipUnchanged:   w.SkipUnchanged,
+			MaxStaleness:    w.MaxStaleness,
 			MaxWorkers:      w.MaxWorkers,
 			ReconcilePeriod: w.ReconcilePeriod,
+			RateLimiter:     newRateLimiter(w.RateLimiter),
+			RunLimiter:      runLimiter,
+			Selector:        selector,
+			Namespace:       namespace,
+			Hooks:           hooks[w.GroupVersionKind],
+			Credentials:     credentials,
 		})
 		if ctr == nil {
 			return fmt.Errorf("failed to add controller for GVK %v", w.GroupVersionKind.String())
 		}
 
+		serviceAccount, err := watchServiceAccount(w)
+		if err != nil {
+			log.Error(err, "Failed to get service account", "GVK", w.GroupVersionKind.String())
+			return err
+		}
+
+		applyFieldManager, forceApply := "", false
+		if w.ServerSideApply != nil {
+			applyFieldManager, forceApply = w.ServerSideApply.FieldManager, w.ServerSideApply.Force
+		}
+
 		cMap.Store(w.GroupVersionKind, &controllermap.Contents{Controller: *ctr,
 			WatchDependentResources:     w.WatchDependentResources,
 			WatchClusterScopedResources: w.WatchClusterScopedResources,
 			OwnerWatchMap:               controllermap.NewWatchMap(),
 			AnnotationWatchMap:          controllermap.NewWatchMap(),
+			ServiceAccount:              serviceAccount,
+			IncludeDependentGVKs:        w.DependentResources.Include,
+			ExcludeDependentGVKs:        w.DependentResources.Exclude,
+			MaxDependentWatches:         w.DependentResources.MaxWatches,
+			ApplyFieldManager:           applyFieldManager,
+			ForceApply:                  forceApply,
 		})
+		if w.Policy != nil {
+			policies[w.GroupVersionKind] = proxy.Policy{
+				OwnerNamespace:    w.Policy.OwnerNamespace,
+				AllowedNamespaces: w.Policy.AllowedNamespaces,
+				AllowedGVKs:       w.Policy.AllowedKinds,
+				ReadOnlyGVKs:      w.Policy.ReadOnlyKinds,
+			}
+		}
 		gvks = append(gvks, w.GroupVersionKind)
 	}
 
+	if flags.ArtifactsBindAddress != "" || flags.ArtifactsExportPath != "" || flags.ArtifactsMaxAge > 0 {
+		reviewClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return err
+		}
+		err = mgr.Add(&artifacts.Server{
+			Store: &artifacts.Store{
+				RunnerDir: runner.Dir,
+				ExportDir: flags.ArtifactsExportPath,
+				MaxAge:    flags.ArtifactsMaxAge,
+				MaxCount:  flags.ArtifactsMaxCount,
+				GVKs:      gvks,
+			},
+			Address:    flags.ArtifactsBindAddress,
+			Client:     reviewClient,
+			SyncPeriod: time.Minute,
+		})
+		if err != nil {
+			log.Error(err, "Failed to add artifacts server.")
+			return err
+		}
+	}
+
 	operatorName, err := k8sutil.GetOperatorName()
 	if err != nil {
 		log.Error(err, "Failed to get the operator name")
@@ -166,18 +307,34 @@ func Run(flags *aoflags.AnsibleOperatorFlags) error {
 		return err
 	}
 
-	done := make(chan error)
+	var auditSink proxy.AuditSink
+	if flags.AuditLogPath != "" {
+		fileSink, err := proxy.NewFileAuditSink(flags.AuditLogPath, int64(flags.AuditLogMaxSize)*1024*1024, flags.AuditLogMaxBackups)
+		if err != nil {
+			log.Error(err, "Failed to open the audit log.")
+			return err
+		}
+		defer func() {
+			if err := fileSink.Close(); err != nil {
+				log.Error(err, "Failed to close the audit log.")
+			}
+		}()
+		auditSink = fileSink
+	}
 
 	// start the proxy
 	err = proxy.Run(done, proxy.Options{
-		Address:           "localhost",
-		Port:              8888,
+		Address:           proxyAddress,
+		Port:              proxyPort,
+		Credentials:       credentials,
 		KubeConfig:        mgr.GetConfig(),
 		Cache:             mgr.GetCache(),
 		RESTMapper:        mgr.GetRESTMapper(),
 		ControllerMap:     cMap,
 		OwnerInjection:    flags.InjectOwnerRef,
 		WatchedNamespaces: []string{namespace},
+		AuditSink:         auditSink,
+		Policies:          policies,
 	})
 	if err != nil {
 		log.Error(err, "Error starting proxy.")
@@ -198,3 +355,72 @@ func Run(flags *aoflags.AnsibleOperatorFlags) error {
 	log.Info("Exiting.")
 	return nil
 }
+
+// newJobExecutor - creates the executor that runs ansible-runner in Jobs,
+// issuing each Job a token from credentials.
+func newJobExecutor(flags *aoflags.AnsibleOperatorFlags, cfg *rest.Config, credentials *kubeconfig.Credentials, errChan chan<- error) (*runner.JobExecutor, error) {
+	if flags.RunnerJobTemplate == "" || flags.RunnerCallbackHost == "" {
+		return nil, errors.New("--runner-job-template and --runner-callback-host are required by the job runner backend")
+	}
+	b, err := ioutil.ReadFile(flags.RunnerJobTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runner job template: %w", err)
+	}
+	template := &batchv1.Job{}
+	if err := yaml.Unmarshal(b, template); err != nil {
+		return nil, fmt.Errorf("failed to parse runner job template: %w", err)
+	}
+	if len(template.Spec.Template.Spec.Containers) == 0 {
+		return nil, errors.New("runner job template has no containers")
+	}
+	namespace, err := k8sutil.GetOperatorNamespace()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	events, err := eventapi.NewServer(fmt.Sprintf(":%d", runnerEventsPort), errChan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start runner event server: %w", err)
+	}
+	return &runner.JobExecutor{
+		Client:       client,
+		Namespace:    namespace,
+		Template:     template,
+		ProxyURL:     fmt.Sprintf("http://%s", net.JoinHostPort(flags.RunnerCallbackHost, strconv.Itoa(proxyPort))),
+		Credentials:  credentials,
+		Events:       events,
+		EventsURL:    fmt.Sprintf("http://%s", net.JoinHostPort(flags.RunnerCallbackHost, strconv.Itoa(runnerEventsPort))),
+		PollInterval: 2 * time.Second,
+		Timeout:      flags.RunnerJobTimeout,
+	}, nil
+}
+
+// watchServiceAccount - returns the service account that the proxy
+// impersonates for the runs of a watch. A service account without a
+// namespace is in the operator's namespace.
+func watchServiceAccount(w watches.Watch) (types.NamespacedName, error) {
+	namespace, name := w.ServiceAccountName()
+	if name == "" || namespace != "" {
+		return types.NamespacedName{Namespace: namespace, Name: name}, nil
+	}
+	namespace, err := k8sutil.GetOperatorNamespace()
+	if err != nil {
+		return types.NamespacedName{}, fmt.Errorf("failed to get the namespace of service account %q, set it as <namespace>/<name> when running outside of a cluster: %w", name, err)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// newRateLimiter - returns the workqueue rate limiter of a watch's
+// configuration, or nil if it has none.
+func newRateLimiter(rl *watches.RateLimiter) workqueue.RateLimiter {
+	if rl == nil {
+		return nil
+	}
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(rl.BaseDelay, rl.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(rl.QPS), rl.Burst)},
+	)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Server - serves the event API of many runs on a single TCP listener, so
+// that runs of ansible-runner outside of the operator's pod can send their
+// events back to the operator. Each run gets its own EventReceiver, whose
+// URLPath contains a random token, so that only the run the path was handed
+// to can send events to it.
+type Server struct {
+	listener  net.Listener
+	server    *http.Server
+	mutex     sync.RWMutex
+	receivers map[string]*EventReceiver
+}
+
+// NewServer - starts serving the event API on address. Errors of the server
+// are sent to errChan.
+func NewServer(address string, errChan chan<- error) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener:  listener,
+		receivers: map[string]*EventReceiver{},
+	}
+	s.server = &http.Server{Handler: s}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			errChan <- err
+		}
+	}()
+	return s, nil
+}
+
+// Addr - returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close - stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// NewReceiver - returns an EventReceiver for the run with the given ident.
+// The receiver must be closed, which stops routing requests to it.
+func (s *Server) NewReceiver(ident string) (*EventReceiver, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate event path token: %w", err)
+	}
+	rec := &EventReceiver{
+		Events:  make(chan JobEvent, 1000),
+		URLPath: fmt.Sprintf("/events/%s/%s/", ident, hex.EncodeToString(token)),
+		ident:   ident,
+		logger:  logf.Log.WithName("eventapi").WithValues("job", ident),
+	}
+	rec.server = receiverCloser{server: s, path: rec.URLPath}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.receivers[rec.URLPath] = rec
+	return rec, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	rec, ok := s.receivers[r.URL.Path]
+	s.mutex.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	rec.handleEvents(w, r)
+}
+
+// receiverCloser - unregisters a receiver from a Server when it is closed.
+type receiverCloser struct {
+	server *Server
+	path   string
+}
+
+func (c receiverCloser) Close() error {
+	c.server.mutex.Lock()
+	defer c.server.mutex.Unlock()
+	delete(c.server.receivers, c.path)
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/artifacts"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/internal/inputdir"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Executor - runs ansible-runner for a run of a CR. Execute must not block
+// until ansible-runner exits; the events of the run are sent on the
+// RunResult's Events channel, which is closed once ansible-runner exited.
+type Executor interface {
+	Execute(Execution) (RunResult, error)
+}
+
+// Execution - a run of ansible-runner for a CR.
+type Execution struct {
+	// Ident is the unique identifier of the run.
+	Ident string
+	// GVK is the GroupVersionKind of the CR.
+	GVK schema.GroupVersionKind
+	// Resource is the CR that is reconciled.
+	Resource *unstructured.Unstructured
+	// Kubeconfig is the path to the kubeconfig of the operator's proxy for
+	// the CR.
+	Kubeconfig string
+	// Parameters are the extra vars of the run.
+	Parameters map[string]interface{}
+	// SensitiveParameters are the extra vars of the run that contain
+	// sensitive values. They are written to a separate vars file, which
+	// must not be kept after the run.
+	SensitiveParameters map[string]interface{}
+	// VaultParameters are the extra vars of the run that contain values
+	// encrypted with Ansible Vault. They are written to a separate vars
+	// file, with the values tagged with "!vault".
+	VaultParameters map[string]interface{}
+	// VaultPassword, if not nil, is the password that ansible decrypts the
+	// vault encrypted values with.
+	VaultPassword []byte
+	// PlaybookPath is the path to the playbook that is run. It is empty
+	// when a role is run.
+	PlaybookPath string
+	// CheckMode is set for runs that only report what they would change.
+	CheckMode bool
+
+	cmdFunc      cmdFuncType
+	maxArtifacts int
+	verbosity    int
+	scrubber     *scrubber
+}
+
+// Args - returns the ansible-runner command line for an input directory at
+// inputDirPath, starting with the "ansible-runner" executable.
+func (e Execution) Args(inputDirPath string) []string {
+	return e.cmd(inputDirPath).Args
+}
+
+// cmd - returns the Cmd that runs ansible-runner for an input directory at
+// inputDirPath.
+func (e Execution) cmd(inputDirPath string) *exec.Cmd {
+	dc := e.cmdFunc(e.Ident, inputDirPath, e.maxArtifacts, e.verbosity)
+	cmdline := []string{}
+	if e.CheckMode {
+		cmdline = append(cmdline, "--check --diff")
+	}
+	if e.VaultPassword != nil {
+		cmdline = append(cmdline, fmt.Sprintf("--vault-password-file %s", filepath.Join(inputDirPath, inputdir.VaultPasswordFile)))
+	}
+	if len(e.VaultParameters) > 0 {
+		cmdline = append(cmdline, fmt.Sprintf("-e @%s", filepath.Join(inputDirPath, inputdir.VaultVarsFile)))
+	}
+	if len(e.SensitiveParameters) > 0 {
+		cmdline = append(cmdline, fmt.Sprintf("-e @%s", filepath.Join(inputDirPath, inputdir.SensitiveVarsFile)))
+	}
+	if len(cmdline) > 0 {
+		dc.Args = append([]string{dc.Args[0], "--cmdline", strings.Join(cmdline, " ")}, dc.Args[1:]...)
+	}
+	return dc
+}
+
+// localExecutor - runs ansible-runner as a child process of the operator.
+type localExecutor struct{}
+
+func (localExecutor) Execute(e Execution) (RunResult, error) {
+	logger := log.WithValues(
+		"job", e.Ident,
+		"name", e.Resource.GetName(),
+		"namespace", e.Resource.GetNamespace(),
+	)
+
+	// start the event receiver. We'll check errChan for an error after
+	// ansible-runner exits.
+	errChan := make(chan error, 1)
+	receiver, err := eventapi.New(e.Ident, errChan)
+	if err != nil {
+		return nil, err
+	}
+	inputDir := inputdir.InputDir{
+		Path:                filepath.Join(Dir, e.GVK.Group, e.GVK.Version, e.GVK.Kind, e.Resource.GetNamespace(), e.Resource.GetName()),
+		PlaybookPath:        e.PlaybookPath,
+		Parameters:          e.Parameters,
+		SensitiveParameters: e.SensitiveParameters,
+		VaultParameters:     e.VaultParameters,
+		VaultPassword:       e.VaultPassword,
+		EnvVars: map[string]string{
+			"K8S_AUTH_KUBECONFIG": e.Kubeconfig,
+			"KUBECONFIG":          e.Kubeconfig,
+		},
+		Settings: map[string]string{
+			"runner_http_url":  receiver.SocketPath,
+			"runner_http_path": receiver.URLPath,
+		},
+	}
+	err = inputDir.Write()
+	if err != nil {
+		receiver.Close()
+		return nil, err
+	}
+
+	go func() {
+		dc := e.cmd(inputDir.Path)
+		// Append current environment since setting dc.Env to anything other than nil overwrites current env
+		dc.Env = append(dc.Env, os.Environ()...)
+		dc.Env = append(dc.Env, fmt.Sprintf("K8S_AUTH_KUBECONFIG=%s", e.Kubeconfig), fmt.Sprintf("KUBECONFIG=%s", e.Kubeconfig))
+
+		output, err := dc.CombinedOutput()
+		if err != nil {
+			logger.Error(err, e.scrubber.String(string(output)))
+		} else {
+			logger.Info("Ansible-runner exited successfully")
+		}
+		if err := inputDir.RemoveSensitiveFiles(); err != nil {
+			logger.Error(err, "Failed to remove sensitive files")
+		}
+
+		receiver.Close()
+		err = <-errChan
+		// http.Server returns this in the case of being closed cleanly
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error(err, "Error from event API")
+		}
+
+		// link the current run to the `latest` directory under artifacts
+		currentRun := filepath.Join(inputDir.Path, "artifacts", e.Ident)
+		// The artifacts are only served and exported once they were scrubbed.
+		if err := e.scrubber.Dir(currentRun); err != nil {
+			logger.Error(err, "Failed to scrub sensitive values from artifacts")
+		} else if err := ioutil.WriteFile(filepath.Join(currentRun, artifacts.ScrubbedFile), nil, 0644); err != nil {
+			logger.Error(err, "Failed to mark the artifacts as scrubbed")
+		}
+		latestArtifacts := filepath.Join(inputDir.Path, "artifacts", "latest")
+		if _, err = os.Lstat(latestArtifacts); err == nil {
+			if err = os.Remove(latestArtifacts); err != nil {
+				logger.Error(err, "Error removing the latest artifacts symlink")
+			}
+		}
+		if err = os.Symlink(currentRun, latestArtifacts); err != nil {
+			logger.Error(err, "Error symlinking latest artifacts")
+		}
+
+	}()
+
+	return &runResult{
+		events:   receiver.Events,
+		inputDir: &inputDir,
+		ident:    e.Ident,
+	}, nil
+}
@@ -15,6 +15,7 @@
 package fake
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -36,6 +37,8 @@ type Runner struct {
 	JobEvents []eventapi.JobEvent
 	//Stdout standard out to reply if failure occurs.
 	Stdout string
+	// CRs that were run, in the order of their runs.
+	Runs []*unstructured.Unstructured
 }
 
 type runResult struct {
@@ -56,6 +59,7 @@ func (r *runResult) Stdout() (string, error) {
 
 // Run - runs the fake runner.
 func (r *Runner) Run(_ string, u *unstructured.Unstructured, _ string) (runner.RunResult, error) {
+	r.Runs = append(r.Runs, u.DeepCopy())
 	if r.Error != nil {
 		return nil, r.Error
 	}
@@ -69,6 +73,12 @@ func (r *Runner) Run(_ string, u *unstructured.Unstructured, _ string) (runner.R
 	return &runResult{events: c, stdout: r.Stdout}, nil
 }
 
+// InputHash - hashes the spec of the CR.
+func (r *Runner) InputHash(u *unstructured.Unstructured) (string, error) {
+	b, err := json.Marshal(u.Object["spec"])
+	return string(b), err
+}
+
 // GetReconcilePeriod - new reconcile period.
 func (r *Runner) GetReconcilePeriod() (time.Duration, bool) {
 	return r.ReconcilePeriod, r.ReconcilePeriod != time.Duration(0)
@@ -30,13 +30,29 @@ import (
 
 var log = logf.Log.WithName("inputdir")
 
+// Paths of the files with sensitive content, relative to the input
+// directory. ansible-runner does not read them by itself; the vars files must
+// be passed to ansible with "-e @<path>", and the vault password file with
+// "--vault-password-file <path>".
+const (
+	// SensitiveVarsFile is the vars file with the sensitive parameters.
+	SensitiveVarsFile = "env/sensitive_vars"
+	// VaultVarsFile is the vars file with the vault encrypted parameters.
+	VaultVarsFile = "env/vault_vars"
+	// VaultPasswordFile is the file with the vault password.
+	VaultPasswordFile = "env/vault_password"
+)
+
 // InputDir represents an input directory for ansible-runner.
 type InputDir struct {
-	Path         string
-	PlaybookPath string
-	Parameters   map[string]interface{}
-	EnvVars      map[string]string
-	Settings     map[string]string
+	Path                string
+	PlaybookPath        string
+	Parameters          map[string]interface{}
+	SensitiveParameters map[string]interface{}
+	VaultParameters     map[string]interface{}
+	VaultPassword       []byte
+	EnvVars             map[string]string
+	Settings            map[string]string
 }
 
 // makeDirs creates the required directory structure.
@@ -54,14 +70,43 @@ func (i *InputDir) makeDirs() error {
 
 // addFile adds a file to the given relative path within the input directory.
 func (i *InputDir) addFile(path string, content []byte) error {
+	return i.addFileWithMode(path, content, 0644)
+}
+
+// addFileWithMode adds a file with the given mode to the given relative path
+// within the input directory.
+func (i *InputDir) addFileWithMode(path string, content []byte, mode os.FileMode) error {
 	fullPath := filepath.Join(i.Path, path)
-	err := ioutil.WriteFile(fullPath, content, 0644)
+	err := ioutil.WriteFile(fullPath, content, mode)
 	if err != nil {
 		log.Error(err, "Unable to write file", "Path", fullPath)
 	}
 	return err
 }
 
+// addSensitiveFile adds a file that is only readable by its owner to the
+// given relative path within the input directory.
+func (i *InputDir) addSensitiveFile(path string, content []byte) error {
+	// The mode of an existing file is not changed by WriteFile.
+	err := os.Remove(filepath.Join(i.Path, path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return i.addFileWithMode(path, content, 0600)
+}
+
+// RemoveSensitiveFiles removes the files with sensitive content from the input
+// directory.
+func (i *InputDir) RemoveSensitiveFiles() error {
+	for _, path := range []string{SensitiveVarsFile, VaultVarsFile, VaultPasswordFile} {
+		err := os.Remove(filepath.Join(i.Path, path))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // copyInventory copies a file or directory from src to dst
 func (i *InputDir) copyInventory(src string, dst string) error {
 	fs := afero.NewOsFs()
@@ -131,6 +176,32 @@ func (i *InputDir) Write() error {
 	if err != nil {
 		return err
 	}
+	if len(i.SensitiveParameters) > 0 {
+		sensitiveBytes, err := json.Marshal(i.SensitiveParameters)
+		if err != nil {
+			return err
+		}
+		err = i.addSensitiveFile(SensitiveVarsFile, sensitiveBytes)
+		if err != nil {
+			return err
+		}
+	}
+	if len(i.VaultParameters) > 0 {
+		vaultBytes, err := marshalVaultVars(i.VaultParameters)
+		if err != nil {
+			return err
+		}
+		err = i.addSensitiveFile(VaultVarsFile, vaultBytes)
+		if err != nil {
+			return err
+		}
+	}
+	if i.VaultPassword != nil {
+		err = i.addSensitiveFile(VaultPasswordFile, i.VaultPassword)
+		if err != nil {
+			return err
+		}
+	}
 
 	// ANSIBLE_INVENTORY takes precedence over our generated hosts file
 	// so if the envvar is set we don't bother making it, we just copy
@@ -0,0 +1,96 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inputdir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
+)
+
+// marshalVaultVars - marshals vars to YAML in the flow style, which is JSON
+// with tags, tagging the vault encrypted strings with "!vault" so that
+// ansible decrypts them.
+func marshalVaultVars(vars map[string]interface{}) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if err := writeVaultValue(b, vars); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeVaultValue(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		return writeVaultMap(b, keys, func(k string) interface{} { return v[k] })
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		values := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			keys = append(keys, fmt.Sprint(k))
+			values[fmt.Sprint(k)] = e
+		}
+		return writeVaultMap(b, keys, func(k string) interface{} { return values[k] })
+	case []interface{}:
+		b.WriteString("[")
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if err := writeVaultValue(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteString("]")
+		return nil
+	}
+	if watches.IsVaultEncrypted(v) {
+		b.WriteString("!vault ")
+	}
+	e, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.Write(e)
+	return nil
+}
+
+func writeVaultMap(b *bytes.Buffer, keys []string, value func(string) interface{}) error {
+	sort.Strings(keys)
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteString(": ")
+		if err := writeVaultValue(b, value(k)); err != nil {
+			return err
+		}
+	}
+	b.WriteString("}")
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inputdir
+
+import (
+	"testing"
+)
+
+func TestMarshalVaultVars(t *testing.T) {
+	vars := map[string]interface{}{
+		"db": map[interface{}]interface{}{
+			"password": "$ANSIBLE_VAULT;1.1;AES256\n6231\n",
+			"port":     5432,
+		},
+		"hosts": []interface{}{"a", "b"},
+	}
+	expected := `{"db": {"password": !vault "$ANSIBLE_VAULT;1.1;AES256\n6231\n", "port": 5432}, "hosts": ["a", "b"]}`
+	b, err := marshalVaultVars(vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Unexpected vars:\nActual: %s\nExpected: %s", b, expected)
+	}
+}
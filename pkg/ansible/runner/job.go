@@ -0,0 +1,421 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/internal/inputdir"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// JobContainerName - name of the container of a Job template that runs
+	// ansible-runner. The first container is used if no container has this
+	// name.
+	JobContainerName = "ansible-runner"
+
+	// JobIdentLabel - label set on the Jobs, Secrets and pods of runs to the
+	// ident of the run.
+	JobIdentLabel = "ansible.operator-sdk/runner-ident"
+
+	jobInputVolume    = "ansible-runner-input"
+	jobInputMountPath = "/tmp/ansible-runner-input"
+	jobRunnerVolume   = "ansible-runner"
+	jobRunnerDir      = "/tmp/ansible-runner"
+
+	// maxJobLogBytes is the maximum size of the pod logs kept as the stdout
+	// of a run.
+	maxJobLogBytes = 1 << 20
+
+	// DefaultJobTimeout - the maximum duration of a run in a Job if the
+	// JobExecutor has no Timeout.
+	DefaultJobTimeout = time.Hour
+	// jobWaitGrace - how long a run waits for its Job to be marked as failed
+	// after the Job's deadline passed.
+	jobWaitGrace = time.Minute
+)
+
+// JobExecutor - runs ansible-runner in a Kubernetes Job. The input directory
+// of a run is handed to the Job in a Secret, ansible-runner talks to the API
+// server through the operator's proxy, and sends its events to the
+// operator's event Server. The stdout of a run is read from the logs of the
+// Job's pod, and the Job is deleted once it finished.
+type JobExecutor struct {
+	Client kubernetes.Interface
+	// Namespace is the namespace the Jobs and Secrets of runs are created in.
+	Namespace string
+	// Template is the Job that is created for each run. It must use an image
+	// that contains ansible-runner and the playbooks and roles of the
+	// operator at the same paths as the operator's image.
+	Template *batchv1.Job
+	// ProxyURL is the URL of the operator's proxy, as reachable from the
+	// Jobs' pods.
+	ProxyURL string
+	// Credentials issue the token that the Job of a run authenticates to the
+	// proxy with. The token is only valid for the owner of the run, and is
+	// revoked once the Job ended.
+	Credentials *kubeconfig.Credentials
+	// Events is the server that receives the events of runs.
+	Events *eventapi.Server
+	// EventsURL is the URL of Events, as reachable from the Jobs' pods.
+	EventsURL string
+	// PollInterval is the interval in which the status of a Job is checked.
+	PollInterval time.Duration
+	// Timeout is the maximum duration of a run. It is set as the
+	// activeDeadlineSeconds of the Jobs whose template has no shorter
+	// deadline. DefaultJobTimeout is used if it is 0.
+	Timeout time.Duration
+}
+
+func (j *JobExecutor) Execute(e Execution) (RunResult, error) {
+	logger := log.WithValues(
+		"job", e.Ident,
+		"name", e.Resource.GetName(),
+		"namespace", e.Resource.GetNamespace(),
+	)
+
+	u := e.Resource
+	owner := kubeconfig.NamespacedOwnerReference{
+		OwnerReference: metav1.OwnerReference{
+			APIVersion: u.GetAPIVersion(),
+			Kind:       u.GetKind(),
+			Name:       u.GetName(),
+			UID:        u.GetUID(),
+		},
+		Namespace: u.GetNamespace(),
+		CheckMode: e.CheckMode,
+	}
+	token, err := j.Credentials.Issue(owner)
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := j.Events.NewReceiver(e.Ident)
+	if err != nil {
+		j.Credentials.Revoke(token)
+		return nil, err
+	}
+	secret, err := j.newSecret(e, owner, token, receiver.URLPath)
+	if err != nil {
+		j.Credentials.Revoke(token)
+		receiver.Close()
+		return nil, err
+	}
+	// The Secret is created before the Job, so that the Job's pod never
+	// waits for its volume.
+	newJob := j.newJob(e, secret)
+	secret, err = j.Client.CoreV1().Secrets(j.Namespace).Create(secret)
+	if err != nil {
+		j.Credentials.Revoke(token)
+		receiver.Close()
+		return nil, fmt.Errorf("failed to create runner input secret: %w", err)
+	}
+	job, err := j.Client.BatchV1().Jobs(j.Namespace).Create(newJob)
+	if err != nil {
+		j.deleteSecret(secret)
+		j.Credentials.Revoke(token)
+		receiver.Close()
+		return nil, fmt.Errorf("failed to create runner job: %w", err)
+	}
+	// The Secret is owned by the Job, so that it is garbage collected with
+	// the Job if the operator exits during the run.
+	secret.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job")),
+	}
+	if _, err := j.Client.CoreV1().Secrets(j.Namespace).Update(secret); err != nil {
+		logger.Error(err, "Failed to set the owner of the runner input secret", "Secret.Name", secret.GetName())
+	}
+	logger.V(1).Info("Created runner job", "Job.Name", job.GetName(), "Job.Namespace", job.GetNamespace())
+
+	result := &jobRunResult{events: receiver.Events}
+	go func() {
+		// The stdout is set before the events channel is closed, so that it
+		// can be read by the receiver of the events.
+		if err := j.wait(job); err != nil {
+			logger.Error(err, "Failed to wait for runner job", "Job.Name", job.GetName())
+			result.stdoutErr = err
+		} else {
+			result.stdout, result.stdoutErr = j.logs(job)
+		}
+		// The Job's token must not outlive it.
+		j.Credentials.Revoke(token)
+		j.deleteJob(job)
+		j.deleteSecret(secret)
+		receiver.Close()
+	}()
+	return result, nil
+}
+
+// newSecret - returns the Secret with the input directory of a run and the
+// kubeconfig of the proxy, which authenticates with token.
+func (j *JobExecutor) newSecret(e Execution, owner kubeconfig.NamespacedOwnerReference, token, eventsPath string) (*corev1.Secret, error) {
+	dir, err := ioutil.TempDir("", "ansible-runner-input")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error(err, "Failed to remove runner input directory", "Path", dir)
+		}
+	}()
+
+	kubeconfigPath := filepath.Join(jobRunnerDir, "kubeconfig")
+	inputDir := inputdir.InputDir{
+		Path:                dir,
+		PlaybookPath:        e.PlaybookPath,
+		Parameters:          e.Parameters,
+		SensitiveParameters: e.SensitiveParameters,
+		VaultParameters:     e.VaultParameters,
+		VaultPassword:       e.VaultPassword,
+		EnvVars: map[string]string{
+			"K8S_AUTH_KUBECONFIG": kubeconfigPath,
+			"KUBECONFIG":          kubeconfigPath,
+		},
+		Settings: map[string]string{
+			"runner_http_url":  j.EventsURL,
+			"runner_http_path": eventsPath,
+		},
+	}
+	if err := inputDir.Write(); err != nil {
+		return nil, err
+	}
+	data := map[string][]byte{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data[filepath.Join("input", rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runner input directory: %w", err)
+	}
+
+	kc, err := kubeconfig.Render(owner, j.ProxyURL, token)
+	if err != nil {
+		return nil, err
+	}
+	data["kubeconfig"] = kc
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName(e.Ident),
+			Namespace: j.Namespace,
+			Labels:    map[string]string{JobIdentLabel: e.Ident},
+		},
+		Data: data,
+	}, nil
+}
+
+// newJob - returns the Job of a run from the template. Secret keys can't
+// contain slashes, so the files of the input directory are stored under
+// numbered keys and mapped to their paths in the volume.
+func (j *JobExecutor) newJob(e Execution, secret *corev1.Secret) *batchv1.Job {
+	job := j.Template.DeepCopy()
+	job.ObjectMeta = metav1.ObjectMeta{
+		Name:        jobName(e.Ident),
+		Namespace:   j.Namespace,
+		Labels:      job.GetLabels(),
+		Annotations: job.GetAnnotations(),
+	}
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[JobIdentLabel] = e.Ident
+	if job.Spec.Template.Labels == nil {
+		job.Spec.Template.Labels = map[string]string{}
+	}
+	job.Spec.Template.Labels[JobIdentLabel] = e.Ident
+	// ansible-runner is run once; the reconcile is retried by the operator.
+	backoffLimit := int32(0)
+	job.Spec.BackoffLimit = &backoffLimit
+	deadline := int64(j.timeout() / time.Second)
+	if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds > deadline {
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+
+	keys := map[string][]byte{}
+	items := []corev1.KeyToPath{}
+	for path, content := range secret.Data {
+		key := fmt.Sprintf("file-%d", len(items))
+		keys[key] = content
+		items = append(items, corev1.KeyToPath{Key: key, Path: path})
+	}
+	secret.Data = keys
+
+	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes,
+		corev1.Volume{
+			Name: jobInputVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: secret.GetName(), Items: items},
+			},
+		},
+		corev1.Volume{
+			Name:         jobRunnerVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	)
+
+	containers := job.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		containers = append(containers, corev1.Container{Name: JobContainerName})
+	}
+	c := &containers[0]
+	for i := range containers {
+		if containers[i].Name == JobContainerName {
+			c = &containers[i]
+			break
+		}
+	}
+	// The input directory is copied out of the read-only Secret volume,
+	// since ansible-runner writes its artifacts into it.
+	inputDirPath := filepath.Join(jobRunnerDir, "input")
+	script := fmt.Sprintf(`cp -RL %s/. %s && exec "$@"`, jobInputMountPath, jobRunnerDir)
+	c.Command = append([]string{"/bin/sh", "-c", script, "sh"}, e.Args(inputDirPath)...)
+	c.Args = nil
+	kubeconfigPath := filepath.Join(jobRunnerDir, "kubeconfig")
+	c.Env = append(c.Env,
+		corev1.EnvVar{Name: "K8S_AUTH_KUBECONFIG", Value: kubeconfigPath},
+		corev1.EnvVar{Name: "KUBECONFIG", Value: kubeconfigPath},
+	)
+	c.VolumeMounts = append(c.VolumeMounts,
+		corev1.VolumeMount{Name: jobInputVolume, MountPath: jobInputMountPath, ReadOnly: true},
+		corev1.VolumeMount{Name: jobRunnerVolume, MountPath: jobRunnerDir},
+	)
+	job.Spec.Template.Spec.Containers = containers
+	return job
+}
+
+// wait - waits until the Job completed or failed, and returns an error if it
+// exceeded its deadline or did not finish in time.
+func (j *JobExecutor) wait(job *batchv1.Job) error {
+	timeout := time.Duration(*job.Spec.ActiveDeadlineSeconds)*time.Second + jobWaitGrace
+	err := wait.PollImmediate(j.PollInterval, timeout, func() (bool, error) {
+		current, err := j.Client.BatchV1().Jobs(job.GetNamespace()).Get(job.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, errors.New("runner job was deleted")
+		}
+		if err != nil {
+			log.Error(err, "Failed to get runner job", "Job.Name", job.GetName())
+			return false, nil
+		}
+		for _, c := range current.Status.Conditions {
+			if c.Status != corev1.ConditionTrue {
+				continue
+			}
+			if c.Type == batchv1.JobFailed && c.Reason == "DeadlineExceeded" {
+				return false, fmt.Errorf("runner job exceeded its deadline of %ds", *job.Spec.ActiveDeadlineSeconds)
+			}
+			if c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("runner job did not finish within %s", timeout)
+	}
+	return err
+}
+
+func (j *JobExecutor) timeout() time.Duration {
+	if j.Timeout > 0 {
+		return j.Timeout
+	}
+	return DefaultJobTimeout
+}
+
+// logs - returns the logs of the ansible-runner container of the Job's pod.
+func (j *JobExecutor) logs(job *batchv1.Job) (string, error) {
+	pods, err := j.Client.CoreV1().Pods(job.GetNamespace()).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", JobIdentLabel, job.GetLabels()[JobIdentLabel]),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no pod found for runner job %s", job.GetName())
+	}
+	pod := pods.Items[len(pods.Items)-1]
+	limit := int64(maxJobLogBytes)
+	opts := &corev1.PodLogOptions{LimitBytes: &limit}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == JobContainerName {
+			opts.Container = c.Name
+		}
+	}
+	if opts.Container == "" && len(pod.Spec.Containers) > 0 {
+		opts.Container = pod.Spec.Containers[0].Name
+	}
+	out, err := j.Client.CoreV1().Pods(pod.GetNamespace()).GetLogs(pod.GetName(), opts).DoRaw()
+	return string(out), err
+}
+
+func (j *JobExecutor) deleteJob(job *batchv1.Job) {
+	propagation := metav1.DeletePropagationBackground
+	err := j.Client.BatchV1().Jobs(job.GetNamespace()).Delete(job.GetName(), &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete runner job", "Job.Name", job.GetName())
+	}
+}
+
+func (j *JobExecutor) deleteSecret(secret *corev1.Secret) {
+	err := j.Client.CoreV1().Secrets(secret.GetNamespace()).Delete(secret.GetName(), &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete runner input secret", "Secret.Name", secret.GetName())
+	}
+}
+
+func jobName(ident string) string {
+	return fmt.Sprintf("ansible-runner-%s", ident)
+}
+
+// jobRunResult - the result of a run in a Job.
+type jobRunResult struct {
+	events    <-chan eventapi.JobEvent
+	stdout    string
+	stdoutErr error
+}
+
+// Stdout returns the logs of the Job's pod once the Job finished.
+func (r *jobRunResult) Stdout() (string, error) {
+	return r.stdout, r.stdoutErr
+}
+
+func (r *jobRunResult) Events() <-chan eventapi.JobEvent {
+	return r.events
+}
@@ -0,0 +1,273 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+
+	"github.com/ghodss/yaml"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestJobExecutor(t *testing.T) {
+	playbook, err := ioutil.TempFile("", "playbook")
+	if err != nil {
+		t.Fatalf("Failed to create playbook: %v", err)
+	}
+	defer os.Remove(playbook.Name())
+	if _, err := playbook.WriteString("- hosts: localhost\n"); err != nil {
+		t.Fatalf("Failed to write playbook: %v", err)
+	}
+	playbook.Close()
+
+	errChan := make(chan error, 1)
+	events, err := eventapi.NewServer("127.0.0.1:0", errChan)
+	if err != nil {
+		t.Fatalf("Failed to start event server: %v", err)
+	}
+	defer events.Close()
+
+	client := fake.NewSimpleClientset()
+	j := &JobExecutor{
+		Client:    client,
+		Namespace: "operator",
+		Template: &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "ignored", Labels: map[string]string{"app": "operator"}},
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{Name: "sidecar", Image: "sidecar"},
+							{Name: JobContainerName, Image: "operator"},
+						},
+					},
+				},
+			},
+		},
+		ProxyURL:     "http://10.0.0.1:8888",
+		Credentials:  kubeconfig.NewCredentials(),
+		Events:       events,
+		EventsURL:    fmt.Sprintf("http://%s", events.Addr()),
+		PollInterval: 10 * time.Millisecond,
+	}
+
+	u := &unstructured.Unstructured{}
+	gvk := schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	u.SetGroupVersionKind(gvk)
+	u.SetName("example")
+	u.SetNamespace("default")
+	result, err := j.Execute(Execution{
+		Ident:        "1234",
+		GVK:          gvk,
+		Resource:     u,
+		Parameters:   map[string]interface{}{"size": 3},
+		PlaybookPath: playbook.Name(),
+		cmdFunc:      playbookCmdFunc(playbook.Name()),
+		maxArtifacts: 20,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	job, err := client.BatchV1().Jobs("operator").Get("ansible-runner-1234", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if job.Labels["app"] != "operator" || job.Labels[JobIdentLabel] != "1234" {
+		t.Fatalf("Unexpected job labels: %v", job.Labels)
+	}
+	if *job.Spec.BackoffLimit != 0 || job.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever ||
+		*job.Spec.ActiveDeadlineSeconds != int64(DefaultJobTimeout/time.Second) {
+		t.Fatalf("Unexpected job spec: %#v", job.Spec)
+	}
+	c := job.Spec.Template.Spec.Containers[1]
+	command := strings.Join(c.Command, " ")
+	if !strings.Contains(command, "ansible-runner") || !strings.Contains(command, "run /tmp/ansible-runner/input") {
+		t.Fatalf("Unexpected command: %q", command)
+	}
+	if len(c.VolumeMounts) != 2 || len(job.Spec.Template.Spec.Containers[0].VolumeMounts) != 0 {
+		t.Fatalf("Unexpected volume mounts: %v", c.VolumeMounts)
+	}
+
+	secret, err := client.CoreV1().Secrets("operator").Get("ansible-runner-1234", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Name != job.GetName() {
+		t.Fatalf("Unexpected secret owner references: %v", secret.OwnerReferences)
+	}
+	files := map[string][]byte{}
+	for _, item := range job.Spec.Template.Spec.Volumes[0].Secret.Items {
+		files[item.Path] = secret.Data[item.Key]
+	}
+	for _, path := range []string{"kubeconfig", "input/env/extravars", "input/env/envvars", "input/project/playbook.yaml", "input/inventory/hosts"} {
+		if _, ok := files[path]; !ok {
+			t.Fatalf("Expected %s in the input secret; got %v", path, files)
+		}
+	}
+	kc := struct {
+		Users []struct {
+			User struct {
+				Password string `json:"password"`
+			} `json:"user"`
+		} `json:"users"`
+	}{}
+	if err := yaml.Unmarshal(files["kubeconfig"], &kc); err != nil || len(kc.Users) != 1 {
+		t.Fatalf("Unexpected kubeconfig:\n%s", files["kubeconfig"])
+	}
+	token := kc.Users[0].User.Password
+	if owner, ok := j.Credentials.Owner(token); !ok || owner.Name != "example" || owner.Namespace != "default" {
+		t.Fatalf("Unexpected owner %v of the kubeconfig token, issued: %t", owner, ok)
+	}
+	settings := map[string]string{}
+	if err := json.Unmarshal(files["input/env/settings"], &settings); err != nil {
+		t.Fatalf("Failed to parse settings: %v", err)
+	}
+	if settings["runner_http_url"] != j.EventsURL {
+		t.Fatalf("Unexpected settings: %v", settings)
+	}
+
+	// send an event the way ansible-runner does, then complete the job
+	resp, err := http.Post(settings["runner_http_url"]+settings["runner_http_path"], "application/json",
+		strings.NewReader(`{"uuid": "abc", "event": "playbook_on_start"}`))
+	if err != nil {
+		t.Fatalf("Failed to post event: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if _, err := client.BatchV1().Jobs("operator").UpdateStatus(job); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+
+	received := []string{}
+	for event := range result.Events() {
+		received = append(received, event.Event)
+	}
+	if len(received) != 1 || received[0] != "playbook_on_start" {
+		t.Fatalf("Unexpected events: %v", received)
+	}
+	if _, err := result.Stdout(); err == nil {
+		t.Fatalf("Expected an error reading stdout of a job without pods")
+	}
+	if _, err := client.BatchV1().Jobs("operator").Get(job.GetName(), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Expected the job to be deleted; got %v", err)
+	}
+	if _, err := client.CoreV1().Secrets("operator").Get(secret.GetName(), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("Expected the secret to be deleted; got %v", err)
+	}
+	if _, ok := j.Credentials.Owner(token); ok {
+		t.Fatalf("Expected the token of the job to be revoked")
+	}
+
+	// the receiver of the run is gone once the run finished
+	err = wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		resp, err := http.Post(settings["runner_http_url"]+settings["runner_http_path"], "application/json",
+			strings.NewReader(`{"uuid": "def", "event": "playbook_on_stats"}`))
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusNotFound, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected events of a finished run to be rejected: %v", err)
+	}
+}
+
+func TestJobExecutorDeadline(t *testing.T) {
+	templateDeadline := int64(60)
+	testCases := []struct {
+		name             string
+		timeout          time.Duration
+		templateDeadline *int64
+		condition        batchv1.JobCondition
+		expectedDeadline int64
+		expectedError    string
+	}{
+		{
+			name:             "completed",
+			timeout:          10 * time.Minute,
+			condition:        batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			expectedDeadline: 600,
+		},
+		{
+			name:             "failed",
+			condition:        batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+			expectedDeadline: 3600,
+		},
+		{
+			name:             "deadline exceeded",
+			timeout:          10 * time.Minute,
+			templateDeadline: &templateDeadline,
+			condition:        batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded"},
+			expectedDeadline: 60,
+			expectedError:    "runner job exceeded its deadline of 60s",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			j := &JobExecutor{
+				Client:    client,
+				Namespace: "operator",
+				Template: &batchv1.Job{
+					Spec: batchv1.JobSpec{
+						ActiveDeadlineSeconds: tc.templateDeadline,
+						Template: corev1.PodTemplateSpec{
+							Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: JobContainerName}}},
+						},
+					},
+				},
+				PollInterval: 10 * time.Millisecond,
+				Timeout:      tc.timeout,
+			}
+			job := j.newJob(Execution{Ident: "1234", cmdFunc: playbookCmdFunc("playbook.yaml")}, &corev1.Secret{})
+			if *job.Spec.ActiveDeadlineSeconds != tc.expectedDeadline {
+				t.Fatalf("Unexpected deadline %d, expected %d", *job.Spec.ActiveDeadlineSeconds, tc.expectedDeadline)
+			}
+			job.Status.Conditions = []batchv1.JobCondition{tc.condition}
+			if _, err := client.BatchV1().Jobs("operator").Create(job); err != nil {
+				t.Fatalf("Failed to create job: %v", err)
+			}
+			err := j.wait(job)
+			if tc.expectedError == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.expectedError != "" && (err == nil || err.Error() != tc.expectedError) {
+				t.Fatalf("Unexpected error %v, expected %q", err, tc.expectedError)
+			}
+		})
+	}
+}
@@ -15,9 +15,11 @@
 package runner
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -32,6 +34,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -48,6 +51,17 @@ const (
 	// to the ansible-runner command. This will override the value for a particular CR.
 	// Example usage "ansible.operator-sdk/verbosity: 5"
 	AnsibleVerbosityAnnotation = "ansible.operator-sdk/verbosity"
+
+	// CheckModeAnnotation - annotation used by a user to run the playbook or role of a CR in
+	// check mode, which reports what a run would change without changing anything.
+	// Example usage "ansible.operator-sdk/check-mode: true"
+	CheckModeAnnotation = "ansible.operator-sdk/check-mode"
+
+	// Dir - directory that contains the ansible-runner input directory of
+	// each CR at <group>/<version>/<kind>/<namespace>/<name>. The artifacts of
+	// the CR's runs are kept in the "artifacts" directory of its input
+	// directory.
+	Dir = "/tmp/ansible-operator/runner"
 )
 
 // Runner - a runnable that should take the parameters and name and namespace
@@ -57,6 +71,12 @@ type Runner interface {
 	GetFinalizer() (string, bool)
 }
 
+// InputHasher - implemented by Runners that can hash the inputs of a run for
+// a CR, so that runs whose inputs did not change can be skipped.
+type InputHasher interface {
+	InputHash(*unstructured.Unstructured) (string, error)
+}
+
 func ansibleVerbosityString(verbosity int) string {
 	if verbosity > 0 {
 		return fmt.Sprintf("-%v", strings.Repeat("v", verbosity))
@@ -79,8 +99,25 @@ func roleCmdFunc(path string) cmdFuncType {
 	}
 }
 
+// Option - configures a Runner.
+type Option func(*runner)
+
+// SecretReader - sets the client that the Secrets of the sensitive vars of a
+// watch are read with.
+func SecretReader(reader client.Reader) Option {
+	return func(r *runner) {
+		r.secretReader = reader
+	}
+}
+
 // New - creates a Runner from a Watch struct
-func New(watch watches.Watch) (Runner, error) {
+func New(watch watches.Watch, opts ...Option) (Runner, error) {
+	return NewWithExecutor(watch, localExecutor{}, opts...)
+}
+
+// NewWithExecutor - creates a Runner from a Watch struct, which runs
+// ansible-runner with the given Executor.
+func NewWithExecutor(watch watches.Watch, executor Executor, opts ...Option) (Runner, error) {
 	var path string
 	var cmdFunc, finalizerCmdFunc cmdFuncType
 
@@ -111,16 +148,23 @@ func New(watch watches.Watch) (Runner, error) {
 		finalizerCmdFunc = cmdFunc
 	}
 
-	return &runner{
+	r := &runner{
 		Path:               path,
 		cmdFunc:            cmdFunc,
 		Vars:               watch.Vars,
 		Finalizer:          watch.Finalizer,
+		Sensitive:          watch.Sensitive,
+		VaultPasswordFile:  watch.VaultPasswordFile,
 		finalizerCmdFunc:   finalizerCmdFunc,
 		GVK:                watch.GroupVersionKind,
 		maxRunnerArtifacts: watch.MaxRunnerArtifacts,
 		ansibleVerbosity:   watch.AnsibleVerbosity,
-	}, nil
+		executor:           executor,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
 }
 
 // runner - implements the Runner interface for a GVK that's being watched.
@@ -129,10 +173,14 @@ type runner struct {
 	GVK                schema.GroupVersionKind // GVK being watched that corresponds to the Path
 	Finalizer          *watches.Finalizer
 	Vars               map[string]interface{}
+	Sensitive          watches.Sensitive
+	VaultPasswordFile  string
 	cmdFunc            cmdFuncType // returns a Cmd that runs ansible-runner
 	finalizerCmdFunc   cmdFuncType
 	maxRunnerArtifacts int
 	ansibleVerbosity   int
+	executor           Executor
+	secretReader       client.Reader
 }
 
 func (r *runner) Run(ident string, u *unstructured.Unstructured, kubeconfig string) (RunResult, error) {
@@ -142,44 +190,6 @@ func (r *runner) Run(ident string, u *unstructured.Unstructured, kubeconfig stri
 	if u.GetDeletionTimestamp() != nil && !r.isFinalizerRun(u) {
 		return nil, errors.New("resource has been deleted, but no finalizer was matched, skipping reconciliation")
 	}
-	logger := log.WithValues(
-		"job", ident,
-		"name", u.GetName(),
-		"namespace", u.GetNamespace(),
-	)
-
-	// start the event receiver. We'll check errChan for an error after
-	// ansible-runner exits.
-	errChan := make(chan error, 1)
-	receiver, err := eventapi.New(ident, errChan)
-	if err != nil {
-		return nil, err
-	}
-	inputDir := inputdir.InputDir{
-		Path:       filepath.Join("/tmp/ansible-operator/runner/", r.GVK.Group, r.GVK.Version, r.GVK.Kind, u.GetNamespace(), u.GetName()),
-		Parameters: r.makeParameters(u),
-		EnvVars: map[string]string{
-			"K8S_AUTH_KUBECONFIG": kubeconfig,
-			"KUBECONFIG":          kubeconfig,
-		},
-		Settings: map[string]string{
-			"runner_http_url":  receiver.SocketPath,
-			"runner_http_path": receiver.URLPath,
-		},
-	}
-	// If Path is a dir, assume it is a role path. Otherwise assume it's a
-	// playbook path
-	fi, err := os.Lstat(r.Path)
-	if err != nil {
-		return nil, err
-	}
-	if !fi.IsDir() {
-		inputDir.PlaybookPath = r.Path
-	}
-	err = inputDir.Write()
-	if err != nil {
-		return nil, err
-	}
 	maxArtifacts := r.maxRunnerArtifacts
 	if ma, ok := u.GetAnnotations()[MaxRunnerArtifactsAnnotation]; ok {
 		i, err := strconv.Atoi(ma)
@@ -200,51 +210,119 @@ func (r *runner) Run(ident string, u *unstructured.Unstructured, kubeconfig stri
 		}
 	}
 
-	go func() {
-		var dc *exec.Cmd
-		if r.isFinalizerRun(u) {
-			logger.V(1).Info("Resource is marked for deletion, running finalizer", "Finalizer", r.Finalizer.Name)
-			dc = r.finalizerCmdFunc(ident, inputDir.Path, maxArtifacts, verbosity)
-		} else {
-			dc = r.cmdFunc(ident, inputDir.Path, maxArtifacts, verbosity)
-		}
-		// Append current environment since setting dc.Env to anything other than nil overwrites current env
-		dc.Env = append(dc.Env, os.Environ()...)
-		dc.Env = append(dc.Env, fmt.Sprintf("K8S_AUTH_KUBECONFIG=%s", kubeconfig), fmt.Sprintf("KUBECONFIG=%s", kubeconfig))
-
-		output, err := dc.CombinedOutput()
+	parameters := r.makeParameters(u)
+	vaultParameters := r.vaultVars(u)
+	for k := range vaultParameters {
+		delete(parameters, k)
+	}
+	sensitiveParameters, sensitiveValues, err := r.sensitiveVars(u)
+	if err != nil {
+		return nil, err
+	}
+	splitParameters(parameters, sensitiveParameters, sensitiveValues)
+	sensitivePaths := r.sensitivePaths()
+	for k := range vaultParameters {
+		sensitivePaths = append(sensitivePaths, []string{k})
+	}
+	var vaultPassword []byte
+	if r.VaultPasswordFile != "" {
+		// The file is read for every run, so that a changed password is
+		// used once the Secret it is mounted from was updated.
+		vaultPassword, err = ioutil.ReadFile(r.VaultPasswordFile)
 		if err != nil {
-			logger.Error(err, string(output))
-		} else {
-			logger.Info("Ansible-runner exited successfully")
+			return nil, fmt.Errorf("failed to read vault password file: %w", err)
 		}
-
-		receiver.Close()
-		err = <-errChan
-		// http.Server returns this in the case of being closed cleanly
-		if err != nil && err != http.ErrServerClosed {
-			logger.Error(err, "Error from event API")
+		sensitiveValues = append(sensitiveValues, strings.TrimSpace(string(vaultPassword)))
+		// The decrypted values are scrubbed from the output of the run like
+		// the password.
+		for k, v := range vaultParameters {
+			if sensitiveValues, err = vaultValues(sensitiveValues, v, vaultPassword); err != nil {
+				return nil, fmt.Errorf("failed to decrypt vault encrypted var %s: %w", k, err)
+			}
 		}
+	}
+	s := newScrubber(sensitiveValues, sensitivePaths)
+
+	execution := Execution{
+		Ident:               ident,
+		GVK:                 r.GVK,
+		Resource:            u,
+		Kubeconfig:          kubeconfig,
+		Parameters:          parameters,
+		SensitiveParameters: sensitiveParameters,
+		VaultParameters:     vaultParameters,
+		VaultPassword:       vaultPassword,
+		cmdFunc:             r.cmdFunc,
+		maxArtifacts:        maxArtifacts,
+		verbosity:           verbosity,
+		CheckMode:           CheckMode(u),
+		scrubber:            s,
+	}
+	if r.isFinalizerRun(u) {
+		log.V(1).Info("Resource is marked for deletion, running finalizer", "job", ident, "name", u.GetName(),
+			"namespace", u.GetNamespace(), "Finalizer", r.Finalizer.Name)
+		execution.cmdFunc = r.finalizerCmdFunc
+	}
+	// If Path is a dir, assume it is a role path. Otherwise assume it's a
+	// playbook path
+	fi, err := os.Lstat(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		execution.PlaybookPath = r.Path
+	}
+	result, err := r.executor.Execute(execution)
+	if err != nil || s == nil {
+		return result, err
+	}
+	return newScrubbedRunResult(result, s), nil
+}
 
-		// link the current run to the `latest` directory under artifacts
-		currentRun := filepath.Join(inputDir.Path, "artifacts", ident)
-		latestArtifacts := filepath.Join(inputDir.Path, "artifacts", "latest")
-		if _, err = os.Lstat(latestArtifacts); err == nil {
-			if err = os.Remove(latestArtifacts); err != nil {
-				logger.Error(err, "Error removing the latest artifacts symlink")
-			}
+// InputHash - returns a hash of the extra vars of a run for the CR and of its
+// annotations, and of the resource versions of the Secrets of its sensitive
+// vars. The status of the CR and the metadata that changes with every write,
+// like the resourceVersion, are left out, since they change without the CR's
+// desired state changing.
+func (r *runner) InputHash(u *unstructured.Unstructured) (string, error) {
+	obj := u.DeepCopy()
+	delete(obj.Object, "status")
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	obj.SetManagedFields(nil)
+	secretVersions := map[string]string{}
+	for _, s := range r.Sensitive.Secrets {
+		secret, err := r.sensitiveSecret(u, s)
+		if err != nil {
+			return "", err
 		}
-		if err = os.Symlink(currentRun, latestArtifacts); err != nil {
-			logger.Error(err, "Error symlinking latest artifacts")
+		if secret != nil {
+			secretVersions[s.Var] = secret.GetResourceVersion()
 		}
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"parameters":     r.makeParameters(obj),
+		"secretVersions": secretVersions,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal run inputs: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
 
-	}()
-
-	return &runResult{
-		events:   receiver.Events,
-		inputDir: &inputDir,
-		ident:    ident,
-	}, nil
+// CheckMode - returns true if the CR is to be reconciled in check mode.
+// Finalizers of deleted CRs are never run in check mode.
+func CheckMode(u *unstructured.Unstructured) bool {
+	v, ok := u.GetAnnotations()[CheckModeAnnotation]
+	if !ok || u.GetDeletionTimestamp() != nil {
+		return false
+	}
+	checkMode, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Info("Invalid check mode annotation", "err", err, "value", v)
+		return false
+	}
+	return checkMode
 }
 
 func (r *runner) isFinalizerRun(u *unstructured.Unstructured) bool {
@@ -277,7 +355,13 @@ func (r *runner) isFinalizerRun(u *unstructured.Unstructured) bool {
 //   }
 // }
 func (r *runner) makeParameters(u *unstructured.Unstructured) map[string]interface{} {
-	s := u.Object["spec"]
+	obj := u.Object
+	if r.VaultPasswordFile != "" {
+		// Ansible would decrypt the vault objects of the CR with the
+		// operator's password.
+		obj = withoutVaultObjects(obj).(map[string]interface{})
+	}
+	s := obj["spec"]
 	spec, ok := s.(map[string]interface{})
 	if !ok {
 		log.Info("Spec was not found for CR", "GroupVersionKind", u.GroupVersionKind(), "Namespace", u.GetNamespace(), "Name", u.GetName())
@@ -285,10 +369,14 @@ func (r *runner) makeParameters(u *unstructured.Unstructured) map[string]interfa
 	}
 
 	parameters := paramconv.MapToSnake(spec)
+	if r.VaultPasswordFile != "" {
+		// Keys of the spec like "__ansible_Vault" become vault objects.
+		parameters = withoutVaultObjects(parameters).(map[string]interface{})
+	}
 	parameters["meta"] = map[string]string{"namespace": u.GetNamespace(), "name": u.GetName()}
 
 	objKey := fmt.Sprintf("_%v_%v", strings.Replace(r.GVK.Group, ".", "_", -1), strings.ToLower(r.GVK.Kind))
-	parameters[objKey] = u.Object
+	parameters[objKey] = obj
 
 	specKey := fmt.Sprintf("%s_spec", objKey)
 	parameters[specKey] = spec
@@ -21,7 +21,12 @@ import (
 	"reflect"
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
 )
@@ -218,3 +223,136 @@ func TestAnsibleVerbosityString(t *testing.T) {
 		}
 	}
 }
+
+func TestInputHash(t *testing.T) {
+	newSecretReader := func(resourceVersion string) client.Reader {
+		return fakeclient.NewFakeClient(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "credentials", ResourceVersion: resourceVersion},
+			Data:       map[string][]byte{"token": []byte("s3cr3t-token")},
+		})
+	}
+	r := &runner{
+		GVK:          schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Memcached"},
+		Vars:         map[string]interface{}{"sentinel": "reconciling"},
+		Sensitive:    watches.Sensitive{Secrets: []watches.SensitiveSecret{{Name: "credentials", Var: "credentials"}}},
+		secretReader: newSecretReader("1"),
+	}
+	newCR := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "app.example.com/v1alpha1",
+			"kind":       "Memcached",
+			"metadata": map[string]interface{}{
+				"name":            "example",
+				"namespace":       "default",
+				"resourceVersion": "1",
+				"generation":      int64(1),
+			},
+			"spec":   map[string]interface{}{"size": int64(3)},
+			"status": map[string]interface{}{"conditions": []interface{}{}},
+		}}
+	}
+	base, err := r.InputHash(newCR())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		mutate        func(u *unstructured.Unstructured)
+		secretVersion string
+		expectChanged bool
+	}{
+		{
+			name: "status and resource version",
+			mutate: func(u *unstructured.Unstructured) {
+				u.Object["status"] = map[string]interface{}{"ready": true}
+				u.SetResourceVersion("2")
+			},
+		},
+		{
+			name: "spec",
+			mutate: func(u *unstructured.Unstructured) {
+				u.Object["spec"] = map[string]interface{}{"size": int64(4)}
+			},
+			expectChanged: true,
+		},
+		{
+			name: "annotations",
+			mutate: func(u *unstructured.Unstructured) {
+				u.SetAnnotations(map[string]string{AnsibleVerbosityAnnotation: "4"})
+			},
+			expectChanged: true,
+		},
+		{
+			name:          "secret of a sensitive var",
+			mutate:        func(u *unstructured.Unstructured) {},
+			secretVersion: "2",
+			expectChanged: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := newCR()
+			tc.mutate(u)
+			r.secretReader = newSecretReader("1")
+			if tc.secretVersion != "" {
+				r.secretReader = newSecretReader(tc.secretVersion)
+			}
+			hash, err := r.InputHash(u)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if changed := hash != base; changed != tc.expectChanged {
+				t.Fatalf("Unexpected hash change %v, expected %v", changed, tc.expectChanged)
+			}
+		})
+	}
+}
+
+func TestExecutionArgs(t *testing.T) {
+	e := Execution{
+		Ident:        "1234",
+		cmdFunc:      playbookCmdFunc("/opt/ansible/playbook.yml"),
+		maxArtifacts: 20,
+	}
+	args := e.Args("/tmp/input")
+	if args[0] != "ansible-runner" || args[1] == "--cmdline" {
+		t.Fatalf("Unexpected args: %v", args)
+	}
+
+	e.CheckMode = true
+	checkArgs := e.Args("/tmp/input")
+	expected := append([]string{"ansible-runner", "--cmdline", "--check --diff"}, args[1:]...)
+	if !reflect.DeepEqual(checkArgs, expected) {
+		t.Fatalf("Unexpected check mode args:\nActual: %v\nExpected: %v", checkArgs, expected)
+	}
+}
+
+func TestExecutionArgsSensitive(t *testing.T) {
+	e := Execution{
+		Ident:               "1234",
+		cmdFunc:             playbookCmdFunc("/opt/ansible/playbook.yml"),
+		maxArtifacts:        20,
+		SensitiveParameters: map[string]interface{}{"password": "hunter2"},
+		CheckMode:           true,
+	}
+	args := e.Args("/tmp/input")
+	if len(args) < 3 || args[1] != "--cmdline" || args[2] != "--check --diff -e @/tmp/input/env/sensitive_vars" {
+		t.Fatalf("Unexpected args: %v", args)
+	}
+}
+
+func TestExecutionArgsVault(t *testing.T) {
+	e := Execution{
+		Ident:           "1234",
+		cmdFunc:         playbookCmdFunc("/opt/ansible/playbook.yml"),
+		maxArtifacts:    20,
+		VaultParameters: map[string]interface{}{"password": "$ANSIBLE_VAULT;1.1;AES256\n6231\n"},
+		VaultPassword:   []byte("secret"),
+	}
+	args := e.Args("/tmp/input")
+	expected := "--vault-password-file /tmp/input/env/vault_password -e @/tmp/input/env/vault_vars"
+	if len(args) < 3 || args[1] != "--cmdline" || args[2] != expected {
+		t.Fatalf("Unexpected args: %v", args)
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/paramconv"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Scrubbed - replaces the sensitive values of a run in its artifacts, events
+// and logs.
+const Scrubbed = "********"
+
+// minScrubbedLength - the minimum length of the sensitive values that are
+// replaced in the text of the output of runs. Shorter values would replace
+// common strings, e.g. "1" or "yes", and are only scrubbed from the fields of
+// their vars.
+const minScrubbedLength = 4
+
+// sensitiveVars - returns the vars with the data of the sensitive Secrets of
+// a CR, and the sensitive values of the CR and of the Secrets.
+func (r *runner) sensitiveVars(u *unstructured.Unstructured) (map[string]interface{}, []string, error) {
+	values := []string{}
+	for _, path := range r.Sensitive.Fields {
+		v, found, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(path, ".")...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get sensitive field %s: %w", path, err)
+		}
+		if found {
+			values = appendStrings(values, v)
+		}
+	}
+
+	vars := map[string]interface{}{}
+	for _, s := range r.Sensitive.Secrets {
+		secret, err := r.sensitiveSecret(u, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret == nil {
+			continue
+		}
+		data := map[string]interface{}{}
+		for k, v := range secret.Data {
+			data[k] = string(v)
+			values = append(values, string(v))
+		}
+		vars[s.Var] = data
+	}
+	return vars, values, nil
+}
+
+// sensitiveSecret - returns the Secret of a sensitive var of a CR, or nil if
+// the CR does not reference one.
+func (r *runner) sensitiveSecret(u *unstructured.Unstructured, s watches.SensitiveSecret) (*corev1.Secret, error) {
+	name := s.Name
+	if s.NameField != "" {
+		var err error
+		name, _, err = unstructured.NestedString(u.Object, strings.Split(s.NameField, ".")...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the secret name field %s: %w", s.NameField, err)
+		}
+		if name == "" {
+			// the CR does not reference a Secret
+			return nil, nil
+		}
+	}
+	if r.secretReader == nil {
+		return nil, fmt.Errorf("no client to read secret %s for var %s", name, s.Var)
+	}
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: u.GetNamespace(), Name: name}
+	if err := r.secretReader.Get(context.TODO(), key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %s for var %s: %w", key, s.Var, err)
+	}
+	return secret, nil
+}
+
+// sensitivePaths - returns the key paths of the sensitive vars. The fields
+// of the CR are passed to the runs both under their own and their snake case
+// names, below the var of the spec or the CR, so their paths are relative to
+// the first field of the path, e.g. ["password"] for "spec.password".
+func (r *runner) sensitivePaths() [][]string {
+	paths := [][]string{}
+	for _, path := range r.Sensitive.Fields {
+		keys := strings.Split(path, ".")
+		if len(keys) > 1 {
+			keys = keys[1:]
+		}
+		snake := make([]string, len(keys))
+		for i, k := range keys {
+			snake[i] = paramconv.ToSnake(k)
+		}
+		paths = append(paths, keys)
+		if !reflect.DeepEqual(snake, keys) {
+			paths = append(paths, snake)
+		}
+	}
+	for _, s := range r.Sensitive.Secrets {
+		paths = append(paths, []string{s.Var})
+	}
+	return paths
+}
+
+// appendStrings - appends the string values of v, including those nested in
+// maps and slices, to values.
+func appendStrings(values []string, v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			values = append(values, v)
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			values = appendStrings(values, e)
+		}
+	case []interface{}:
+		for _, e := range v {
+			values = appendStrings(values, e)
+		}
+	}
+	return values
+}
+
+// containsValue - returns true if a string value of v, including those nested
+// in maps and slices, contains one of values.
+func containsValue(v interface{}, values []string) bool {
+	switch v := v.(type) {
+	case string:
+		for _, value := range values {
+			if strings.Contains(v, value) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			if containsValue(e, values) {
+				return true
+			}
+		}
+	case map[string]string:
+		for _, e := range v {
+			if containsValue(e, values) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if containsValue(e, values) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitParameters - moves the top level extra vars that contain a sensitive
+// value from parameters into sensitive.
+func splitParameters(parameters, sensitive map[string]interface{}, values []string) {
+	for k, v := range parameters {
+		if _, ok := sensitive[k]; ok {
+			delete(parameters, k)
+			continue
+		}
+		if containsValue(v, values) {
+			sensitive[k] = v
+			delete(parameters, k)
+		}
+	}
+}
+
+// scrubber - replaces sensitive values, and their forms encoded in JSON and
+// base64, with Scrubbed. In structured data, e.g. events, the fields at the
+// key paths of sensitive vars are replaced whatever their values are.
+type scrubber struct {
+	replacer *strings.Replacer
+	paths    [][]string
+}
+
+// newScrubber - returns a scrubber for values and the key paths of sensitive
+// vars, or nil if there are none.
+func newScrubber(values []string, paths [][]string) *scrubber {
+	forms := map[string]bool{}
+	short := 0
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if len(v) < minScrubbedLength {
+			short++
+			continue
+		}
+		forms[v] = true
+		if b, err := json.Marshal(v); err == nil {
+			forms[string(b[1:len(b)-1])] = true
+		}
+		forms[base64.StdEncoding.EncodeToString([]byte(v))] = true
+	}
+	if short > 0 {
+		log.Info("Sensitive values that are too short are not scrubbed from the text of the output of runs",
+			"count", short, "minLength", minScrubbedLength)
+	}
+	if len(forms) == 0 && len(paths) == 0 {
+		return nil
+	}
+	s := &scrubber{paths: paths}
+	if len(forms) == 0 {
+		return s
+	}
+	// Longer values are replaced first, so that a value containing another
+	// one is replaced as a whole.
+	sorted := make([]string, 0, len(forms))
+	for f := range forms {
+		sorted = append(sorted, f)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, f := range sorted {
+		oldnew = append(oldnew, f, Scrubbed)
+	}
+	s.replacer = strings.NewReplacer(oldnew...)
+	return s
+}
+
+// String - scrubs a string. A nil scrubber returns it unchanged.
+func (s *scrubber) String(str string) string {
+	if s == nil || s.replacer == nil {
+		return str
+	}
+	return s.replacer.Replace(str)
+}
+
+// Event - returns a copy of a job event with its stdout and its event data
+// scrubbed.
+func (s *scrubber) Event(e eventapi.JobEvent) eventapi.JobEvent {
+	if s == nil {
+		return e
+	}
+	e.StdOut = s.String(e.StdOut)
+	if e.EventData != nil {
+		e.EventData = s.value(e.EventData).(map[string]interface{})
+	}
+	return e
+}
+
+// value - returns a scrubbed copy of v. The key paths of sensitive vars are
+// matched at any depth, since ansible nests vars in the results, arguments
+// and facts of tasks.
+func (s *scrubber) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return s.String(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = s.value(e)
+		}
+		for _, path := range s.paths {
+			scrubPath(m, path)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = s.value(e)
+		}
+		return l
+	}
+	return v
+}
+
+func scrubPath(m map[string]interface{}, path []string) {
+	v, ok := m[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		m[path[0]] = Scrubbed
+		return
+	}
+	if sub, ok := v.(map[string]interface{}); ok {
+		scrubPath(sub, path[1:])
+	}
+}
+
+// Dir - scrubs the files in a directory and its subdirectories. Files with
+// JSON objects, like job events and facts, are scrubbed as structured data.
+func (s *scrubber) Dir(dir string) error {
+	if s == nil {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		scrubbed, err := s.file(content)
+		if err != nil || bytes.Equal(scrubbed, content) {
+			return err
+		}
+		return ioutil.WriteFile(path, scrubbed, info.Mode())
+	})
+}
+
+func (s *scrubber) file(content []byte) ([]byte, error) {
+	obj := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil || decoder.More() {
+		return []byte(s.String(string(content))), nil
+	}
+	scrubbed := s.value(obj)
+	if reflect.DeepEqual(scrubbed, obj) {
+		return content, nil
+	}
+	return json.Marshal(scrubbed)
+}
+
+// scrubbedRunResult - scrubs the events and stdout of a RunResult.
+type scrubbedRunResult struct {
+	result   RunResult
+	scrubber *scrubber
+	events   chan eventapi.JobEvent
+}
+
+func newScrubbedRunResult(result RunResult, s *scrubber) *scrubbedRunResult {
+	r := &scrubbedRunResult{result: result, scrubber: s, events: make(chan eventapi.JobEvent)}
+	go func() {
+		for e := range result.Events() {
+			r.events <- s.Event(e)
+		}
+		close(r.events)
+	}()
+	return r
+}
+
+func (r *scrubbedRunResult) Stdout() (string, error) {
+	stdout, err := r.result.Stdout()
+	return r.scrubber.String(stdout), err
+}
+
+func (r *scrubbedRunResult) Events() <-chan eventapi.JobEvent {
+	return r.events
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSensitiveVars(t *testing.T) {
+	r := &runner{
+		GVK: schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"},
+		Sensitive: watches.Sensitive{
+			Fields: []string{"spec.password", "spec.missing"},
+			Secrets: []watches.SensitiveSecret{
+				{NameField: "spec.credentialsSecret", Var: "credentials"},
+				{NameField: "spec.unsetSecret", Var: "unset"},
+			},
+		},
+		secretReader: fakeclient.NewFakeClient(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-credentials"},
+			Data:       map[string][]byte{"token": []byte("s3cr3t-token")},
+		}),
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "app.example.com/v1alpha1",
+		"kind":       "Database",
+		"metadata":   map[string]interface{}{"name": "example", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"size":              int64(3),
+			"password":          "hunter2",
+			"credentialsSecret": "db-credentials",
+		},
+	}}
+
+	vars, values, err := r.sensitiveVars(u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedVars := map[string]interface{}{"credentials": map[string]interface{}{"token": "s3cr3t-token"}}
+	if !reflect.DeepEqual(vars, expectedVars) {
+		t.Fatalf("Unexpected vars %#v, expected %#v", vars, expectedVars)
+	}
+	sort.Strings(values)
+	if expected := []string{"hunter2", "s3cr3t-token"}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Unexpected values %v, expected %v", values, expected)
+	}
+
+	parameters := r.makeParameters(u)
+	splitParameters(parameters, vars, values)
+	keys := []string{}
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if expected := []string{"credentials_secret", "meta", "size"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Unexpected parameters %v, expected %v", keys, expected)
+	}
+	keys = []string{}
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	expected := []string{"_app_example_com_database", "_app_example_com_database_spec", "credentials", "password"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Unexpected sensitive parameters %v, expected %v", keys, expected)
+	}
+
+	r.Sensitive.Fields = append(r.Sensitive.Fields, "spec.database.adminPort")
+	expectedPaths := [][]string{{"password"}, {"missing"}, {"database", "adminPort"}, {"database", "admin_port"}, {"credentials"}, {"unset"}}
+	if paths := r.sensitivePaths(); !reflect.DeepEqual(paths, expectedPaths) {
+		t.Fatalf("Unexpected sensitive paths %v, expected %v", paths, expectedPaths)
+	}
+
+	r.secretReader = nil
+	if _, _, err := r.sensitiveVars(u); err == nil {
+		t.Fatalf("Expected an error without a secret reader")
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	if s := newScrubber(nil, nil); s != nil || s.String("hunter2") != "hunter2" {
+		t.Fatalf("Expected no scrubber without sensitive values")
+	}
+	s := newScrubber([]string{"hunter2", `pa"ss`, "yes"}, [][]string{{"password"}, {"database", "port"}})
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "raw value",
+			input:    "password: hunter2",
+			expected: "password: " + Scrubbed,
+		},
+		{
+			name:     "JSON encoded value",
+			input:    `{"password": "pa\"ss"}`,
+			expected: `{"password": "` + Scrubbed + `"}`,
+		},
+		{
+			name:     "base64 encoded value",
+			input:    "data: aHVudGVyMg==",
+			expected: "data: " + Scrubbed,
+		},
+		{
+			name:     "no sensitive value",
+			input:    "changed: true",
+			expected: "changed: true",
+		},
+		{
+			name:     "value that is too short",
+			input:    "enabled: yes",
+			expected: "enabled: yes",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := s.String(tc.input); actual != tc.expected {
+				t.Fatalf("Unexpected scrubbed string %q, expected %q", actual, tc.expected)
+			}
+		})
+	}
+
+	event := eventapi.JobEvent{
+		StdOut: "ok: [localhost] => hunter2",
+		EventData: map[string]interface{}{
+			"res": map[string]interface{}{"stdout_lines": []interface{}{"hunter2"}, "changed": true},
+			"task_args": map[string]interface{}{
+				"password": "yes",
+				"database": map[string]interface{}{"port": float64(5432), "host": "db"},
+			},
+		},
+	}
+	scrubbed := s.Event(event)
+	expected := eventapi.JobEvent{
+		StdOut: "ok: [localhost] => " + Scrubbed,
+		EventData: map[string]interface{}{
+			"res": map[string]interface{}{"stdout_lines": []interface{}{Scrubbed}, "changed": true},
+			"task_args": map[string]interface{}{
+				"password": Scrubbed,
+				"database": map[string]interface{}{"port": Scrubbed, "host": "db"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(scrubbed, expected) {
+		t.Fatalf("Unexpected scrubbed event %#v, expected %#v", scrubbed, expected)
+	}
+	if event.StdOut != "ok: [localhost] => hunter2" {
+		t.Fatalf("The event was changed by scrubbing it")
+	}
+
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "job_events", "1.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	files := map[string]string{
+		path:                            `{"stdout": "hunter2", "counter": 12345678901234567890, "database": {"port": 5432}}`,
+		filepath.Join(dir, "stdout"):    "password: hunter2\n",
+		filepath.Join(dir, "unchanged"): `{"stdout": "ok"}`,
+	}
+	expectedFiles := map[string]string{
+		path:                            `{"counter":12345678901234567890,"database":{"port":"` + Scrubbed + `"},"stdout":"` + Scrubbed + `"}`,
+		filepath.Join(dir, "stdout"):    "password: " + Scrubbed + "\n",
+		filepath.Join(dir, "unchanged"): `{"stdout": "ok"}`,
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := s.Dir(dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for path, expected := range expectedFiles {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Unexpected scrubbed file %s:\n%s\nexpected:\n%s", path, content, expected)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
+
+	"golang.org/x/crypto/pbkdf2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// vaultIterations - the PBKDF2 iterations of the keys of AES256 vaults.
+	vaultIterations = 10000
+	// vaultKeyLength - the length of the AES key, the HMAC key and the IV
+	// derived from the password.
+	vaultKeyLength = 2*32 + aes.BlockSize
+	// vaultObjectKey - the key of the JSON objects that ansible turns into
+	// vault encrypted values when they are passed in the extra vars.
+	vaultObjectKey = "__ansible_vault"
+)
+
+// vaultVars - returns the vars of the watch and of the finalizer of a run
+// that contain vault encrypted values. Only these are decrypted by the runs,
+// never values of the CR, since anyone who can write a CR could otherwise have
+// the operator decrypt any value encrypted with its password. Vault encrypted
+// strings of the CR are passed as plain strings, and its vault objects are
+// removed by withoutVaultObjects.
+func (r *runner) vaultVars(u *unstructured.Unstructured) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for k, v := range r.Vars {
+		if watches.ContainsVaultEncrypted(v) {
+			vars[k] = v
+		}
+	}
+	if r.isFinalizerRun(u) {
+		for k, v := range r.Finalizer.Vars {
+			if watches.ContainsVaultEncrypted(v) {
+				vars[k] = v
+			} else {
+				delete(vars, k)
+			}
+		}
+	}
+	return vars
+}
+
+// withoutVaultObjects - returns a copy of v whose maps, including those nested
+// in maps and slices, don't have the key of vault objects, so that ansible
+// doesn't decrypt them.
+func withoutVaultObjects(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if k != vaultObjectKey {
+				m[k] = withoutVaultObjects(e)
+			}
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = withoutVaultObjects(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// vaultValues - appends the decrypted values of the vault encrypted strings
+// of v, including those nested in maps and slices, to values.
+func vaultValues(values []string, v interface{}, password []byte) ([]string, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, e := range v {
+			var err error
+			if values, err = vaultValues(values, e, password); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for _, e := range v {
+			var err error
+			if values, err = vaultValues(values, e, password); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			var err error
+			if values, err = vaultValues(values, e, password); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		if !watches.IsVaultEncrypted(v) {
+			return values, nil
+		}
+		plaintext, err := decryptVault(v, password)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, plaintext)
+	}
+	return values, nil
+}
+
+// decryptVault - decrypts a string encrypted with the AES256 cipher of Ansible
+// Vault, in the format 1.1 or 1.2 with a vault ID.
+func decryptVault(vault string, password []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(vault), "\n")
+	header := strings.Split(strings.TrimSpace(lines[0]), ";")
+	if len(header) < 3 || strings.TrimSpace(header[2]) != "AES256" {
+		return "", fmt.Errorf("unsupported vault header %q", lines[0])
+	}
+	body := ""
+	for _, l := range lines[1:] {
+		body += strings.TrimSpace(l)
+	}
+	decoded, err := hex.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault: %w", err)
+	}
+	parts := strings.Split(string(decoded), "\n")
+	if len(parts) != 3 {
+		return "", errors.New("invalid vault: expected a salt, an HMAC and a ciphertext")
+	}
+	fields := make([][]byte, len(parts))
+	for i, p := range parts {
+		if fields[i], err = hex.DecodeString(p); err != nil {
+			return "", fmt.Errorf("invalid vault: %w", err)
+		}
+	}
+	salt, mac, ciphertext := fields[0], fields[1], fields[2]
+
+	keys := pbkdf2.Key(bytes.TrimSpace(password), salt, vaultIterations, vaultKeyLength, sha256.New)
+	h := hmac.New(sha256.New, keys[32:64])
+	_, _ = h.Write(ciphertext)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return "", errors.New("failed to decrypt vault: wrong password or corrupted vault")
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, keys[64:]).XORKeyStream(plaintext, ciphertext)
+	// The plaintext is padded to the AES block size like PKCS#7.
+	if n := len(plaintext); n > 0 {
+		padding := int(plaintext[n-1])
+		if padding > 0 && padding <= aes.BlockSize && padding <= n {
+			plaintext = plaintext[:n-padding]
+		}
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/watches"
+
+	"golang.org/x/crypto/pbkdf2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// encryptVault - encrypts plaintext like `ansible-vault encrypt_string`.
+func encryptVault(plaintext, password string) string {
+	salt := bytes.Repeat([]byte{7}, 32)
+	keys := pbkdf2.Key([]byte(password), salt, vaultIterations, vaultKeyLength, sha256.New)
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append([]byte(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, _ := aes.NewCipher(keys[:32])
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCTR(block, keys[64:]).XORKeyStream(ciphertext, padded)
+	h := hmac.New(sha256.New, keys[32:64])
+	_, _ = h.Write(ciphertext)
+	body := hex.EncodeToString([]byte(hex.EncodeToString(salt) + "\n" + hex.EncodeToString(h.Sum(nil)) + "\n" + hex.EncodeToString(ciphertext)))
+	lines := []string{"$ANSIBLE_VAULT;1.1;AES256"}
+	for len(body) > 80 {
+		lines = append(lines, body[:80])
+		body = body[80:]
+	}
+	return strings.Join(append(lines, body), "\n") + "\n"
+}
+
+func TestDecryptVault(t *testing.T) {
+	testCases := []struct {
+		name          string
+		vault         string
+		password      string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "short value",
+			vault:    encryptVault("hunter2", "secret"),
+			password: "secret\n",
+			expected: "hunter2",
+		},
+		{
+			name:     "value of whole blocks",
+			vault:    encryptVault("0123456789abcdef", "secret"),
+			password: "secret",
+			expected: "0123456789abcdef",
+		},
+		{
+			name:     "vault ID",
+			vault:    strings.Replace(encryptVault("hunter2", "secret"), "1.1;AES256", "1.2;AES256;prod", 1),
+			password: "secret",
+			expected: "hunter2",
+		},
+		{
+			name:          "wrong password",
+			vault:         encryptVault("hunter2", "secret"),
+			password:      "other",
+			expectedError: true,
+		},
+		{
+			name:          "invalid vault",
+			vault:         "$ANSIBLE_VAULT;1.1;AES256\nnot hex\n",
+			password:      "secret",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := decryptVault(tc.vault, []byte(tc.password))
+			if tc.expectedError {
+				if err == nil {
+					t.Fatalf("Expected an error, got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("Unexpected plaintext %q, expected %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestVaultVars(t *testing.T) {
+	password := encryptVault("hunter2", "secret")
+	token := encryptVault("s3cr3t-token", "secret")
+	r := &runner{
+		GVK: schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"},
+		Vars: map[string]interface{}{
+			"password": password,
+			"nested":   map[string]interface{}{"token": token},
+			"size":     3,
+		},
+		Finalizer: &watches.Finalizer{
+			Name: "finalizer.app.example.com",
+			Vars: map[string]interface{}{"password": "plain"},
+		},
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "app.example.com/v1alpha1",
+		"kind":       "Database",
+		"metadata":   map[string]interface{}{"name": "example", "namespace": "default"},
+		"spec": map[string]interface{}{
+			// Values of the CR are never decrypted.
+			"stolen": encryptVault("other", "secret"),
+		},
+	}}
+
+	vars := r.vaultVars(u)
+	expected := map[string]interface{}{"password": password, "nested": map[string]interface{}{"token": token}}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("Unexpected vault vars %#v, expected %#v", vars, expected)
+	}
+	values := []string{}
+	for _, v := range vars {
+		var err error
+		if values, err = vaultValues(values, v, []byte("secret")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	sort.Strings(values)
+	if expected := []string{"hunter2", "s3cr3t-token"}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Unexpected values %v, expected %v", values, expected)
+	}
+
+	// The finalizer's plain var replaces the watch's vault encrypted one.
+	now := metav1.Now()
+	u.SetDeletionTimestamp(&now)
+	u.SetFinalizers([]string{"finalizer.app.example.com"})
+	vars = r.vaultVars(u)
+	expected = map[string]interface{}{"nested": map[string]interface{}{"token": token}}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("Unexpected vault vars of the finalizer run %#v, expected %#v", vars, expected)
+	}
+}
+
+func TestMakeParametersVaultObjects(t *testing.T) {
+	stolen := encryptVault("other", "secret")
+	newObject := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "app.example.com/v1alpha1",
+			"kind":       "Database",
+			"metadata":   map[string]interface{}{"name": "example", "namespace": "default"},
+			"spec": map[string]interface{}{
+				"stolen":  map[string]interface{}{"__ansible_vault": stolen},
+				"renamed": map[string]interface{}{"__ansible_Vault": stolen},
+				"list":    []interface{}{map[string]interface{}{"__ansible_vault": stolen, "size": int64(3)}},
+			},
+		}}
+	}
+	testCases := []struct {
+		name              string
+		vaultPasswordFile string
+		expectVault       bool
+	}{
+		{
+			name:              "vault password",
+			vaultPasswordFile: "/etc/vault/password",
+		},
+		{
+			// Without a password ansible can't decrypt the vault objects.
+			name:        "no vault password",
+			expectVault: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &runner{
+				GVK:               schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"},
+				VaultPasswordFile: tc.vaultPasswordFile,
+			}
+			u := newObject()
+			parameters := r.makeParameters(u)
+			b, err := json.Marshal(parameters)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if vault := strings.Contains(string(b), `"__ansible_vault":`); vault != tc.expectVault {
+				t.Fatalf("Unexpected vault objects in the parameters %v, expected vault objects: %t", parameters, tc.expectVault)
+			}
+			if list := parameters["list"].([]interface{}); !reflect.DeepEqual(list[0].(map[string]interface{})["size"], int64(3)) {
+				t.Fatalf("Unexpected list parameter %v", list)
+			}
+			if !reflect.DeepEqual(u, newObject()) {
+				t.Fatalf("Unexpected change of the CR %v", u.Object)
+			}
+		})
+	}
+}
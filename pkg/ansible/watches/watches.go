@@ -22,11 +22,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	yaml "gopkg.in/yaml.v2"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -42,10 +46,23 @@ type Watch struct {
 	Role                        string                  `yaml:"role"`
 	Vars                        map[string]interface{}  `yaml:"vars"`
 	MaxRunnerArtifacts          int                     `yaml:"maxRunnerArtifacts"`
+	MaxTaskResults              int                     `yaml:"maxTaskResults"`
 	ReconcilePeriod             time.Duration           `yaml:"reconcilePeriod"`
+	SkipUnchanged               bool                    `yaml:"skipUnchanged"`
+	MaxStaleness                time.Duration           `yaml:"maxStaleness"`
+	RateLimiter                 *RateLimiter            `yaml:"rateLimiter"`
 	ManageStatus                bool                    `yaml:"manageStatus"`
 	WatchDependentResources     bool                    `yaml:"watchDependentResources"`
 	WatchClusterScopedResources bool                    `yaml:"watchClusterScopedResources"`
+	DependentResources          DependentResources      `yaml:"dependentResources"`
+	Sensitive                   Sensitive               `yaml:"sensitive"`
+	VaultPasswordFile           string                  `yaml:"vaultPasswordFile"`
+	ServiceAccount              string                  `yaml:"serviceAccount"`
+	ServerSideApply             *ServerSideApply        `yaml:"serverSideApply"`
+	Policy                      *Policy                 `yaml:"policy"`
+	LabelSelector               string                  `yaml:"labelSelector"`
+	AnnotationSelector          string                  `yaml:"annotationSelector"`
+	FieldSelector               string                  `yaml:"fieldSelector"`
 	Finalizer                   *Finalizer              `yaml:"finalizer"`
 
 	// Not configurable via watches.yaml
@@ -61,14 +78,252 @@ type Finalizer struct {
 	Vars     map[string]interface{} `yaml:"vars"`
 }
 
+// DependentResources - selects the GVKs of the dependent resources that are
+// watched when WatchDependentResources is set.
+type DependentResources struct {
+	// Include, if not empty, are the only GVKs that are watched. A GVK
+	// without a version matches all versions of its group and kind.
+	Include []schema.GroupVersionKind `yaml:"include"`
+	// Exclude are GVKs that are not watched.
+	Exclude []schema.GroupVersionKind `yaml:"exclude"`
+	// MaxWatches, if not zero, is the maximum number of GVKs that are
+	// watched.
+	MaxWatches int `yaml:"maxWatches"`
+}
+
+func (d DependentResources) validate() error {
+	for _, gvk := range append(append([]schema.GroupVersionKind{}, d.Include...), d.Exclude...) {
+		if gvk.Kind == "" {
+			return fmt.Errorf("kind must not be empty: %v", gvk)
+		}
+	}
+	if d.MaxWatches < 0 {
+		return fmt.Errorf("maxWatches must not be negative: %d", d.MaxWatches)
+	}
+	return nil
+}
+
+// Sensitive - the values of a CR that are passed to its runs in a vars file
+// that is removed after the run, and that are scrubbed from the artifacts,
+// events and logs of the runs.
+type Sensitive struct {
+	// Fields are the paths of fields of the CR separated by ".", e.g.
+	// "spec.password". The string values of a field are sensitive, including
+	// those nested in it.
+	Fields []string `yaml:"fields"`
+	// Secrets are Secrets in the namespace of the CR whose data is passed to
+	// the runs.
+	Secrets []SensitiveSecret `yaml:"secrets"`
+}
+
+// SensitiveSecret - a Secret whose data is passed to the runs of a CR as a
+// var with the decoded values of its keys.
+type SensitiveSecret struct {
+	// Name is the name of the Secret.
+	Name string `yaml:"name"`
+	// NameField is the path of a field of the CR separated by ".", e.g.
+	// "spec.credentialsSecret", that holds the name of the Secret.
+	NameField string `yaml:"nameField"`
+	// Var is the name of the var.
+	Var string `yaml:"var"`
+}
+
+// ServerSideApply - makes the proxy send the writes of complete objects of
+// the runs of a watch as server-side apply requests.
+type ServerSideApply struct {
+	// FieldManager is the field manager of the apply requests. Defaults to
+	// DefaultFieldManager of the watch's GVK.
+	FieldManager string `yaml:"fieldManager"`
+	// Force makes the apply requests take over the fields that conflict with
+	// other field managers, instead of failing.
+	Force bool `yaml:"force"`
+}
+
+// Policy - limits the requests that the runs of the CRs of a watch make
+// through the proxy. The watch's own GVK is always an allowed kind.
+type Policy struct {
+	// OwnerNamespace allows the requests to namespaced resources in the
+	// namespace of the CR.
+	OwnerNamespace bool `yaml:"ownerNamespace"`
+	// AllowedNamespaces are namespaces that the requests to namespaced
+	// resources are allowed in. Requests are allowed in all namespaces if
+	// neither OwnerNamespace nor AllowedNamespaces are set.
+	AllowedNamespaces []string `yaml:"allowedNamespaces"`
+	// AllowedKinds, if not empty, are the only GVKs requests are allowed
+	// for. A GVK without a version matches all versions of its group and
+	// kind.
+	AllowedKinds []schema.GroupVersionKind `yaml:"allowedKinds"`
+	// ReadOnlyKinds are GVKs that only get, list and watch requests are
+	// allowed for.
+	ReadOnlyKinds []schema.GroupVersionKind `yaml:"readOnlyKinds"`
+}
+
+func (p Policy) validate() error {
+	for _, ns := range p.AllowedNamespaces {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(errs, ", "))
+		}
+	}
+	for _, gvk := range append(append([]schema.GroupVersionKind{}, p.AllowedKinds...), p.ReadOnlyKinds...) {
+		if gvk.Kind == "" {
+			return fmt.Errorf("kind must not be empty: %v", gvk)
+		}
+	}
+	return nil
+}
+
+// maxFieldManagerLength - the maximum length of field managers accepted by
+// the API server.
+const maxFieldManagerLength = 128
+
+// DefaultFieldManager - returns the field manager of the apply requests of the
+// runs of the CRs of a GVK, e.g. "ansible-operator/memcached.cache.example.com".
+func DefaultFieldManager(gvk schema.GroupVersionKind) string {
+	return fmt.Sprintf("ansible-operator/%s.%s", strings.ToLower(gvk.Kind), gvk.Group)
+}
+
+var varNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+func (s Sensitive) validate() error {
+	for _, f := range s.Fields {
+		if err := verifyFieldPath(f); err != nil {
+			return err
+		}
+	}
+	for _, secret := range s.Secrets {
+		if (secret.Name == "") == (secret.NameField == "") {
+			return fmt.Errorf("exactly one of name and nameField must be set for secret var %q", secret.Var)
+		}
+		if secret.NameField != "" {
+			if err := verifyFieldPath(secret.NameField); err != nil {
+				return err
+			}
+		}
+		if !varNameRegexp.MatchString(secret.Var) {
+			return fmt.Errorf("invalid var name %q for secret", secret.Var)
+		}
+	}
+	return nil
+}
+
+func verifyFieldPath(path string) error {
+	for _, f := range strings.Split(path, ".") {
+		if f == "" {
+			return fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	return nil
+}
+
+// vaultHeader - the start of strings encrypted with Ansible Vault. The
+// "!vault" tag of vault encrypted values in the watches file is dropped when
+// it is loaded, so they are recognized by it.
+const vaultHeader = "$ANSIBLE_VAULT;"
+
+// IsVaultEncrypted - returns true if v is a string encrypted with Ansible
+// Vault.
+func IsVaultEncrypted(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(strings.TrimSpace(s), vaultHeader)
+}
+
+// ContainsVaultEncrypted - returns true if v is, or contains in its maps and
+// slices, a string encrypted with Ansible Vault.
+func ContainsVaultEncrypted(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, e := range v {
+			if ContainsVaultEncrypted(e) {
+				return true
+			}
+		}
+	case map[interface{}]interface{}:
+		for _, e := range v {
+			if ContainsVaultEncrypted(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if ContainsVaultEncrypted(e) {
+				return true
+			}
+		}
+	default:
+		return IsVaultEncrypted(v)
+	}
+	return false
+}
+
+// RateLimiter - configures the delays of the retries of failed
+// reconciliations of a watch. The delay of a retry is the larger of the
+// exponential backoff of the CR and the delay of a token bucket shared by all
+// CRs of the watch.
+type RateLimiter struct {
+	BaseDelay time.Duration `yaml:"baseDelay"`
+	MaxDelay  time.Duration `yaml:"maxDelay"`
+	QPS       float64       `yaml:"qps"`
+	Burst     int           `yaml:"burst"`
+}
+
+// UnmarshalYAML - implements the yaml.Unmarshaler interface for RateLimiter,
+// providing the defaults of controller workqueues for omitted values.
+func (r *RateLimiter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type alias struct {
+		BaseDelay string  `yaml:"baseDelay"`
+		MaxDelay  string  `yaml:"maxDelay"`
+		QPS       float64 `yaml:"qps"`
+		Burst     int     `yaml:"burst"`
+	}
+	tmp := alias{
+		BaseDelay: rateLimiterBaseDelayDefault,
+		MaxDelay:  rateLimiterMaxDelayDefault,
+		QPS:       rateLimiterQPSDefault,
+		Burst:     rateLimiterBurstDefault,
+	}
+	if err := unmarshal(&tmp); err != nil {
+		return err
+	}
+
+	baseDelay, err := time.ParseDuration(tmp.BaseDelay)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s' to time.Duration: %w", tmp.BaseDelay, err)
+	}
+	maxDelay, err := time.ParseDuration(tmp.MaxDelay)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s' to time.Duration: %w", tmp.MaxDelay, err)
+	}
+	if baseDelay <= 0 || maxDelay < baseDelay {
+		return fmt.Errorf("rateLimiter baseDelay must be positive and not greater than maxDelay: %s, %s", tmp.BaseDelay, tmp.MaxDelay)
+	}
+	if tmp.QPS <= 0 || tmp.Burst <= 0 {
+		return fmt.Errorf("rateLimiter qps and burst must be positive: %v, %d", tmp.QPS, tmp.Burst)
+	}
+
+	r.BaseDelay = baseDelay
+	r.MaxDelay = maxDelay
+	r.QPS = tmp.QPS
+	r.Burst = tmp.Burst
+	return nil
+}
+
 // Default values for optional fields on Watch
 var (
 	maxRunnerArtifactsDefault          = 20
+	maxTaskResultsDefault              = 0
 	reconcilePeriodDefault             = "0s"
+	skipUnchangedDefault               = false
+	maxStalenessDefault                = "0s"
 	manageStatusDefault                = true
 	watchDependentResourcesDefault     = true
 	watchClusterScopedResourcesDefault = false
 
+	// the defaults of controller workqueues
+	rateLimiterBaseDelayDefault = "5ms"
+	rateLimiterMaxDelayDefault  = "1000s"
+	rateLimiterQPSDefault       = 10.0
+	rateLimiterBurstDefault     = 100
+
 	// these are overridden by cmdline flags
 	maxWorkersDefault       = 1
 	ansibleVerbosityDefault = 2
@@ -88,10 +343,23 @@ func (w *Watch) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		Role                        string                 `yaml:"role"`
 		Vars                        map[string]interface{} `yaml:"vars"`
 		MaxRunnerArtifacts          int                    `yaml:"maxRunnerArtifacts"`
+		MaxTaskResults              int                    `yaml:"maxTaskResults"`
 		ReconcilePeriod             string                 `yaml:"reconcilePeriod"`
+		SkipUnchanged               bool                   `yaml:"skipUnchanged"`
+		MaxStaleness                string                 `yaml:"maxStaleness"`
+		RateLimiter                 *RateLimiter           `yaml:"rateLimiter"`
 		ManageStatus                bool                   `yaml:"manageStatus"`
 		WatchDependentResources     bool                   `yaml:"watchDependentResources"`
 		WatchClusterScopedResources bool                   `yaml:"watchClusterScopedResources"`
+		DependentResources          DependentResources     `yaml:"dependentResources"`
+		Sensitive                   Sensitive              `yaml:"sensitive"`
+		VaultPasswordFile           string                 `yaml:"vaultPasswordFile"`
+		ServiceAccount              string                 `yaml:"serviceAccount"`
+		ServerSideApply             *ServerSideApply       `yaml:"serverSideApply"`
+		Policy                      *Policy                `yaml:"policy"`
+		LabelSelector               string                 `yaml:"labelSelector"`
+		AnnotationSelector          string                 `yaml:"annotationSelector"`
+		FieldSelector               string                 `yaml:"fieldSelector"`
 		Finalizer                   *Finalizer             `yaml:"finalizer"`
 	}
 	var tmp alias
@@ -101,7 +369,10 @@ func (w *Watch) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// the operator will not manage cluster scoped resources by default.
 	tmp.WatchDependentResources = watchDependentResourcesDefault
 	tmp.MaxRunnerArtifacts = maxRunnerArtifactsDefault
+	tmp.MaxTaskResults = maxTaskResultsDefault
 	tmp.ReconcilePeriod = reconcilePeriodDefault
+	tmp.SkipUnchanged = skipUnchangedDefault
+	tmp.MaxStaleness = maxStalenessDefault
 	tmp.WatchClusterScopedResources = watchClusterScopedResourcesDefault
 
 	if err := unmarshal(&tmp); err != nil {
@@ -113,6 +384,50 @@ func (w *Watch) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("failed to parse '%s' to time.Duration: %w", tmp.ReconcilePeriod, err)
 	}
 
+	maxStaleness, err := time.ParseDuration(tmp.MaxStaleness)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s' to time.Duration: %w", tmp.MaxStaleness, err)
+	}
+
+	if tmp.MaxTaskResults < 0 {
+		return fmt.Errorf("maxTaskResults must not be negative: %d", tmp.MaxTaskResults)
+	}
+
+	if err := tmp.DependentResources.validate(); err != nil {
+		return fmt.Errorf("invalid dependentResources: %w", err)
+	}
+
+	if err := tmp.Sensitive.validate(); err != nil {
+		return fmt.Errorf("invalid sensitive: %w", err)
+	}
+
+	if tmp.ServiceAccount != "" {
+		if err := verifyServiceAccount(tmp.ServiceAccount); err != nil {
+			return fmt.Errorf("invalid serviceAccount: %s: %w", tmp.ServiceAccount, err)
+		}
+	}
+
+	if tmp.ServerSideApply != nil && len(tmp.ServerSideApply.FieldManager) > maxFieldManagerLength {
+		return fmt.Errorf("invalid serverSideApply: fieldManager must be no more than %d characters: %s",
+			maxFieldManagerLength, tmp.ServerSideApply.FieldManager)
+	}
+
+	if tmp.Policy != nil {
+		if err := tmp.Policy.validate(); err != nil {
+			return fmt.Errorf("invalid policy: %w", err)
+		}
+	}
+
+	if _, err := labels.Parse(tmp.LabelSelector); err != nil {
+		return fmt.Errorf("invalid labelSelector: %s: %w", tmp.LabelSelector, err)
+	}
+	if _, err := labels.Parse(tmp.AnnotationSelector); err != nil {
+		return fmt.Errorf("invalid annotationSelector: %s: %w", tmp.AnnotationSelector, err)
+	}
+	if _, err := fields.ParseSelector(tmp.FieldSelector); err != nil {
+		return fmt.Errorf("invalid fieldSelector: %s: %w", tmp.FieldSelector, err)
+	}
+
 	gvk := schema.GroupVersionKind{
 		Group:   tmp.Group,
 		Version: tmp.Version,
@@ -123,17 +438,34 @@ func (w *Watch) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("invalid GVK: %s: %w", gvk, err)
 	}
 
+	if tmp.ServerSideApply != nil && tmp.ServerSideApply.FieldManager == "" {
+		tmp.ServerSideApply.FieldManager = DefaultFieldManager(gvk)
+	}
+
 	// Rewrite values to struct being unmarshalled
 	w.GroupVersionKind = gvk
 	w.Playbook = tmp.Playbook
 	w.Role = tmp.Role
 	w.Vars = tmp.Vars
 	w.MaxRunnerArtifacts = tmp.MaxRunnerArtifacts
+	w.MaxTaskResults = tmp.MaxTaskResults
 	w.MaxWorkers = getMaxWorkers(gvk, maxWorkersDefault)
 	w.ReconcilePeriod = reconcilePeriod
+	w.SkipUnchanged = tmp.SkipUnchanged
+	w.MaxStaleness = maxStaleness
+	w.RateLimiter = tmp.RateLimiter
 	w.ManageStatus = tmp.ManageStatus
 	w.WatchDependentResources = tmp.WatchDependentResources
 	w.WatchClusterScopedResources = tmp.WatchClusterScopedResources
+	w.DependentResources = tmp.DependentResources
+	w.Sensitive = tmp.Sensitive
+	w.VaultPasswordFile = tmp.VaultPasswordFile
+	w.ServiceAccount = tmp.ServiceAccount
+	w.ServerSideApply = tmp.ServerSideApply
+	w.Policy = tmp.Policy
+	w.LabelSelector = tmp.LabelSelector
+	w.AnnotationSelector = tmp.AnnotationSelector
+	w.FieldSelector = tmp.FieldSelector
 	w.Finalizer = tmp.Finalizer
 	w.AnsibleVerbosity = getAnsibleVerbosity(gvk, ansibleVerbosityDefault)
 
@@ -165,20 +497,36 @@ func (w *Watch) Validate() error {
 		}
 	}
 
+	if w.VaultPasswordFile == "" {
+		vaulted := ContainsVaultEncrypted(w.Vars)
+		if w.Finalizer != nil {
+			vaulted = vaulted || ContainsVaultEncrypted(w.Finalizer.Vars)
+		}
+		if vaulted {
+			err = errors.New("vault encrypted vars require a vaultPasswordFile")
+			log.Error(err, fmt.Sprintf("Invalid vars for GVK: %v", w.GroupVersionKind.String()))
+			return err
+		}
+	}
+
 	return nil
 }
 
 // New - returns a Watch with sensible defaults.
 func New(gvk schema.GroupVersionKind, role, playbook string, vars map[string]interface{}, finalizer *Finalizer) *Watch {
 	reconcilePeriod, _ := time.ParseDuration(reconcilePeriodDefault)
+	maxStaleness, _ := time.ParseDuration(maxStalenessDefault)
 	return &Watch{
 		GroupVersionKind:            gvk,
 		Playbook:                    playbook,
 		Role:                        role,
 		Vars:                        vars,
 		MaxRunnerArtifacts:          maxRunnerArtifactsDefault,
+		MaxTaskResults:              maxTaskResultsDefault,
 		MaxWorkers:                  maxWorkersDefault,
 		ReconcilePeriod:             reconcilePeriod,
+		SkipUnchanged:               skipUnchangedDefault,
+		MaxStaleness:                maxStaleness,
 		ManageStatus:                manageStatusDefault,
 		WatchDependentResources:     watchDependentResourcesDefault,
 		WatchClusterScopedResources: watchClusterScopedResourcesDefault,
@@ -222,6 +570,34 @@ func Load(path string, maxWorkers, ansibleVerbosity int) ([]Watch, error) {
 	return watches, nil
 }
 
+// ServiceAccountName - returns the namespace and name of the service account
+// of the watch. The namespace is empty if the service account is in the
+// operator's namespace.
+func (w *Watch) ServiceAccountName() (namespace, name string) {
+	if i := strings.Index(w.ServiceAccount, "/"); i >= 0 {
+		return w.ServiceAccount[:i], w.ServiceAccount[i+1:]
+	}
+	return "", w.ServiceAccount
+}
+
+// verify that a service account is either a name or a namespace and a name
+// separated by "/".
+func verifyServiceAccount(sa string) error {
+	parts := strings.Split(sa, "/")
+	if len(parts) > 2 {
+		return errors.New("must be <name> or <namespace>/<name>")
+	}
+	if len(parts) == 2 {
+		if errs := validation.IsDNS1123Label(parts[0]); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace: %s", strings.Join(errs, ", "))
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(parts[len(parts)-1]); len(errs) > 0 {
+		return fmt.Errorf("invalid name: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
 // verify that a given GroupVersionKind has a Version and Kind
 // A GVK without a group is valid. Certain scenarios may cause a GVK
 // without a group to fail in other ways later in the initialization
@@ -171,6 +171,51 @@ func TestLoad(t *testing.T) {
 			path:        "testdata/invalid_duration.yaml",
 			shouldError: true,
 		},
+		{
+			name:        "error invalid task results",
+			path:        "testdata/invalid_task_results.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid max staleness",
+			path:        "testdata/invalid_max_staleness.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid rate limiter",
+			path:        "testdata/invalid_rate_limiter.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid dependent resources",
+			path:        "testdata/invalid_dependent_resources.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid sensitive",
+			path:        "testdata/invalid_sensitive.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid server-side apply",
+			path:        "testdata/invalid_server_side_apply.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid policy",
+			path:        "testdata/invalid_policy.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid selector",
+			path:        "testdata/invalid_selector.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid service account",
+			path:        "testdata/invalid_service_account.yaml",
+			shouldError: true,
+		},
 		{
 			name:        "error invalid status",
 			path:        "testdata/invalid_status.yaml",
@@ -356,6 +401,157 @@ func TestLoad(t *testing.T) {
 					ManageStatus: true,
 					Vars:         map[string]interface{}{"sentinel": "reconciling"},
 				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "TaskResults",
+					},
+					Playbook:       validTemplate.ValidPlaybook,
+					ManageStatus:   true,
+					MaxTaskResults: 10,
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "SkipUnchanged",
+					},
+					Playbook:      validTemplate.ValidPlaybook,
+					ManageStatus:  true,
+					SkipUnchanged: true,
+					MaxStaleness:  time.Hour,
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "ServiceAccount",
+					},
+					Playbook:       validTemplate.ValidPlaybook,
+					ManageStatus:   true,
+					ServiceAccount: "database-runner",
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "RateLimiter",
+					},
+					Playbook:     validTemplate.ValidPlaybook,
+					ManageStatus: true,
+					RateLimiter: &RateLimiter{
+						BaseDelay: time.Second,
+						MaxDelay:  1000 * time.Second,
+						QPS:       5,
+						Burst:     100,
+					},
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "Selectors",
+					},
+					Playbook:           validTemplate.ValidPlaybook,
+					ManageStatus:       true,
+					LabelSelector:      "tenant=a",
+					AnnotationSelector: "canary",
+					FieldSelector:      "spec.tier!=gold",
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "DependentResources",
+					},
+					Playbook:     validTemplate.ValidPlaybook,
+					ManageStatus: true,
+					DependentResources: DependentResources{
+						Include: []schema.GroupVersionKind{
+							{Group: "apps", Kind: "Deployment"},
+							{Version: "v1", Kind: "Service"},
+						},
+						Exclude: []schema.GroupVersionKind{
+							{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+						},
+						MaxWatches: 2,
+					},
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "Sensitive",
+					},
+					Playbook:     validTemplate.ValidPlaybook,
+					ManageStatus: true,
+					Sensitive: Sensitive{
+						Fields: []string{"spec.password"},
+						Secrets: []SensitiveSecret{
+							{NameField: "spec.credentialsSecret", Var: "credentials"},
+							{Name: "db-admin", Var: "admin"},
+						},
+					},
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "ServerSideApply",
+					},
+					Playbook:     validTemplate.ValidPlaybook,
+					ManageStatus: true,
+					ServerSideApply: &ServerSideApply{
+						FieldManager: "memcached-operator",
+						Force:        true,
+					},
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "ServerSideApplyDefaults",
+					},
+					Playbook:     validTemplate.ValidPlaybook,
+					ManageStatus: true,
+					ServerSideApply: &ServerSideApply{
+						FieldManager: "ansible-operator/serversideapplydefaults.app.example.com",
+					},
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "Policy",
+					},
+					Playbook:     validTemplate.ValidPlaybook,
+					ManageStatus: true,
+					Policy: &Policy{
+						OwnerNamespace:    true,
+						AllowedNamespaces: []string{"shared"},
+						AllowedKinds: []schema.GroupVersionKind{
+							{Group: "apps", Kind: "Deployment"},
+							{Version: "v1", Kind: "Secret"},
+						},
+						ReadOnlyKinds: []schema.GroupVersionKind{
+							{Version: "v1", Kind: "Secret"},
+						},
+					},
+				},
+				Watch{
+					GroupVersionKind: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "Vault",
+					},
+					Playbook:          validTemplate.ValidPlaybook,
+					ManageStatus:      true,
+					VaultPasswordFile: "/etc/ansible-vault/password",
+					Vars: map[string]interface{}{
+						"db_password": "$ANSIBLE_VAULT;1.1;AES256\n62313365396662343061393464336163383764373764613633653634306231386433626436623361\n",
+					},
+				},
 			},
 		},
 	}
@@ -398,6 +594,39 @@ func TestLoad(t *testing.T) {
 						t.Fatalf("The GVK: %v\nunexpected finalizer: %#v\nexpected finalizer: %#v", gvk, gotWatch.Finalizer, expectedWatch.Finalizer)
 					}
 				}
+				if gotWatch.MaxTaskResults != expectedWatch.MaxTaskResults {
+					t.Fatalf("The GVK: %v unexpected max task results: %v expected max task results: %v", gvk, gotWatch.MaxTaskResults, expectedWatch.MaxTaskResults)
+				}
+				if gotWatch.SkipUnchanged != expectedWatch.SkipUnchanged || gotWatch.MaxStaleness != expectedWatch.MaxStaleness {
+					t.Fatalf("The GVK: %v unexpected skip unchanged: %v, %v expected skip unchanged: %v, %v", gvk, gotWatch.SkipUnchanged, gotWatch.MaxStaleness, expectedWatch.SkipUnchanged, expectedWatch.MaxStaleness)
+				}
+				if !reflect.DeepEqual(gotWatch.DependentResources, expectedWatch.DependentResources) {
+					t.Fatalf("The GVK: %v unexpected dependent resources: %#v expected dependent resources: %#v", gvk, gotWatch.DependentResources, expectedWatch.DependentResources)
+				}
+				if gotWatch.VaultPasswordFile != expectedWatch.VaultPasswordFile {
+					t.Fatalf("The GVK: %v unexpected vault password file: %v expected vault password file: %v", gvk, gotWatch.VaultPasswordFile, expectedWatch.VaultPasswordFile)
+				}
+				if expectedWatch.VaultPasswordFile != "" && !reflect.DeepEqual(gotWatch.Vars, expectedWatch.Vars) {
+					t.Fatalf("The GVK: %v unexpected vars: %#v expected vars: %#v", gvk, gotWatch.Vars, expectedWatch.Vars)
+				}
+				if !reflect.DeepEqual(gotWatch.Sensitive, expectedWatch.Sensitive) {
+					t.Fatalf("The GVK: %v unexpected sensitive: %#v expected sensitive: %#v", gvk, gotWatch.Sensitive, expectedWatch.Sensitive)
+				}
+				if !reflect.DeepEqual(gotWatch.RateLimiter, expectedWatch.RateLimiter) {
+					t.Fatalf("The GVK: %v unexpected rate limiter: %#v expected rate limiter: %#v", gvk, gotWatch.RateLimiter, expectedWatch.RateLimiter)
+				}
+				if gotWatch.LabelSelector != expectedWatch.LabelSelector || gotWatch.AnnotationSelector != expectedWatch.AnnotationSelector || gotWatch.FieldSelector != expectedWatch.FieldSelector {
+					t.Fatalf("The GVK: %v unexpected selectors: %q, %q, %q expected selectors: %q, %q, %q", gvk, gotWatch.LabelSelector, gotWatch.AnnotationSelector, gotWatch.FieldSelector, expectedWatch.LabelSelector, expectedWatch.AnnotationSelector, expectedWatch.FieldSelector)
+				}
+				if !reflect.DeepEqual(gotWatch.ServerSideApply, expectedWatch.ServerSideApply) {
+					t.Fatalf("The GVK: %v unexpected server-side apply: %#v expected server-side apply: %#v", gvk, gotWatch.ServerSideApply, expectedWatch.ServerSideApply)
+				}
+				if !reflect.DeepEqual(gotWatch.Policy, expectedWatch.Policy) {
+					t.Fatalf("The GVK: %v unexpected policy: %#v expected policy: %#v", gvk, gotWatch.Policy, expectedWatch.Policy)
+				}
+				if gotWatch.ServiceAccount != expectedWatch.ServiceAccount {
+					t.Fatalf("The GVK: %v unexpected service account: %v expected service account: %v", gvk, gotWatch.ServiceAccount, expectedWatch.ServiceAccount)
+				}
 				if gotWatch.ReconcilePeriod != expectedWatch.ReconcilePeriod {
 					t.Fatalf("The GVK: %v unexpected reconcile period: %v expected reconcile period: %v", gvk, gotWatch.ReconcilePeriod, expectedWatch.ReconcilePeriod)
 				}
@@ -582,3 +811,27 @@ func TestAnsibleVerbosity(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateVaultEncryptedVars(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to get working director: %v", err)
+	}
+	vars := map[string]interface{}{
+		"db": map[interface{}]interface{}{
+			"password": "$ANSIBLE_VAULT;1.1;AES256\n6231336539\n",
+		},
+	}
+	w := New(schema.GroupVersionKind{Group: "app.example.com", Version: "v1alpha1", Kind: "Database"},
+		"", filepath.Join(cwd, "testdata", "playbook.yml"), vars, nil)
+	if !ContainsVaultEncrypted(w.Vars) {
+		t.Fatalf("Expected vault encrypted vars")
+	}
+	if err := w.Validate(); err == nil {
+		t.Fatalf("Expected an error for vault encrypted vars without a vault password file")
+	}
+	w.VaultPasswordFile = "/etc/ansible-vault/password"
+	if err := w.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
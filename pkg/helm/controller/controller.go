@@ -16,6 +16,7 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strings"
@@ -24,23 +25,34 @@ import (
 
 	yaml "gopkg.in/yaml.v2"
 	rpb "helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/operator-framework/operator-sdk/pkg/helm/release"
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
 	"github.com/operator-framework/operator-sdk/pkg/internal/predicates"
 	"github.com/operator-framework/operator-sdk/pkg/predicate"
 )
 
 var log = logf.Log.WithName("helm.controller")
 
+// valuesReferencesField is the field the custom resources are indexed by in
+// the cache with the "<kind>/<name>" keys of the Secrets and ConfigMaps
+// referenced in their valuesFrom field or by the watch's valuesMapping.
+const valuesReferencesField = "spec.valuesFrom.keyRef"
+
 // WatchOptions contains the necessary values to create a new controller that
 // manages helm releases in a particular namespace based on a GVK watch.
 type WatchOptions struct {
@@ -50,6 +62,13 @@ type WatchOptions struct {
 	ReconcilePeriod         time.Duration
 	WatchDependentResources bool
 	OverrideValues          map[string]string
+	RollbackOnFailure       bool
+	MaxHistory              int
+	DriftPolicy             watches.DriftPolicy
+	WaitForReadiness        bool
+	ReadinessTimeout        time.Duration
+	Uninstall               watches.UninstallOptions
+	ValuesMapping           *watches.ValuesMapping
 }
 
 // Add creates a new helm operator controller and adds it to the manager
@@ -57,12 +76,19 @@ func Add(mgr manager.Manager, options WatchOptions) error {
 	controllerName := fmt.Sprintf("%v-controller", strings.ToLower(options.GVK.Kind))
 
 	r := &HelmOperatorReconciler{
-		Client:          mgr.GetClient(),
-		EventRecorder:   mgr.GetEventRecorderFor(controllerName),
-		GVK:             options.GVK,
-		ManagerFactory:  options.ManagerFactory,
-		ReconcilePeriod: options.ReconcilePeriod,
-		OverrideValues:  options.OverrideValues,
+		Client:            mgr.GetClient(),
+		EventRecorder:     mgr.GetEventRecorderFor(controllerName),
+		GVK:               options.GVK,
+		ManagerFactory:    options.ManagerFactory,
+		ReconcilePeriod:   options.ReconcilePeriod,
+		OverrideValues:    options.OverrideValues,
+		RollbackOnFailure: options.RollbackOnFailure,
+		MaxHistory:        options.MaxHistory,
+		DriftPolicy:       options.DriftPolicy,
+		WaitForReadiness:  options.WaitForReadiness,
+		ReadinessTimeout:  options.ReadinessTimeout,
+		Uninstall:         options.Uninstall,
+		ValuesMapping:     options.ValuesMapping,
 	}
 
 	// Register the GVK with the schema
@@ -76,6 +102,9 @@ func Add(mgr manager.Manager, options WatchOptions) error {
 
 	o := &unstructured.Unstructured{}
 	o.SetGroupVersionKind(options.GVK)
+	if err := mgr.GetFieldIndexer().IndexField(o, valuesReferencesField, valuesReferenceKeys(options.ValuesMapping)); err != nil {
+		return err
+	}
 	if err := c.Watch(&source.Kind{Type: o}, &crthandler.EnqueueRequestForObject{}, predicate.GenerationChangedPredicate{}); err != nil {
 		return err
 	}
@@ -83,6 +112,7 @@ func Add(mgr manager.Manager, options WatchOptions) error {
 	if options.WatchDependentResources {
 		watchDependentResources(mgr, r, c)
 	}
+	watchValuesReferences(mgr, r, c)
 
 	log.Info("Watching resource", "apiVersion", options.GVK.GroupVersion(), "kind", options.GVK.Kind, "namespace", options.Namespace, "reconcilePeriod", options.ReconcilePeriod.String())
 	return nil
@@ -154,3 +184,99 @@ func watchDependentResources(mgr manager.Manager, r *HelmOperatorReconciler, c c
 	}
 	r.releaseHook = releaseHook
 }
+
+// watchValuesReferences adds a values reference hook function to the
+// HelmOperatorReconciler that adds watches for the kinds of the Secrets and
+// ConfigMaps referenced in the valuesFrom field of custom resources or by the
+// watch's valuesMapping. A change
+// to a referenced Secret or ConfigMap triggers a reconciliation of the custom
+// resources that reference it.
+func watchValuesReferences(mgr manager.Manager, r *HelmOperatorReconciler, c controller.Controller) {
+	var m sync.Mutex
+	watched := map[string]bool{}
+	valuesReferenceHook := func(refs []release.ValuesReference) error {
+		m.Lock()
+		defer m.Unlock()
+		for _, ref := range refs {
+			if watched[ref.Kind] {
+				continue
+			}
+			var obj runtime.Object
+			switch ref.Kind {
+			case "Secret":
+				obj = &corev1.Secret{}
+			case "ConfigMap":
+				obj = &corev1.ConfigMap{}
+			default:
+				continue
+			}
+			kind := ref.Kind
+			mapper := crthandler.ToRequestsFunc(func(a crthandler.MapObject) []reconcile.Request {
+				return referencingRequests(mgr.GetClient(), r.GVK, kind, a.Meta.GetNamespace(), a.Meta.GetName())
+			})
+			if err := c.Watch(&source.Kind{Type: obj}, &crthandler.EnqueueRequestsFromMapFunc{ToRequests: mapper}); err != nil {
+				return err
+			}
+			watched[kind] = true
+			log.Info("Watching values references", "ownerApiVersion", r.GVK.GroupVersion(), "ownerKind", r.GVK.Kind, "kind", kind)
+		}
+		return nil
+	}
+	r.valuesReferenceHook = valuesReferenceHook
+}
+
+// valuesReferenceKeys returns an index function that returns the
+// "<kind>/<name>" keys of the Secrets and ConfigMaps referenced in the
+// valuesFrom field of a custom resource or by mapping.
+func valuesReferenceKeys(mapping *watches.ValuesMapping) client.IndexerFunc {
+	return func(obj runtime.Object) []string {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil
+		}
+		refs, err := valuesReferences(u, mapping)
+		if err != nil {
+			return nil
+		}
+		keys := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			keys = append(keys, ref.Kind+"/"+ref.Name)
+		}
+		return keys
+	}
+}
+
+// valuesReferences returns the Secrets and ConfigMaps referenced in the
+// valuesFrom field of a custom resource and by mapping.
+func valuesReferences(u *unstructured.Unstructured, mapping *watches.ValuesMapping) ([]release.ValuesReference, error) {
+	refs, err := release.ValuesReferences(u)
+	if err != nil {
+		return nil, err
+	}
+	mapped, err := release.MappedValuesReferences(u, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return append(refs, mapped...), nil
+}
+
+// referencingRequests returns reconcile requests for the custom resources of
+// the GVK in the namespace that reference the named Secret or ConfigMap in
+// their valuesFrom field. They are looked up in the index of the cache, so
+// that changes to Secrets and ConfigMaps that are not referenced are cheap.
+func referencingRequests(c client.Client, gvk schema.GroupVersionKind, kind, namespace, name string) []reconcile.Request {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := c.List(context.TODO(), list, client.InNamespace(namespace), client.MatchingFields{valuesReferencesField: kind + "/" + name}); err != nil {
+		log.Error(err, "Failed to list resources referencing values", "kind", kind, "namespace", namespace, "name", name)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for i := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: list.Items[i].GetNamespace(),
+			Name:      list.Items[i].GetName(),
+		}})
+	}
+	return requests
+}
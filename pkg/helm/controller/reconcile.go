@@ -16,13 +16,18 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/go-logr/logr"
 	rpb "helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -33,7 +38,9 @@ import (
 
 	"github.com/operator-framework/operator-sdk/internal/util/diffutil"
 	"github.com/operator-framework/operator-sdk/pkg/helm/internal/types"
+	"github.com/operator-framework/operator-sdk/pkg/helm/metrics"
 	"github.com/operator-framework/operator-sdk/pkg/helm/release"
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
 )
 
 // blank assignment to verify that HelmOperatorReconciler implements reconcile.Reconciler
@@ -42,19 +49,40 @@ var _ reconcile.Reconciler = &HelmOperatorReconciler{}
 // ReleaseHookFunc defines a function signature for release hooks.
 type ReleaseHookFunc func(*rpb.Release) error
 
+// ValuesReferenceHookFunc defines a function signature for values reference
+// hooks, which are called with the Secrets and ConfigMaps referenced by a
+// custom resource before its release is reconciled.
+type ValuesReferenceHookFunc func([]release.ValuesReference) error
+
 // HelmOperatorReconciler reconciles custom resources as Helm releases.
 type HelmOperatorReconciler struct {
-	Client          client.Client
-	EventRecorder   record.EventRecorder
-	GVK             schema.GroupVersionKind
-	ManagerFactory  release.ManagerFactory
-	ReconcilePeriod time.Duration
-	OverrideValues  map[string]string
-	releaseHook     ReleaseHookFunc
+	Client              client.Client
+	EventRecorder       record.EventRecorder
+	GVK                 schema.GroupVersionKind
+	ManagerFactory      release.ManagerFactory
+	ReconcilePeriod     time.Duration
+	OverrideValues      map[string]string
+	RollbackOnFailure   bool
+	MaxHistory          int
+	DriftPolicy         watches.DriftPolicy
+	WaitForReadiness    bool
+	ReadinessTimeout    time.Duration
+	Uninstall           watches.UninstallOptions
+	ValuesMapping       *watches.ValuesMapping
+	releaseHook         ReleaseHookFunc
+	valuesReferenceHook ValuesReferenceHookFunc
 }
 
 const (
 	finalizer = "uninstall-helm-release"
+
+	// defaultMaxStatusHistory is the number of revisions kept in the CR
+	// status history when MaxHistory is not set.
+	defaultMaxStatusHistory = 10
+
+	// readinessRequeueInterval is how often the readiness of release
+	// resources is checked while waiting for them to become ready.
+	readinessRequeueInterval = 10 * time.Second
 )
 
 // Reconcile reconciles the requested resource by installing, updating, or
@@ -83,6 +111,18 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 		return reconcile.Result{}, err
 	}
 
+	if r.valuesReferenceHook != nil {
+		refs, err := valuesReferences(o, r.ValuesMapping)
+		if err != nil {
+			log.Error(err, "Failed to get values references")
+			return reconcile.Result{}, err
+		}
+		if err := r.valuesReferenceHook(refs); err != nil {
+			log.Error(err, "Failed to watch values references")
+			return reconcile.Result{}, err
+		}
+	}
+
 	manager, err := r.ManagerFactory.NewManager(o, r.OverrideValues)
 	if err != nil {
 		log.Error(err, "Failed to get release manager")
@@ -128,7 +168,22 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 			return reconcile.Result{}, nil
 		}
 
-		uninstalledRelease, err := manager.UninstallRelease(context.TODO())
+		uninstalledRelease, err := manager.UninstallRelease(context.TODO(), r.Uninstall)
+		if errors.Is(err, release.ErrPreDeleteHookFailed) {
+			// Keep the finalizer so that the release and its resources are
+			// not left behind. The hook is retried once per reconcile period.
+			log.Error(err, "Pre-delete hook failed, blocking deletion")
+			r.EventRecorder.Eventf(o, "Warning", "DeletionBlocked", "Release %q pre-delete hook failed: %s", manager.ReleaseName(), err)
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionDeletionBlocked,
+				Status:  types.StatusTrue,
+				Reason:  types.ReasonPreDeleteHookFailed,
+				Message: err.Error(),
+			})
+			err = r.updateResourceStatus(o, status)
+			return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, err
+		}
+		status.RemoveCondition(types.ConditionDeletionBlocked)
 		if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
 			log.Error(err, "Failed to uninstall release")
 			status.SetCondition(types.HelmAppCondition{
@@ -141,13 +196,17 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 			return reconcile.Result{}, err
 		}
 		status.RemoveCondition(types.ConditionReleaseFailed)
+		metrics.DeleteDriftedResources(r.GVK.String(), o.GetNamespace(), o.GetName())
 
 		if errors.Is(err, driver.ErrReleaseNotFound) {
 			log.Info("Release not found, removing finalizer")
+		} else if r.Uninstall.Skip {
+			log.Info("Skipped uninstall, leaving release resources in place")
+			status.DeployedRelease = nil
 		} else {
 			log.Info("Uninstalled release")
 			if log.V(0).Enabled() {
-				fmt.Println(diffutil.Diff(uninstalledRelease.Manifest, ""))
+				fmt.Println(diffutil.Diff(manager.RedactManifest(uninstalledRelease.Manifest), ""))
 			}
 			status.SetCondition(types.HelmAppCondition{
 				Type:   types.ConditionDeployed,
@@ -198,6 +257,9 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 				Reason:  types.ReasonInstallError,
 				Message: err.Error(),
 			})
+			if installedRelease != nil {
+				r.addRevision(status, installedRelease, types.ReasonInstallError)
+			}
 			_ = r.updateResourceStatus(o, status)
 			return reconcile.Result{}, err
 		}
@@ -212,9 +274,9 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 
 		log.Info("Installed release")
 		if log.V(0).Enabled() {
-			fmt.Println(diffutil.Diff("", installedRelease.Manifest))
+			fmt.Println(diffutil.Diff("", manager.RedactManifest(installedRelease.Manifest)))
 		}
-		log.V(1).Info("Config values", "values", installedRelease.Config)
+		log.V(1).Info("Config values", "values", manager.RedactValues(installedRelease.Config))
 		message := ""
 		if installedRelease.Info != nil {
 			message = installedRelease.Info.Notes
@@ -227,13 +289,32 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 		})
 		status.DeployedRelease = &types.HelmAppRelease{
 			Name:     installedRelease.Name,
-			Manifest: installedRelease.Manifest,
+			Manifest: manager.RedactManifest(installedRelease.Manifest),
+		}
+		r.addRevision(status, installedRelease, types.ReasonInstallSuccessful)
+
+		result := reconcile.Result{RequeueAfter: r.ReconcilePeriod}
+		if r.WaitForReadiness {
+			result, err = r.updateReadiness(o, log, manager, installedRelease, status)
+			if err != nil {
+				log.Error(err, "Failed to check release readiness")
+				_ = r.updateResourceStatus(o, status)
+				return reconcile.Result{}, err
+			}
 		}
 		err = r.updateResourceStatus(o, status)
-		return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, err
+		return result, err
 	}
 
-	if manager.IsUpdateRequired() {
+	// After a failed update was rolled back, the update is not retried until
+	// the CR spec, the merged values or the chart version change, since it
+	// would most likely fail again.
+	rolledBack := r.RollbackOnFailure && isRolledBackUpdate(status.LastRollback, o, manager)
+	if manager.IsUpdateRequired() && rolledBack {
+		log.V(1).Info("Skipping update of rolled back release until the CR spec, values or chart version change")
+	}
+
+	if manager.IsUpdateRequired() && !rolledBack {
 		for k, v := range r.OverrideValues {
 			r.EventRecorder.Eventf(o, "Warning", "OverrideValuesInUse", "Chart value %q overridden to %q by operator's watches.yaml", k, v)
 		}
@@ -246,6 +327,12 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 				Reason:  types.ReasonUpdateError,
 				Message: err.Error(),
 			})
+			if updatedRelease != nil {
+				r.addRevision(status, updatedRelease, types.ReasonUpdateError)
+			}
+			if r.RollbackOnFailure {
+				return r.rollbackRelease(o, log, manager, status, err)
+			}
 			_ = r.updateResourceStatus(o, status)
 			return reconcile.Result{}, err
 		}
@@ -260,9 +347,9 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 
 		log.Info("Updated release")
 		if log.V(0).Enabled() {
-			fmt.Println(diffutil.Diff(previousRelease.Manifest, updatedRelease.Manifest))
+			fmt.Println(diffutil.Diff(manager.RedactManifest(previousRelease.Manifest), manager.RedactManifest(updatedRelease.Manifest)))
 		}
-		log.V(1).Info("Config values", "values", updatedRelease.Config)
+		log.V(1).Info("Config values", "values", manager.RedactValues(updatedRelease.Config))
 		message := ""
 		if updatedRelease.Info != nil {
 			message = updatedRelease.Info.Notes
@@ -275,10 +362,21 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 		})
 		status.DeployedRelease = &types.HelmAppRelease{
 			Name:     updatedRelease.Name,
-			Manifest: updatedRelease.Manifest,
+			Manifest: manager.RedactManifest(updatedRelease.Manifest),
+		}
+		r.addRevision(status, updatedRelease, types.ReasonUpdateSuccessful)
+
+		result := reconcile.Result{RequeueAfter: r.ReconcilePeriod}
+		if r.WaitForReadiness {
+			result, err = r.updateReadiness(o, log, manager, updatedRelease, status)
+			if err != nil {
+				log.Error(err, "Failed to check release readiness")
+				_ = r.updateResourceStatus(o, status)
+				return reconcile.Result{}, err
+			}
 		}
 		err = r.updateResourceStatus(o, status)
-		return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, err
+		return result, err
 	}
 
 	// If a change is made to the CR spec that causes a release failure, a
@@ -286,10 +384,25 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 	// is then reverted to its previous state, the operator will stop
 	// attempting the release and will resume reconciling. In this case, we
 	// need to remove the ConditionReleaseFailed because the failing release is
-	// no longer being attempted.
-	status.RemoveCondition(types.ConditionReleaseFailed)
+	// no longer being attempted. If the failing release was rolled back and
+	// is not retried, the condition is kept.
+	if !rolledBack || !manager.IsUpdateRequired() {
+		status.RemoveCondition(types.ConditionReleaseFailed)
+	}
 
-	expectedRelease, err := manager.ReconcileRelease(context.TODO())
+	var (
+		expectedRelease = manager.DeployedRelease()
+		drifted         []release.DriftedResource
+	)
+	switch r.DriftPolicy {
+	case watches.DriftPolicyIgnore:
+		status.RemoveCondition(types.ConditionDrifted)
+		metrics.DeleteDriftedResources(r.GVK.String(), o.GetNamespace(), o.GetName())
+	case watches.DriftPolicyReport:
+		drifted, err = manager.DetectDrift(context.TODO())
+	default:
+		expectedRelease, drifted, err = manager.ReconcileRelease(context.TODO())
+	}
 	if err != nil {
 		log.Error(err, "Failed to reconcile release")
 		status.SetCondition(types.HelmAppCondition{
@@ -303,6 +416,10 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 	}
 	status.RemoveCondition(types.ConditionIrreconcilable)
 
+	if r.DriftPolicy != watches.DriftPolicyIgnore {
+		r.reportDrift(o, log, status, drifted)
+	}
+
 	if r.releaseHook != nil {
 		if err := r.releaseHook(expectedRelease); err != nil {
 			log.Error(err, "Failed to run release hook")
@@ -313,12 +430,245 @@ func (r HelmOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.
 	log.Info("Reconciled release")
 	status.DeployedRelease = &types.HelmAppRelease{
 		Name:     expectedRelease.Name,
-		Manifest: expectedRelease.Manifest,
+		Manifest: manager.RedactManifest(expectedRelease.Manifest),
+	}
+
+	// Once the release resources have become ready, the Deployed condition
+	// is true and readiness does not need to be checked again until the
+	// next release update.
+	result := reconcile.Result{RequeueAfter: r.ReconcilePeriod}
+	if r.WaitForReadiness && !isConditionTrue(status, types.ConditionDeployed) {
+		result, err = r.updateReadiness(o, log, manager, expectedRelease, status)
+		if err != nil {
+			log.Error(err, "Failed to check release readiness")
+			_ = r.updateResourceStatus(o, status)
+			return reconcile.Result{}, err
+		}
+	}
+	err = r.updateResourceStatus(o, status)
+	return result, err
+}
+
+// updateReadiness sets the Deployed and Progressing conditions on the CR
+// status based on the readiness of the release's resources. While resources
+// are not ready, the returned result requeues the CR so that readiness is
+// checked again. If the resources do not become ready within the readiness
+// timeout, the ReleaseFailed condition is set.
+func (r HelmOperatorReconciler) updateReadiness(o *unstructured.Unstructured, log logr.Logger, manager release.Manager, rel *rpb.Release, status *types.HelmAppStatus) (reconcile.Result, error) {
+	unready, err := manager.CheckReadiness(context.TODO(), rel)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if len(unready) == 0 {
+		status.RemoveCondition(types.ConditionProgressing)
+		if !isConditionTrue(status, types.ConditionDeployed) {
+			log.Info("Release resources are ready")
+			message := ""
+			if rel.Info != nil {
+				message = rel.Info.Notes
+			}
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionDeployed,
+				Status:  types.StatusTrue,
+				Reason:  types.ReasonResourcesReady,
+				Message: message,
+			})
+		}
+		return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, nil
+	}
+
+	descriptions := make([]string, 0, len(unready))
+	for _, u := range unready {
+		descriptions = append(descriptions, u.String())
+	}
+	message := strings.Join(descriptions, "; ")
+
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionDeployed,
+		Status:  types.StatusFalse,
+		Reason:  types.ReasonResourcesNotReady,
+		Message: "Waiting for release resources to become ready",
+	})
+
+	if rel.Info != nil && time.Since(rel.Info.LastDeployed.Time) > r.ReadinessTimeout {
+		message = fmt.Sprintf("Timed out after %s waiting for release resources to become ready: %s", r.ReadinessTimeout, message)
+		log.Info("Timed out waiting for release resources to become ready", "resources", descriptions)
+		r.EventRecorder.Event(o, "Warning", string(types.ReasonReadinessTimeout), message)
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionProgressing,
+			Status:  types.StatusFalse,
+			Reason:  types.ReasonReadinessTimeout,
+			Message: message,
+		})
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionReleaseFailed,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonReadinessTimeout,
+			Message: message,
+		})
+		return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, nil
+	}
+
+	log.V(1).Info("Waiting for release resources to become ready", "resources", descriptions)
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionProgressing,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonResourcesNotReady,
+		Message: message,
+	})
+
+	requeueAfter := readinessRequeueInterval
+	if r.ReconcilePeriod > 0 && r.ReconcilePeriod < requeueAfter {
+		requeueAfter = r.ReconcilePeriod
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reportDrift sets the Drifted condition on the CR status based on the
+// release resources that differ from the release manifest, and the number of
+// drifted resources in the drift metric. Drift is only logged, counted and
+// reported with an event when the drifted resources change, so that a drift
+// that persists or is corrected on every reconcile is reported once.
+func (r HelmOperatorReconciler) reportDrift(o *unstructured.Unstructured, log logr.Logger, status *types.HelmAppStatus, drifted []release.DriftedResource) {
+	metrics.SetDriftedResources(r.GVK.String(), o.GetNamespace(), o.GetName(), len(drifted))
+
+	condition := types.HelmAppCondition{
+		Type:   types.ConditionDrifted,
+		Status: types.StatusFalse,
+	}
+	var descriptions []string
+	if len(drifted) > 0 {
+		condition.Status = types.StatusTrue
+		condition.Reason = types.ReasonDriftDetected
+		if r.DriftPolicy != watches.DriftPolicyReport {
+			condition.Reason = types.ReasonDriftCorrected
+		}
+		descriptions = make([]string, 0, len(drifted))
+		for _, d := range drifted {
+			descriptions = append(descriptions, d.String())
+		}
+		condition.Message = strings.Join(descriptions, "; ")
+	}
+
+	var previous types.HelmAppCondition
+	for _, c := range status.Conditions {
+		if c.Type == condition.Type {
+			previous = c
+		}
+	}
+	status.SetCondition(condition)
+
+	if len(drifted) == 0 {
+		if previous.Status == types.StatusTrue {
+			log.Info("Release resources no longer drifted")
+		}
+		return
+	}
+	if previous.Status == condition.Status && previous.Reason == condition.Reason && previous.Message == condition.Message {
+		return
+	}
+	log.Info("Detected drifted release resources", "reason", condition.Reason, "resources", descriptions)
+	metrics.DriftDetected(r.GVK.String(), string(condition.Reason))
+	r.EventRecorder.Eventf(o, "Warning", string(condition.Reason), "Release resources differ from the release manifest: %s", condition.Message)
+}
+
+// rollbackRelease rolls the release back to the last deployed revision after
+// a failed update and records the rollback in the CR status. If the rollback
+// succeeds, the failed update is not retried until the CR spec changes.
+func (r HelmOperatorReconciler) rollbackRelease(o *unstructured.Unstructured, log logr.Logger, manager release.Manager, status *types.HelmAppStatus, updateErr error) (reconcile.Result, error) {
+	rolledBackRelease, err := manager.RollbackRelease(context.TODO())
+	if err != nil {
+		log.Error(err, "Failed to roll back release")
+		r.EventRecorder.Eventf(o, "Warning", "RollbackFailed", "Failed to roll back release after failed update: %s", err)
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionReleaseFailed,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonRollbackError,
+			Message: fmt.Sprintf("failed update (%s) and failed rollback: %s", updateErr, err),
+		})
+		_ = r.updateResourceStatus(o, status)
+		return reconcile.Result{}, err
 	}
+
+	if r.releaseHook != nil {
+		if err := r.releaseHook(rolledBackRelease); err != nil {
+			log.Error(err, "Failed to run release hook")
+			return reconcile.Result{}, err
+		}
+	}
+
+	log.Info("Rolled back release", "revision", rolledBackRelease.Version)
+	r.EventRecorder.Eventf(o, "Warning", "RolledBack", "Release %q rolled back to revision %d after failed update: %s", rolledBackRelease.Name, rolledBackRelease.Version, updateErr)
+	status.LastRollback = &types.HelmAppRollback{
+		Revision:     rolledBackRelease.Version,
+		Time:         metav1.Now(),
+		Message:      updateErr.Error(),
+		SpecDigest:   specDigest(o),
+		ValuesDigest: manager.ValuesDigest(),
+		ChartVersion: manager.ChartVersion(),
+	}
+	status.DeployedRelease = &types.HelmAppRelease{
+		Name:     rolledBackRelease.Name,
+		Manifest: manager.RedactManifest(rolledBackRelease.Manifest),
+	}
+	r.addRevision(status, rolledBackRelease, types.ReasonRollbackSuccessful)
 	err = r.updateResourceStatus(o, status)
 	return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, err
 }
 
+// addRevision records a release revision and its outcome in the CR status
+// history, keeping at most MaxHistory entries.
+func (r HelmOperatorReconciler) addRevision(status *types.HelmAppStatus, rel *rpb.Release, outcome types.HelmAppConditionReason) {
+	max := r.MaxHistory
+	if max <= 0 {
+		max = defaultMaxStatusHistory
+	}
+	status.AddRevision(revisionFor(rel, outcome), max)
+}
+
+// revisionFor summarizes a release as a status history entry. The release
+// values are reported as a digest so that they are not exposed in the status.
+func revisionFor(rel *rpb.Release, outcome types.HelmAppConditionReason) types.HelmAppRevision {
+	revision := types.HelmAppRevision{
+		Revision: rel.Version,
+		Time:     metav1.Now(),
+		Outcome:  outcome,
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		revision.ChartVersion = rel.Chart.Metadata.Version
+	}
+	if rel.Info != nil && !rel.Info.LastDeployed.IsZero() {
+		revision.Time = metav1.NewTime(rel.Info.LastDeployed.Time)
+	}
+	if values, err := json.Marshal(rel.Config); err == nil {
+		revision.ValuesDigest = fmt.Sprintf("sha256:%x", sha256.Sum256(values))
+	}
+	return revision
+}
+
+// specDigest returns the digest of the CR spec, which identifies the spec
+// whose release update failed and was rolled back.
+func specDigest(o *unstructured.Unstructured) string {
+	spec, err := json.Marshal(o.Object["spec"])
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(spec))
+}
+
+// isRolledBackUpdate returns true if the update the release manager would
+// perform is the one that failed and was rolled back, i.e. the CR spec, the
+// merged values and the chart version are unchanged since the rollback.
+func isRolledBackUpdate(rollback *types.HelmAppRollback, o *unstructured.Unstructured, manager release.Manager) bool {
+	if rollback == nil || rollback.SpecDigest == "" || rollback.ValuesDigest == "" {
+		return false
+	}
+	return rollback.SpecDigest == specDigest(o) &&
+		rollback.ValuesDigest == manager.ValuesDigest() &&
+		rollback.ChartVersion == manager.ChartVersion()
+}
+
 func (r HelmOperatorReconciler) updateResource(o runtime.Object) error {
 	return r.Client.Update(context.TODO(), o)
 }
@@ -348,6 +698,15 @@ func (r HelmOperatorReconciler) waitForDeletion(o runtime.Object) error {
 	}, ctx.Done())
 }
 
+func isConditionTrue(status *types.HelmAppStatus, conditionType types.HelmAppConditionType) bool {
+	for _, c := range status.Conditions {
+		if c.Type == conditionType {
+			return c.Status == types.StatusTrue
+		}
+	}
+	return false
+}
+
 func contains(l []string, s string) bool {
 	for _, elem := range l {
 		if elem == s {
@@ -0,0 +1,399 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/operator-framework/operator-sdk/pkg/helm/internal/types"
+	"github.com/operator-framework/operator-sdk/pkg/helm/release"
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
+)
+
+// fakeManager is a release.Manager of an installed release that requires an
+// update, whose update and rollback results are set by the test.
+type fakeManager struct {
+	deployed    *rpb.Release
+	updateErr   error
+	rollback    *rpb.Release
+	rollbackErr error
+
+	valuesDigest string
+	chartVersion string
+
+	updated    bool
+	rolledBack bool
+}
+
+func (m *fakeManager) ReleaseName() string            { return m.deployed.Name }
+func (m *fakeManager) IsInstalled() bool              { return true }
+func (m *fakeManager) IsUpdateRequired() bool         { return true }
+func (m *fakeManager) DeployedRelease() *rpb.Release  { return m.deployed }
+func (m *fakeManager) Sync(context.Context) error     { return nil }
+func (m *fakeManager) RedactManifest(s string) string { return s }
+func (m *fakeManager) ValuesDigest() string           { return m.valuesDigest }
+func (m *fakeManager) ChartVersion() string           { return m.chartVersion }
+func (m *fakeManager) InstallRelease(context.Context) (*rpb.Release, error) {
+	return nil, errors.New("unexpected install")
+}
+
+func (m *fakeManager) UpdateRelease(context.Context) (*rpb.Release, *rpb.Release, error) {
+	m.updated = true
+	return nil, nil, m.updateErr
+}
+
+func (m *fakeManager) RollbackRelease(context.Context) (*rpb.Release, error) {
+	m.rolledBack = true
+	return m.rollback, m.rollbackErr
+}
+
+func (m *fakeManager) ReconcileRelease(context.Context) (*rpb.Release, []release.DriftedResource, error) {
+	return m.deployed, nil, nil
+}
+
+func (m *fakeManager) DetectDrift(context.Context) ([]release.DriftedResource, error) {
+	return nil, nil
+}
+
+func (m *fakeManager) CheckReadiness(context.Context, *rpb.Release) ([]release.UnreadyResource, error) {
+	return nil, nil
+}
+
+func (m *fakeManager) UninstallRelease(context.Context, watches.UninstallOptions) (*rpb.Release, error) {
+	return nil, errors.New("unexpected uninstall")
+}
+
+func (m *fakeManager) RedactValues(values map[string]interface{}) map[string]interface{} {
+	return values
+}
+
+type fakeManagerFactory struct {
+	manager release.Manager
+}
+
+func (f fakeManagerFactory) NewManager(*unstructured.Unstructured, map[string]string) (release.Manager, error) {
+	return f.manager, nil
+}
+
+// statusClient converts the status the reconciler sets on a CR to a map, as
+// the API server would, since the fake client can't deep copy it.
+type statusClient struct {
+	client.Client
+}
+
+func (c statusClient) Status() client.StatusWriter {
+	return statusWriter{c.Client.Status()}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+}
+
+func (w statusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		if status, ok := u.Object["status"].(*types.HelmAppStatus); ok {
+			m, err := status.ToMap()
+			if err != nil {
+				return err
+			}
+			u.Object["status"] = m
+		}
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestReconcileRollback(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Nginx"}
+	spec := map[string]interface{}{"replicaCount": int64(3)}
+	newCR := func(lastRollback *types.HelmAppRollback) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		o.SetGroupVersionKind(gvk)
+		o.SetNamespace("default")
+		o.SetName("example")
+		o.SetFinalizers([]string{finalizer})
+		if lastRollback != nil {
+			status, err := (&types.HelmAppStatus{LastRollback: lastRollback}).ToMap()
+			if err != nil {
+				t.Fatalf("Failed to convert status: %v", err)
+			}
+			o.Object["status"] = status
+		}
+		return o
+	}
+	deployed := &rpb.Release{Name: "example", Version: 1, Manifest: "kind: Deployment"}
+	updateErr := errors.New("failed to update release: timed out")
+	newManager := func() *fakeManager {
+		return &fakeManager{deployed: deployed, updateErr: updateErr, rollback: deployed, valuesDigest: "sha256:4567", chartVersion: "0.1.0"}
+	}
+	rollback := func(specDigest, valuesDigest, chartVersion string) *types.HelmAppRollback {
+		return &types.HelmAppRollback{Revision: 1, SpecDigest: specDigest, ValuesDigest: valuesDigest, ChartVersion: chartVersion}
+	}
+
+	testCases := []struct {
+		name             string
+		cr               *unstructured.Unstructured
+		manager          *fakeManager
+		expectErr        bool
+		expectUpdated    bool
+		expectRolledBack bool
+		expectReason     types.HelmAppConditionReason
+		expectRevision   int
+		expectEvent      string
+	}{
+		{
+			name:             "rollback after failed update",
+			cr:               newCR(nil),
+			manager:          newManager(),
+			expectUpdated:    true,
+			expectRolledBack: true,
+			expectReason:     types.ReasonUpdateError,
+			expectRevision:   1,
+			expectEvent:      "RolledBack",
+		},
+		{
+			name:             "failed rollback",
+			cr:               newCR(nil),
+			manager:          &fakeManager{deployed: deployed, updateErr: updateErr, rollbackErr: errors.New("release not found")},
+			expectErr:        true,
+			expectUpdated:    true,
+			expectRolledBack: true,
+			expectReason:     types.ReasonRollbackError,
+			expectEvent:      "RollbackFailed",
+		},
+		{
+			name:           "rolled back update is not retried",
+			cr:             newCR(rollback(specDigest(newCR(nil)), "sha256:4567", "0.1.0")),
+			manager:        newManager(),
+			expectRevision: 1,
+		},
+		{
+			name:             "changed spec is updated again",
+			cr:               newCR(rollback("sha256:0123", "sha256:4567", "0.1.0")),
+			manager:          newManager(),
+			expectUpdated:    true,
+			expectRolledBack: true,
+			expectReason:     types.ReasonUpdateError,
+			expectRevision:   1,
+			expectEvent:      "RolledBack",
+		},
+		{
+			name:             "changed values are updated again",
+			cr:               newCR(rollback(specDigest(newCR(nil)), "sha256:0123", "0.1.0")),
+			manager:          newManager(),
+			expectUpdated:    true,
+			expectRolledBack: true,
+			expectReason:     types.ReasonUpdateError,
+			expectRevision:   1,
+			expectEvent:      "RolledBack",
+		},
+		{
+			name:             "changed chart version is updated again",
+			cr:               newCR(rollback(specDigest(newCR(nil)), "sha256:4567", "0.2.0")),
+			manager:          newManager(),
+			expectUpdated:    true,
+			expectRolledBack: true,
+			expectReason:     types.ReasonUpdateError,
+			expectRevision:   1,
+			expectEvent:      "RolledBack",
+		},
+		{
+			name:             "rollback without values digest is updated again",
+			cr:               newCR(rollback(specDigest(newCR(nil)), "", "")),
+			manager:          newManager(),
+			expectUpdated:    true,
+			expectRolledBack: true,
+			expectReason:     types.ReasonUpdateError,
+			expectRevision:   1,
+			expectEvent:      "RolledBack",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := HelmOperatorReconciler{
+				Client:            statusClient{fakeclient.NewFakeClient(tc.cr)},
+				EventRecorder:     recorder,
+				GVK:               gvk,
+				ManagerFactory:    fakeManagerFactory{manager: tc.manager},
+				ReconcilePeriod:   time.Minute,
+				RollbackOnFailure: true,
+				DriftPolicy:       watches.DriftPolicyIgnore,
+			}
+			request := reconcile.Request{NamespacedName: apitypes.NamespacedName{Namespace: "default", Name: "example"}}
+			_, err := r.Reconcile(request)
+			if tc.expectErr && err == nil {
+				t.Fatalf("Expected error; got no error")
+			}
+			if !tc.expectErr && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.manager.updated != tc.expectUpdated {
+				t.Fatalf("Unexpected update: %t expected: %t", tc.manager.updated, tc.expectUpdated)
+			}
+			if tc.manager.rolledBack != tc.expectRolledBack {
+				t.Fatalf("Unexpected rollback: %t expected: %t", tc.manager.rolledBack, tc.expectRolledBack)
+			}
+
+			o := &unstructured.Unstructured{}
+			o.SetGroupVersionKind(gvk)
+			if err := r.Client.Get(context.TODO(), request.NamespacedName, o); err != nil {
+				t.Fatalf("Failed to get CR: %v", err)
+			}
+			status := types.StatusFor(o)
+			if tc.expectReason != "" {
+				c := status.Conditions[len(status.Conditions)-1]
+				if c.Type != types.ConditionReleaseFailed || c.Reason != tc.expectReason {
+					t.Fatalf("Unexpected condition: %s with reason %s expected: %s with reason %s",
+						c.Type, c.Reason, types.ConditionReleaseFailed, tc.expectReason)
+				}
+			}
+			if tc.expectRevision != 0 {
+				if status.LastRollback == nil || status.LastRollback.Revision != tc.expectRevision {
+					t.Fatalf("Unexpected last rollback: %#v expected revision: %d", status.LastRollback, tc.expectRevision)
+				}
+				if status.LastRollback.SpecDigest != specDigest(o) {
+					t.Fatalf("Unexpected spec digest: %s expected: %s", status.LastRollback.SpecDigest, specDigest(o))
+				}
+				if status.LastRollback.ValuesDigest != tc.manager.valuesDigest {
+					t.Fatalf("Unexpected values digest: %s expected: %s", status.LastRollback.ValuesDigest, tc.manager.valuesDigest)
+				}
+				if status.LastRollback.ChartVersion != tc.manager.chartVersion {
+					t.Fatalf("Unexpected chart version: %s expected: %s", status.LastRollback.ChartVersion, tc.manager.chartVersion)
+				}
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tc.expectEvent == "" || !strings.Contains(event, tc.expectEvent) {
+					t.Fatalf("Unexpected event: %q expected: %q", event, tc.expectEvent)
+				}
+			default:
+				if tc.expectEvent != "" {
+					t.Fatalf("Expected event %q; got no event", tc.expectEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestReportDrift(t *testing.T) {
+	deployment := release.DriftedResource{
+		GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Namespace:        "default",
+		Name:             "example",
+		Paths:            []string{"/spec/replicas"},
+	}
+	service := release.DriftedResource{
+		GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Service"},
+		Namespace:        "default",
+		Name:             "example",
+		Missing:          true,
+	}
+
+	// Each step is a reconcile of the same CR that finds the drifted
+	// resources; an event is only expected when the drift changes.
+	steps := []struct {
+		drifted      []release.DriftedResource
+		expectStatus types.ConditionStatus
+		expectEvent  bool
+	}{
+		{drifted: nil, expectStatus: types.StatusFalse},
+		{drifted: []release.DriftedResource{deployment}, expectStatus: types.StatusTrue, expectEvent: true},
+		{drifted: []release.DriftedResource{deployment}, expectStatus: types.StatusTrue},
+		{drifted: []release.DriftedResource{deployment, service}, expectStatus: types.StatusTrue, expectEvent: true},
+		{drifted: nil, expectStatus: types.StatusFalse},
+		{drifted: nil, expectStatus: types.StatusFalse},
+		{drifted: []release.DriftedResource{deployment}, expectStatus: types.StatusTrue, expectEvent: true},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := HelmOperatorReconciler{
+		EventRecorder: recorder,
+		GVK:           schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Nginx"},
+		DriftPolicy:   watches.DriftPolicyCorrect,
+	}
+	o := &unstructured.Unstructured{}
+	o.SetNamespace("default")
+	o.SetName("example")
+	status := &types.HelmAppStatus{}
+	detected := driftDetected(t, r.GVK.String(), types.ReasonDriftCorrected)
+	for i, step := range steps {
+		r.reportDrift(o, log, status, step.drifted)
+		if step.expectEvent {
+			detected++
+		}
+		if count := driftDetected(t, r.GVK.String(), types.ReasonDriftCorrected); count != detected {
+			t.Fatalf("Step %d: unexpected drift counter: %v expected: %v", i, count, detected)
+		}
+
+		c := status.Conditions[0]
+		if c.Type != types.ConditionDrifted || c.Status != step.expectStatus {
+			t.Fatalf("Step %d: unexpected condition: %s %s expected: %s %s", i, c.Type, c.Status, types.ConditionDrifted, step.expectStatus)
+		}
+		select {
+		case event := <-recorder.Events:
+			if !step.expectEvent {
+				t.Fatalf("Step %d: unexpected event: %q", i, event)
+			}
+			if !strings.Contains(event, string(types.ReasonDriftCorrected)) {
+				t.Fatalf("Step %d: unexpected event: %q expected reason: %s", i, event, types.ReasonDriftCorrected)
+			}
+		default:
+			if step.expectEvent {
+				t.Fatalf("Step %d: expected event; got no event", i)
+			}
+		}
+	}
+}
+
+// driftDetected returns the value of the drift counter of the CRs of type gvk
+// whose drift was reported with reason.
+func driftDetected(t *testing.T, gvk string, reason types.HelmAppConditionReason) float64 {
+	families, err := crmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "helm_operator_drift_detected_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["GVK"] == gvk && labels["reason"] == string(reason) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
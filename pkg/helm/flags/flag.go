@@ -15,6 +15,10 @@
 package flags
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/operator-framework/operator-sdk/internal/flags/watch"
 	"github.com/operator-framework/operator-sdk/pkg/log/zap"
 	"github.com/spf13/pflag"
@@ -23,6 +27,7 @@ import (
 // HelmOperatorFlags - Options to be used by a helm operator
 type HelmOperatorFlags struct {
 	watch.WatchFlags
+	ChartCacheDir string
 }
 
 // AddTo - Add the helm operator flags to the the flagset
@@ -31,5 +36,12 @@ func AddTo(flagSet *pflag.FlagSet, helpTextPrefix ...string) *HelmOperatorFlags
 	hof := &HelmOperatorFlags{}
 	hof.WatchFlags.AddTo(flagSet, helpTextPrefix...)
 	flagSet.AddFlagSet(zap.FlagSet())
+	flagSet.StringVar(&hof.ChartCacheDir,
+		"chart-cache-dir",
+		filepath.Join(os.TempDir(), "helm-operator-charts"),
+		strings.Join(append(helpTextPrefix,
+			"Directory in which charts fetched from chart repositories and OCI registries are cached"),
+			" "),
+	)
 	return hof
 }
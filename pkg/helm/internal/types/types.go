@@ -55,11 +55,37 @@ type HelmAppRelease struct {
 	Manifest string `json:"manifest,omitempty"`
 }
 
+// HelmAppRevision summarizes a single release revision attempted by the
+// operator.
+type HelmAppRevision struct {
+	Revision     int                    `json:"revision,omitempty"`
+	ChartVersion string                 `json:"chartVersion,omitempty"`
+	ValuesDigest string                 `json:"valuesDigest,omitempty"`
+	Time         metav1.Time            `json:"time,omitempty"`
+	Outcome      HelmAppConditionReason `json:"outcome,omitempty"`
+}
+
+// HelmAppRollback records a rollback performed by the operator after a
+// failed release update. SpecDigest, ValuesDigest and ChartVersion identify
+// the CR spec, the merged values and the chart version of the failed update;
+// the update is not retried until one of them changes.
+type HelmAppRollback struct {
+	Revision     int         `json:"revision,omitempty"`
+	Time         metav1.Time `json:"time,omitempty"`
+	Message      string      `json:"message,omitempty"`
+	SpecDigest   string      `json:"specDigest,omitempty"`
+	ValuesDigest string      `json:"valuesDigest,omitempty"`
+	ChartVersion string      `json:"chartVersion,omitempty"`
+}
+
 const (
-	ConditionInitialized    HelmAppConditionType = "Initialized"
-	ConditionDeployed       HelmAppConditionType = "Deployed"
-	ConditionReleaseFailed  HelmAppConditionType = "ReleaseFailed"
-	ConditionIrreconcilable HelmAppConditionType = "Irreconcilable"
+	ConditionInitialized     HelmAppConditionType = "Initialized"
+	ConditionDeployed        HelmAppConditionType = "Deployed"
+	ConditionReleaseFailed   HelmAppConditionType = "ReleaseFailed"
+	ConditionIrreconcilable  HelmAppConditionType = "Irreconcilable"
+	ConditionDrifted         HelmAppConditionType = "Drifted"
+	ConditionProgressing     HelmAppConditionType = "Progressing"
+	ConditionDeletionBlocked HelmAppConditionType = "DeletionBlocked"
 
 	StatusTrue    ConditionStatus = "True"
 	StatusFalse   ConditionStatus = "False"
@@ -68,15 +94,25 @@ const (
 	ReasonInstallSuccessful   HelmAppConditionReason = "InstallSuccessful"
 	ReasonUpdateSuccessful    HelmAppConditionReason = "UpdateSuccessful"
 	ReasonUninstallSuccessful HelmAppConditionReason = "UninstallSuccessful"
+	ReasonRollbackSuccessful  HelmAppConditionReason = "RollbackSuccessful"
 	ReasonInstallError        HelmAppConditionReason = "InstallError"
 	ReasonUpdateError         HelmAppConditionReason = "UpdateError"
 	ReasonReconcileError      HelmAppConditionReason = "ReconcileError"
 	ReasonUninstallError      HelmAppConditionReason = "UninstallError"
+	ReasonRollbackError       HelmAppConditionReason = "RollbackError"
+	ReasonDriftDetected       HelmAppConditionReason = "DriftDetected"
+	ReasonDriftCorrected      HelmAppConditionReason = "DriftCorrected"
+	ReasonResourcesNotReady   HelmAppConditionReason = "ResourcesNotReady"
+	ReasonResourcesReady      HelmAppConditionReason = "ResourcesReady"
+	ReasonReadinessTimeout    HelmAppConditionReason = "ReadinessTimeout"
+	ReasonPreDeleteHookFailed HelmAppConditionReason = "PreDeleteHookFailed"
 )
 
 type HelmAppStatus struct {
 	Conditions      []HelmAppCondition `json:"conditions"`
 	DeployedRelease *HelmAppRelease    `json:"deployedRelease,omitempty"`
+	LastRollback    *HelmAppRollback   `json:"lastRollback,omitempty"`
+	History         []HelmAppRevision  `json:"history,omitempty"`
 }
 
 func (s *HelmAppStatus) ToMap() (map[string]interface{}, error) {
@@ -129,6 +165,17 @@ func (s *HelmAppStatus) RemoveCondition(conditionType HelmAppConditionType) *Hel
 	return s
 }
 
+// AddRevision appends a revision to the status history, dropping the oldest
+// entries so that at most max revisions are kept. AddRevision does not update
+// the resource in the cluster.
+func (s *HelmAppStatus) AddRevision(revision HelmAppRevision, max int) *HelmAppStatus {
+	s.History = append(s.History, revision)
+	if max > 0 && len(s.History) > max {
+		s.History = s.History[len(s.History)-max:]
+	}
+	return s
+}
+
 // StatusFor safely returns a typed status block from a custom resource.
 func StatusFor(cr *unstructured.Unstructured) *HelmAppStatus {
 	switch s := cr.Object["status"].(type) {
@@ -59,6 +59,26 @@ func TestRemoveCondition(t *testing.T) {
 	assert.Empty(t, actual.Conditions)
 }
 
+func TestAddRevision(t *testing.T) {
+	status := newTestStatus()
+	for i := 1; i <= 5; i++ {
+		status.AddRevision(HelmAppRevision{Revision: i, Outcome: ReasonUpdateSuccessful}, 3)
+	}
+
+	assert.Len(t, status.History, 3)
+	assert.Equal(t, 3, status.History[0].Revision)
+	assert.Equal(t, 5, status.History[2].Revision)
+}
+
+func TestAddRevisionUnbounded(t *testing.T) {
+	status := newTestStatus()
+	for i := 1; i <= 5; i++ {
+		status.AddRevision(HelmAppRevision{Revision: i, Outcome: ReasonUpdateSuccessful}, 0)
+	}
+
+	assert.Len(t, status.History, 5)
+}
+
 func TestStatusForEmpty(t *testing.T) {
 	status := StatusFor(newTestResource())
 
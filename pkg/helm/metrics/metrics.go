@@ -0,0 +1,89 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	subsystem = "helm_operator"
+)
+
+var (
+	driftedResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "drifted_resources",
+			Help:      "Gauge of the release resources of a CR that differ from their release manifest.",
+		},
+		[]string{
+			"GVK",
+			"namespace",
+			"name",
+		})
+
+	driftDetected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "drift_detected_total",
+			Help:      "Counter of the changes of the drifted release resources of CRs, by whether they were corrected.",
+		},
+		[]string{
+			"GVK",
+			"reason",
+		})
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftedResources)
+	metrics.Registry.MustRegister(driftDetected)
+}
+
+// We will never want to panic our app because of metric saving.
+// Therefore, we will recover our panics here and error log them
+// for later diagnosis but will never fail the app.
+func recoverMetricPanic() {
+	if r := recover(); r != nil {
+		logf.Log.WithName("metrics").Error(fmt.Errorf("%v", r),
+			"Recovering from metric function")
+	}
+}
+
+// SetDriftedResources sets the number of drifted release resources of the CR
+// of type gvk with the given namespace and name.
+func SetDriftedResources(gvk, namespace, name string, count int) {
+	defer recoverMetricPanic()
+	driftedResources.WithLabelValues(gvk, namespace, name).Set(float64(count))
+}
+
+// DeleteDriftedResources removes the drifted release resources of the CR of
+// type gvk with the given namespace and name, once its drift is no longer
+// checked.
+func DeleteDriftedResources(gvk, namespace, name string) {
+	defer recoverMetricPanic()
+	driftedResources.DeleteLabelValues(gvk, namespace, name)
+}
+
+// DriftDetected counts a change of the drifted release resources of a CR of
+// type gvk, with the reason of its Drifted condition.
+func DriftDetected(gvk, reason string) {
+	defer recoverMetricPanic()
+	driftDetected.WithLabelValues(gvk, reason).Inc()
+}
@@ -0,0 +1,313 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cpb "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/repo"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("helm.release")
+
+// ChartLoader loads the chart that is released for custom resources.
+type ChartLoader interface {
+	Load() (*cpb.Chart, error)
+}
+
+type dirChartLoader string
+
+// NewDirChartLoader returns a ChartLoader that loads a chart from a local
+// directory.
+func NewDirChartLoader(dir string) ChartLoader {
+	return dirChartLoader(dir)
+}
+
+func (d dirChartLoader) Load() (*cpb.Chart, error) {
+	c, err := loader.LoadDir(string(d))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart dir: %w", err)
+	}
+	return c, nil
+}
+
+// OCIScheme is the URL scheme that identifies OCI registry repositories in
+// ChartSource.Repository.
+const OCIScheme = "oci://"
+
+// ChartSource identifies a chart in a chart repository or an OCI registry.
+type ChartSource struct {
+	// Repository is the URL of a chart repository, or the URL of an OCI
+	// registry repository prefixed with "oci://".
+	Repository string
+
+	// Name is the name of the chart.
+	Name string
+
+	// Version is a semantic version constraint for charts in a chart
+	// repository, or the tag of a chart in an OCI registry.
+	Version string
+
+	// Keyring is the path to a keyring used to verify the chart against its
+	// provenance file. If empty, the chart's provenance is not verified.
+	Keyring string
+}
+
+// IsOCI returns true if the chart source is an OCI registry.
+func (s ChartSource) IsOCI() bool {
+	return strings.HasPrefix(s.Repository, OCIScheme)
+}
+
+// chartRefreshInterval is how often a remote chart loader resolves the chart
+// version again so that new chart versions matching the version constraint
+// are picked up.
+const chartRefreshInterval = 5 * time.Minute
+
+// chartFetchTimeout bounds each request of a remote chart loader to a chart
+// repository or an OCI registry.
+const chartFetchTimeout = time.Minute
+
+type remoteChartLoader struct {
+	source   ChartSource
+	cacheDir string
+	client   *http.Client
+
+	// fetchMu serializes fetches of the chart. mu guards the fields below
+	// and is never held during a fetch.
+	fetchMu    sync.Mutex
+	mu         sync.Mutex
+	chartPath  string
+	resolvedAt time.Time
+	fetching   bool
+}
+
+// NewRemoteChartLoader returns a ChartLoader that fetches a chart from a chart
+// repository or an OCI registry and caches chart archives in cacheDir. The
+// chart version is resolved again every few minutes; if the source cannot be
+// reached, the previously fetched chart is used.
+func NewRemoteChartLoader(source ChartSource, cacheDir string) ChartLoader {
+	return &remoteChartLoader{
+		source:   source,
+		cacheDir: filepath.Join(cacheDir, cacheKey(source.Repository)),
+		client:   &http.Client{Timeout: chartFetchTimeout},
+	}
+}
+
+func (l *remoteChartLoader) Load() (*cpb.Chart, error) {
+	chartPath, err := l.resolve()
+	if err != nil {
+		return nil, err
+	}
+	c, err := loader.LoadFile(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart archive %s: %w", chartPath, err)
+	}
+	return c, nil
+}
+
+// resolve returns the path of the cached chart archive, fetching the chart
+// first if it was not fetched yet or was resolved too long ago. While the
+// chart is refreshed, other callers use the previously fetched chart instead
+// of waiting for the refresh.
+func (l *remoteChartLoader) resolve() (string, error) {
+	l.mu.Lock()
+	if l.chartPath != "" && (l.fetching || time.Since(l.resolvedAt) <= chartRefreshInterval) {
+		defer l.mu.Unlock()
+		return l.chartPath, nil
+	}
+	l.mu.Unlock()
+
+	l.fetchMu.Lock()
+	defer l.fetchMu.Unlock()
+	l.mu.Lock()
+	// The chart may have been fetched while waiting for fetchMu.
+	if l.chartPath != "" && time.Since(l.resolvedAt) <= chartRefreshInterval {
+		defer l.mu.Unlock()
+		return l.chartPath, nil
+	}
+	l.fetching = true
+	l.mu.Unlock()
+
+	chartPath, err := l.fetch()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fetching = false
+	l.resolvedAt = time.Now()
+	if err != nil {
+		if l.chartPath == "" {
+			return "", err
+		}
+		log.Error(err, "Failed to refresh chart, using previously fetched chart",
+			"repository", l.source.Repository, "chart", l.source.Name, "path", l.chartPath)
+		return l.chartPath, nil
+	}
+	l.chartPath = chartPath
+	return l.chartPath, nil
+}
+
+func (l *remoteChartLoader) fetch() (string, error) {
+	if err := os.MkdirAll(l.cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create chart cache dir: %w", err)
+	}
+	if l.source.IsOCI() {
+		return l.fetchFromRegistry()
+	}
+	return l.fetchFromRepository()
+}
+
+// fetchFromRepository resolves the chart version from the repository index,
+// downloads the chart archive into the cache unless it is already cached,
+// and returns the path of the cached archive.
+func (l *remoteChartLoader) fetchFromRepository() (string, error) {
+	indexURL := strings.TrimSuffix(l.source.Repository, "/") + "/index.yaml"
+	indexPath := filepath.Join(l.cacheDir, "index.yaml")
+	if err := l.download(indexURL, indexPath, ""); err != nil {
+		return "", fmt.Errorf("failed to download repository index: %w", err)
+	}
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to load repository index: %w", err)
+	}
+	cv, err := index.Get(l.source.Name, l.source.Version)
+	if err != nil {
+		return "", fmt.Errorf("chart %q version %q not found in repository %s: %w", l.source.Name, l.source.Version, l.source.Repository, err)
+	}
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("chart %q version %q has no downloadable URLs", cv.Name, cv.Version)
+	}
+	chartURL, err := repo.ResolveReferenceURL(l.source.Repository, cv.URLs[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve chart URL: %w", err)
+	}
+
+	chartPath := filepath.Join(l.cacheDir, fmt.Sprintf("%s-%s.tgz", cv.Name, cv.Version))
+	if !isCached(chartPath, cv.Digest) {
+		if err := l.download(chartURL, chartPath, cv.Digest); err != nil {
+			return "", fmt.Errorf("failed to download chart: %w", err)
+		}
+	}
+	if err := l.verify(chartPath, func() error {
+		return l.download(chartURL+".prov", chartPath+".prov", "")
+	}); err != nil {
+		return "", err
+	}
+	return chartPath, nil
+}
+
+// verify verifies the chart archive at chartPath against its provenance
+// file if a keyring is configured. fetchProvenance is called to write the
+// provenance file next to the chart archive.
+func (l *remoteChartLoader) verify(chartPath string, fetchProvenance func() error) error {
+	if l.source.Keyring == "" {
+		return nil
+	}
+	if err := fetchProvenance(); err != nil {
+		return fmt.Errorf("failed to fetch provenance file: %w", err)
+	}
+	if _, err := downloader.VerifyChart(chartPath, l.source.Keyring); err != nil {
+		// Remove the archive so that it is not trusted on the next fetch.
+		_ = os.Remove(chartPath)
+		return fmt.Errorf("failed to verify chart provenance: %w", err)
+	}
+	return nil
+}
+
+// download fetches url and atomically writes the response body to dest. If
+// digest is not empty, the sha256 digest of the body must match it.
+func (l *remoteChartLoader) download(url, dest, digest string) error {
+	resp, err := l.get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return writeFile(resp.Body, dest, digest)
+}
+
+func (l *remoteChartLoader) get(url string) (*http.Response, error) {
+	resp, err := l.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func writeFile(r io.Reader, dest, digest string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if digest != "" {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != trimDigest(digest) {
+			return fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, actual)
+		}
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// isCached returns true if the file at path exists and, if digest is not
+// empty, its sha256 digest matches digest.
+func isCached(path, digest string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if digest == "" {
+		return true
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == trimDigest(digest)
+}
+
+func trimDigest(digest string) string {
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+func cacheKey(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Media types used by Helm to store charts in OCI registries.
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+	// Helm 3.0 stores the chart archive with the legacy media type; later
+	// versions use the chart content media type.
+	chartLayerMediaTypeLegacy = "application/tar+gzip"
+	chartLayerMediaType       = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	provLayerMediaType        = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+var errNoChartLayer = errors.New("manifest does not contain a chart layer")
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// fetchFromRegistry pulls the chart manifest for the configured tag,
+// downloads the chart layer into the cache unless it is already cached, and
+// returns the path of the cached archive. Registries that require a bearer
+// token are supported for anonymous pulls.
+func (l *remoteChartLoader) fetchFromRegistry() (string, error) {
+	host, repository, err := parseOCIRepository(l.source.Repository, l.source.Name)
+	if err != nil {
+		return "", err
+	}
+	baseURL := fmt.Sprintf("https://%s/v2/%s", host, repository)
+
+	resp, err := l.registryGet(fmt.Sprintf("%s/manifests/%s", baseURL, l.source.Version), ociManifestMediaType)
+	if err != nil {
+		return "", fmt.Errorf("failed to get chart manifest: %w", err)
+	}
+	var manifest ociManifest
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to decode chart manifest: %w", err)
+	}
+
+	var chartLayer, provLayer *ociDescriptor
+	for i, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case chartLayerMediaType, chartLayerMediaTypeLegacy:
+			chartLayer = &manifest.Layers[i]
+		case provLayerMediaType:
+			provLayer = &manifest.Layers[i]
+		}
+	}
+	if chartLayer == nil {
+		return "", errNoChartLayer
+	}
+
+	chartPath := filepath.Join(l.cacheDir, fmt.Sprintf("%s-%s.tgz", l.source.Name, trimDigest(chartLayer.Digest)))
+	if !isCached(chartPath, chartLayer.Digest) {
+		if err := l.downloadBlob(baseURL, *chartLayer, chartPath); err != nil {
+			return "", fmt.Errorf("failed to download chart: %w", err)
+		}
+	}
+	if err := l.verify(chartPath, func() error {
+		if provLayer == nil {
+			return errors.New("manifest does not contain a provenance layer")
+		}
+		return l.downloadBlob(baseURL, *provLayer, chartPath+".prov")
+	}); err != nil {
+		return "", err
+	}
+	return chartPath, nil
+}
+
+func (l *remoteChartLoader) downloadBlob(baseURL string, layer ociDescriptor, dest string) error {
+	resp, err := l.registryGet(fmt.Sprintf("%s/blobs/%s", baseURL, layer.Digest), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return writeFile(resp.Body, dest, layer.Digest)
+}
+
+// registryGet performs a GET request against the registry. If the registry
+// responds with a bearer token challenge, an anonymous token is requested
+// from the challenge's realm and the request is retried with it.
+func (l *remoteChartLoader) registryGet(u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		token, err := l.bearerToken(challenge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate with registry: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if resp, err = l.client.Do(req); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+func (l *remoteChartLoader) bearerToken(challenge string) (string, error) {
+	params, ok := parseBearerChallenge(challenge)
+	if !ok || params["realm"] == "" {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	q := tokenURL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v := params[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+	tokenURL.RawQuery = q.Encode()
+
+	resp, err := l.get(tokenURL.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", errors.New("token response does not contain a token")
+}
+
+// parseBearerChallenge parses a WWW-Authenticate header value of the form
+// `Bearer realm="...",service="...",scope="..."`.
+func parseBearerChallenge(challenge string) (map[string]string, bool) {
+	const prefix = "bearer "
+	if len(challenge) < len(prefix) || !strings.EqualFold(challenge[:len(prefix)], prefix) {
+		return nil, false
+	}
+	params := map[string]string{}
+	rest := challenge[len(prefix):]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(strings.TrimLeft(rest[:eq], ", ")))
+		rest = rest[eq+1:]
+
+		// Values may be quoted and quoted values may contain commas, e.g.
+		// scope="repository:charts/nginx:pull,push".
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, false
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return params, true
+}
+
+// parseOCIRepository splits an "oci://host/path" repository URL into the
+// registry host and the repository path of the named chart.
+func parseOCIRepository(repository, name string) (string, string, error) {
+	ref := strings.TrimRight(strings.TrimPrefix(repository, OCIScheme), "/")
+	parts := strings.SplitN(ref, "/", 2)
+	if parts[0] == "" {
+		return "", "", fmt.Errorf("invalid OCI repository %q: missing registry host", repository)
+	}
+	path := name
+	if len(parts) == 2 && parts[1] != "" {
+		path = parts[1] + "/" + name
+	}
+	return parts[0], path, nil
+}
@@ -0,0 +1,393 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const testChartDir = "../../../internal/scaffold/helm/testdata/testcharts/test-chart"
+
+// packageTestChart packages the test chart into dir and returns the path of
+// the chart archive.
+func packageTestChart(t *testing.T, dir string) string {
+	c, err := loader.LoadDir(testChartDir)
+	if err != nil {
+		t.Fatalf("Failed to load test chart: %v", err)
+	}
+	path, err := chartutil.Save(c, dir)
+	if err != nil {
+		t.Fatalf("Failed to package test chart: %v", err)
+	}
+	return path
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "helm-chart-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	return dir
+}
+
+func TestRemoteChartLoaderRepository(t *testing.T) {
+	repoDir := tempDir(t)
+	defer os.RemoveAll(repoDir)
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	packageTestChart(t, repoDir)
+	server := httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+	defer server.Close()
+
+	index, err := repo.IndexDirectory(repoDir, server.URL)
+	if err != nil {
+		t.Fatalf("Failed to index repository: %v", err)
+	}
+	if err := index.WriteFile(filepath.Join(repoDir, "index.yaml"), 0644); err != nil {
+		t.Fatalf("Failed to write repository index: %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		source    ChartSource
+		expectErr bool
+	}{
+		{
+			name:   "latest version",
+			source: ChartSource{Repository: server.URL, Name: "test-chart"},
+		},
+		{
+			name:   "version constraint",
+			source: ChartSource{Repository: server.URL + "/", Name: "test-chart", Version: ">=1.0.0 <2.0.0"},
+		},
+		{
+			name:      "unsatisfiable version constraint",
+			source:    ChartSource{Repository: server.URL, Name: "test-chart", Version: ">=2.0.0"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown chart",
+			source:    ChartSource{Repository: server.URL, Name: "nonexistent"},
+			expectErr: true,
+		},
+		{
+			name:      "missing provenance file",
+			source:    ChartSource{Repository: server.URL, Name: "test-chart", Keyring: filepath.Join(repoDir, "pubring.gpg")},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewRemoteChartLoader(tc.source, cacheDir).Load()
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error; got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+			if c.Name() != "test-chart" {
+				t.Fatalf("Expected chart %q; got %q", "test-chart", c.Name())
+			}
+		})
+	}
+}
+
+func TestRemoteChartLoaderRepositoryFallback(t *testing.T) {
+	repoDir := tempDir(t)
+	defer os.RemoveAll(repoDir)
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	packageTestChart(t, repoDir)
+	server := httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+	index, err := repo.IndexDirectory(repoDir, server.URL)
+	if err != nil {
+		t.Fatalf("Failed to index repository: %v", err)
+	}
+	if err := index.WriteFile(filepath.Join(repoDir, "index.yaml"), 0644); err != nil {
+		t.Fatalf("Failed to write repository index: %v", err)
+	}
+
+	l := NewRemoteChartLoader(ChartSource{Repository: server.URL, Name: "test-chart"}, cacheDir).(*remoteChartLoader)
+	if _, err := l.Load(); err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+
+	// Once the repository is unreachable, the cached chart is still used.
+	server.Close()
+	l.resolvedAt = l.resolvedAt.Add(-2 * chartRefreshInterval)
+	if _, err := l.Load(); err != nil {
+		t.Fatalf("Expected cached chart to be used; got error: %v", err)
+	}
+}
+
+func TestRemoteChartLoaderRefresh(t *testing.T) {
+	repoDir := tempDir(t)
+	defer os.RemoveAll(repoDir)
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	packageTestChart(t, repoDir)
+	blocked, unblock := make(chan struct{}), make(chan struct{})
+	refreshing := false
+	files := http.FileServer(http.Dir(repoDir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refreshing && r.URL.Path == "/index.yaml" {
+			close(blocked)
+			<-unblock
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	index, err := repo.IndexDirectory(repoDir, server.URL)
+	if err != nil {
+		t.Fatalf("Failed to index repository: %v", err)
+	}
+	if err := index.WriteFile(filepath.Join(repoDir, "index.yaml"), 0644); err != nil {
+		t.Fatalf("Failed to write repository index: %v", err)
+	}
+
+	l := NewRemoteChartLoader(ChartSource{Repository: server.URL, Name: "test-chart"}, cacheDir).(*remoteChartLoader)
+	if l.client.Timeout == 0 {
+		t.Fatalf("Expected the client of the loader to have a timeout")
+	}
+	if _, err := l.Load(); err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+
+	// While the chart is refreshed, the previously fetched chart is loaded
+	// without waiting for the refresh.
+	refreshing = true
+	l.mu.Lock()
+	l.resolvedAt = l.resolvedAt.Add(-2 * chartRefreshInterval)
+	l.mu.Unlock()
+	refreshed := make(chan error)
+	go func() {
+		_, err := l.Load()
+		refreshed <- err
+	}()
+	<-blocked
+	if _, err := l.Load(); err != nil {
+		t.Fatalf("Expected the previously fetched chart to be used; got error: %v", err)
+	}
+	close(unblock)
+	if err := <-refreshed; err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+}
+
+func TestRemoteChartLoaderRegistry(t *testing.T) {
+	chartDir := tempDir(t)
+	defer os.RemoveAll(chartDir)
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	chart, err := ioutil.ReadFile(packageTestChart(t, chartDir))
+	if err != nil {
+		t.Fatalf("Failed to read chart archive: %v", err)
+	}
+	sum := sha256.Sum256(chart)
+	chartDigest := "sha256:" + hex.EncodeToString(sum[:])
+	manifest, err := json.Marshal(ociManifest{
+		Layers: []ociDescriptor{{MediaType: chartLayerMediaTypeLegacy, Digest: chartDigest}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+
+	const token = "test-token"
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "repository:charts/test-chart:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"token": %q}`, token)
+	})
+	mux.HandleFunc("/v2/charts/test-chart/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry",scope="repository:charts/test-chart:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/charts/test-chart/manifests/1.2.3":
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			_, _ = w.Write(manifest)
+		case "/v2/charts/test-chart/blobs/" + chartDigest:
+			_, _ = w.Write(chart)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	server = httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	repository := OCIScheme + strings.TrimPrefix(server.URL, "https://") + "/charts"
+	testCases := []struct {
+		name      string
+		source    ChartSource
+		expectErr bool
+	}{
+		{
+			name:   "tagged chart",
+			source: ChartSource{Repository: repository, Name: "test-chart", Version: "1.2.3"},
+		},
+		{
+			name:      "unknown tag",
+			source:    ChartSource{Repository: repository, Name: "test-chart", Version: "0.2.0"},
+			expectErr: true,
+		},
+		{
+			name:      "missing provenance layer",
+			source:    ChartSource{Repository: repository, Name: "test-chart", Version: "1.2.3", Keyring: filepath.Join(chartDir, "pubring.gpg")},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewRemoteChartLoader(tc.source, cacheDir).(*remoteChartLoader)
+			l.client = server.Client()
+			c, err := l.Load()
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error; got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+			if c.Name() != "test-chart" {
+				t.Fatalf("Expected chart %q; got %q", "test-chart", c.Name())
+			}
+		})
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	testCases := []struct {
+		name         string
+		challenge    string
+		expectParams map[string]string
+		expectOK     bool
+	}{
+		{
+			name:      "quoted values",
+			challenge: `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts/nginx:pull,push"`,
+			expectParams: map[string]string{
+				"realm":   "https://auth.example.com/token",
+				"service": "registry.example.com",
+				"scope":   "repository:charts/nginx:pull,push",
+			},
+			expectOK: true,
+		},
+		{
+			name:         "unquoted values",
+			challenge:    `bearer realm=https://auth.example.com/token, service=registry`,
+			expectParams: map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+			expectOK:     true,
+		},
+		{
+			name:      "basic challenge",
+			challenge: `Basic realm="registry"`,
+		},
+		{
+			name:      "unterminated quote",
+			challenge: `Bearer realm="https://auth.example.com/token`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params, ok := parseBearerChallenge(tc.challenge)
+			if ok != tc.expectOK {
+				t.Fatalf("Expected ok %t; got %t", tc.expectOK, ok)
+			}
+			if ok && !reflect.DeepEqual(params, tc.expectParams) {
+				t.Fatalf("Expected params %v; got %v", tc.expectParams, params)
+			}
+		})
+	}
+}
+
+func TestParseOCIRepository(t *testing.T) {
+	testCases := []struct {
+		name             string
+		repository       string
+		expectHost       string
+		expectRepository string
+		expectErr        bool
+	}{
+		{
+			name:             "registry root",
+			repository:       "oci://registry.example.com",
+			expectHost:       "registry.example.com",
+			expectRepository: "nginx",
+		},
+		{
+			name:             "nested repository",
+			repository:       "oci://registry.example.com:5000/org/charts/",
+			expectHost:       "registry.example.com:5000",
+			expectRepository: "org/charts/nginx",
+		},
+		{
+			name:       "missing host",
+			repository: "oci:///charts",
+			expectErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			host, repository, err := parseOCIRepository(tc.repository, "nginx")
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error; got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+			if host != tc.expectHost || repository != tc.expectRepository {
+				t.Fatalf("Expected %s/%s; got %s/%s", tc.expectHost, tc.expectRepository, host, repository)
+			}
+		})
+	}
+}
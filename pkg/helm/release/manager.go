@@ -17,6 +17,7 @@ package release
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,17 +27,20 @@ import (
 	cpb "helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/kube"
 	rpb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/rest"
 
 	"github.com/mattbaird/jsonpatch"
 	"github.com/operator-framework/operator-sdk/pkg/helm/internal/types"
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
 )
 
 // Manager manages a Helm release. It can install, update, reconcile,
@@ -45,23 +49,61 @@ type Manager interface {
 	ReleaseName() string
 	IsInstalled() bool
 	IsUpdateRequired() bool
+	DeployedRelease() *rpb.Release
 	Sync(context.Context) error
 	InstallRelease(context.Context) (*rpb.Release, error)
 	UpdateRelease(context.Context) (*rpb.Release, *rpb.Release, error)
-	ReconcileRelease(context.Context) (*rpb.Release, error)
-	UninstallRelease(context.Context) (*rpb.Release, error)
+	RollbackRelease(context.Context) (*rpb.Release, error)
+	ReconcileRelease(context.Context) (*rpb.Release, []DriftedResource, error)
+	DetectDrift(context.Context) ([]DriftedResource, error)
+	CheckReadiness(context.Context, *rpb.Release) ([]UnreadyResource, error)
+	UninstallRelease(context.Context, watches.UninstallOptions) (*rpb.Release, error)
+	RedactManifest(string) string
+	RedactValues(map[string]interface{}) map[string]interface{}
+	ValuesDigest() string
+	ChartVersion() string
+}
+
+// DriftedResource describes a release resource whose live state differs from
+// the deployed release's manifest.
+type DriftedResource struct {
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+
+	// Missing is true if the resource does not exist in the cluster.
+	Missing bool
+
+	// Paths are the JSON pointers of the fields that differ from the
+	// manifest.
+	Paths []string
+}
+
+// String returns a human-readable description of the drifted resource.
+func (d DriftedResource) String() string {
+	name := d.Name
+	if d.Namespace != "" {
+		name = d.Namespace + "/" + name
+	}
+	if d.Missing {
+		return fmt.Sprintf("%s %s: missing", d.GroupVersionKind.Kind, name)
+	}
+	return fmt.Sprintf("%s %s: %s", d.GroupVersionKind.Kind, name, strings.Join(d.Paths, ", "))
 }
 
 type manager struct {
 	actionConfig   *action.Configuration
 	storageBackend *storage.Storage
 	kubeClient     kube.Interface
+	ownerRef       metav1.OwnerReference
 
 	releaseName string
 	namespace   string
+	maxHistory  int
 
-	values map[string]interface{}
-	status *types.HelmAppStatus
+	values   map[string]interface{}
+	redactor *redactor
+	status   *types.HelmAppStatus
 
 	isInstalled      bool
 	isUpdateRequired bool
@@ -82,6 +124,45 @@ func (m manager) IsUpdateRequired() bool {
 	return m.isUpdateRequired
 }
 
+// DeployedRelease returns the release that was deployed when the manager
+// was synced, or nil if the release is not installed.
+func (m manager) DeployedRelease() *rpb.Release {
+	return m.deployedRelease
+}
+
+// RedactManifest replaces the data of Secrets, and the fields equal to the
+// values read from Secrets referenced in the CR's valuesFrom field or their
+// base64 encodings, in a release manifest.
+func (m manager) RedactManifest(manifest string) string {
+	return m.redactor.redactString(manifest)
+}
+
+// RedactValues returns a copy of values in which the values at the paths read
+// from Secrets referenced in the CR's valuesFrom field are replaced.
+func (m manager) RedactValues(values map[string]interface{}) map[string]interface{} {
+	return m.redactor.redactValues(values)
+}
+
+// ValuesDigest returns the digest of the values the release is installed or
+// updated with, after the CR values were merged with the values read from
+// valuesFrom and valuesMapping and the override values.
+func (m manager) ValuesDigest() string {
+	values, err := json.Marshal(m.values)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(values))
+}
+
+// ChartVersion returns the version of the chart the release is installed or
+// updated with.
+func (m manager) ChartVersion() string {
+	if m.chart == nil || m.chart.Metadata == nil {
+		return ""
+	}
+	return m.chart.Metadata.Version
+}
+
 // Sync ensures the Helm storage backend is in sync with the status of the
 // custom resource.
 func (m *manager) Sync(ctx context.Context) error {
@@ -103,6 +184,12 @@ func (m *manager) Sync(ctx context.Context) error {
 		}
 	}
 
+	// Trim the release history down to the configured maximum so that stale
+	// release secrets do not accumulate in the namespace.
+	if err := m.trimHistory(); err != nil {
+		return fmt.Errorf("failed to trim release history: %w", err)
+	}
+
 	// Load the most recently deployed release from the storage backend.
 	deployedRelease, err := m.getDeployedRelease()
 	if errors.Is(err, driver.ErrReleaseNotFound) {
@@ -126,6 +213,35 @@ func (m *manager) Sync(ctx context.Context) error {
 	return nil
 }
 
+// trimHistory deletes the oldest release versions until at most maxHistory
+// versions remain. The deployed release version is never deleted.
+func (m manager) trimHistory() error {
+	if m.maxHistory <= 0 {
+		return nil
+	}
+	releases, err := m.storageBackend.History(m.releaseName)
+	if err != nil {
+		if notFoundErr(err) {
+			return nil
+		}
+		return err
+	}
+	if len(releases) <= m.maxHistory {
+		return nil
+	}
+
+	releaseutil.SortByRevision(releases)
+	for _, rel := range releases[:len(releases)-m.maxHistory] {
+		if rel.Info != nil && rel.Info.Status == rpb.StatusDeployed {
+			continue
+		}
+		if _, err := m.storageBackend.Delete(rel.Name, rel.Version); err != nil && !notFoundErr(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func notFoundErr(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "not found")
 }
@@ -144,11 +260,13 @@ func (m manager) getDeployedRelease() (*rpb.Release, error) {
 func (m manager) getCandidateRelease(namespace, name string, chart *cpb.Chart, values map[string]interface{}) (*rpb.Release, error) {
 	upgrade := action.NewUpgrade(m.actionConfig)
 	upgrade.Namespace = namespace
+	upgrade.MaxHistory = m.maxHistory
 	upgrade.DryRun = true
 	return upgrade.Run(name, chart, values)
 }
 
-// InstallRelease performs a Helm release install.
+// InstallRelease performs a Helm release install. If the install fails after
+// Helm rendered a release, that release is returned along with the error.
 func (m manager) InstallRelease(ctx context.Context) (*rpb.Release, error) {
 	install := action.NewInstall(m.actionConfig)
 	install.ReleaseName = m.releaseName
@@ -170,18 +288,21 @@ func (m manager) InstallRelease(ctx context.Context) (*rpb.Release, error) {
 			// Only log a message about a rollback failure if the failure was caused
 			// by something other than the release not being found.
 			if uninstallErr != nil && !notFoundErr(uninstallErr) {
-				return nil, fmt.Errorf("failed installation (%s) and failed rollback: %w", err, uninstallErr)
+				return installedRelease, fmt.Errorf("failed installation (%s) and failed rollback: %w", err, uninstallErr)
 			}
 		}
-		return nil, fmt.Errorf("failed to install release: %w", err)
+		return installedRelease, fmt.Errorf("failed to install release: %w", err)
 	}
 	return installedRelease, nil
 }
 
-// UpdateRelease performs a Helm release update.
+// UpdateRelease performs a Helm release update. If the update fails after
+// Helm recorded a new release version, that failed release is returned along
+// with the error.
 func (m manager) UpdateRelease(ctx context.Context) (*rpb.Release, *rpb.Release, error) {
 	upgrade := action.NewUpgrade(m.actionConfig)
 	upgrade.Namespace = m.namespace
+	upgrade.MaxHistory = m.maxHistory
 
 	updatedRelease, err := upgrade.Run(m.releaseName, m.chart, m.values)
 	if err != nil {
@@ -197,27 +318,66 @@ func (m manager) UpdateRelease(ctx context.Context) (*rpb.Release, *rpb.Release,
 			// log both the update and rollback errors.
 			rollbackErr := rollback.Run(m.releaseName)
 			if rollbackErr != nil {
-				return nil, nil, fmt.Errorf("failed update (%s) and failed rollback: %w", err, rollbackErr)
+				return nil, updatedRelease, fmt.Errorf("failed update (%s) and failed rollback: %w", err, rollbackErr)
 			}
 		}
-		return nil, nil, fmt.Errorf("failed to update release: %w", err)
+		return nil, updatedRelease, fmt.Errorf("failed to update release: %w", err)
 	}
 	return m.deployedRelease, updatedRelease, err
 }
 
+// RollbackRelease rolls the release back to the revision that was deployed
+// when the manager was synced. If that revision's manifest is already the
+// deployed one (e.g. because a failed update was rolled back by
+// UpdateRelease), no new revision is created.
+func (m manager) RollbackRelease(ctx context.Context) (*rpb.Release, error) {
+	if m.deployedRelease == nil {
+		return nil, driver.ErrReleaseNotFound
+	}
+
+	currentRelease, err := m.getDeployedRelease()
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, fmt.Errorf("failed to get deployed release: %w", err)
+	}
+	if currentRelease != nil && currentRelease.Manifest == m.deployedRelease.Manifest {
+		return currentRelease, nil
+	}
+
+	rollback := action.NewRollback(m.actionConfig)
+	rollback.Version = m.deployedRelease.Version
+	rollback.Force = true
+	if err := rollback.Run(m.releaseName); err != nil {
+		return nil, fmt.Errorf("failed to roll back release to revision %d: %w", m.deployedRelease.Version, err)
+	}
+
+	rolledBackRelease, err := m.getDeployedRelease()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rolled back release: %w", err)
+	}
+	return rolledBackRelease, nil
+}
+
 // ReconcileRelease creates or patches resources as necessary to match the
-// deployed release's manifest.
-func (m manager) ReconcileRelease(ctx context.Context) (*rpb.Release, error) {
-	err := reconcileRelease(ctx, m.kubeClient, m.deployedRelease.Manifest)
-	return m.deployedRelease, err
+// deployed release's manifest. It returns the resources that had drifted
+// from the manifest before they were corrected.
+func (m manager) ReconcileRelease(ctx context.Context) (*rpb.Release, []DriftedResource, error) {
+	drifted, err := reconcileRelease(ctx, m.kubeClient, m.deployedRelease.Manifest, true)
+	return m.deployedRelease, drifted, err
 }
 
-func reconcileRelease(ctx context.Context, kubeClient kube.Interface, expectedManifest string) error {
+// DetectDrift returns the resources that differ from the deployed release's
+// manifest without modifying them.
+func (m manager) DetectDrift(ctx context.Context) ([]DriftedResource, error) {
+	return reconcileRelease(ctx, m.kubeClient, m.deployedRelease.Manifest, false)
+}
+
+func reconcileRelease(ctx context.Context, kubeClient kube.Interface, expectedManifest string, correct bool) ([]DriftedResource, error) {
 	expectedInfos, err := kubeClient.Build(bytes.NewBufferString(expectedManifest), false)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return expectedInfos.Visit(func(expected *resource.Info, err error) error {
+	var drifted []DriftedResource
+	err = expectedInfos.Visit(func(expected *resource.Info, err error) error {
 		if err != nil {
 			return err
 		}
@@ -226,9 +386,19 @@ func reconcileRelease(ctx context.Context, kubeClient kube.Interface, expectedMa
 			*r = *r.Context(ctx)
 		})
 		helper := resource.NewHelper(expectedClient, expected.Mapping)
+		drift := DriftedResource{
+			GroupVersionKind: expected.Mapping.GroupVersionKind,
+			Namespace:        expected.Namespace,
+			Name:             expected.Name,
+		}
 
 		existing, err := helper.Get(expected.Namespace, expected.Name, false)
 		if apierrors.IsNotFound(err) {
+			drift.Missing = true
+			drifted = append(drifted, drift)
+			if !correct {
+				return nil
+			}
 			if _, err := helper.Create(expected.Namespace, true, expected.Object, &metav1.CreateOptions{}); err != nil {
 				return fmt.Errorf("create error: %w", err)
 			}
@@ -237,24 +407,37 @@ func reconcileRelease(ctx context.Context, kubeClient kube.Interface, expectedMa
 			return err
 		}
 
-		patch, err := generatePatch(existing, expected.Object)
+		patchOps, err := generatePatchOps(existing, expected.Object)
 		if err != nil {
-			return fmt.Errorf("failed to marshal JSON patch: %w", err)
+			return fmt.Errorf("failed to generate JSON patch: %w", err)
 		}
 
-		if patch == nil {
+		if len(patchOps) == 0 {
+			return nil
+		}
+		for _, op := range patchOps {
+			drift.Paths = append(drift.Paths, op.Path)
+		}
+		drifted = append(drifted, drift)
+		if !correct {
 			return nil
 		}
 
+		patch, err := json.Marshal(patchOps)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON patch: %w", err)
+		}
+
 		_, err = helper.Patch(expected.Namespace, expected.Name, apitypes.JSONPatchType, patch, &metav1.PatchOptions{})
 		if err != nil {
 			return fmt.Errorf("patch error: %w", err)
 		}
 		return nil
 	})
+	return drifted, err
 }
 
-func generatePatch(existing, expected runtime.Object) ([]byte, error) {
+func generatePatchOps(existing, expected runtime.Object) ([]jsonpatch.JsonPatchOperation, error) {
 	existingJSON, err := json.Marshal(existing)
 	if err != nil {
 		return nil, err
@@ -279,19 +462,20 @@ func generatePatch(existing, expected runtime.Object) ([]byte, error) {
 			patchOps = append(patchOps, op)
 		}
 	}
+	return patchOps, nil
+}
 
-	// If there are no patch operations, return nil. Callers are expected
-	// to check for a nil response and skip the patch operation to avoid
-	// unnecessary chatter with the API server.
-	if len(patchOps) == 0 {
-		return nil, nil
-	}
-
-	return json.Marshal(patchOps)
+// CheckReadiness returns the resources of the given release that are not
+// yet ready.
+func (m manager) CheckReadiness(ctx context.Context, rel *rpb.Release) ([]UnreadyResource, error) {
+	return checkReadiness(ctx, m.kubeClient, rel.Manifest)
 }
 
-// UninstallRelease performs a Helm release uninstall.
-func (m manager) UninstallRelease(ctx context.Context) (*rpb.Release, error) {
+// UninstallRelease performs a Helm release uninstall according to opts. If
+// opts.Skip is set, the release is not uninstalled and its resources are only
+// disowned from the custom resource. If a pre-delete hook fails, the returned
+// error wraps ErrPreDeleteHookFailed.
+func (m manager) UninstallRelease(ctx context.Context, opts watches.UninstallOptions) (*rpb.Release, error) {
 	// Get history of this release
 	h, err := m.storageBackend.History(m.releaseName)
 	if err != nil {
@@ -304,7 +488,29 @@ func (m manager) UninstallRelease(ctx context.Context) (*rpb.Release, error) {
 		return nil, driver.ErrReleaseNotFound
 	}
 
-	uninstall := action.NewUninstall(m.actionConfig)
+	if opts.Skip {
+		releaseutil.SortByRevision(h)
+		rel := h[len(h)-1]
+		return rel, m.orphanRelease(rel)
+	}
+
+	cfg := *m.actionConfig
+	if len(opts.OrphanKinds) > 0 {
+		cfg.KubeClient = &orphaningClient{
+			Interface: m.kubeClient,
+			ownerRef:  m.ownerRef,
+			orphan:    newKindMatcher(opts.OrphanKinds),
+		}
+	}
+	uninstall := action.NewUninstall(&cfg)
+	uninstall.KeepHistory = opts.KeepHistory
 	uninstallResponse, err := uninstall.Run(m.releaseName)
-	return uninstallResponse.Release, err
+	var uninstalledRelease *rpb.Release
+	if uninstallResponse != nil {
+		uninstalledRelease = uninstallResponse.Release
+	}
+	if err != nil && preDeleteHookFailed(uninstalledRelease) {
+		return uninstalledRelease, fmt.Errorf("%w: %v", ErrPreDeleteHookFailed, err)
+	}
+	return uninstalledRelease, err
 }
@@ -22,7 +22,6 @@ import (
 	"github.com/martinlindhe/base36"
 	"github.com/pborman/uuid"
 	"helm.sh/helm/v3/pkg/action"
-	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/kube"
 	helmreleasev3 "helm.sh/helm/v3/pkg/release"
 	storagev3 "helm.sh/helm/v3/pkg/storage"
@@ -39,6 +38,7 @@ import (
 
 	"github.com/operator-framework/operator-sdk/pkg/helm/client"
 	"github.com/operator-framework/operator-sdk/pkg/helm/internal/types"
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
 )
 
 // ManagerFactory creates Managers that are specific to custom resources. It is
@@ -50,13 +50,20 @@ type ManagerFactory interface {
 }
 
 type managerFactory struct {
-	mgr      crmanager.Manager
-	chartDir string
+	mgr           crmanager.Manager
+	chartLoader   ChartLoader
+	maxHistory    int
+	valuesMapping *watches.ValuesMapping
 }
 
 // NewManagerFactory returns a new Helm manager factory capable of installing and uninstalling releases.
-func NewManagerFactory(mgr crmanager.Manager, chartDir string) ManagerFactory {
-	return &managerFactory{mgr, chartDir}
+// The chart released for each custom resource is loaded with chartLoader. If
+// maxHistory is greater than zero, the number of release versions kept in
+// storage for each release is limited to maxHistory. If valuesMapping is not
+// nil, the chart values are built from the custom resource with it instead of
+// using the custom resource's spec as is.
+func NewManagerFactory(mgr crmanager.Manager, chartLoader ChartLoader, maxHistory int, valuesMapping *watches.ValuesMapping) ManagerFactory {
+	return &managerFactory{mgr, chartLoader, maxHistory, valuesMapping}
 }
 
 func (f managerFactory) NewManager(cr *unstructured.Unstructured, overrideValues map[string]string) (Manager, error) {
@@ -67,6 +74,7 @@ func (f managerFactory) NewManager(cr *unstructured.Unstructured, overrideValues
 	}
 	storageBackendV2 := storagev2.Init(driverv2.NewSecrets(clientv1.Secrets(cr.GetNamespace())))
 	storageBackendV3 := storagev3.Init(driverv3.NewSecrets(clientv1.Secrets(cr.GetNamespace())))
+	storageBackendV3.MaxHistory = f.maxHistory
 
 	// Automatically convert V2 releases to V3 releases. This is required to
 	// maintain backward compatibility with old releases now that the
@@ -85,9 +93,9 @@ func (f managerFactory) NewManager(cr *unstructured.Unstructured, overrideValues
 	ownerRef := metav1.NewControllerRef(cr, cr.GroupVersionKind())
 	ownerRefClient := client.NewOwnerRefInjectingClient(*kubeClient, *ownerRef)
 
-	crChart, err := loader.LoadDir(f.chartDir)
+	crChart, err := f.chartLoader.Load()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load chart dir: %w", err)
+		return nil, err
 	}
 
 	releaseName, err := getReleaseName(storageBackendV3, crChart.Name(), cr)
@@ -95,16 +103,40 @@ func (f managerFactory) NewManager(cr *unstructured.Unstructured, overrideValues
 		return nil, fmt.Errorf("failed to get helm release name: %w", err)
 	}
 
-	crValues, ok := cr.Object["spec"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("failed to get spec: expected map[string]interface{}")
+	var crValues, mappedSecretValues map[string]interface{}
+	if f.valuesMapping != nil {
+		if crValues, mappedSecretValues, err = mapValues(cr, f.valuesMapping, clientv1); err != nil {
+			return nil, err
+		}
+	} else {
+		spec, ok := cr.Object["spec"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("failed to get spec: expected map[string]interface{}")
+		}
+		crValues = spec
+	}
+
+	// Values from Secrets and ConfigMaps referenced in the CR's valuesFrom
+	// field are overridden by the CR's own values. The valuesFrom field
+	// itself is not passed to the chart.
+	refs, err := ValuesReferences(cr)
+	if err != nil {
+		return nil, err
+	}
+	fromValues, secretValues, err := resolveValuesFrom(clientv1, cr.GetNamespace(), refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve values references: %w", err)
+	}
+	if _, ok := crValues[ValuesFromField]; ok {
+		crValues = mergeMaps(crValues, nil)
+		delete(crValues, ValuesFromField)
 	}
 
 	expOverrides, err := parseOverrides(overrideValues)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse override values: %w", err)
 	}
-	values := mergeMaps(crValues, expOverrides)
+	values := mergeMaps(mergeMaps(fromValues, crValues), expOverrides)
 
 	actionConfig := &action.Configuration{
 		RESTClientGetter: rcg,
@@ -117,13 +149,16 @@ func (f managerFactory) NewManager(cr *unstructured.Unstructured, overrideValues
 		actionConfig:   actionConfig,
 		storageBackend: storageBackendV3,
 		kubeClient:     ownerRefClient,
+		ownerRef:       *ownerRef,
 
 		releaseName: releaseName,
 		namespace:   cr.GetNamespace(),
+		maxHistory:  f.maxHistory,
 
-		chart:  crChart,
-		values: values,
-		status: types.StatusFor(cr),
+		chart:    crChart,
+		values:   values,
+		redactor: newRedactor(mergeMaps(secretValues, mappedSecretValues)),
+		status:   types.StatusFor(cr),
 	}, nil
 }
 
@@ -0,0 +1,218 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	rpb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newTestStorage(t *testing.T, name string, statuses ...rpb.Status) *storage.Storage {
+	s := storage.Init(driver.NewMemory())
+	for i, status := range statuses {
+		rel := &rpb.Release{
+			Name:    name,
+			Version: i + 1,
+			Info:    &rpb.Info{Status: status},
+		}
+		if err := s.Create(rel); err != nil {
+			t.Fatalf("Failed to create release version %d: %v", rel.Version, err)
+		}
+	}
+	return s
+}
+
+func TestTrimHistory(t *testing.T) {
+	testCases := []struct {
+		name           string
+		maxHistory     int
+		statuses       []rpb.Status
+		expectVersions []int
+	}{
+		{
+			name:           "unbounded",
+			maxHistory:     0,
+			statuses:       []rpb.Status{rpb.StatusSuperseded, rpb.StatusSuperseded, rpb.StatusDeployed},
+			expectVersions: []int{1, 2, 3},
+		},
+		{
+			name:           "within limit",
+			maxHistory:     3,
+			statuses:       []rpb.Status{rpb.StatusSuperseded, rpb.StatusDeployed},
+			expectVersions: []int{1, 2},
+		},
+		{
+			name:           "over limit",
+			maxHistory:     2,
+			statuses:       []rpb.Status{rpb.StatusSuperseded, rpb.StatusSuperseded, rpb.StatusSuperseded, rpb.StatusDeployed},
+			expectVersions: []int{3, 4},
+		},
+		{
+			name:           "keeps deployed version",
+			maxHistory:     1,
+			statuses:       []rpb.Status{rpb.StatusDeployed, rpb.StatusSuperseded},
+			expectVersions: []int{1, 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := manager{
+				storageBackend: newTestStorage(t, "test-release", tc.statuses...),
+				releaseName:    "test-release",
+				maxHistory:     tc.maxHistory,
+			}
+			if err := m.trimHistory(); err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+
+			for v := 1; v <= len(tc.statuses); v++ {
+				_, err := m.storageBackend.Get(m.releaseName, v)
+				expectExists := false
+				for _, ev := range tc.expectVersions {
+					if ev == v {
+						expectExists = true
+					}
+				}
+				if expectExists && err != nil {
+					t.Fatalf("Expected version %d to exist; got error: %v", v, err)
+				}
+				if !expectExists && err == nil {
+					t.Fatalf("Expected version %d to be deleted", v)
+				}
+			}
+		})
+	}
+}
+
+func TestDriftedResourceString(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	testCases := []struct {
+		name   string
+		drift  DriftedResource
+		expect string
+	}{
+		{
+			name:   "missing",
+			drift:  DriftedResource{GroupVersionKind: gvk, Namespace: "default", Name: "test", Missing: true},
+			expect: "Deployment default/test: missing",
+		},
+		{
+			name:   "changed fields",
+			drift:  DriftedResource{GroupVersionKind: gvk, Namespace: "default", Name: "test", Paths: []string{"/spec/replicas", "/spec/paused"}},
+			expect: "Deployment default/test: /spec/replicas, /spec/paused",
+		},
+		{
+			name:   "cluster-scoped",
+			drift:  DriftedResource{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, Name: "test", Paths: []string{"/metadata/labels"}},
+			expect: "Namespace test: /metadata/labels",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.drift.String(); actual != tc.expect {
+				t.Fatalf("Expected %q; got %q", tc.expect, actual)
+			}
+		})
+	}
+}
+
+func TestRollbackRelease(t *testing.T) {
+	testCases := []struct {
+		name            string
+		releases        []*rpb.Release
+		deployedVersion int
+		expectVersion   int
+		expectErr       bool
+	}{
+		{
+			name: "failed update",
+			releases: []*rpb.Release{
+				{Version: 1, Manifest: "replicas: 1", Info: &rpb.Info{Status: rpb.StatusSuperseded}},
+				{Version: 2, Manifest: "replicas: 3", Info: &rpb.Info{Status: rpb.StatusFailed}},
+			},
+			deployedVersion: 1,
+			expectVersion:   3,
+		},
+		{
+			name: "already rolled back",
+			releases: []*rpb.Release{
+				{Version: 1, Manifest: "replicas: 1", Info: &rpb.Info{Status: rpb.StatusSuperseded}},
+				{Version: 2, Manifest: "replicas: 3", Info: &rpb.Info{Status: rpb.StatusFailed}},
+				{Version: 3, Manifest: "replicas: 1", Info: &rpb.Info{Status: rpb.StatusDeployed}},
+			},
+			deployedVersion: 1,
+			expectVersion:   3,
+		},
+		{
+			name: "not deployed",
+			releases: []*rpb.Release{
+				{Version: 1, Manifest: "replicas: 3", Info: &rpb.Info{Status: rpb.StatusFailed}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := storage.Init(driver.NewMemory())
+			m := manager{
+				actionConfig: &action.Configuration{
+					Releases:   s,
+					KubeClient: &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+					Log:        func(string, ...interface{}) {},
+				},
+				storageBackend: s,
+				releaseName:    "test-release",
+			}
+			for _, rel := range tc.releases {
+				rel.Name = m.releaseName
+				if err := s.Create(rel); err != nil {
+					t.Fatalf("Failed to create release version %d: %v", rel.Version, err)
+				}
+				if rel.Version == tc.deployedVersion {
+					m.deployedRelease = rel
+				}
+			}
+
+			rel, err := m.RollbackRelease(context.TODO())
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error; got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+			if rel.Version != tc.expectVersion || rel.Manifest != m.deployedRelease.Manifest {
+				t.Fatalf("Expected version %d with manifest %q; got version %d with manifest %q",
+					tc.expectVersion, m.deployedRelease.Manifest, rel.Version, rel.Manifest)
+			}
+			if last, err := s.Last(m.releaseName); err != nil || last.Version != tc.expectVersion {
+				t.Fatalf("Expected no release versions after version %d", tc.expectVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest"
+)
+
+// UnreadyResource describes a release resource that is not yet ready.
+type UnreadyResource struct {
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+	Reason           string
+}
+
+// String returns a human-readable description of the unready resource.
+func (u UnreadyResource) String() string {
+	name := u.Name
+	if u.Namespace != "" {
+		name = u.Namespace + "/" + name
+	}
+	return fmt.Sprintf("%s %s: %s", u.GroupVersionKind.Kind, name, u.Reason)
+}
+
+// checkReadiness returns the resources in the manifest that are not yet
+// ready. Like `helm --wait`, it considers pods, deployments, statefulsets,
+// daemonsets, jobs, persistent volume claims and services. All other
+// resources are considered ready once they exist.
+func checkReadiness(ctx context.Context, kubeClient kube.Interface, manifest string) ([]UnreadyResource, error) {
+	infos, err := kubeClient.Build(bytes.NewBufferString(manifest), false)
+	if err != nil {
+		return nil, err
+	}
+	var unready []UnreadyResource
+	err = infos.Visit(func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+
+		infoClient := resource.NewClientWithOptions(info.Client, func(r *rest.Request) {
+			*r = *r.Context(ctx)
+		})
+		helper := resource.NewHelper(infoClient, info.Mapping)
+
+		res := UnreadyResource{
+			GroupVersionKind: info.Mapping.GroupVersionKind,
+			Namespace:        info.Namespace,
+			Name:             info.Name,
+		}
+		existing, err := helper.Get(info.Namespace, info.Name, false)
+		if apierrors.IsNotFound(err) {
+			res.Reason = "not found"
+			unready = append(unready, res)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		u, ok := existing.(*unstructured.Unstructured)
+		if !ok {
+			return nil
+		}
+		reason, err := unreadyReason(u)
+		if err != nil {
+			return fmt.Errorf("failed to check readiness of %s: %w", res, err)
+		}
+		if reason != "" {
+			res.Reason = reason
+			unready = append(unready, res)
+		}
+		return nil
+	})
+	return unready, err
+}
+
+// unreadyReason returns the reason an object is not ready, or an empty string
+// if it is ready.
+func unreadyReason(u *unstructured.Unstructured) (string, error) {
+	gk := u.GroupVersionKind().GroupKind()
+	switch gk {
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "Deployment"}:
+		var d appsv1.Deployment
+		if err := fromUnstructured(u, &d); err != nil {
+			return "", err
+		}
+		return deploymentUnreadyReason(&d), nil
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "StatefulSet"}:
+		var sts appsv1.StatefulSet
+		if err := fromUnstructured(u, &sts); err != nil {
+			return "", err
+		}
+		return statefulSetUnreadyReason(&sts), nil
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "DaemonSet"}:
+		var ds appsv1.DaemonSet
+		if err := fromUnstructured(u, &ds); err != nil {
+			return "", err
+		}
+		return daemonSetUnreadyReason(&ds), nil
+	case schema.GroupKind{Group: batchv1.GroupName, Kind: "Job"}:
+		var job batchv1.Job
+		if err := fromUnstructured(u, &job); err != nil {
+			return "", err
+		}
+		return jobUnreadyReason(&job), nil
+	case schema.GroupKind{Kind: "Pod"}:
+		var pod corev1.Pod
+		if err := fromUnstructured(u, &pod); err != nil {
+			return "", err
+		}
+		return podUnreadyReason(&pod), nil
+	case schema.GroupKind{Kind: "PersistentVolumeClaim"}:
+		var pvc corev1.PersistentVolumeClaim
+		if err := fromUnstructured(u, &pvc); err != nil {
+			return "", err
+		}
+		if pvc.Status.Phase != corev1.ClaimBound {
+			return fmt.Sprintf("phase is %q", pvc.Status.Phase), nil
+		}
+	case schema.GroupKind{Kind: "Service"}:
+		var svc corev1.Service
+		if err := fromUnstructured(u, &svc); err != nil {
+			return "", err
+		}
+		return serviceUnreadyReason(&svc), nil
+	}
+	return "", nil
+}
+
+func fromUnstructured(u *unstructured.Unstructured, obj interface{}) error {
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj)
+}
+
+func deploymentUnreadyReason(d *appsv1.Deployment) string {
+	if d.Status.ObservedGeneration < d.Generation {
+		return "rollout not yet observed"
+	}
+	replicas := replicasOrDefault(d.Spec.Replicas)
+	if d.Status.UpdatedReplicas < replicas {
+		return fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas)
+	}
+	maxUnavailable := 0
+	if d.Spec.Strategy.RollingUpdate != nil {
+		maxUnavailable = scaledValue(d.Spec.Strategy.RollingUpdate.MaxUnavailable, replicas, false)
+	}
+	if d.Status.AvailableReplicas < replicas-int32(maxUnavailable) {
+		return fmt.Sprintf("%d of %d replicas available", d.Status.AvailableReplicas, replicas)
+	}
+	return ""
+}
+
+func statefulSetUnreadyReason(sts *appsv1.StatefulSet) string {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return "rollout not yet observed"
+	}
+	replicas := replicasOrDefault(sts.Spec.Replicas)
+	if sts.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		partition := int32(0)
+		if sts.Spec.UpdateStrategy.RollingUpdate != nil && sts.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+			partition = *sts.Spec.UpdateStrategy.RollingUpdate.Partition
+		}
+		if expected := replicas - partition; sts.Status.UpdatedReplicas < expected {
+			return fmt.Sprintf("%d of %d replicas updated", sts.Status.UpdatedReplicas, expected)
+		}
+	}
+	if sts.Status.ReadyReplicas < replicas {
+		return fmt.Sprintf("%d of %d replicas ready", sts.Status.ReadyReplicas, replicas)
+	}
+	return ""
+}
+
+func daemonSetUnreadyReason(ds *appsv1.DaemonSet) string {
+	if ds.Status.ObservedGeneration < ds.Generation {
+		return "rollout not yet observed"
+	}
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		return ""
+	}
+	desired := ds.Status.DesiredNumberScheduled
+	if ds.Status.UpdatedNumberScheduled < desired {
+		return fmt.Sprintf("%d of %d pods updated", ds.Status.UpdatedNumberScheduled, desired)
+	}
+	maxUnavailable := 0
+	if ds.Spec.UpdateStrategy.RollingUpdate != nil {
+		maxUnavailable = scaledValue(ds.Spec.UpdateStrategy.RollingUpdate.MaxUnavailable, desired, true)
+	}
+	if ds.Status.NumberAvailable < desired-int32(maxUnavailable) {
+		return fmt.Sprintf("%d of %d pods available", ds.Status.NumberAvailable, desired)
+	}
+	return ""
+}
+
+func jobUnreadyReason(job *batchv1.Job) string {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return fmt.Sprintf("failed: %s", c.Message)
+		}
+	}
+	completions := replicasOrDefault(job.Spec.Completions)
+	if job.Status.Succeeded < completions {
+		return fmt.Sprintf("%d of %d completions succeeded", job.Status.Succeeded, completions)
+	}
+	return ""
+}
+
+func podUnreadyReason(pod *corev1.Pod) string {
+	if pod.Status.Phase == corev1.PodSucceeded {
+		return ""
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			return ""
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			return fmt.Sprintf("container %q is waiting: %s", cs.Name, cs.State.Waiting.Reason)
+		}
+	}
+	return "not ready"
+}
+
+func serviceUnreadyReason(svc *corev1.Service) string {
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return ""
+	}
+	if svc.Spec.ClusterIP == "" {
+		return "cluster IP not assigned"
+	}
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return "load balancer ingress not assigned"
+	}
+	return ""
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func scaledValue(v *intstr.IntOrString, total int32, roundUp bool) int {
+	if v == nil {
+		return 0
+	}
+	scaled, err := intstr.GetValueFromIntOrPercent(v, int(total), roundUp)
+	if err != nil {
+		return 0
+	}
+	return scaled
+}
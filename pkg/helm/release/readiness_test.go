@@ -0,0 +1,182 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestUnreadyReason(t *testing.T) {
+	testCases := []struct {
+		name        string
+		obj         map[string]interface{}
+		expectReady bool
+	}{
+		{
+			name: "deployment available",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"generation": int64(2)},
+				"spec":       map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"updatedReplicas":    int64(3),
+					"availableReplicas":  int64(3),
+				},
+			},
+			expectReady: true,
+		},
+		{
+			name: "deployment rollout not observed",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"generation": int64(3)},
+				"spec":       map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"updatedReplicas":    int64(3),
+					"availableReplicas":  int64(3),
+				},
+			},
+			expectReady: false,
+		},
+		{
+			name: "deployment within max unavailable",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"replicas": int64(4),
+					"strategy": map[string]interface{}{
+						"type":          "RollingUpdate",
+						"rollingUpdate": map[string]interface{}{"maxUnavailable": "25%"},
+					},
+				},
+				"status": map[string]interface{}{
+					"updatedReplicas":   int64(4),
+					"availableReplicas": int64(3),
+				},
+			},
+			expectReady: true,
+		},
+		{
+			name: "deployment replicas unavailable",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"spec":       map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{
+					"updatedReplicas":   int64(2),
+					"availableReplicas": int64(0),
+				},
+			},
+			expectReady: false,
+		},
+		{
+			name: "statefulset ready",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "StatefulSet",
+				"spec": map[string]interface{}{
+					"replicas":       int64(2),
+					"updateStrategy": map[string]interface{}{"type": "RollingUpdate"},
+				},
+				"status": map[string]interface{}{
+					"updatedReplicas": int64(2),
+					"readyReplicas":   int64(2),
+				},
+			},
+			expectReady: true,
+		},
+		{
+			name: "statefulset not ready",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "StatefulSet",
+				"spec": map[string]interface{}{
+					"replicas":       int64(2),
+					"updateStrategy": map[string]interface{}{"type": "RollingUpdate"},
+				},
+				"status": map[string]interface{}{
+					"updatedReplicas": int64(2),
+					"readyReplicas":   int64(1),
+				},
+			},
+			expectReady: false,
+		},
+		{
+			name: "pod crash looping",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"status": map[string]interface{}{
+					"phase": "Running",
+					"containerStatuses": []interface{}{
+						map[string]interface{}{
+							"name":  "app",
+							"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "CrashLoopBackOff"}},
+						},
+					},
+				},
+			},
+			expectReady: false,
+		},
+		{
+			name: "pvc pending",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "PersistentVolumeClaim",
+				"status":     map[string]interface{}{"phase": "Pending"},
+			},
+			expectReady: false,
+		},
+		{
+			name: "load balancer service without ingress",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"spec":       map[string]interface{}{"type": "LoadBalancer", "clusterIP": "10.0.0.1"},
+			},
+			expectReady: false,
+		},
+		{
+			name: "configmap",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+			},
+			expectReady: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, err := unreadyReason(&unstructured.Unstructured{Object: tc.obj})
+			if err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+			if tc.expectReady && reason != "" {
+				t.Fatalf("Expected ready; got not ready: %s", reason)
+			}
+			if !tc.expectReady && reason == "" {
+				t.Fatalf("Expected not ready; got ready")
+			}
+		})
+	}
+}
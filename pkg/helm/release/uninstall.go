@@ -0,0 +1,173 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/kube"
+	rpb "helm.sh/helm/v3/pkg/release"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// ErrPreDeleteHookFailed is returned by UninstallRelease if a pre-delete hook
+// of the release's chart failed. The release and its resources are left in
+// place.
+var ErrPreDeleteHookFailed = errors.New("pre-delete hook failed")
+
+// kindMatcher matches resource kinds against a list of "Kind" or "Kind.group"
+// entries. A "Kind" entry matches the kind in any API group.
+type kindMatcher []schema.GroupKind
+
+func newKindMatcher(kinds []string) kindMatcher {
+	m := make(kindMatcher, 0, len(kinds))
+	for _, k := range kinds {
+		m = append(m, schema.ParseGroupKind(k))
+	}
+	return m
+}
+
+func (m kindMatcher) matches(gk schema.GroupKind) bool {
+	for _, k := range m {
+		if strings.EqualFold(k.Kind, gk.Kind) && (k.Group == "" || k.Group == gk.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+// orphaningClient is a kube.Interface that does not delete resources of the
+// orphaned kinds. Instead, it removes the owner reference to the custom
+// resource from them so that they are not garbage collected once the custom
+// resource is deleted.
+type orphaningClient struct {
+	kube.Interface
+	ownerRef metav1.OwnerReference
+	orphan   kindMatcher
+}
+
+func (c *orphaningClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	var toDelete, toOrphan kube.ResourceList
+	for _, info := range resources {
+		if c.orphan.matches(info.Mapping.GroupVersionKind.GroupKind()) {
+			toOrphan = append(toOrphan, info)
+		} else {
+			toDelete = append(toDelete, info)
+		}
+	}
+
+	errs := removeOwnerRefs(toOrphan, c.ownerRef)
+	if len(toDelete) == 0 {
+		return &kube.Result{}, errs
+	}
+	res, deleteErrs := c.Interface.Delete(toDelete)
+	return res, append(errs, deleteErrs...)
+}
+
+// removeOwnerRefs removes the owner reference from the live objects of the
+// resources.
+func removeOwnerRefs(resources kube.ResourceList, ownerRef metav1.OwnerReference) []error {
+	var errs []error
+	for _, info := range resources {
+		helper := resource.NewHelper(info.Client, info.Mapping)
+		existing, err := helper.Get(info.Namespace, info.Name, false)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get %s %s: %w", info.Mapping.GroupVersionKind.Kind, info.Name, err))
+			continue
+		}
+		accessor, err := meta.Accessor(existing)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		refs := accessor.GetOwnerReferences()
+		kept := make([]metav1.OwnerReference, 0, len(refs))
+		for _, ref := range refs {
+			if ref.UID != ownerRef.UID {
+				kept = append(kept, ref)
+			}
+		}
+		if len(kept) == len(refs) {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"ownerReferences": kept,
+				"resourceVersion": accessor.GetResourceVersion(),
+			},
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := helper.Patch(info.Namespace, info.Name, apitypes.MergePatchType, patch, nil); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove owner reference from %s %s: %w", info.Mapping.GroupVersionKind.Kind, info.Name, err))
+			continue
+		}
+		log.Info("Orphaned release resource", "kind", info.Mapping.GroupVersionKind.Kind, "namespace", info.Namespace, "name", info.Name)
+	}
+	return errs
+}
+
+// orphanRelease leaves all resources of the release in place by removing the
+// owner reference to the custom resource from them.
+func (m manager) orphanRelease(rel *rpb.Release) error {
+	resources, err := m.kubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+	if err != nil {
+		return fmt.Errorf("failed to build release resources: %w", err)
+	}
+	if errs := removeOwnerRefs(resources, m.ownerRef); len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return nil
+}
+
+// preDeleteHookFailed returns true if a pre-delete hook of rel, the release
+// returned by a failed uninstall, failed. Helm stops the uninstall before
+// deleting any resources in that case.
+func preDeleteHookFailed(rel *rpb.Release) bool {
+	if rel == nil {
+		return false
+	}
+	for _, h := range rel.Hooks {
+		for _, e := range h.Events {
+			if e == rpb.HookPreDelete && h.LastRun.Phase == rpb.HookPhaseFailed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinErrors(errs []error) error {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
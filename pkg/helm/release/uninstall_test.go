@@ -0,0 +1,234 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	rpb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest/fake"
+
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
+)
+
+func TestKindMatcher(t *testing.T) {
+	m := newKindMatcher([]string{"PersistentVolumeClaim", "Certificate.cert-manager.io"})
+	testCases := []struct {
+		gk          schema.GroupKind
+		expectMatch bool
+	}{
+		{gk: schema.GroupKind{Kind: "PersistentVolumeClaim"}, expectMatch: true},
+		{gk: schema.GroupKind{Group: "cert-manager.io", Kind: "Certificate"}, expectMatch: true},
+		{gk: schema.GroupKind{Group: "example.com", Kind: "Certificate"}, expectMatch: false},
+		{gk: schema.GroupKind{Group: "apps", Kind: "Deployment"}, expectMatch: false},
+	}
+	for _, tc := range testCases {
+		if actual := m.matches(tc.gk); actual != tc.expectMatch {
+			t.Fatalf("Expected match %t for %s; got %t", tc.expectMatch, tc.gk, actual)
+		}
+	}
+}
+
+func TestUninstallReleasePreDeleteHook(t *testing.T) {
+	hookErr := errors.New("job failed: BackoffLimitExceeded")
+	deleteErr := errors.New(`failed to delete ConfigMap "pre-delete-backup": connection refused`)
+	testCases := []struct {
+		name                 string
+		hooks                []*rpb.Hook
+		watchUntilReadyError error
+		deleteError          error
+		expectErr            bool
+		expectHookErr        bool
+	}{
+		{
+			name:                 "failed pre-delete hook",
+			hooks:                []*rpb.Hook{{Name: "backup", Path: "templates/backup-job.yaml", Events: []rpb.HookEvent{rpb.HookPreDelete}}},
+			watchUntilReadyError: hookErr,
+			expectErr:            true,
+			expectHookErr:        true,
+		},
+		{
+			name:        "delete error mentioning pre-delete after a successful hook",
+			hooks:       []*rpb.Hook{{Name: "backup", Path: "templates/backup-job.yaml", Events: []rpb.HookEvent{rpb.HookPreDelete}}},
+			deleteError: deleteErr,
+			expectErr:   true,
+		},
+		{
+			name:        "delete error mentioning pre-delete without hooks",
+			deleteError: deleteErr,
+			expectErr:   true,
+		},
+		{
+			name:                 "failed post-delete hook",
+			hooks:                []*rpb.Hook{{Name: "cleanup", Path: "templates/cleanup-job.yaml", Events: []rpb.HookEvent{rpb.HookPostDelete}}},
+			watchUntilReadyError: hookErr,
+			expectErr:            true,
+		},
+		{
+			name: "successful uninstall",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := storage.Init(driver.NewMemory())
+			m := manager{
+				actionConfig: &action.Configuration{
+					Releases: s,
+					KubeClient: &kubefake.FailingKubeClient{
+						PrintingKubeClient:   kubefake.PrintingKubeClient{Out: ioutil.Discard},
+						WatchUntilReadyError: tc.watchUntilReadyError,
+						DeleteError:          tc.deleteError,
+					},
+					Capabilities: chartutil.DefaultCapabilities,
+					Log:          func(string, ...interface{}) {},
+				},
+				storageBackend: s,
+				releaseName:    "test-release",
+			}
+			rel := &rpb.Release{
+				Name:     m.releaseName,
+				Version:  1,
+				Manifest: "kind: ConfigMap",
+				Hooks:    tc.hooks,
+				Info:     &rpb.Info{Status: rpb.StatusDeployed},
+			}
+			if err := s.Create(rel); err != nil {
+				t.Fatalf("Failed to create release: %v", err)
+			}
+
+			_, err := m.UninstallRelease(context.TODO(), watches.UninstallOptions{})
+			if tc.expectErr && err == nil {
+				t.Fatalf("Expected error; got no error")
+			}
+			if !tc.expectErr && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if hookErr := errors.Is(err, ErrPreDeleteHookFailed); hookErr != tc.expectHookErr {
+				t.Fatalf("Expected pre-delete hook error %t; got %t for error: %v", tc.expectHookErr, hookErr, err)
+			}
+		})
+	}
+}
+
+type recordingKubeClient struct {
+	kube.Interface
+	deleted kube.ResourceList
+}
+
+func (c *recordingKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	c.deleted = append(c.deleted, resources...)
+	return &kube.Result{Deleted: resources}, nil
+}
+
+// newPVCInfo returns a resource info for a PVC served by a fake REST client.
+// The PVC is owned by owner and another object, and patches sent to it are
+// recorded in patches.
+func newPVCInfo(t *testing.T, owner metav1.OwnerReference, patches *[]map[string]interface{}) *resource.Info {
+	pvc := &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "data",
+			Namespace:       "default",
+			ResourceVersion: "42",
+			OwnerReferences: []metav1.OwnerReference{
+				owner,
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"},
+			},
+		},
+	}
+	body, err := json.Marshal(pvc)
+	if err != nil {
+		t.Fatalf("Failed to marshal PVC: %v", err)
+	}
+
+	client := &fake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPatch {
+				b, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				var patch map[string]interface{}
+				if err := json.Unmarshal(b, &patch); err != nil {
+					return nil, err
+				}
+				*patches = append(*patches, patch)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       ioutil.NopCloser(bytes.NewReader(body)),
+			}, nil
+		}),
+	}
+	return &resource.Info{
+		Client:    client,
+		Mapping:   &meta.RESTMapping{GroupVersionKind: corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"), Resource: corev1.SchemeGroupVersion.WithResource("persistentvolumeclaims"), Scope: meta.RESTScopeNamespace},
+		Namespace: "default",
+		Name:      "data",
+	}
+}
+
+func TestOrphaningClientDelete(t *testing.T) {
+	owner := metav1.OwnerReference{APIVersion: "example.com/v1alpha1", Kind: "Nginx", Name: "example", UID: "owner-uid"}
+	var patches []map[string]interface{}
+	pvc := newPVCInfo(t, owner, &patches)
+	deployment := &resource.Info{
+		Mapping:   &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}},
+		Namespace: "default",
+		Name:      "web",
+	}
+
+	base := &recordingKubeClient{}
+	c := &orphaningClient{Interface: base, ownerRef: owner, orphan: newKindMatcher([]string{"PersistentVolumeClaim"})}
+	if _, errs := c.Delete(kube.ResourceList{pvc, deployment}); len(errs) > 0 {
+		t.Fatalf("Expected no errors; got errors: %v", errs)
+	}
+
+	if len(base.deleted) != 1 || base.deleted[0] != deployment {
+		t.Fatalf("Expected only the deployment to be deleted; got %v", base.deleted)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("Expected 1 patch; got %d", len(patches))
+	}
+	metadata := patches[0]["metadata"].(map[string]interface{})
+	refs := metadata["ownerReferences"].([]interface{})
+	if len(refs) != 1 || refs[0].(map[string]interface{})["uid"] != "other-uid" {
+		t.Fatalf("Expected only the owner reference to be removed; got %v", refs)
+	}
+	if metadata["resourceVersion"] != "42" {
+		t.Fatalf("Expected patch to be guarded by resourceVersion 42; got %v", metadata["resourceVersion"])
+	}
+}
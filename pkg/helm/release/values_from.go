@@ -0,0 +1,408 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// ValuesFromField is the field of a CR's spec that references Secrets and
+	// ConfigMaps containing chart values. It is not passed to the chart.
+	ValuesFromField = "valuesFrom"
+
+	defaultValuesKey = "values.yaml"
+	redacted         = "<redacted>"
+
+	// minRedactedLength is the minimum length of the values read from
+	// Secrets that are replaced where they are part of a longer string.
+	// Shorter values would replace common strings, e.g. "1" or "yes", and are
+	// only replaced where they are a whole field.
+	minRedactedLength = 4
+)
+
+// ValuesReference references a key of a Secret or ConfigMap in the CR's
+// namespace that contains chart values.
+type ValuesReference struct {
+	// Kind is either "Secret" or "ConfigMap".
+	Kind string
+	Name string
+
+	// Key is the key of the values. Defaults to "values.yaml".
+	Key string
+
+	// TargetPath is the dot-separated path of the chart value that is set
+	// to the raw value of the key. If empty, the value of the key is parsed
+	// as YAML chart values.
+	TargetPath string
+
+	// Optional allows the Secret or ConfigMap, or the key, to be missing.
+	Optional bool
+}
+
+type valuesKeyRef struct {
+	Name     string `json:"name"`
+	Key      string `json:"key,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+type valuesFromSource struct {
+	SecretKeyRef    *valuesKeyRef `json:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef *valuesKeyRef `json:"configMapKeyRef,omitempty"`
+	TargetPath      string        `json:"targetPath,omitempty"`
+}
+
+// ValuesReferences returns the Secrets and ConfigMaps referenced by the
+// valuesFrom field of the CR's spec.
+func ValuesReferences(cr *unstructured.Unstructured) ([]ValuesReference, error) {
+	in, ok, err := unstructured.NestedFieldNoCopy(cr.Object, "spec", ValuesFromField)
+	if err != nil || !ok {
+		return nil, err
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	var sources []valuesFromSource
+	if err := json.Unmarshal(b, &sources); err != nil {
+		return nil, fmt.Errorf("invalid spec.%s: %w", ValuesFromField, err)
+	}
+
+	refs := make([]ValuesReference, 0, len(sources))
+	for i, s := range sources {
+		ref := ValuesReference{TargetPath: s.TargetPath}
+		var keyRef *valuesKeyRef
+		switch {
+		case s.SecretKeyRef != nil && s.ConfigMapKeyRef != nil:
+			return nil, fmt.Errorf("invalid spec.%s[%d]: only one of secretKeyRef or configMapKeyRef may be set", ValuesFromField, i)
+		case s.SecretKeyRef != nil:
+			ref.Kind, keyRef = "Secret", s.SecretKeyRef
+		case s.ConfigMapKeyRef != nil:
+			ref.Kind, keyRef = "ConfigMap", s.ConfigMapKeyRef
+		default:
+			return nil, fmt.Errorf("invalid spec.%s[%d]: one of secretKeyRef or configMapKeyRef must be set", ValuesFromField, i)
+		}
+		if keyRef.Name == "" {
+			return nil, fmt.Errorf("invalid spec.%s[%d]: name must not be empty", ValuesFromField, i)
+		}
+		ref.Name, ref.Key, ref.Optional = keyRef.Name, keyRef.Key, keyRef.Optional
+		if ref.Key == "" {
+			ref.Key = defaultValuesKey
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// resolveValuesFrom reads the values referenced by refs from the namespace
+// and merges them in order. It also returns the merged values read from
+// Secrets, which must not be exposed in the CR's status or in logs.
+func resolveValuesFrom(client v1.CoreV1Interface, namespace string, refs []ValuesReference) (map[string]interface{}, map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	secretValues := map[string]interface{}{}
+	for _, ref := range refs {
+		data, found, err := readValuesReference(client, namespace, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !found {
+			continue
+		}
+
+		refValues := map[string]interface{}{}
+		if ref.TargetPath != "" {
+			if err := setValue(refValues, ref.TargetPath, data); err != nil {
+				return nil, nil, err
+			}
+		} else if refValues, err = chartutil.ReadValues([]byte(data)); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse values from %s %s key %q: %w", ref.Kind, ref.Name, ref.Key, err)
+		}
+		if ref.Kind == "Secret" {
+			secretValues = mergeMaps(secretValues, refValues)
+		}
+		values = mergeMaps(values, refValues)
+	}
+	return values, secretValues, nil
+}
+
+func readValuesReference(client v1.CoreV1Interface, namespace string, ref ValuesReference) (string, bool, error) {
+	var (
+		data  string
+		found bool
+		err   error
+	)
+	switch ref.Kind {
+	case "Secret":
+		var secret *corev1.Secret
+		secret, err = client.Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err == nil {
+			var b []byte
+			b, found = secret.Data[ref.Key]
+			data = string(b)
+		}
+	case "ConfigMap":
+		var cm *corev1.ConfigMap
+		cm, err = client.ConfigMaps(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err == nil {
+			data, found = cm.Data[ref.Key]
+		}
+	default:
+		return "", false, fmt.Errorf("unsupported values reference kind %q", ref.Kind)
+	}
+
+	if apierrors.IsNotFound(err) {
+		if ref.Optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("%s %s referenced in spec.%s not found", ref.Kind, ref.Name, ValuesFromField)
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to get %s %s: %w", ref.Kind, ref.Name, err)
+	}
+	if !found {
+		if ref.Optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("%s %s referenced in spec.%s does not contain key %q", ref.Kind, ref.Name, ValuesFromField, ref.Key)
+	}
+	return data, true, nil
+}
+
+// redactor replaces the values read from Secrets in release values and
+// manifests.
+type redactor struct {
+	// paths are the paths of the values tree that were read from Secrets.
+	paths [][]string
+	// sensitive are the rendered forms of the scalar values read from
+	// Secrets, and their base64 encodings, that are replaced in manifests.
+	sensitive map[string]struct{}
+	// replacer replaces the sensitive values that are at least
+	// minRedactedLength long in strings. It is built on first use.
+	replacer *strings.Replacer
+}
+
+func newRedactor(secretValues map[string]interface{}) *redactor {
+	r := &redactor{sensitive: map[string]struct{}{}}
+	r.addPaths(nil, secretValues)
+	return r
+}
+
+func (r *redactor) addPaths(prefix []string, values map[string]interface{}) {
+	for k, v := range values {
+		path := append(append([]string{}, prefix...), k)
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			r.addPaths(path, m)
+			continue
+		}
+		r.paths = append(r.paths, path)
+		r.addSensitive(v)
+	}
+}
+
+func (r *redactor) addSensitive(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			r.addSensitive(e)
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			r.addSensitive(e)
+		}
+	case string, float64, int64, int:
+		// Booleans are not replaced in manifests, since any true or false
+		// would be.
+		s := fmt.Sprint(v)
+		if s != "" {
+			r.sensitive[s] = struct{}{}
+			r.sensitive[base64.StdEncoding.EncodeToString([]byte(s))] = struct{}{}
+			if b, err := json.Marshal(s); err == nil {
+				r.sensitive[string(b[1:len(b)-1])] = struct{}{}
+			}
+			r.replacer = nil
+		}
+	}
+}
+
+// replace replaces the sensitive values that are at least minRedactedLength
+// long in s.
+func (r *redactor) replace(s string) string {
+	if r.replacer == nil {
+		// Longer values are replaced first, so that a value containing
+		// another one is replaced as a whole.
+		sorted := make([]string, 0, len(r.sensitive))
+		for v := range r.sensitive {
+			if len(v) >= minRedactedLength {
+				sorted = append(sorted, v)
+			}
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if len(sorted[i]) != len(sorted[j]) {
+				return len(sorted[i]) > len(sorted[j])
+			}
+			return sorted[i] < sorted[j]
+		})
+		oldnew := make([]string, 0, 2*len(sorted))
+		for _, v := range sorted {
+			oldnew = append(oldnew, v, redacted)
+		}
+		r.replacer = strings.NewReplacer(oldnew...)
+	}
+	return r.replacer.Replace(s)
+}
+
+// redactValues returns a copy of values in which the values at the paths
+// read from Secrets are replaced, whatever their type.
+func (r *redactor) redactValues(values map[string]interface{}) map[string]interface{} {
+	for _, path := range r.paths {
+		values = redactPath(values, path)
+	}
+	return values
+}
+
+func redactPath(values map[string]interface{}, path []string) map[string]interface{} {
+	v, ok := values[path[0]]
+	if !ok {
+		return values
+	}
+	out := make(map[string]interface{}, len(values))
+	for k, e := range values {
+		out[k] = e
+	}
+	if len(path) == 1 {
+		out[path[0]] = redacted
+	} else if m, ok := v.(map[string]interface{}); ok {
+		out[path[0]] = redactPath(m, path[1:])
+	}
+	return out
+}
+
+// redactString redacts each document of a release manifest. A document is
+// left as it is unless it contains a value read from a Secret.
+func (r *redactor) redactString(manifest string) string {
+	if len(r.sensitive) == 0 {
+		return manifest
+	}
+	var out, doc strings.Builder
+	for _, line := range strings.SplitAfter(manifest, "\n") {
+		if strings.TrimSpace(line) == "---" {
+			out.WriteString(r.redactDocument(doc.String()))
+			doc.Reset()
+			out.WriteString(line)
+			continue
+		}
+		doc.WriteString(line)
+	}
+	out.WriteString(r.redactDocument(doc.String()))
+	return out.String()
+}
+
+// redactDocument replaces the data of a Secret, and the values read from a
+// Secret, or their base64 encodings, in the scalar fields of other objects.
+// The leading comments of the document, e.g. its source template, are kept.
+// Documents that are not YAML objects are redacted as text.
+func (r *redactor) redactDocument(doc string) string {
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(doc), &obj); err != nil || len(obj) == 0 {
+		return r.replace(doc)
+	}
+	changed := false
+	if obj["apiVersion"] == "v1" && obj["kind"] == "Secret" {
+		for _, field := range []string{"data", "stringData"} {
+			if data, ok := obj[field].(map[string]interface{}); ok {
+				for k := range data {
+					data[k] = redacted
+					changed = true
+				}
+			}
+		}
+	}
+	if !r.redactFields(obj) && !changed {
+		return doc
+	}
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return doc
+	}
+	var comments strings.Builder
+	for _, line := range strings.SplitAfter(doc, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		comments.WriteString(line)
+	}
+	return comments.String() + string(b)
+}
+
+// redactFields replaces the fields of v that are a value read from a Secret,
+// and the values read from Secrets that are part of its string fields.
+func (r *redactor) redactFields(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if redactedField, ok := r.redactField(e); ok {
+				v[k] = redactedField
+				changed = true
+			} else if r.redactFields(e) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if redactedField, ok := r.redactField(e); ok {
+				v[i] = redactedField
+				changed = true
+			} else if r.redactFields(e) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// redactField returns the redacted form of a scalar field, or false if the
+// field contains no value read from a Secret.
+func (r *redactor) redactField(v interface{}) (interface{}, bool) {
+	if r.isSensitive(v) {
+		return redacted, true
+	}
+	if s, ok := v.(string); ok {
+		if replaced := r.replace(s); replaced != s {
+			return replaced, true
+		}
+	}
+	return nil, false
+}
+
+func (r *redactor) isSensitive(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, int64, int:
+		_, ok := r.sensitive[fmt.Sprint(v)]
+		return ok
+	}
+	return false
+}
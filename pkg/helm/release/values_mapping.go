@@ -0,0 +1,217 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
+)
+
+// MappedValuesReferences returns the Secrets and ConfigMaps that mapping
+// reads the values of cr from.
+func MappedValuesReferences(cr *unstructured.Unstructured, mapping *watches.ValuesMapping) ([]ValuesReference, error) {
+	if mapping == nil {
+		return nil, nil
+	}
+	var refs []ValuesReference
+	for _, m := range mapping.Values {
+		kind, keyRef := "ConfigMap", m.ConfigMapKeyRef
+		if m.SecretKeyRef != nil {
+			kind, keyRef = "Secret", m.SecretKeyRef
+		}
+		if keyRef == nil {
+			continue
+		}
+		name, err := executeTemplate(cr, m.To, keyRef.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map value %q: %w", m.To, err)
+		}
+		refs = append(refs, ValuesReference{
+			Kind:       kind,
+			Name:       name,
+			Key:        keyRef.Key,
+			TargetPath: m.To,
+			Optional:   m.Default != nil,
+		})
+	}
+	return refs, nil
+}
+
+// mapValues builds the chart values for cr according to mapping. If the
+// mapping passes the CR spec through, the mapped values are set on a copy of
+// the spec; otherwise they are set on empty values. It also returns the
+// mapped values read from Secrets, which must not be exposed in the CR's
+// status or in logs.
+func mapValues(cr *unstructured.Unstructured, mapping *watches.ValuesMapping, client v1.CoreV1Interface) (map[string]interface{}, map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	secretValues := map[string]interface{}{}
+	if mapping.PassthroughSpec {
+		spec, ok := cr.Object["spec"].(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to get spec: expected map[string]interface{}")
+		}
+		values = runtime.DeepCopyJSON(spec)
+	}
+
+	for _, m := range mapping.Values {
+		value, found, err := resolveValue(cr, m, client)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to map value %q: %w", m.To, err)
+		}
+		if !found {
+			if m.Default == nil {
+				continue
+			}
+			value = runtime.DeepCopyJSONValue(m.Default)
+		} else if m.SecretKeyRef != nil {
+			if err := setValue(secretValues, m.To, value); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := setValue(values, m.To, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, secretValues, nil
+}
+
+// resolveValue returns the value selected by m. If the source of the value
+// is missing or empty, found is false.
+func resolveValue(cr *unstructured.Unstructured, m watches.ValueMapping, client v1.CoreV1Interface) (value interface{}, found bool, err error) {
+	switch {
+	case m.From != "":
+		return resolveJSONPath(cr, m)
+	case m.Template != "":
+		out, err := executeTemplate(cr, m.To, m.Template)
+		return out, out != "", err
+	case m.ConfigMapKeyRef != nil:
+		name, err := executeTemplate(cr, m.To, m.ConfigMapKeyRef.Name)
+		if err != nil {
+			return nil, false, err
+		}
+		cm, err := client.ConfigMaps(cr.GetNamespace()).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return missingRef(m, err, "ConfigMap", name)
+		}
+		data, ok := cm.Data[m.ConfigMapKeyRef.Key]
+		if !ok {
+			return missingKey(m, "ConfigMap", name, m.ConfigMapKeyRef.Key)
+		}
+		return data, data != "", nil
+	case m.SecretKeyRef != nil:
+		name, err := executeTemplate(cr, m.To, m.SecretKeyRef.Name)
+		if err != nil {
+			return nil, false, err
+		}
+		secret, err := client.Secrets(cr.GetNamespace()).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return missingRef(m, err, "Secret", name)
+		}
+		data, ok := secret.Data[m.SecretKeyRef.Key]
+		if !ok {
+			return missingKey(m, "Secret", name, m.SecretKeyRef.Key)
+		}
+		return string(data), len(data) > 0, nil
+	}
+	return nil, false, nil
+}
+
+// missingRef handles an error getting a referenced ConfigMap or Secret. A
+// missing object is only allowed if the mapping has a default value.
+func missingRef(m watches.ValueMapping, err error, kind, name string) (interface{}, bool, error) {
+	if apierrors.IsNotFound(err) && m.Default != nil {
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("failed to get %s %s: %w", kind, name, err)
+}
+
+// missingKey handles a key missing from a referenced ConfigMap or Secret. A
+// missing key is only allowed if the mapping has a default value.
+func missingKey(m watches.ValueMapping, kind, name, key string) (interface{}, bool, error) {
+	if m.Default != nil {
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("%s %s does not contain key %q", kind, name, key)
+}
+
+func resolveJSONPath(cr *unstructured.Unstructured, m watches.ValueMapping) (interface{}, bool, error) {
+	j, err := watches.ParseJSONPath(m.To, m.From)
+	if err != nil {
+		return nil, false, err
+	}
+	results, err := j.FindResults(cr.Object)
+	if err != nil {
+		return nil, false, err
+	}
+	var found []interface{}
+	for _, r := range results {
+		for _, v := range r {
+			if v.IsValid() && v.CanInterface() && v.Interface() != nil {
+				found = append(found, v.Interface())
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, false, nil
+	case 1:
+		return runtime.DeepCopyJSONValue(found[0]), found[0] != "", nil
+	}
+	return runtime.DeepCopyJSONValue(found), true, nil
+}
+
+func executeTemplate(cr *unstructured.Unstructured, name, text string) (string, error) {
+	t, err := watches.ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, cr.Object); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// setValue sets the value at the dot-separated path in values, creating
+// intermediate maps as needed.
+func setValue(values map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	m := values
+	for i, k := range keys[:len(keys)-1] {
+		next, ok := m[k]
+		if !ok || next == nil {
+			child := map[string]interface{}{}
+			m[k] = child
+			m = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("failed to set value %q: %q is not a map", path, strings.Join(keys[:i+1], "."))
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = value
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/operator-framework/operator-sdk/pkg/helm/watches"
+)
+
+func TestMapValues(t *testing.T) {
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1alpha1",
+		"kind":       "Nginx",
+		"metadata": map[string]interface{}{
+			"name":      "example",
+			"namespace": "default",
+			"labels":    map[string]interface{}{"tier": "frontend"},
+		},
+		"spec": map[string]interface{}{
+			"image":    "nginx:1.17",
+			"replicas": int64(3),
+			"ports":    []interface{}{int64(80), int64(443)},
+		},
+	}}
+	client := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "example-config", Namespace: "default"},
+			Data:       map[string]string{"logLevel": "debug"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "example-credentials", Namespace: "default"},
+			Data:       map[string][]byte{"password": []byte("s3cr3t")},
+		},
+	).CoreV1()
+
+	testCases := []struct {
+		name               string
+		mapping            watches.ValuesMapping
+		expectValues       map[string]interface{}
+		expectSecretValues map[string]interface{}
+		expectErr          bool
+	}{
+		{
+			name: "rename spec fields",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "image.repository", From: "{.spec.image}"},
+				{To: "replicaCount", From: "{.spec.replicas}"},
+				{To: "service.ports", From: "{.spec.ports[*]}"},
+			}},
+			expectValues: map[string]interface{}{
+				"image":        map[string]interface{}{"repository": "nginx:1.17"},
+				"replicaCount": int64(3),
+				"service":      map[string]interface{}{"ports": []interface{}{int64(80), int64(443)}},
+			},
+		},
+		{
+			name: "passthrough spec",
+			mapping: watches.ValuesMapping{PassthroughSpec: true, Values: []watches.ValueMapping{
+				{To: "tier", From: "{.metadata.labels.tier}"},
+			}},
+			expectValues: map[string]interface{}{
+				"image":    "nginx:1.17",
+				"replicas": int64(3),
+				"ports":    []interface{}{int64(80), int64(443)},
+				"tier":     "frontend",
+			},
+		},
+		{
+			name: "defaults",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "pullPolicy", From: "{.spec.pullPolicy}", Default: "IfNotPresent"},
+				{To: "resources", Default: map[string]interface{}{"limits": map[string]interface{}{"cpu": "100m"}}},
+				{To: "optional", From: "{.spec.optional}"},
+			}},
+			expectValues: map[string]interface{}{
+				"pullPolicy": "IfNotPresent",
+				"resources":  map[string]interface{}{"limits": map[string]interface{}{"cpu": "100m"}},
+			},
+		},
+		{
+			name: "templates",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "fullnameOverride", Template: "{{ .metadata.name }}-{{ .metadata.namespace }}"},
+				{To: "image.tag", Template: `{{ .spec.image | splitList ":" | last }}`},
+			}},
+			expectValues: map[string]interface{}{
+				"fullnameOverride": "example-default",
+				"image":            map[string]interface{}{"tag": "1.17"},
+			},
+		},
+		{
+			name: "configmap and secret references",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "logLevel", ConfigMapKeyRef: &watches.KeyRef{Name: "{{ .metadata.name }}-config", Key: "logLevel"}},
+				{To: "auth.password", SecretKeyRef: &watches.KeyRef{Name: "{{ .metadata.name }}-credentials", Key: "password"}},
+				{To: "auth.user", SecretKeyRef: &watches.KeyRef{Name: "{{ .metadata.name }}-credentials", Key: "user"}, Default: "admin"},
+				{To: "tls", SecretKeyRef: &watches.KeyRef{Name: "missing", Key: "tls.crt"}, Default: false},
+			}},
+			expectValues: map[string]interface{}{
+				"logLevel": "debug",
+				"auth":     map[string]interface{}{"password": "s3cr3t", "user": "admin"},
+				"tls":      false,
+			},
+			expectSecretValues: map[string]interface{}{
+				"auth": map[string]interface{}{"password": "s3cr3t"},
+			},
+		},
+		{
+			name: "missing secret without default",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "tls", SecretKeyRef: &watches.KeyRef{Name: "missing", Key: "tls.crt"}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "missing configmap key without default",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "logLevel", ConfigMapKeyRef: &watches.KeyRef{Name: "example-config", Key: "level"}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "template with missing key",
+			mapping: watches.ValuesMapping{Values: []watches.ValueMapping{
+				{To: "name", Template: "{{ .spec.name }}"},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "path through non-map value",
+			mapping: watches.ValuesMapping{PassthroughSpec: true, Values: []watches.ValueMapping{
+				{To: "image.tag", Default: "latest"},
+			}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			values, secretValues, err := mapValues(cr, &tc.mapping, client)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error; got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; got error: %v", err)
+			}
+			if !reflect.DeepEqual(values, tc.expectValues) {
+				t.Fatalf("Expected values %#v; got %#v", tc.expectValues, values)
+			}
+			if tc.expectSecretValues == nil {
+				tc.expectSecretValues = map[string]interface{}{}
+			}
+			if !reflect.DeepEqual(secretValues, tc.expectSecretValues) {
+				t.Fatalf("Expected secret values %#v; got %#v", tc.expectSecretValues, secretValues)
+			}
+		})
+	}
+
+	// The CR spec must not be modified by the mapping.
+	if image := cr.Object["spec"].(map[string]interface{})["image"]; image != "nginx:1.17" {
+		t.Fatalf("Expected CR spec to be unchanged; got image %v", image)
+	}
+}
+
+func TestMappedValuesReferences(t *testing.T) {
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "example", "namespace": "default"},
+	}}
+	mapping := &watches.ValuesMapping{Values: []watches.ValueMapping{
+		{To: "image.repository", From: "{.spec.image}"},
+		{To: "logLevel", ConfigMapKeyRef: &watches.KeyRef{Name: "{{ .metadata.name }}-config", Key: "logLevel"}},
+		{To: "auth.password", SecretKeyRef: &watches.KeyRef{Name: "{{ .metadata.name }}-credentials", Key: "password"}},
+		{To: "tls", SecretKeyRef: &watches.KeyRef{Name: "tls", Key: "tls.crt"}, Default: false},
+	}}
+	refs, err := MappedValuesReferences(cr, mapping)
+	if err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+	expectRefs := []ValuesReference{
+		{Kind: "ConfigMap", Name: "example-config", Key: "logLevel", TargetPath: "logLevel"},
+		{Kind: "Secret", Name: "example-credentials", Key: "password", TargetPath: "auth.password"},
+		{Kind: "Secret", Name: "tls", Key: "tls.crt", TargetPath: "tls", Optional: true},
+	}
+	if !reflect.DeepEqual(refs, expectRefs) {
+		t.Fatalf("Expected references %#v; got %#v", expectRefs, refs)
+	}
+
+	if refs, err := MappedValuesReferences(cr, nil); err != nil || refs != nil {
+		t.Fatalf("Expected no references without a mapping; got %#v, error: %v", refs, err)
+	}
+}
@@ -107,14 +107,31 @@ func Run(flags *hoflags.HelmOperatorFlags) error {
 	}
 	var gvks []schema.GroupVersionKind
 	for _, w := range watches {
+		chartLoader := release.NewDirChartLoader(w.ChartDir)
+		if w.ChartRepository != "" {
+			chartLoader = release.NewRemoteChartLoader(release.ChartSource{
+				Repository: w.ChartRepository,
+				Name:       w.ChartName,
+				Version:    w.ChartVersion,
+				Keyring:    w.ChartKeyring,
+			}, flags.ChartCacheDir)
+		}
+
 		// Register the controller with the factory.
 		err := controller.Add(mgr, controller.WatchOptions{
 			Namespace:               namespace,
 			GVK:                     w.GroupVersionKind,
-			ManagerFactory:          release.NewManagerFactory(mgr, w.ChartDir),
+			ManagerFactory:          release.NewManagerFactory(mgr, chartLoader, w.MaxHistory, w.ValuesMapping),
 			ReconcilePeriod:         flags.ReconcilePeriod,
 			WatchDependentResources: w.WatchDependentResources,
 			OverrideValues:          w.OverrideValues,
+			RollbackOnFailure:       w.RollbackOnFailure,
+			MaxHistory:              w.MaxHistory,
+			DriftPolicy:             w.DriftPolicy,
+			WaitForReadiness:        w.WaitForReadiness,
+			ReadinessTimeout:        w.ReadinessTimeout,
+			Uninstall:               w.Uninstall,
+			ValuesMapping:           w.ValuesMapping,
 		})
 		if err != nil {
 			log.Error(err, "Failed to add manager factory to controller.")
@@ -0,0 +1,176 @@
+// Copyright 2020 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watches
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// ValuesMapping defines how the chart values of a release are built from a
+// custom resource.
+type ValuesMapping struct {
+	// PassthroughSpec determines whether the CR spec is used as the base
+	// chart values that the mapped values are set on. Defaults to true.
+	PassthroughSpec bool
+
+	// Values are the mapped chart values, applied in order.
+	Values []ValueMapping
+}
+
+// ValueMapping sets a single chart value. The value is taken from exactly one
+// of From, Template, ConfigMapKeyRef or SecretKeyRef. If none of them is set,
+// Default is used as a constant value.
+type ValueMapping struct {
+	// To is the dot-separated path of the chart value, e.g.
+	// "image.repository".
+	To string `yaml:"to"`
+
+	// From is a JSONPath expression evaluated against the CR, e.g.
+	// "{.spec.image}".
+	From string `yaml:"from"`
+
+	// Template is a Go template evaluated against the CR. The template can
+	// use the same functions as Helm chart templates. Its output is used as a
+	// string value.
+	Template string `yaml:"template"`
+
+	// ConfigMapKeyRef selects a key of a ConfigMap in the CR's namespace.
+	ConfigMapKeyRef *KeyRef `yaml:"configMapKeyRef"`
+
+	// SecretKeyRef selects a key of a Secret in the CR's namespace.
+	SecretKeyRef *KeyRef `yaml:"secretKeyRef"`
+
+	// Default is used if the source of the value is missing or empty.
+	Default interface{} `yaml:"default"`
+}
+
+// KeyRef selects a key of a ConfigMap or Secret. Name may be a Go template
+// evaluated against the CR, e.g. "{{ .metadata.name }}-credentials".
+type KeyRef struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type yamlValuesMapping struct {
+	PassthroughSpec *bool          `yaml:"passthroughSpec"`
+	Values          []ValueMapping `yaml:"values"`
+}
+
+// ParseTemplate parses a value mapping template.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(text)
+}
+
+// ParseJSONPath parses a value mapping JSONPath expression. Missing keys are
+// allowed so that optional CR fields can fall back to a default.
+func ParseJSONPath(name, expr string) (*jsonpath.JSONPath, error) {
+	j := jsonpath.New(name).AllowMissingKeys(true)
+	if err := j.Parse(expr); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func loadValuesMapping(in *yamlValuesMapping) (*ValuesMapping, error) {
+	if in == nil {
+		return nil, nil
+	}
+	out := &ValuesMapping{PassthroughSpec: true}
+	if in.PassthroughSpec != nil {
+		out.PassthroughSpec = *in.PassthroughSpec
+	}
+	for i, v := range in.Values {
+		if err := verifyValueMapping(v); err != nil {
+			return nil, fmt.Errorf("invalid values[%d]: %w", i, err)
+		}
+		v.Default = normalizeYAML(v.Default)
+		out.Values = append(out.Values, v)
+	}
+	return out, nil
+}
+
+func verifyValueMapping(v ValueMapping) error {
+	if v.To == "" {
+		return errors.New("to must not be empty")
+	}
+	for _, p := range strings.Split(v.To, ".") {
+		if p == "" {
+			return fmt.Errorf("invalid path %q: path elements must not be empty", v.To)
+		}
+	}
+
+	sources := 0
+	if v.From != "" {
+		sources++
+		if _, err := ParseJSONPath(v.To, v.From); err != nil {
+			return fmt.Errorf("invalid from expression %q: %w", v.From, err)
+		}
+	}
+	if v.Template != "" {
+		sources++
+		if _, err := ParseTemplate(v.To, v.Template); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	for _, ref := range []*KeyRef{v.ConfigMapKeyRef, v.SecretKeyRef} {
+		if ref == nil {
+			continue
+		}
+		sources++
+		if ref.Name == "" || ref.Key == "" {
+			return errors.New("name and key of a key reference must not be empty")
+		}
+		if _, err := ParseTemplate(v.To, ref.Name); err != nil {
+			return fmt.Errorf("invalid key reference name: %w", err)
+		}
+	}
+
+	switch {
+	case sources > 1:
+		return fmt.Errorf("value %q must have only one of from, template, configMapKeyRef or secretKeyRef", v.To)
+	case sources == 0 && v.Default == nil:
+		return fmt.Errorf("value %q must have one of from, template, configMapKeyRef, secretKeyRef or default", v.To)
+	}
+	return nil
+}
+
+// normalizeYAML converts the map[interface{}]interface{} and int values
+// decoded by yaml.v2 into map[string]interface{} and int64 values, as expected
+// by Helm and the JSON-compatible values of unstructured objects.
+func normalizeYAML(in interface{}) interface{} {
+	switch v := in.(type) {
+	case int:
+		return int64(v)
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[fmt.Sprint(k)] = normalizeYAML(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = normalizeYAML(e)
+		}
+		return out
+	}
+	return in
+}
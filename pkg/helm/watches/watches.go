@@ -18,35 +18,104 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+const (
+	readinessTimeoutDefault = "5m"
+	ociScheme               = "oci://"
+)
+
+// DriftPolicy defines how the operator handles release resources whose live
+// state differs from the release manifest.
+type DriftPolicy string
+
+const (
+	// DriftPolicyCorrect reports drifted resources and patches them to match
+	// the release manifest.
+	DriftPolicyCorrect DriftPolicy = "correct"
+
+	// DriftPolicyReport reports drifted resources without modifying them.
+	DriftPolicyReport DriftPolicy = "report"
+
+	// DriftPolicyIgnore disables drift detection. Release resources are not
+	// modified outside of release installs and updates.
+	DriftPolicyIgnore DriftPolicy = "ignore"
+)
+
 // Watch defines options for configuring a watch for a Helm-based
 // custom resource.
 type Watch struct {
 	GroupVersionKind        schema.GroupVersionKind
 	ChartDir                string
+	ChartRepository         string
+	ChartName               string
+	ChartVersion            string
+	ChartKeyring            string
 	WatchDependentResources bool
 	OverrideValues          map[string]string
+	RollbackOnFailure       bool
+	MaxHistory              int
+	DriftPolicy             DriftPolicy
+	WaitForReadiness        bool
+	ReadinessTimeout        time.Duration
+	ValuesMapping           *ValuesMapping
+	Uninstall               UninstallOptions
+}
+
+// UninstallOptions defines what happens to the release of a custom resource
+// when the custom resource is deleted.
+type UninstallOptions struct {
+	// Skip leaves the release and all of its resources in place.
+	Skip bool `yaml:"skip"`
+
+	// KeepHistory keeps the release history, marked as uninstalled, in the
+	// release storage.
+	KeepHistory bool `yaml:"keepHistory"`
+
+	// OrphanKinds are the kinds of release resources that are left in place
+	// when the release is uninstalled. A kind is either "Kind", matching the
+	// kind in any API group, or "Kind.group".
+	OrphanKinds []string `yaml:"orphanKinds"`
 }
 
 type yamlWatch struct {
-	Group                   string            `yaml:"group"`
-	Version                 string            `yaml:"version"`
-	Kind                    string            `yaml:"kind"`
-	Chart                   string            `yaml:"chart"`
-	WatchDependentResources bool              `yaml:"watchDependentResources"`
-	OverrideValues          map[string]string `yaml:"overrideValues"`
+	Group                   string             `yaml:"group"`
+	Version                 string             `yaml:"version"`
+	Kind                    string             `yaml:"kind"`
+	Chart                   string             `yaml:"chart"`
+	ChartRepository         string             `yaml:"chartRepository"`
+	ChartVersion            string             `yaml:"chartVersion"`
+	ChartKeyring            string             `yaml:"chartKeyring"`
+	WatchDependentResources bool               `yaml:"watchDependentResources"`
+	OverrideValues          map[string]string  `yaml:"overrideValues"`
+	RollbackOnFailure       bool               `yaml:"rollbackOnFailure"`
+	MaxHistory              int                `yaml:"maxHistory"`
+	DriftPolicy             DriftPolicy        `yaml:"driftPolicy"`
+	WaitForReadiness        bool               `yaml:"waitForReadiness"`
+	ReadinessTimeout        string             `yaml:"readinessTimeout"`
+	ValuesMapping           *yamlValuesMapping `yaml:"valuesMapping"`
+	Uninstall               UninstallOptions   `yaml:"uninstall"`
 }
 
 func (w *yamlWatch) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// by default, the operator will watch dependent resources
 	w.WatchDependentResources = true
 
+	// by default, the operator will correct drifted release resources
+	w.DriftPolicy = DriftPolicyCorrect
+
+	// by default, the operator will wait up to 5 minutes for release
+	// resources to become ready when waitForReadiness is enabled
+	w.ReadinessTimeout = readinessTimeoutDefault
+
 	// hide watch data in plain struct to prevent unmarshal from calling
 	// UnmarshalYAML again
 	type plain yamlWatch
@@ -83,8 +152,41 @@ func Load(path string) ([]Watch, error) {
 			return nil, fmt.Errorf("invalid GVK: %s: %w", gvk, err)
 		}
 
-		if _, err := chartutil.IsChartDir(w.Chart); err != nil {
-			return nil, fmt.Errorf("invalid chart directory %s: %w", w.Chart, err)
+		if err := verifyChart(w); err != nil {
+			return nil, err
+		}
+
+		if w.MaxHistory < 0 {
+			return nil, fmt.Errorf("invalid maxHistory %d for GVK %s: must not be negative", w.MaxHistory, gvk)
+		}
+
+		switch w.DriftPolicy {
+		case DriftPolicyCorrect, DriftPolicyReport, DriftPolicyIgnore:
+		default:
+			return nil, fmt.Errorf("invalid driftPolicy %q for GVK %s: must be one of %q, %q or %q",
+				w.DriftPolicy, gvk, DriftPolicyCorrect, DriftPolicyReport, DriftPolicyIgnore)
+		}
+
+		readinessTimeout, err := time.ParseDuration(w.ReadinessTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readinessTimeout %q for GVK %s: %w", w.ReadinessTimeout, gvk, err)
+		}
+		if readinessTimeout <= 0 {
+			return nil, fmt.Errorf("invalid readinessTimeout %q for GVK %s: must be positive", w.ReadinessTimeout, gvk)
+		}
+
+		valuesMapping, err := loadValuesMapping(w.ValuesMapping)
+		if err != nil {
+			return nil, fmt.Errorf("invalid valuesMapping for GVK %s: %w", gvk, err)
+		}
+
+		if w.Uninstall.Skip && (w.Uninstall.KeepHistory || len(w.Uninstall.OrphanKinds) > 0) {
+			return nil, fmt.Errorf("invalid uninstall options for GVK %s: skip must not be combined with keepHistory or orphanKinds", gvk)
+		}
+		for _, k := range w.Uninstall.OrphanKinds {
+			if gk := schema.ParseGroupKind(k); gk.Kind == "" {
+				return nil, fmt.Errorf("invalid uninstall orphanKinds entry %q for GVK %s: kind must not be empty", k, gvk)
+			}
 		}
 
 		if _, ok := watchesMap[gvk]; ok {
@@ -93,9 +195,23 @@ func Load(path string) ([]Watch, error) {
 
 		watch := Watch{
 			GroupVersionKind:        gvk,
-			ChartDir:                w.Chart,
 			WatchDependentResources: w.WatchDependentResources,
 			OverrideValues:          expandOverrideEnvs(w.OverrideValues),
+			RollbackOnFailure:       w.RollbackOnFailure,
+			MaxHistory:              w.MaxHistory,
+			DriftPolicy:             w.DriftPolicy,
+			WaitForReadiness:        w.WaitForReadiness,
+			ReadinessTimeout:        readinessTimeout,
+			ValuesMapping:           valuesMapping,
+			Uninstall:               w.Uninstall,
+		}
+		if w.ChartRepository == "" {
+			watch.ChartDir = w.Chart
+		} else {
+			watch.ChartRepository = w.ChartRepository
+			watch.ChartName = w.Chart
+			watch.ChartVersion = w.ChartVersion
+			watch.ChartKeyring = w.ChartKeyring
 		}
 		watchesMap[gvk] = watch
 		watches = append(watches, watch)
@@ -103,6 +219,38 @@ func Load(path string) ([]Watch, error) {
 	return watches, nil
 }
 
+// verifyChart verifies that the chart of a watch is either a local chart
+// directory or a chart in a chart repository or OCI registry.
+func verifyChart(w yamlWatch) error {
+	if w.ChartRepository == "" {
+		if w.ChartVersion != "" || w.ChartKeyring != "" {
+			return fmt.Errorf("invalid chart %s: chartVersion and chartKeyring require a chartRepository", w.Chart)
+		}
+		if _, err := chartutil.IsChartDir(w.Chart); err != nil {
+			return fmt.Errorf("invalid chart directory %s: %w", w.Chart, err)
+		}
+		return nil
+	}
+
+	if w.Chart == "" {
+		return fmt.Errorf("invalid chart for repository %s: chart name must not be empty", w.ChartRepository)
+	}
+	if strings.HasPrefix(w.ChartRepository, ociScheme) {
+		if w.ChartVersion == "" {
+			return fmt.Errorf("invalid chart %s: chartVersion must be set for OCI repository %s", w.Chart, w.ChartRepository)
+		}
+		return nil
+	}
+	u, err := url.Parse(w.ChartRepository)
+	if err != nil {
+		return fmt.Errorf("invalid chart repository %s: %w", w.ChartRepository, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid chart repository %s: scheme must be one of http, https or oci", w.ChartRepository)
+	}
+	return nil
+}
+
 func expandOverrideEnvs(in map[string]string) map[string]string {
 	out := make(map[string]string)
 	for k, v := range in {
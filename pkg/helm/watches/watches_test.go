@@ -19,6 +19,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type testCase struct {
@@ -28,6 +29,14 @@ type testCase struct {
 	expectLen       int
 	expectErr       bool
 	expectOverrides []map[string]string
+	expectRollback  bool
+	expectHistory   int
+	expectDrift     DriftPolicy
+	expectWait      bool
+	expectTimeout   time.Duration
+	expectChartRepo string
+	expectMapping   *ValuesMapping
+	expectUninstall UninstallOptions
 }
 
 func TestLoadWatches(t *testing.T) {
@@ -63,6 +72,148 @@ func TestLoadWatches(t *testing.T) {
 			expectErr:       false,
 			expectOverrides: []map[string]string{{"key": "value"}},
 		},
+		{
+			name: "valid with rollback on failure",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  rollbackOnFailure: true
+`,
+			expectLen:      1,
+			expectErr:      false,
+			expectRollback: true,
+		},
+		{
+			name: "valid with max history",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  maxHistory: 5
+`,
+			expectLen:     1,
+			expectErr:     false,
+			expectHistory: 5,
+		},
+		{
+			name: "valid with drift policy",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  driftPolicy: report
+`,
+			expectLen:   1,
+			expectErr:   false,
+			expectDrift: DriftPolicyReport,
+		},
+		{
+			name: "valid with wait for readiness",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  waitForReadiness: true
+  readinessTimeout: 90s
+`,
+			expectLen:     1,
+			expectErr:     false,
+			expectWait:    true,
+			expectTimeout: 90 * time.Second,
+		},
+		{
+			name: "valid with chart repository",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: nginx
+  chartRepository: https://charts.example.com
+  chartVersion: ">=1.2.0 <2.0.0"
+  chartKeyring: /etc/helm/pubring.gpg
+`,
+			expectLen:       1,
+			expectErr:       false,
+			expectChartRepo: "https://charts.example.com",
+		},
+		{
+			name: "valid with OCI repository",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: nginx
+  chartRepository: oci://registry.example.com/charts
+  chartVersion: 1.2.3
+`,
+			expectLen:       1,
+			expectErr:       false,
+			expectChartRepo: "oci://registry.example.com/charts",
+		},
+		{
+			name: "valid with values mapping",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  valuesMapping:
+    passthroughSpec: false
+    values:
+    - to: image.repository
+      from: "{.spec.image}"
+      default: nginx
+    - to: fullnameOverride
+      template: "{{ .metadata.name }}-web"
+    - to: auth.password
+      secretKeyRef:
+        name: "{{ .metadata.name }}-credentials"
+        key: password
+    - to: resources
+      default:
+        limits:
+          cpu: 100m
+    - to: replicaCount
+      default: 2
+`,
+			expectLen: 1,
+			expectErr: false,
+			expectMapping: &ValuesMapping{
+				PassthroughSpec: false,
+				Values: []ValueMapping{
+					{To: "image.repository", From: "{.spec.image}", Default: "nginx"},
+					{To: "fullnameOverride", Template: "{{ .metadata.name }}-web"},
+					{To: "auth.password", SecretKeyRef: &KeyRef{Name: "{{ .metadata.name }}-credentials", Key: "password"}},
+					{To: "resources", Default: map[string]interface{}{"limits": map[string]interface{}{"cpu": "100m"}}},
+					{To: "replicaCount", Default: int64(2)},
+				},
+			},
+		},
+		{
+			name: "valid with uninstall options",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  uninstall:
+    keepHistory: true
+    orphanKinds:
+    - PersistentVolumeClaim
+    - Certificate.cert-manager.io
+`,
+			expectLen: 1,
+			expectErr: false,
+			expectUninstall: UninstallOptions{
+				KeepHistory: true,
+				OrphanKinds: []string{"PersistentVolumeClaim", "Certificate.cert-manager.io"},
+			},
+		},
 		{
 			name: "multiple gvk",
 			data: `---
@@ -120,6 +271,208 @@ func TestLoadWatches(t *testing.T) {
   version: v1alpha1
   kind: MyKind
   chart: nonexistent/path/to/chart
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "chart version without repository",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  chartVersion: 1.2.3
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "OCI repository without chart version",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: nginx
+  chartRepository: oci://registry.example.com/charts
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "unsupported chart repository scheme",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: nginx
+  chartRepository: ftp://charts.example.com
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "values mapping with multiple sources",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  valuesMapping:
+    values:
+    - to: image
+      from: "{.spec.image}"
+      template: "{{ .spec.image }}"
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "values mapping without source",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  valuesMapping:
+    values:
+    - to: image
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "values mapping with invalid path",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  valuesMapping:
+    values:
+    - to: image..repository
+      from: "{.spec.image}"
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "values mapping with invalid template",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  valuesMapping:
+    values:
+    - to: image
+      template: "{{ .spec.image "
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "values mapping with invalid JSONPath",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  valuesMapping:
+    values:
+    - to: image
+      from: "{.spec.image"
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "uninstall skip with keep history",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  uninstall:
+    skip: true
+    keepHistory: true
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "uninstall orphan kind without kind",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  uninstall:
+    orphanKinds:
+    - .cert-manager.io
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "negative max history",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  maxHistory: -1
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "invalid drift policy",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  driftPolicy: revert
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "invalid readiness timeout",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  waitForReadiness: true
+  readinessTimeout: soon
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "zero readiness timeout",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  waitForReadiness: true
+  readinessTimeout: 0s
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "negative readiness timeout",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/scaffold/helm/testdata/testcharts/test-chart
+  waitForReadiness: true
+  readinessTimeout: -1m
 `,
 			expectLen: 0,
 			expectErr: true,
@@ -191,6 +544,40 @@ func doTest(t *testing.T, tc testCase, watchesFile string) {
 	}
 
 	for i, w := range watches {
+		if w.RollbackOnFailure != tc.expectRollback {
+			t.Fatalf("Expected rollbackOnFailure %t; got %t", tc.expectRollback, w.RollbackOnFailure)
+		}
+		expectDrift := tc.expectDrift
+		if expectDrift == "" {
+			expectDrift = DriftPolicyCorrect
+		}
+		if w.DriftPolicy != expectDrift {
+			t.Fatalf("Expected driftPolicy %q; got %q", expectDrift, w.DriftPolicy)
+		}
+		expectTimeout := tc.expectTimeout
+		if expectTimeout == 0 {
+			expectTimeout = 5 * time.Minute
+		}
+		if w.WaitForReadiness != tc.expectWait || w.ReadinessTimeout != expectTimeout {
+			t.Fatalf("Expected waitForReadiness %t with timeout %s; got %t with timeout %s",
+				tc.expectWait, expectTimeout, w.WaitForReadiness, w.ReadinessTimeout)
+		}
+		if w.ChartRepository != tc.expectChartRepo {
+			t.Fatalf("Expected chart repository %q; got %q", tc.expectChartRepo, w.ChartRepository)
+		}
+		if w.ChartRepository == "" && w.ChartDir == "" {
+			t.Fatalf("Expected chart dir to be set for local chart")
+		}
+		if !reflect.DeepEqual(w.ValuesMapping, tc.expectMapping) {
+			t.Fatalf("Expected values mapping %#v; got %#v", tc.expectMapping, w.ValuesMapping)
+		}
+		if !reflect.DeepEqual(w.Uninstall, tc.expectUninstall) {
+			t.Fatalf("Expected uninstall options %#v; got %#v", tc.expectUninstall, w.Uninstall)
+		}
+		if w.MaxHistory != tc.expectHistory {
+			t.Fatalf("Expected maxHistory %d; got %d", tc.expectHistory, w.MaxHistory)
+		}
+
 		if len(tc.expectOverrides) <= i {
 			if len(w.OverrideValues) > 0 {
 				t.Fatalf("Expected no overides; got %#v", w.OverrideValues)